@@ -5,71 +5,342 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/0x0Glitch/toll-calculator/apperr"
 	"github.com/0x0Glitch/toll-calculator/types"
 )
 
 type HTTPClient struct {
-	Endpoint string
+	balancer *Balancer
+	client   *http.Client
+	scheme   string
 }
 
-func NewHTTPClient(endpoint string) Client {
+// NewHTTPClient returns a client that talks to a single aggregator.
+func NewHTTPClient(endpoint string, opts ...Option) *HTTPClient {
+	return NewBalancedHTTPClient(NewBalancer(NewStaticResolver(endpoint)), opts...)
+}
+
+// NewBalancedHTTPClient returns a client that spreads its calls over every
+// aggregator replica known to the balancer.
+func NewBalancedHTTPClient(b *Balancer, opts ...Option) *HTTPClient {
+	o := newOptions(opts)
+	scheme := "http"
+	if o.tls != nil {
+		scheme = "https"
+	}
 	return &HTTPClient{
-		Endpoint: endpoint,
+		balancer: b,
+		client:   o.httpClient(),
+		scheme:   scheme,
 	}
 }
 
-func (c *HTTPClient) Aggregate(ctx context.Context,request *types.AggregatorRequest) error {
+func (c *HTTPClient) Aggregate(ctx context.Context, request *types.AggregatorRequest) error {
 	distance := types.Distance{
 		OBUID:  int32(request.ObuID),
 		Values: request.Value,
 		Unix:   request.Unix,
+		ZoneID: request.ZoneID,
+		Estimated: request.Estimated,
+		Trip: request.Trip.Leg(),
+		OffPeak: request.OffPeak,
 	}
 	b, err := json.Marshal(distance)
 	if err != nil {
 		return err
 	}
-	req, err := http.NewRequest("POST", c.Endpoint+"/aggregate", bytes.NewReader(b))
-	if err != nil {
-		return err
-	}
-
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := c.do(ctx, http.MethodPost, "/aggregate", b)
 	if err != nil {
 		return err
 	}
+	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("the service responded with non 200 status code %d",resp.StatusCode)
+		return apperr.FromHTTPResponse(resp)
 	}
-	resp.Body.Close()
 	return nil
 }
 
 func (c *HTTPClient) GetInvoice(ctx context.Context, id int) (*types.Invoice, error) {
 	// Instead of creating a request body, use query parameters
-	url := fmt.Sprintf("%s/invoice?obu=%d", c.Endpoint, id)
-	
-	req, err := http.NewRequest("GET", url, nil)
+	resp, err := c.do(ctx, http.MethodGet, fmt.Sprintf("/invoice?obu=%d", id), nil)
 	if err != nil {
 		return nil, err
 	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, apperr.FromHTTPResponse(resp)
+	}
+
+	var inv types.Invoice
+	if err := json.NewDecoder(resp.Body).Decode(&inv); err != nil {
+		return nil, err
+	}
+
+	return &inv, nil
+}
 
-	resp, err := http.DefaultClient.Do(req)
+// Trips lists the trips of the OBU that overlap the period from to to. A
+// zero from or to leaves that end of the period open.
+func (c *HTTPClient) Trips(ctx context.Context, obuID int32, from, to time.Time) ([]types.Trip, error) {
+	q := url.Values{"obu": {strconv.Itoa(int(obuID))}}
+	if !from.IsZero() {
+		q.Set("from", from.Format(time.RFC3339Nano))
+	}
+	if !to.IsZero() {
+		q.Set("to", to.Format(time.RFC3339Nano))
+	}
+	resp, err := c.do(ctx, http.MethodGet, "/trips?"+q.Encode(), nil)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
-	
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("the service responded with non 200 status code %v", resp.Status)
+		return nil, apperr.FromHTTPResponse(resp)
+	}
+	var trips []types.Trip
+	if err := json.NewDecoder(resp.Body).Decode(&trips); err != nil {
+		return nil, err
+	}
+	return trips, nil
+}
+
+// Invoices lists the invoice documents of the OBU whose periods overlap
+// from to to. A zero from or to leaves that end of the period open.
+func (c *HTTPClient) Invoices(ctx context.Context, obuID int32, from, to time.Time) ([]types.Invoice, error) {
+	q := url.Values{"obu": {strconv.Itoa(int(obuID))}}
+	if !from.IsZero() {
+		q.Set("from", from.Format(time.RFC3339Nano))
+	}
+	if !to.IsZero() {
+		q.Set("to", to.Format(time.RFC3339Nano))
+	}
+	var invoices []types.Invoice
+	if err := c.call(ctx, http.MethodGet, "/invoices?"+q.Encode(), nil, &invoices); err != nil {
+		return nil, err
+	}
+	return invoices, nil
+}
+
+// CreditNotes lists the credit notes of the OBU's invoices.
+func (c *HTTPClient) CreditNotes(ctx context.Context, obuID int32) ([]types.CreditNote, error) {
+	var notes []types.CreditNote
+	if err := c.call(ctx, http.MethodGet, fmt.Sprintf("/credit-notes?obu=%d", obuID), nil, &notes); err != nil {
+		return nil, err
 	}
-	
+	return notes, nil
+}
+
+// FinalizeInvoice freezes the draft invoice with the ID.
+func (c *HTTPClient) FinalizeInvoice(ctx context.Context, id string) (*types.Invoice, error) {
+	return c.invoiceAction(ctx, "finalize", url.Values{"id": {id}})
+}
+
+// PayInvoice records the payment of the invoice with the ID.
+func (c *HTTPClient) PayInvoice(ctx context.Context, id string) (*types.Invoice, error) {
+	return c.invoiceAction(ctx, "pay", url.Values{"id": {id}})
+}
+
+// VoidInvoice cancels the invoice with the ID.
+func (c *HTTPClient) VoidInvoice(ctx context.Context, id, reason string) (*types.Invoice, error) {
+	return c.invoiceAction(ctx, "void", url.Values{"id": {id}, "reason": {reason}})
+}
+
+func (c *HTTPClient) invoiceAction(ctx context.Context, action string, q url.Values) (*types.Invoice, error) {
 	var inv types.Invoice
-	if err := json.NewDecoder(resp.Body).Decode(&inv); err != nil {
+	if err := c.call(ctx, http.MethodPost, "/admin/invoices/"+action+"?"+q.Encode(), nil, &inv); err != nil {
+		return nil, err
+	}
+	return &inv, nil
+}
+
+// CreditInvoice issues a credit note with the lines against the invoice
+// with the ID.
+func (c *HTTPClient) CreditInvoice(ctx context.Context, id, reason string, lines []types.InvoiceLine) (*types.CreditNote, error) {
+	b, err := json.Marshal(types.CreditNote{Reason: reason, Lines: lines})
+	if err != nil {
+		return nil, err
+	}
+	var note types.CreditNote
+	if err := c.call(ctx, http.MethodPost, "/admin/invoices/credit?id="+url.QueryEscape(id), b, &note); err != nil {
 		return nil, err
 	}
+	return &note, nil
+}
+
+// CloseInvoices closes the billing period with the label on the aggregator
+// replica picked by the balancer and returns how many invoices it issued.
+func (c *HTTPClient) CloseInvoices(ctx context.Context, period string) (int, error) {
+	var closed struct {
+		Invoices int `json:"invoices"`
+	}
+	if err := c.call(ctx, http.MethodPost, "/admin/invoices/close?period="+url.QueryEscape(period), nil, &closed); err != nil {
+		return 0, err
+	}
+	return closed.Invoices, nil
+}
 
+// Invoice returns the invoice document with the ID.
+func (c *HTTPClient) Invoice(ctx context.Context, id string) (*types.Invoice, error) {
+	var inv types.Invoice
+	if err := c.call(ctx, http.MethodGet, "/invoices/document?id="+url.QueryEscape(id), nil, &inv); err != nil {
+		return nil, err
+	}
 	return &inv, nil
 }
-  
\ No newline at end of file
+
+// ExportInvoices calls fn with every invoice of the billing period with the
+// label as the aggregator streams them, and stops at the first error.
+func (c *HTTPClient) ExportInvoices(ctx context.Context, period string, fn func(types.Invoice) error) error {
+	q := url.Values{"period": {period}, "format": {"jsonl"}}
+	resp, err := c.do(ctx, http.MethodGet, "/invoices/export?"+q.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return apperr.FromHTTPResponse(resp)
+	}
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var inv types.Invoice
+		err := dec.Decode(&inv)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return apperr.Wrap(apperr.Unavailable, err, "reading invoices of period %s", period)
+		}
+		if err := fn(inv); err != nil {
+			return err
+		}
+	}
+}
+
+// Contracts lists the contracts of the aggregator replica picked by the
+// balancer.
+func (c *HTTPClient) Contracts(ctx context.Context) ([]types.Contract, error) {
+	var list []types.Contract
+	if err := c.call(ctx, http.MethodGet, "/admin/contracts", nil, &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// Contract returns the contract with the ID.
+func (c *HTTPClient) Contract(ctx context.Context, id string) (*types.Contract, error) {
+	var con types.Contract
+	if err := c.call(ctx, http.MethodGet, "/admin/contracts?id="+url.QueryEscape(id), nil, &con); err != nil {
+		return nil, err
+	}
+	return &con, nil
+}
+
+// PutContract adds the contract, or replaces the one with its ID.
+func (c *HTTPClient) PutContract(ctx context.Context, con types.Contract) (*types.Contract, error) {
+	b, err := json.Marshal(con)
+	if err != nil {
+		return nil, err
+	}
+	var saved types.Contract
+	if err := c.call(ctx, http.MethodPut, "/admin/contracts", b, &saved); err != nil {
+		return nil, err
+	}
+	return &saved, nil
+}
+
+// DeleteContract deletes the contract with the ID.
+func (c *HTTPClient) DeleteContract(ctx context.Context, id string) error {
+	var deleted struct {
+		Deleted string `json:"deleted"`
+	}
+	return c.call(ctx, http.MethodDelete, "/admin/contracts?id="+url.QueryEscape(id), nil, &deleted)
+}
+
+// Erase erases the OBU from the stores of the aggregator replica picked by
+// the balancer.
+func (c *HTTPClient) Erase(ctx context.Context, obuID int32) error {
+	resp, err := c.do(ctx, http.MethodPost, fmt.Sprintf("/admin/erase?obu=%d", obuID), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return apperr.FromHTTPResponse(resp)
+	}
+	return nil
+}
+
+// Ping checks that the aggregator replica picked by the balancer is ready.
+func (c *HTTPClient) Ping(ctx context.Context) error {
+	resp, err := c.do(ctx, http.MethodGet, "/readyz", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return apperr.Unavailablef("aggregator not ready: %s", resp.Status)
+	}
+	return nil
+}
+
+// call sends the request and decodes the JSON response into v, or returns
+// the error the aggregator answered with.
+func (c *HTTPClient) call(ctx context.Context, method, path string, body []byte, v any) error {
+	resp, err := c.do(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return apperr.FromHTTPResponse(resp)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// do sends the request to the endpoint picked by the balancer and reports
+// transport errors and 5xx responses back to it.
+func (c *HTTPClient) do(ctx context.Context, method, path string, body []byte) (*http.Response, error) {
+	ep, err := c.balancer.Pick(ctx)
+	if err != nil {
+		return nil, apperr.Wrap(apperr.Unavailable, err, "no aggregator available")
+	}
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, baseURL(c.scheme, ep.Addr)+path, r)
+	if err != nil {
+		c.balancer.Done(ep, nil)
+		return nil, err
+	}
+	if IsForwarded(ctx) {
+		req.Header.Set(ForwardedHeader, "1")
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		c.balancer.Done(ep, err)
+		return nil, apperr.Wrap(apperr.Unavailable, err, "calling aggregator %s", ep.Addr)
+	}
+	if resp.StatusCode >= http.StatusInternalServerError {
+		c.balancer.Done(ep, fmt.Errorf("status %d", resp.StatusCode))
+	} else {
+		c.balancer.Done(ep, nil)
+	}
+	return resp, nil
+}
+
+// baseURL turns a bare host:port, as returned by SRV lookups, into a base URL.
+func baseURL(scheme, addr string) string {
+	if strings.Contains(addr, "://") {
+		return strings.TrimSuffix(addr, "/")
+	}
+	return scheme + "://" + addr
+}
@@ -0,0 +1,231 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultRefreshInterval = 30 * time.Second
+	defaultMaxFailures     = 3
+	defaultEjectionTime    = 10 * time.Second
+)
+
+// Endpoint is a single aggregator replica as seen by the balancer.
+type Endpoint struct {
+	Addr string
+
+	inflight     atomic.Int64
+	failures     int
+	ejectedUntil time.Time
+}
+
+// Inflight returns the number of calls currently outstanding on the endpoint.
+func (e *Endpoint) Inflight() int64 {
+	return e.inflight.Load()
+}
+
+// Picker chooses one endpoint out of the healthy candidates.
+type Picker interface {
+	Pick([]*Endpoint) *Endpoint
+}
+
+type roundRobinPicker struct {
+	next atomic.Uint64
+}
+
+// RoundRobin hands out the candidates in turn.
+func RoundRobin() Picker {
+	return &roundRobinPicker{}
+}
+
+func (p *roundRobinPicker) Pick(eps []*Endpoint) *Endpoint {
+	n := p.next.Add(1) - 1
+	return eps[n%uint64(len(eps))]
+}
+
+type leastLoadedPicker struct {
+	rr roundRobinPicker
+}
+
+// LeastLoaded picks the candidate with the fewest calls in flight,
+// rotating between endpoints that are equally loaded.
+func LeastLoaded() Picker {
+	return &leastLoadedPicker{}
+}
+
+func (p *leastLoadedPicker) Pick(eps []*Endpoint) *Endpoint {
+	start := p.rr.Pick(eps)
+	best := start
+	for _, ep := range eps {
+		if ep.Inflight() < best.Inflight() {
+			best = ep
+		}
+	}
+	return best
+}
+
+// ParsePicker maps a balancing policy name to its picker.
+func ParsePicker(name string) (Picker, error) {
+	switch name {
+	case "", "roundrobin":
+		return RoundRobin(), nil
+	case "leastloaded":
+		return LeastLoaded(), nil
+	}
+	return nil, fmt.Errorf("unknown balancing policy %q", name)
+}
+
+// Balancer spreads calls over the addresses returned by a Resolver and
+// temporarily ejects endpoints that keep failing.
+type Balancer struct {
+	resolver        Resolver
+	picker          Picker
+	refreshInterval time.Duration
+	maxFailures     int
+	ejectionTime    time.Duration
+	now             func() time.Time
+
+	mu          sync.Mutex
+	endpoints   []*Endpoint
+	lastRefresh time.Time
+}
+
+type BalancerOption func(*Balancer)
+
+func WithPicker(p Picker) BalancerOption {
+	return func(b *Balancer) {
+		b.picker = p
+	}
+}
+
+// WithRefreshInterval sets how often the resolver is asked for a fresh address list.
+func WithRefreshInterval(d time.Duration) BalancerOption {
+	return func(b *Balancer) {
+		b.refreshInterval = d
+	}
+}
+
+// WithEjection ejects an endpoint for the given duration after maxFailures
+// consecutive failed calls.
+func WithEjection(maxFailures int, d time.Duration) BalancerOption {
+	return func(b *Balancer) {
+		b.maxFailures = maxFailures
+		b.ejectionTime = d
+	}
+}
+
+func NewBalancer(r Resolver, opts ...BalancerOption) *Balancer {
+	b := &Balancer{
+		resolver:        r,
+		picker:          RoundRobin(),
+		refreshInterval: defaultRefreshInterval,
+		maxFailures:     defaultMaxFailures,
+		ejectionTime:    defaultEjectionTime,
+		now:             time.Now,
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// Pick returns the endpoint the next call should go to. Every successful
+// Pick must be paired with a call to Done.
+func (b *Balancer) Pick(ctx context.Context) (*Endpoint, error) {
+	if err := b.refresh(ctx); err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	now := b.now()
+	healthy := make([]*Endpoint, 0, len(b.endpoints))
+	for _, ep := range b.endpoints {
+		if !now.Before(ep.ejectedUntil) {
+			healthy = append(healthy, ep)
+		}
+	}
+	// When every replica is ejected we'd rather keep trying than fail hard.
+	if len(healthy) == 0 {
+		healthy = b.endpoints
+	}
+	b.mu.Unlock()
+
+	ep := b.picker.Pick(healthy)
+	ep.inflight.Add(1)
+	return ep, nil
+}
+
+// Done reports the outcome of a call made against ep. err should only be
+// non-nil for failures that say something about the endpoint's health,
+// such as connection errors or 5xx responses.
+func (b *Balancer) Done(ep *Endpoint, err error) {
+	ep.inflight.Add(-1)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err == nil {
+		ep.failures = 0
+		return
+	}
+	ep.failures++
+	if b.maxFailures > 0 && ep.failures >= b.maxFailures {
+		ep.ejectedUntil = b.now().Add(b.ejectionTime)
+		ep.failures = 0
+		logrus.WithFields(logrus.Fields{
+			"endpoint": ep.Addr,
+			"until":    ep.ejectedUntil,
+			"err":      err,
+		}).Warn("ejecting aggregator endpoint")
+	}
+}
+
+// Endpoints returns a snapshot of the endpoints the balancer currently knows about.
+func (b *Balancer) Endpoints() []*Endpoint {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]*Endpoint(nil), b.endpoints...)
+}
+
+func (b *Balancer) refresh(ctx context.Context) error {
+	b.mu.Lock()
+	stale := b.endpoints == nil || b.now().Sub(b.lastRefresh) >= b.refreshInterval
+	b.mu.Unlock()
+	if !stale {
+		return nil
+	}
+
+	addrs, err := b.resolver.Resolve(ctx)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.lastRefresh = b.now()
+	if err != nil {
+		if len(b.endpoints) > 0 {
+			logrus.Errorf("resolving aggregator endpoints, keeping previous list: %s", err)
+			return nil
+		}
+		return fmt.Errorf("resolving aggregator endpoints: %w", err)
+	}
+
+	// Keep the state of endpoints we already know so that a refresh
+	// doesn't reset load counters or bring ejected replicas back early.
+	known := make(map[string]*Endpoint, len(b.endpoints))
+	for _, ep := range b.endpoints {
+		known[ep.Addr] = ep
+	}
+	endpoints := make([]*Endpoint, 0, len(addrs))
+	for _, addr := range addrs {
+		if ep, ok := known[addr]; ok {
+			endpoints = append(endpoints, ep)
+			continue
+		}
+		endpoints = append(endpoints, &Endpoint{Addr: addr})
+	}
+	b.endpoints = endpoints
+	return nil
+}
@@ -0,0 +1,110 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// ForwardedHeader marks requests that one aggregator node forwarded to the
+// owner of the OBU's shard. The owner handles them locally instead of
+// forwarding them again, which keeps nodes with a stale view of the
+// cluster from bouncing requests back and forth.
+const (
+	ForwardedHeader      = "X-Aggregator-Forwarded"
+	ForwardedMetadataKey = "x-aggregator-forwarded"
+)
+
+type forwardedKey struct{}
+
+// WithForwarded marks calls made with the returned context as forwarded.
+func WithForwarded(ctx context.Context) context.Context {
+	return context.WithValue(ctx, forwardedKey{}, true)
+}
+
+// IsForwarded reports whether ctx was marked with WithForwarded.
+func IsForwarded(ctx context.Context) bool {
+	v, _ := ctx.Value(forwardedKey{}).(bool)
+	return v
+}
+
+// IsForwardedIncoming reports whether an incoming gRPC call was forwarded by
+// another node. Only calls that passed TrustForwardedInterceptor can be
+// relied on.
+func IsForwardedIncoming(ctx context.Context) bool {
+	md, ok := metadata.FromIncomingContext(ctx)
+	return ok && len(md.Get(ForwardedMetadataKey)) > 0
+}
+
+// FromPeer reports whether a connection was made by a cluster member: over
+// mutual TLS, with a certificate naming the host of one of members. The
+// chain is verified against the CA by the listener's mtls config during the
+// handshake, so only the name is checked here.
+func FromPeer(state *tls.ConnectionState, members []string) bool {
+	if state == nil || len(state.PeerCertificates) == 0 {
+		return false
+	}
+	cert := state.PeerCertificates[0]
+	for _, addr := range members {
+		if cert.VerifyHostname(memberHost(addr)) == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// memberHost returns the host of a member address, given as host:port or
+// as a URL.
+func memberHost(addr string) string {
+	if _, rest, ok := strings.Cut(addr, "://"); ok {
+		addr = rest
+	}
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// TrustForwarded drops the forwarded header from requests that don't come
+// from one of the members returned by peers. Any client can set the header,
+// and honouring it would let them skip the routing to the shard's owner and
+// the off-peak marking.
+func TrustForwarded(next http.Handler, peers func() []string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(ForwardedHeader) != "" && !FromPeer(r.TLS, peers()) {
+			r.Header.Del(ForwardedHeader)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// TrustForwardedInterceptor drops the forwarded metadata from calls that
+// don't come from one of the members returned by peers, as TrustForwarded
+// does for HTTP.
+func TrustForwardedInterceptor(peers func() []string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if IsForwardedIncoming(ctx) && !peerCall(ctx, peers()) {
+			md, _ := metadata.FromIncomingContext(ctx)
+			md = md.Copy()
+			delete(md, ForwardedMetadataKey)
+			ctx = metadata.NewIncomingContext(ctx, md)
+		}
+		return handler(ctx, req)
+	}
+}
+
+func peerCall(ctx context.Context, members []string) bool {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return false
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	return ok && FromPeer(&info.State, members)
+}
@@ -2,30 +2,192 @@ package client
 
 import (
 	"context"
+	"net/url"
+	"sync"
 
+	"github.com/0x0Glitch/toll-calculator/apperr"
+	"github.com/0x0Glitch/toll-calculator/money"
+	"github.com/0x0Glitch/toll-calculator/tracing"
 	"github.com/0x0Glitch/toll-calculator/types"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
 type GRPCClient struct {
-	Endpoint string
-	client   types.AggregatorClient
-}
+	balancer *Balancer
+	creds    credentials.TransportCredentials
 
-func NewGRPCClient(Endpoint string) (*GRPCClient, error) {
+	mu    sync.Mutex
+	conns map[string]*grpc.ClientConn
+}
 
-	conn, err := grpc.Dial(Endpoint, grpc.WithInsecure())
-	if err != nil {
+// NewGRPCClient returns a client that talks to a single aggregator.
+func NewGRPCClient(endpoint string, opts ...Option) (*GRPCClient, error) {
+	c := NewBalancedGRPCClient(NewBalancer(NewStaticResolver(endpoint)), opts...)
+	if _, err := c.conn(endpoint); err != nil {
 		return nil, err
 	}
-	c := types.NewAggregatorClient(conn)
+	return c, nil
+}
+
+// NewBalancedGRPCClient returns a client that keeps one connection per
+// aggregator replica and spreads its calls over them using the balancer.
+// The resolver may list http:// or https:// URLs; only their host is
+// dialed, so they must name the gRPC port.
+func NewBalancedGRPCClient(b *Balancer, opts ...Option) *GRPCClient {
+	o := newOptions(opts)
+	creds := insecure.NewCredentials()
+	if o.tls != nil {
+		creds = credentials.NewTLS(o.tls)
+	}
 	return &GRPCClient{
-		Endpoint: Endpoint,
-		client:   c,
-	}, nil
+		balancer: b,
+		creds:    creds,
+		conns:    make(map[string]*grpc.ClientConn),
+	}
 }
 
 func (c *GRPCClient) Aggregate(ctx context.Context, req *types.AggregatorRequest) error {
-	_, err := c.client.Aggregate(ctx, req)
-	return err
+	return c.call(ctx, func(ctx context.Context, conn *grpc.ClientConn) error {
+		_, err := types.NewAggregatorClient(conn).Aggregate(ctx, req)
+		return err
+	})
+}
+
+func (c *GRPCClient) GetInvoice(ctx context.Context, id int) (*types.Invoice, error) {
+	var resp *types.InvoiceResponse
+	err := c.call(ctx, func(ctx context.Context, conn *grpc.ClientConn) (err error) {
+		resp, err = types.NewAggregatorClient(conn).GetInvoice(ctx, &types.GetInvoiceRequest{ObuID: int32(id)})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	inv := &types.Invoice{
+		OBUID:             resp.ObuID,
+		TotalDistance:     resp.TotalDistance,
+		Currency:          money.Currency(resp.Currency),
+		EstimatedDistance: resp.EstimatedDistance,
+	}
+	if inv.Amount, err = parseAmount(resp.Amount); err != nil {
+		return nil, err
+	}
+	if inv.Tax, err = parseAmount(resp.Tax); err != nil {
+		return nil, err
+	}
+	if inv.Gross, err = parseAmount(resp.Gross); err != nil {
+		return nil, err
+	}
+	for _, z := range resp.Zones {
+		amount, err := parseAmount(z.Amount)
+		if err != nil {
+			return nil, err
+		}
+		inv.Zones = append(inv.Zones, types.ZoneTotal{ZoneID: z.ZoneID, Distance: z.Distance, Amount: amount, Estimated: z.Estimated})
+	}
+	return inv, nil
+}
+
+// parseAmount reads an amount the RPC carries as an exact decimal; an
+// empty one is 0.
+func parseAmount(s string) (money.Decimal, error) {
+	if s == "" {
+		return money.Decimal{}, nil
+	}
+	d, err := money.Parse(s)
+	if err != nil {
+		return money.Decimal{}, apperr.Wrap(apperr.Internal, err, "invalid amount in invoice response")
+	}
+	return d, nil
+}
+
+// Ping asks the standard gRPC health service of the replica picked by the
+// balancer whether the Aggregator service is serving.
+func (c *GRPCClient) Ping(ctx context.Context) error {
+	return c.call(ctx, func(ctx context.Context, conn *grpc.ClientConn) error {
+		resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{
+			Service: types.Aggregator_ServiceDesc.ServiceName,
+		})
+		if err != nil {
+			return err
+		}
+		if resp.Status != healthpb.HealthCheckResponse_SERVING {
+			return status.Errorf(codes.Unavailable, "aggregator %s", resp.Status)
+		}
+		return nil
+	})
+}
+
+// call runs fn against the replica picked by the balancer and reports the
+// outcome back to it.
+func (c *GRPCClient) call(ctx context.Context, fn func(context.Context, *grpc.ClientConn) error) error {
+	ep, err := c.balancer.Pick(ctx)
+	if err != nil {
+		return apperr.Wrap(apperr.Unavailable, err, "no aggregator available")
+	}
+	conn, err := c.conn(ep.Addr)
+	if err != nil {
+		c.balancer.Done(ep, err)
+		return apperr.Wrap(apperr.Unavailable, err, "dialing aggregator %s", ep.Addr)
+	}
+	if IsForwarded(ctx) {
+		ctx = metadata.AppendToOutgoingContext(ctx, ForwardedMetadataKey, "1")
+	}
+	err = fn(ctx, conn)
+	c.balancer.Done(ep, unhealthy(err))
+	return apperr.FromGRPC(err)
+}
+
+// Close tears down the connections to every replica.
+func (c *GRPCClient) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var firstErr error
+	for _, conn := range c.conns {
+		if err := conn.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	c.conns = make(map[string]*grpc.ClientConn)
+	return firstErr
+}
+
+func (c *GRPCClient) conn(addr string) (*grpc.ClientConn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if conn, ok := c.conns[addr]; ok {
+		return conn, nil
+	}
+	conn, err := grpc.Dial(dialTarget(addr), grpc.WithTransportCredentials(c.creds), tracing.GRPCDialOption())
+	if err != nil {
+		return nil, err
+	}
+	c.conns[addr] = conn
+	return conn, nil
+}
+
+// dialTarget turns an address a resolver lists for both transports into
+// a gRPC target. gRPC would take the scheme of an http:// or https:// URL
+// for the name of a resolver, so it is dropped along with any path.
+func dialTarget(addr string) string {
+	u, err := url.Parse(addr)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return addr
+	}
+	return u.Host
+}
+
+// unhealthy filters out errors that are the caller's fault rather than the
+// replica's, so they don't count towards ejection.
+func unhealthy(err error) error {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.Internal:
+		return err
+	}
+	return nil
 }
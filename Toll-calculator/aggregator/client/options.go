@@ -0,0 +1,47 @@
+package client
+
+import (
+	"crypto/tls"
+	"net/http"
+
+	"github.com/0x0Glitch/toll-calculator/tracing"
+)
+
+// Option configures how a client connects to the aggregator.
+type Option func(*options)
+
+type options struct {
+	tls *tls.Config
+}
+
+// WithTLS makes the client connect over TLS with cfg, which typically
+// presents a client certificate for mutual TLS. Endpoints given as a bare
+// host:port are then reached over https.
+func WithTLS(cfg *tls.Config) Option {
+	return func(o *options) {
+		o.tls = cfg
+	}
+}
+
+func newOptions(opts []Option) options {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// httpClient returns the client sending the requests, which propagates the
+// caller's trace to the aggregator.
+func (o options) httpClient() *http.Client {
+	if o.tls == nil {
+		return defaultHTTPClient
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = o.tls
+	return &http.Client{Transport: tracing.HTTPTransport(transport)}
+}
+
+// defaultHTTPClient is shared by the plaintext clients, so they share its
+// connection pool.
+var defaultHTTPClient = &http.Client{Transport: tracing.HTTPTransport(nil)}
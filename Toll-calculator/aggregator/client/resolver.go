@@ -0,0 +1,163 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/0x0Glitch/toll-calculator/filewatch"
+)
+
+// Resolver returns the current set of aggregator addresses a client may talk to.
+type Resolver interface {
+	Resolve(context.Context) ([]string, error)
+}
+
+// StaticResolver always resolves to the same fixed list of addresses.
+type StaticResolver struct {
+	addrs []string
+}
+
+func NewStaticResolver(addrs ...string) *StaticResolver {
+	return &StaticResolver{
+		addrs: addrs,
+	}
+}
+
+func (r *StaticResolver) Resolve(_ context.Context) ([]string, error) {
+	if len(r.addrs) == 0 {
+		return nil, fmt.Errorf("static resolver has no addresses")
+	}
+	return r.addrs, nil
+}
+
+// SRVResolver looks up aggregator replicas through DNS SRV records,
+// e.g. _aggregator._tcp.toll.svc.cluster.local.
+type SRVResolver struct {
+	service string
+	proto   string
+	name    string
+	lookup  func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+func NewSRVResolver(service, proto, name string) *SRVResolver {
+	return &SRVResolver{
+		service: service,
+		proto:   proto,
+		name:    name,
+		lookup:  net.DefaultResolver.LookupSRV,
+	}
+}
+
+func (r *SRVResolver) Resolve(ctx context.Context) ([]string, error) {
+	_, records, err := r.lookup(ctx, r.service, r.proto, r.name)
+	if err != nil {
+		return nil, err
+	}
+	addrs := make([]string, 0, len(records))
+	for _, rec := range records {
+		host := strings.TrimSuffix(rec.Target, ".")
+		addrs = append(addrs, net.JoinHostPort(host, fmt.Sprint(rec.Port)))
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no SRV records for %s", r.name)
+	}
+	return addrs, nil
+}
+
+// FileResolver reads one address per line from a file and re-reads it
+// whenever the file's modification time changes. Blank lines and lines
+// starting with # are ignored.
+type FileResolver struct {
+	path  string
+	files *filewatch.Files
+
+	mu    sync.Mutex
+	addrs []string
+}
+
+func NewFileResolver(path string) *FileResolver {
+	return &FileResolver{
+		path:  path,
+		files: filewatch.New(path),
+	}
+}
+
+func (r *FileResolver) Resolve(_ context.Context) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.addrs != nil && !r.files.Changed() {
+		return r.addrs, nil
+	}
+	err := r.files.Load(func() error {
+		addrs, err := readAddrs(r.path)
+		if err != nil {
+			return err
+		}
+		r.addrs = addrs
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r.addrs, nil
+}
+
+func readAddrs(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var addrs []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		addrs = append(addrs, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("endpoint file %s has no addresses", path)
+	}
+	return addrs, nil
+}
+
+// ParseResolver builds a resolver from a target spec:
+//
+//	http://a:3000,http://b:3000   static list (also "static://a:3000,b:3000")
+//	srv://_aggregator._tcp.example.com
+//	file:///etc/toll/aggregators
+func ParseResolver(target string) (Resolver, error) {
+	switch {
+	case strings.HasPrefix(target, "srv://"):
+		parts := strings.SplitN(strings.TrimPrefix(target, "srv://"), ".", 3)
+		if len(parts) != 3 || !strings.HasPrefix(parts[0], "_") || !strings.HasPrefix(parts[1], "_") {
+			return nil, fmt.Errorf("invalid SRV target %q, expected srv://_service._proto.name", target)
+		}
+		return NewSRVResolver(parts[0][1:], parts[1][1:], parts[2]), nil
+	case strings.HasPrefix(target, "file://"):
+		return NewFileResolver(strings.TrimPrefix(target, "file://")), nil
+	}
+	target = strings.TrimPrefix(target, "static://")
+	var addrs []string
+	for _, addr := range strings.Split(target, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			addrs = append(addrs, addr)
+		}
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("empty resolver target")
+	}
+	return NewStaticResolver(addrs...), nil
+}
@@ -0,0 +1,273 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/0x0Glitch/toll-calculator/aggregator/client"
+	"github.com/0x0Glitch/toll-calculator/types"
+	"github.com/sirupsen/logrus"
+)
+
+// Aggregator is the aggregator service a node wraps. It matches the
+// aggregator's own interface so the service can be passed in directly.
+type Aggregator interface {
+	AggregateDistance(*types.Distance) error
+	CalculateInvoice(int32) (*types.Invoice, error)
+}
+
+// Store is the part of the aggregator's storage a node needs to hand the
+// totals of shards it no longer owns over to their new owner.
+type Store interface {
+	Insert(*types.Distance) error
+	IDs() []int32
+	// Take removes the totals of the OBU and returns them by day.
+	Take(int32) (types.DailyTotals, error)
+}
+
+// Dialer returns a client for the peer node at addr.
+type Dialer func(addr string) (client.Client, error)
+
+// Node owns a share of the OBU ID space. Requests for OBUs owned by another
+// member are forwarded to it, and totals are handed over whenever the
+// membership changes.
+type Node struct {
+	self     string
+	local    Aggregator
+	store    Store
+	dial     Dialer
+	replicas int
+
+	mu    sync.RWMutex
+	ring  *Ring
+	peers map[string]client.Client
+
+	// rebalanceMu serialises handoffs so two membership changes never move
+	// the same totals at once.
+	rebalanceMu sync.Mutex
+}
+
+type NodeOption func(*Node)
+
+// WithReplicas sets the number of virtual nodes each member gets on the ring.
+func WithReplicas(n int) NodeOption {
+	return func(node *Node) {
+		node.replicas = n
+	}
+}
+
+// NewNode returns a node that starts out as the only member of its cluster.
+// self is the address other members use to reach this node.
+func NewNode(self string, local Aggregator, store Store, dial Dialer, opts ...NodeOption) *Node {
+	n := &Node{
+		self:     self,
+		local:    local,
+		store:    store,
+		dial:     dial,
+		replicas: defaultReplicas,
+		peers:    make(map[string]client.Client),
+	}
+	for _, opt := range opts {
+		opt(n)
+	}
+	n.ring = NewRing([]string{self}, n.replicas)
+	return n
+}
+
+func (n *Node) AggregateDistance(distance *types.Distance) error {
+	owner := n.Owner(distance.OBUID)
+	if owner == n.self {
+		return n.local.AggregateDistance(distance)
+	}
+	peer, err := n.peer(owner)
+	if err != nil {
+		return err
+	}
+	return peer.Aggregate(client.WithForwarded(context.Background()), &types.AggregatorRequest{
+		ObuID:     distance.OBUID,
+		Value:     distance.Values,
+		Unix:      distance.Unix,
+		ZoneID:    distance.ZoneID,
+		Estimated: distance.Estimated,
+		Trip:      distance.Trip.Proto(),
+		OffPeak:   distance.OffPeak,
+	})
+}
+
+func (n *Node) CalculateInvoice(obuID int32) (*types.Invoice, error) {
+	owner := n.Owner(obuID)
+	if owner == n.self {
+		return n.local.CalculateInvoice(obuID)
+	}
+	peer, err := n.peer(owner)
+	if err != nil {
+		return nil, err
+	}
+	return peer.GetInvoice(client.WithForwarded(context.Background()), int(obuID))
+}
+
+// Local returns the node's own aggregator. Transports use it for requests
+// another node already forwarded here.
+func (n *Node) Local() Aggregator {
+	return n.local
+}
+
+// Self returns the address of this node.
+func (n *Node) Self() string {
+	return n.self
+}
+
+// Owner returns the member that owns the given OBU ID.
+func (n *Node) Owner(obuID int32) string {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return n.ring.Owner(obuID)
+}
+
+// Members returns the current cluster members.
+func (n *Node) Members() []string {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return n.ring.Members()
+}
+
+// SetMembers replaces the cluster membership and hands the totals of every
+// OBU this node no longer owns over to its new owner. Leaving self out of
+// members drains the node completely.
+func (n *Node) SetMembers(members []string) error {
+	n.rebalanceMu.Lock()
+	defer n.rebalanceMu.Unlock()
+
+	if len(members) == 0 {
+		members = []string{n.self}
+	}
+	ring := NewRing(members, n.replicas)
+
+	n.mu.Lock()
+	n.ring = ring
+	for addr := range n.peers {
+		if !slices.Contains(ring.members, addr) {
+			delete(n.peers, addr)
+		}
+	}
+	n.mu.Unlock()
+
+	return n.handoff(ring)
+}
+
+// Leave removes this node from the ring and hands all of its totals to the
+// remaining members.
+func (n *Node) Leave() error {
+	var rest []string
+	for _, m := range n.Members() {
+		if m != n.self {
+			rest = append(rest, m)
+		}
+	}
+	if len(rest) == 0 {
+		return fmt.Errorf("node %s is the last member of the cluster", n.self)
+	}
+	return n.SetMembers(rest)
+}
+
+// HandOff hands the totals of every OBU this node holds but doesn't own
+// over to its owner, as a membership change does.
+func (n *Node) HandOff() error {
+	n.rebalanceMu.Lock()
+	defer n.rebalanceMu.Unlock()
+	n.mu.RLock()
+	ring := n.ring
+	n.mu.RUnlock()
+	return n.handoff(ring)
+}
+
+// Watch polls the resolver for the cluster membership until ctx is done.
+// Every poll also re-runs the handoff, so totals that reached this node
+// from members with a stale view of the ring eventually move on too.
+func (n *Node) Watch(ctx context.Context, r client.Resolver, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		members, err := r.Resolve(ctx)
+		if err != nil {
+			logrus.Errorf("resolving cluster members: %s", err)
+		} else if err := n.SetMembers(members); err != nil {
+			logrus.Errorf("rebalancing cluster: %s", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (n *Node) handoff(ring *Ring) error {
+	var errs []error
+	moved := 0
+	for _, obuID := range n.store.IDs() {
+		owner := ring.Owner(obuID)
+		if owner == n.self {
+			continue
+		}
+		days, err := n.store.Take(obuID)
+		if err != nil {
+			continue
+		}
+		failed := false
+		// The parts keep the day they were travelled on, so the new owner
+		// bills them in the same period.
+		for _, part := range days.Parts(obuID) {
+			if err := n.transfer(owner, part); err != nil {
+				// Put the part back so it isn't lost; the next rebalance retries.
+				n.store.Insert(part)
+				errs = append(errs, fmt.Errorf("handing OBU %d to %s: %w", obuID, owner, err))
+				failed = true
+			}
+		}
+		if !failed {
+			moved++
+		}
+	}
+	if moved > 0 {
+		logrus.WithFields(logrus.Fields{
+			"node":    n.self,
+			"moved":   moved,
+			"members": ring.Members(),
+		}).Info("handed off shards")
+	}
+	return errors.Join(errs...)
+}
+
+func (n *Node) transfer(owner string, d *types.Distance) error {
+	peer, err := n.peer(owner)
+	if err != nil {
+		return err
+	}
+	return peer.Aggregate(client.WithForwarded(context.Background()), &types.AggregatorRequest{
+		ObuID:     d.OBUID,
+		Value:     d.Values,
+		Unix:      d.Unix,
+		ZoneID:    d.ZoneID,
+		Estimated: d.Estimated,
+		OffPeak:   d.OffPeak,
+	})
+}
+
+func (n *Node) peer(addr string) (client.Client, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if c, ok := n.peers[addr]; ok {
+		return c, nil
+	}
+	c, err := n.dial(addr)
+	if err != nil {
+		return nil, fmt.Errorf("dialing cluster member %s: %w", addr, err)
+	}
+	n.peers[addr] = c
+	return c, nil
+}
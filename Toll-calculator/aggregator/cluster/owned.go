@@ -0,0 +1,46 @@
+package cluster
+
+import (
+	"time"
+
+	"github.com/0x0Glitch/toll-calculator/types"
+	"github.com/sirupsen/logrus"
+)
+
+// ClosingStore is the store a member closes billing periods from.
+type ClosingStore interface {
+	Store
+	TakeBefore(id int32, end time.Time) (types.DailyTotals, error)
+}
+
+// OwnedStore is the store of a member as its billing closer sees it. The
+// totals of a vehicle may be split over the members that owned it, after a
+// handoff failed or a member with a stale view of the ring took distance.
+// If each closed its part, the vehicle would get two invoices with the
+// same ID, so only the owner bills a vehicle, once the others handed it
+// their totals.
+type OwnedStore struct {
+	ClosingStore
+	node *Node
+}
+
+// Owned returns the store as the closer of this node sees it.
+func (n *Node) Owned(store ClosingStore) *OwnedStore {
+	return &OwnedStore{ClosingStore: store, node: n}
+}
+
+// IDs hands the totals of the vehicles another member owns over to it and
+// returns the vehicles this node owns.
+func (s *OwnedStore) IDs() []int32 {
+	if err := s.node.HandOff(); err != nil {
+		// What couldn't be handed off is billed by the owner once it is.
+		logrus.WithError(err).Warn("handing off totals before closing a period")
+	}
+	var ids []int32
+	for _, id := range s.ClosingStore.IDs() {
+		if s.node.Owner(id) == s.node.self {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
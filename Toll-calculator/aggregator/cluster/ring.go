@@ -0,0 +1,88 @@
+package cluster
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+// defaultReplicas is the number of virtual nodes each member gets on the
+// ring. More virtual nodes spread OBU IDs more evenly between members.
+const defaultReplicas = 128
+
+// Ring is a consistent hash ring mapping OBU IDs to cluster members.
+// A Ring is immutable once built; membership changes build a new one.
+type Ring struct {
+	hashes  []uint32
+	owners  map[uint32]string
+	members []string
+}
+
+func NewRing(members []string, replicas int) *Ring {
+	if replicas <= 0 {
+		replicas = defaultReplicas
+	}
+	r := &Ring{
+		owners: make(map[uint32]string, len(members)*replicas),
+	}
+	seen := make(map[string]bool, len(members))
+	for _, m := range members {
+		if seen[m] {
+			continue
+		}
+		seen[m] = true
+		r.members = append(r.members, m)
+		for i := 0; i < replicas; i++ {
+			h := hashString(m + "#" + strconv.Itoa(i))
+			r.owners[h] = m
+			r.hashes = append(r.hashes, h)
+		}
+	}
+	sort.Strings(r.members)
+	sort.Slice(r.hashes, func(i, j int) bool { return r.hashes[i] < r.hashes[j] })
+	return r
+}
+
+// Owner returns the member that owns the given OBU ID, or "" for an empty ring.
+func (r *Ring) Owner(obuID int32) string {
+	if len(r.hashes) == 0 {
+		return ""
+	}
+	h := hashOBU(obuID)
+	i := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= h })
+	if i == len(r.hashes) {
+		i = 0
+	}
+	return r.owners[r.hashes[i]]
+}
+
+// Members returns the sorted list of members on the ring.
+func (r *Ring) Members() []string {
+	return append([]string(nil), r.members...)
+}
+
+func hashString(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return mix(h.Sum32())
+}
+
+func hashOBU(obuID int32) uint32 {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(obuID))
+	h := fnv.New32a()
+	h.Write(b[:])
+	return mix(h.Sum32())
+}
+
+// mix is the murmur3 finalizer. FNV alone clusters sequential OBU IDs
+// into neighbouring positions on the ring.
+func mix(h uint32) uint32 {
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return h
+}
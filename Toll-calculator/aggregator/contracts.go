@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/0x0Glitch/toll-calculator/aggregator/client"
+	"github.com/0x0Glitch/toll-calculator/aggregator/cluster"
+	"github.com/0x0Glitch/toll-calculator/apperr"
+	"github.com/0x0Glitch/toll-calculator/contract"
+	"github.com/0x0Glitch/toll-calculator/types"
+)
+
+// Contracts manages the contracts of fleet customers. The HTTP client of
+// a peer node implements it too.
+type Contracts interface {
+	Contracts(ctx context.Context) ([]types.Contract, error)
+	Contract(ctx context.Context, id string) (*types.Contract, error)
+	PutContract(ctx context.Context, c types.Contract) (*types.Contract, error)
+	DeleteContract(ctx context.Context, id string) error
+}
+
+// localContracts are the contracts of this node alone.
+type localContracts struct {
+	registry *contract.Registry
+}
+
+func (l localContracts) Contracts(ctx context.Context) ([]types.Contract, error) {
+	return l.registry.Contracts(), nil
+}
+
+func (l localContracts) Contract(ctx context.Context, id string) (*types.Contract, error) {
+	c, err := l.registry.Contract(id)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (l localContracts) PutContract(ctx context.Context, c types.Contract) (*types.Contract, error) {
+	if err := l.registry.Put(c); err != nil {
+		return nil, err
+	}
+	return l.Contract(ctx, c.ID)
+}
+
+func (l localContracts) DeleteContract(ctx context.Context, id string) error {
+	return l.registry.Delete(id)
+}
+
+// clusterContracts keeps every contract on every member, since a vehicle
+// is billed by whichever node holds its totals when a period closes.
+// Changes are sent to all members and reads are served by this one.
+type clusterContracts struct {
+	node  *cluster.Node
+	local Contracts
+	opts  []client.Option
+}
+
+// peers returns clients of the other members, marked as forwarded so they
+// only change their own contracts.
+func (c *clusterContracts) peers(ctx context.Context) (context.Context, map[string]Contracts) {
+	peers := make(map[string]Contracts)
+	for _, addr := range c.node.Members() {
+		if addr != c.node.Self() {
+			peers[addr] = client.NewHTTPClient(addr, c.opts...)
+		}
+	}
+	return client.WithForwarded(ctx), peers
+}
+
+func (c *clusterContracts) Contracts(ctx context.Context) ([]types.Contract, error) {
+	return c.local.Contracts(ctx)
+}
+
+func (c *clusterContracts) Contract(ctx context.Context, id string) (*types.Contract, error) {
+	return c.local.Contract(ctx, id)
+}
+
+// PutContract saves the contract on this node, which rejects an invalid or
+// conflicting one, then on every other member. Putting it again retries
+// the members that failed.
+func (c *clusterContracts) PutContract(ctx context.Context, con types.Contract) (*types.Contract, error) {
+	saved, err := c.local.PutContract(ctx, con)
+	if err != nil {
+		return nil, err
+	}
+	fctx, peers := c.peers(ctx)
+	var errs []error
+	for addr, p := range peers {
+		if _, err := p.PutContract(fctx, con); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", addr, err))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, apperr.Wrap(apperr.Unavailable, err, "saving contract %s", con.ID)
+	}
+	return saved, nil
+}
+
+// DeleteContract deletes the contract on every member. It is only not
+// found if no member had it.
+func (c *clusterContracts) DeleteContract(ctx context.Context, id string) error {
+	found := true
+	if err := c.local.DeleteContract(ctx, id); apperr.IsCode(err, apperr.NotFound) {
+		found = false
+	} else if err != nil {
+		return err
+	}
+	fctx, peers := c.peers(ctx)
+	var errs []error
+	for addr, p := range peers {
+		err := p.DeleteContract(fctx, id)
+		switch {
+		case err == nil:
+			found = true
+		case !apperr.IsCode(err, apperr.NotFound):
+			errs = append(errs, fmt.Errorf("%s: %w", addr, err))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return apperr.Wrap(apperr.Unavailable, err, "deleting contract %s", id)
+	}
+	if !found {
+		return apperr.NotFoundf("couldn't find contract %s", id)
+	}
+	return nil
+}
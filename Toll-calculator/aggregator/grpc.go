@@ -3,31 +3,68 @@ package main
 import (
 	"context"
 
+	"github.com/0x0Glitch/toll-calculator/aggregator/client"
+	"github.com/0x0Glitch/toll-calculator/apperr"
 	"github.com/0x0Glitch/toll-calculator/types"
 )
 
 type GRPCAggregatorServer struct {
 	types.UnimplementedAggregatorServer
-	svc Aggregator
+	svc   Aggregator
+	local Aggregator
 }
 
-func NewAggregatorGRPCServer(svc Aggregator) *GRPCAggregatorServer {
+// NewAggregatorGRPCServer serves svc. Calls another cluster node forwarded
+// here go straight to local; outside a cluster both are the same service.
+func NewAggregatorGRPCServer(svc, local Aggregator) *GRPCAggregatorServer {
 	return &GRPCAggregatorServer{
-		svc: svc,
+		svc:   svc,
+		local: local,
 	}
 }
 
 // Aggregate implements the Aggregate RPC method from the protobuf definition
 func (s *GRPCAggregatorServer) Aggregate(ctx context.Context, req *types.AggregatorRequest) (*types.Empty, error) {
 	distance := types.Distance{
-		OBUID:  int32(req.ObuID),
-		Values: req.Value,
-		Unix:   req.Unix,
+		OBUID:     int32(req.ObuID),
+		Values:    req.Value,
+		Unix:      req.Unix,
+		ZoneID:    req.ZoneID,
+		Estimated: req.Estimated,
+		Trip:      req.Trip.Leg(),
+		OffPeak:   req.OffPeak,
 	}
-	err := s.svc.AggregateDistance(&distance)
-	return &types.Empty{}, err
+	svc := s.svc
+	if client.IsForwardedIncoming(ctx) {
+		svc = s.local
+	}
+	if err := svc.AggregateDistance(&distance); err != nil {
+		return nil, apperr.ToGRPC(err)
+	}
+	return &types.Empty{}, nil
 }
 
-func (s *GRPCAggregatorServer) CalculateInvoice(ctx context.Context, req *types.Invoice) (*types.Invoice, error) {
-	return s.svc.CalculateInvoice(int32(req.OBUID))
+// GetInvoice implements the GetInvoice RPC method from the protobuf definition
+func (s *GRPCAggregatorServer) GetInvoice(ctx context.Context, req *types.GetInvoiceRequest) (*types.InvoiceResponse, error) {
+	svc := s.svc
+	if client.IsForwardedIncoming(ctx) {
+		svc = s.local
+	}
+	inv, err := svc.CalculateInvoice(req.ObuID)
+	if err != nil {
+		return nil, apperr.ToGRPC(err)
+	}
+	resp := &types.InvoiceResponse{
+		ObuID:             inv.OBUID,
+		TotalDistance:     inv.TotalDistance,
+		Amount:            inv.Amount.String(),
+		Tax:               inv.Tax.String(),
+		Gross:             inv.Gross.String(),
+		Currency:          string(inv.Currency),
+		EstimatedDistance: inv.EstimatedDistance,
+	}
+	for _, z := range inv.Zones {
+		resp.Zones = append(resp.Zones, &types.ZoneCharge{ZoneID: z.ZoneID, Distance: z.Distance, Amount: z.Amount.String(), Estimated: z.Estimated})
+	}
+	return resp, nil
 }
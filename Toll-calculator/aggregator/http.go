@@ -1,24 +1,23 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/0x0Glitch/toll-calculator/aggregator/client"
+	"github.com/0x0Glitch/toll-calculator/apperr"
+	"github.com/0x0Glitch/toll-calculator/export"
+	"github.com/0x0Glitch/toll-calculator/tax"
+	"github.com/0x0Glitch/toll-calculator/trip"
 	"github.com/0x0Glitch/toll-calculator/types"
-	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/sirupsen/logrus"
 )
 
-type HTTPMetricHandler struct {
-	reqCounter prometheus.Counter
-	reqLatency prometheus.Histogram
-	errCounter prometheus.Counter
-}
-
 type HTTPFunc func(http.ResponseWriter, *http.Request) error
 
 type APIError struct {
@@ -30,52 +29,40 @@ func makeHTTPHandlerFunc(fn HTTPFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if err := fn(w, r); err != nil {
 			if apiErr, ok := err.(APIError); ok {
-				writeJSON(w, apiErr.code, map[string]string{"error": apiErr.Error()})
+				writeJSON(w, apiErr.code, apperr.BodyOf(apiErr.Err))
 				return
 			}
-			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			writeJSON(w, apperr.HTTPStatus(err), apperr.BodyOf(err))
 		}
 	}
 }
 
+// forwardedTo hands requests another cluster node already forwarded here
+// to local, so they're handled by this node instead of being forwarded again.
+func forwardedTo(fn, local HTTPFunc) HTTPFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		if r.Header.Get(client.ForwardedHeader) != "" {
+			return local(w, r)
+		}
+		return fn(w, r)
+	}
+}
+
 // Error implemets the error interface
 func (e APIError) Error() string {
 	return e.Err.Error()
 }
 
-func NewHTTPMetricHandler(reqName string) *HTTPMetricHandler {
-	return &HTTPMetricHandler{
-		reqCounter: promauto.NewCounter(prometheus.CounterOpts{
-			Namespace: fmt.Sprintf("http_%s_%s", reqName, "request_counter"),
-			Name:      "aggregator",
-		}),
-		reqLatency: promauto.NewHistogram(prometheus.HistogramOpts{
-			Namespace: fmt.Sprintf("http_%s_%s", reqName, "request_latency"),
-			Name:      "aggregator",
-			Buckets:   []float64{0.1, 0.5, 1},
-		}),
-		errCounter: promauto.NewCounter(prometheus.CounterOpts{
-			Namespace: fmt.Sprintf("http_%s_%s", reqName, "error_counter"),
-			Name:      "aggregator",
-		}),
-	}
-}
-
-func (h *HTTPMetricHandler) Instrument(next HTTPFunc) HTTPFunc {
+// withRequestLog logs the latency and outcome of every request.
+func withRequestLog(next HTTPFunc) HTTPFunc {
 	return func(w http.ResponseWriter, r *http.Request) error {
 		var err error
 		defer func(start time.Time) {
-			latency := time.Since(start).Seconds()
 			logrus.WithFields(logrus.Fields{
-				"latency": latency,
+				"latency": time.Since(start).Seconds(),
 				"request": r.RequestURI,
 				"err":     err,
 			}).Info("HTTP request latency")
-			h.reqLatency.Observe(latency)
-			h.reqCounter.Inc()
-			if err != nil {
-				h.errCounter.Inc()
-			}
 		}(time.Now())
 		err = next(w, r)
 		return err
@@ -87,33 +74,24 @@ func handleGetInvoice(svc Aggregator) HTTPFunc {
 		if r.Method != http.MethodGet {
 			return APIError{
 				code: http.StatusMethodNotAllowed,
-				Err:  fmt.Errorf("invalid HTTP method %v", r.Method),
+				Err:  apperr.InvalidArgumentf("invalid HTTP method %v", r.Method),
 			}
 		}
 		values, ok := r.URL.Query()["obu"]
 		// obuID := r.URL.Query()["obu"][0]
 		if !ok {
-			return APIError{
-				code: http.StatusBadRequest,
-				Err:  fmt.Errorf("missing OBU ID"),
-			}
+			return apperr.InvalidArgumentf("missing OBU ID")
 
 		}
 
 		obuID, err := strconv.Atoi(values[0])
 		if err != nil {
-			return APIError{
-				code: http.StatusBadRequest,
-				Err:  fmt.Errorf("invalid OBU ID %v", values[0]),
-			}
+			return apperr.InvalidArgumentf("invalid OBU ID %v", values[0])
 		}
 
 		invoice, err := svc.CalculateInvoice(int32(obuID))
 		if err != nil {
-			return APIError{
-				code: http.StatusInternalServerError,
-				Err:  fmt.Errorf("failed to calculate invoice for OBU ID %v", obuID),
-			}
+			return fmt.Errorf("failed to calculate invoice for OBU ID %v: %w", obuID, err)
 
 		}
 		return writeJSON(w, http.StatusOK, invoice)
@@ -125,22 +103,339 @@ func handleAggregate(svc Aggregator) HTTPFunc {
 		if r.Method != http.MethodPost {
 			return APIError{
 				code: http.StatusMethodNotAllowed,
-				Err:  fmt.Errorf("invalid HTTP method %v", r.Method),
+				Err:  apperr.InvalidArgumentf("invalid HTTP method %v", r.Method),
 			}
 		}
 		var distance types.Distance
 		if err := json.NewDecoder(r.Body).Decode(&distance); err != nil {
+			return apperr.InvalidArgumentf("failed to decode distance: %v", err)
+		}
+		if err := svc.AggregateDistance(&distance); err != nil {
+			return fmt.Errorf("failed to aggregate distance: %w", err)
+		}
+		return writeJSON(w, http.StatusOK, map[string]string{"message": "distance aggregated successfully"})
+	}
+}
+
+func handleGetTrips(trips trip.Lister) HTTPFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		if r.Method != http.MethodGet {
 			return APIError{
-				code: http.StatusBadRequest,
-				Err:  fmt.Errorf("failed to decode distance: %v", err),
+				code: http.StatusMethodNotAllowed,
+				Err:  apperr.InvalidArgumentf("invalid HTTP method %v", r.Method),
 			}
 		}
-		if err := svc.AggregateDistance(&distance); err != nil {
+		obuID, err := queryOBU(r)
+		if err != nil {
+			return err
+		}
+		q := r.URL.Query()
+		from, err := parseTime(q.Get("from"))
+		if err != nil {
+			return apperr.InvalidArgumentf("invalid from %q: want RFC 3339", q.Get("from"))
+		}
+		to, err := parseTime(q.Get("to"))
+		if err != nil {
+			return apperr.InvalidArgumentf("invalid to %q: want RFC 3339", q.Get("to"))
+		}
+		list, err := trips.Trips(r.Context(), obuID, from, to)
+		if err != nil {
+			return fmt.Errorf("failed to list trips of OBU ID %v: %w", obuID, err)
+		}
+		return writeJSON(w, http.StatusOK, list)
+	}
+}
+
+func handleGetInvoices(inv Invoicing) HTTPFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		if r.Method != http.MethodGet {
 			return APIError{
-				code: http.StatusInternalServerError,
-				Err:  fmt.Errorf("failed to aggregate distance: %v", err),
+				code: http.StatusMethodNotAllowed,
+				Err:  apperr.InvalidArgumentf("invalid HTTP method %v", r.Method),
 			}
 		}
-		return writeJSON(w, http.StatusOK, map[string]string{"message": "distance aggregated successfully"})
+		obuID, err := queryOBU(r)
+		if err != nil {
+			return err
+		}
+		q := r.URL.Query()
+		from, err := parseTime(q.Get("from"))
+		if err != nil {
+			return apperr.InvalidArgumentf("invalid from %q: want RFC 3339", q.Get("from"))
+		}
+		to, err := parseTime(q.Get("to"))
+		if err != nil {
+			return apperr.InvalidArgumentf("invalid to %q: want RFC 3339", q.Get("to"))
+		}
+		invoices, err := inv.Invoices(r.Context(), obuID, from, to)
+		if err != nil {
+			return fmt.Errorf("failed to list invoices of OBU ID %v: %w", obuID, err)
+		}
+		return writeJSON(w, http.StatusOK, invoices)
+	}
+}
+
+func handleGetCreditNotes(inv Invoicing) HTTPFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		if r.Method != http.MethodGet {
+			return APIError{
+				code: http.StatusMethodNotAllowed,
+				Err:  apperr.InvalidArgumentf("invalid HTTP method %v", r.Method),
+			}
+		}
+		obuID, err := queryOBU(r)
+		if err != nil {
+			return err
+		}
+		notes, err := inv.CreditNotes(r.Context(), obuID)
+		if err != nil {
+			return fmt.Errorf("failed to list credit notes of OBU ID %v: %w", obuID, err)
+		}
+		return writeJSON(w, http.StatusOK, notes)
+	}
+}
+
+// handleInvoiceAction serves POST /admin/invoices/<action>?id=, where
+// action is finalize, pay, void or credit.
+func handleInvoiceAction(inv Invoicing) HTTPFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		if r.Method != http.MethodPost {
+			return APIError{
+				code: http.StatusMethodNotAllowed,
+				Err:  apperr.InvalidArgumentf("invalid HTTP method %v", r.Method),
+			}
+		}
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			return apperr.InvalidArgumentf("missing invoice ID")
+		}
+		var (
+			v   any
+			err error
+		)
+		switch action := strings.TrimPrefix(r.URL.Path, "/admin/invoices/"); action {
+		case "finalize":
+			v, err = inv.FinalizeInvoice(r.Context(), id)
+		case "pay":
+			v, err = inv.PayInvoice(r.Context(), id)
+		case "void":
+			v, err = inv.VoidInvoice(r.Context(), id, r.URL.Query().Get("reason"))
+		case "credit":
+			var note types.CreditNote
+			if err := json.NewDecoder(r.Body).Decode(&note); err != nil {
+				return apperr.InvalidArgumentf("failed to decode credit note: %v", err)
+			}
+			v, err = inv.CreditInvoice(r.Context(), id, note.Reason, note.Lines)
+		default:
+			return apperr.NotFoundf("unknown invoice action %q", action)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to update invoice %s: %w", id, err)
+		}
+		return writeJSON(w, http.StatusOK, v)
+	}
+}
+
+func handleCloseInvoices(inv Invoicing) HTTPFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		if r.Method != http.MethodPost {
+			return APIError{
+				code: http.StatusMethodNotAllowed,
+				Err:  apperr.InvalidArgumentf("invalid HTTP method %v", r.Method),
+			}
+		}
+		period := r.URL.Query().Get("period")
+		if period == "" {
+			return apperr.InvalidArgumentf("missing period")
+		}
+		n, err := inv.CloseInvoices(r.Context(), period)
+		if err != nil {
+			return fmt.Errorf("failed to close period %s: %w", period, err)
+		}
+		return writeJSON(w, http.StatusOK, map[string]any{"period": period, "invoices": n})
+	}
+}
+
+// handleExportInvoices serves GET /invoices/export?period=&format=, which
+// streams every invoice of the period as CSV or JSON Lines.
+func handleExportInvoices(inv Invoicing) HTTPFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		if r.Method != http.MethodGet {
+			return APIError{
+				code: http.StatusMethodNotAllowed,
+				Err:  apperr.InvalidArgumentf("invalid HTTP method %v", r.Method),
+			}
+		}
+		q := r.URL.Query()
+		period := q.Get("period")
+		if period == "" {
+			return apperr.InvalidArgumentf("missing period")
+		}
+		format, err := export.ParseFormat(q.Get("format"))
+		if err != nil {
+			return apperr.InvalidArgumentf("%v", err)
+		}
+		err = export.Serve(w, format, period, func(fn func(types.Invoice) error) error {
+			return inv.ExportInvoices(r.Context(), period, fn)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to export invoices of period %s: %w", period, err)
+		}
+		return nil
+	}
+}
+
+// handleGetTaxSummary serves GET /invoices/tax-summary?period=, the taxes
+// of the invoices issued for the period less their credit notes, per
+// jurisdiction, treatment and rate.
+func handleGetTaxSummary(inv Invoicing) HTTPFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		if r.Method != http.MethodGet {
+			return APIError{
+				code: http.StatusMethodNotAllowed,
+				Err:  apperr.InvalidArgumentf("invalid HTTP method %v", r.Method),
+			}
+		}
+		period := r.URL.Query().Get("period")
+		if period == "" {
+			return apperr.InvalidArgumentf("missing period")
+		}
+		summary := tax.NewSummary(period)
+		issued := make(map[string]bool)
+		var obuIDs []int32
+		err := inv.ExportInvoices(r.Context(), period, func(i types.Invoice) error {
+			summary.AddInvoice(i)
+			if i.Status != types.InvoiceDraft && i.Status != types.InvoiceVoid {
+				issued[i.ID] = true
+				obuIDs = append(obuIDs, i.OBUID)
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to sum the taxes of period %s: %w", period, err)
+		}
+		for _, obuID := range obuIDs {
+			notes, err := inv.CreditNotes(r.Context(), obuID)
+			if err != nil {
+				return fmt.Errorf("failed to sum the taxes of period %s: %w", period, err)
+			}
+			for _, n := range notes {
+				if issued[n.InvoiceID] {
+					summary.AddCreditNote(n)
+				}
+			}
+		}
+		return writeJSON(w, http.StatusOK, summary)
+	}
+}
+
+// handleGetInvoiceDocument serves GET /invoices/document?id=&format=, the
+// invoice with the ID as JSON or, with format=pdf, as a printable PDF
+// listing the vehicle's trips in the period.
+func handleGetInvoiceDocument(inv Invoicing, trips trip.Lister) HTTPFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		if r.Method != http.MethodGet {
+			return APIError{
+				code: http.StatusMethodNotAllowed,
+				Err:  apperr.InvalidArgumentf("invalid HTTP method %v", r.Method),
+			}
+		}
+		q := r.URL.Query()
+		id := q.Get("id")
+		if id == "" {
+			return apperr.InvalidArgumentf("missing invoice ID")
+		}
+		format := q.Get("format")
+		if format != "" && format != "json" && format != "pdf" {
+			return apperr.InvalidArgumentf("unknown format %q: want json or pdf", format)
+		}
+		invoice, err := inv.Invoice(r.Context(), id)
+		if err != nil {
+			return fmt.Errorf("failed to get invoice %s: %w", id, err)
+		}
+		if format != "pdf" {
+			return writeJSON(w, http.StatusOK, invoice)
+		}
+		list, err := trips.Trips(r.Context(), invoice.OBUID, invoice.PeriodStart, invoice.PeriodEnd)
+		if err != nil {
+			return fmt.Errorf("failed to list trips of invoice %s: %w", id, err)
+		}
+		var buf bytes.Buffer
+		if err := export.WritePDF(&buf, *invoice, list); err != nil {
+			return fmt.Errorf("failed to render invoice %s: %w", id, err)
+		}
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", `inline; filename="`+id+`.pdf"`)
+		w.WriteHeader(http.StatusOK)
+		_, err = buf.WriteTo(w)
+		return err
+	}
+}
+
+// handleContracts serves /admin/contracts: GET lists the contracts, or
+// returns the one with ?id=, PUT saves the contract in the body, adding or
+// replacing it, and DELETE deletes the one with ?id=.
+func handleContracts(c Contracts) HTTPFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		id := r.URL.Query().Get("id")
+		switch r.Method {
+		case http.MethodGet:
+			if id == "" {
+				list, err := c.Contracts(r.Context())
+				if err != nil {
+					return fmt.Errorf("failed to list contracts: %w", err)
+				}
+				return writeJSON(w, http.StatusOK, list)
+			}
+			con, err := c.Contract(r.Context(), id)
+			if err != nil {
+				return fmt.Errorf("failed to get contract %s: %w", id, err)
+			}
+			return writeJSON(w, http.StatusOK, con)
+		case http.MethodPut:
+			var con types.Contract
+			if err := json.NewDecoder(r.Body).Decode(&con); err != nil {
+				return apperr.InvalidArgumentf("failed to decode contract: %v", err)
+			}
+			saved, err := c.PutContract(r.Context(), con)
+			if err != nil {
+				return fmt.Errorf("failed to save contract %s: %w", con.ID, err)
+			}
+			return writeJSON(w, http.StatusOK, saved)
+		case http.MethodDelete:
+			if id == "" {
+				return apperr.InvalidArgumentf("missing contract ID")
+			}
+			if err := c.DeleteContract(r.Context(), id); err != nil {
+				return fmt.Errorf("failed to delete contract %s: %w", id, err)
+			}
+			return writeJSON(w, http.StatusOK, map[string]any{"deleted": id})
+		default:
+			return APIError{
+				code: http.StatusMethodNotAllowed,
+				Err:  apperr.InvalidArgumentf("invalid HTTP method %v", r.Method),
+			}
+		}
+	}
+}
+
+// queryOBU parses the obu query parameter.
+func queryOBU(r *http.Request) (int32, error) {
+	q := r.URL.Query()
+	if !q.Has("obu") {
+		return 0, apperr.InvalidArgumentf("missing OBU ID")
+	}
+	obuID, err := strconv.Atoi(q.Get("obu"))
+	if err != nil {
+		return 0, apperr.InvalidArgumentf("invalid OBU ID %v", q.Get("obu"))
+	}
+	return int32(obuID), nil
+}
+
+// parseTime parses an RFC 3339 time, the zero time for "".
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
 	}
+	return time.Parse(time.RFC3339Nano, s)
 }
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/0x0Glitch/toll-calculator/aggregator/client"
+	"github.com/0x0Glitch/toll-calculator/aggregator/cluster"
+	"github.com/0x0Glitch/toll-calculator/apperr"
+	"github.com/0x0Glitch/toll-calculator/billing"
+	"github.com/0x0Glitch/toll-calculator/types"
+)
+
+// Invoicing serves the invoice documents of closed billing periods. The
+// HTTP client of a peer node implements it too.
+type Invoicing interface {
+	Invoices(ctx context.Context, obuID int32, from, to time.Time) ([]types.Invoice, error)
+	CreditNotes(ctx context.Context, obuID int32) ([]types.CreditNote, error)
+	FinalizeInvoice(ctx context.Context, id string) (*types.Invoice, error)
+	PayInvoice(ctx context.Context, id string) (*types.Invoice, error)
+	VoidInvoice(ctx context.Context, id, reason string) (*types.Invoice, error)
+	CreditInvoice(ctx context.Context, id, reason string, lines []types.InvoiceLine) (*types.CreditNote, error)
+	CloseInvoices(ctx context.Context, period string) (int, error)
+	Invoice(ctx context.Context, id string) (*types.Invoice, error)
+	ExportInvoices(ctx context.Context, period string, fn func(types.Invoice) error) error
+}
+
+// localInvoicing is the invoicing of this node alone.
+type localInvoicing struct {
+	*billing.Book
+	*billing.Closer
+}
+
+// clusterInvoicing spans every cluster member. Each node closes the
+// periods of the vehicles it owns, after handing the totals it holds of
+// the others to their owner, so a vehicle's invoices sit on whichever node
+// owned it when each period was closed.
+type clusterInvoicing struct {
+	node  *cluster.Node
+	local Invoicing
+	opts  []client.Option
+}
+
+// peers returns clients of the other members, marked as forwarded so they
+// only answer for themselves.
+func (c *clusterInvoicing) peers(ctx context.Context) (context.Context, map[string]Invoicing) {
+	peers := make(map[string]Invoicing)
+	for _, addr := range c.node.Members() {
+		if addr != c.node.Self() {
+			peers[addr] = client.NewHTTPClient(addr, c.opts...)
+		}
+	}
+	return client.WithForwarded(ctx), peers
+}
+
+func (c *clusterInvoicing) Invoices(ctx context.Context, obuID int32, from, to time.Time) ([]types.Invoice, error) {
+	invoices, err := c.local.Invoices(ctx, obuID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	fctx, peers := c.peers(ctx)
+	var errs []error
+	for addr, p := range peers {
+		more, err := p.Invoices(fctx, obuID, from, to)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", addr, err))
+			continue
+		}
+		invoices = append(invoices, more...)
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, apperr.Wrap(apperr.Unavailable, err, "listing invoices of OBU %d", obuID)
+	}
+	sort.SliceStable(invoices, func(a, b int) bool { return invoices[a].PeriodStart.Before(invoices[b].PeriodStart) })
+	return invoices, nil
+}
+
+func (c *clusterInvoicing) CreditNotes(ctx context.Context, obuID int32) ([]types.CreditNote, error) {
+	notes, err := c.local.CreditNotes(ctx, obuID)
+	if err != nil {
+		return nil, err
+	}
+	fctx, peers := c.peers(ctx)
+	var errs []error
+	for addr, p := range peers {
+		more, err := p.CreditNotes(fctx, obuID)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", addr, err))
+			continue
+		}
+		notes = append(notes, more...)
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, apperr.Wrap(apperr.Unavailable, err, "listing credit notes of OBU %d", obuID)
+	}
+	sort.SliceStable(notes, func(a, b int) bool { return notes[a].IssuedAt.Before(notes[b].IssuedAt) })
+	return notes, nil
+}
+
+func (c *clusterInvoicing) FinalizeInvoice(ctx context.Context, id string) (*types.Invoice, error) {
+	return onHolder(c, ctx, id, func(ctx context.Context, inv Invoicing) (*types.Invoice, error) {
+		return inv.FinalizeInvoice(ctx, id)
+	})
+}
+
+func (c *clusterInvoicing) PayInvoice(ctx context.Context, id string) (*types.Invoice, error) {
+	return onHolder(c, ctx, id, func(ctx context.Context, inv Invoicing) (*types.Invoice, error) {
+		return inv.PayInvoice(ctx, id)
+	})
+}
+
+func (c *clusterInvoicing) VoidInvoice(ctx context.Context, id, reason string) (*types.Invoice, error) {
+	return onHolder(c, ctx, id, func(ctx context.Context, inv Invoicing) (*types.Invoice, error) {
+		return inv.VoidInvoice(ctx, id, reason)
+	})
+}
+
+func (c *clusterInvoicing) CreditInvoice(ctx context.Context, id, reason string, lines []types.InvoiceLine) (*types.CreditNote, error) {
+	return onHolder(c, ctx, id, func(ctx context.Context, inv Invoicing) (*types.CreditNote, error) {
+		return inv.CreditInvoice(ctx, id, reason, lines)
+	})
+}
+
+// CloseInvoices closes the period on every member, each over the totals it
+// holds. A member failing doesn't stop the others, and closing again
+// retries the members that failed.
+func (c *clusterInvoicing) CloseInvoices(ctx context.Context, period string) (int, error) {
+	var errs []error
+	n, err := c.local.CloseInvoices(ctx, period)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("%s: %w", c.node.Self(), err))
+	}
+	fctx, peers := c.peers(ctx)
+	for addr, p := range peers {
+		more, err := p.CloseInvoices(fctx, period)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", addr, err))
+			continue
+		}
+		n += more
+	}
+	return n, errors.Join(errs...)
+}
+
+func (c *clusterInvoicing) Invoice(ctx context.Context, id string) (*types.Invoice, error) {
+	return onHolder(c, ctx, id, func(ctx context.Context, inv Invoicing) (*types.Invoice, error) {
+		return inv.Invoice(ctx, id)
+	})
+}
+
+// ExportInvoices streams the invoices of the period on this node, then
+// those of every other member in the order of their addresses. A member
+// failing ends the export, since finance can't use one with gaps.
+func (c *clusterInvoicing) ExportInvoices(ctx context.Context, period string, fn func(types.Invoice) error) error {
+	if err := c.local.ExportInvoices(ctx, period, fn); err != nil {
+		return err
+	}
+	fctx, peers := c.peers(ctx)
+	addrs := make([]string, 0, len(peers))
+	for addr := range peers {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	for _, addr := range addrs {
+		if err := peers[addr].ExportInvoices(fctx, period, fn); err != nil {
+			return apperr.Wrap(apperr.Unavailable, fmt.Errorf("%s: %w", addr, err), "exporting invoices of period %s", period)
+		}
+	}
+	return nil
+}
+
+// onHolder applies fn to the invoice on the member holding it: this node
+// if it does, otherwise the first other member that doesn't answer that
+// the invoice isn't found.
+func onHolder[T any](c *clusterInvoicing, ctx context.Context, id string, fn func(context.Context, Invoicing) (T, error)) (T, error) {
+	v, err := fn(ctx, c.local)
+	if !apperr.IsCode(err, apperr.NotFound) {
+		return v, err
+	}
+	fctx, peers := c.peers(ctx)
+	var errs []error
+	for addr, p := range peers {
+		v, err := fn(fctx, p)
+		if err == nil || !apperr.IsCode(err, apperr.NotFound) && !apperr.IsCode(err, apperr.Unavailable) {
+			return v, err
+		}
+		if !apperr.IsCode(err, apperr.NotFound) {
+			errs = append(errs, fmt.Errorf("%s: %w", addr, err))
+		}
+	}
+	var zero T
+	if err := errors.Join(errs...); err != nil {
+		return zero, apperr.Wrap(apperr.Unavailable, err, "finding invoice %s", id)
+	}
+	return zero, apperr.NotFoundf("couldn't find invoice %s", id)
+}
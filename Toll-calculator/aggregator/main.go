@@ -1,84 +1,321 @@
 package main
 
 import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net"
 	"net/http"
 	"os"
 	"time"
+
+	"github.com/0x0Glitch/toll-calculator/aggregator/client"
+	"github.com/0x0Glitch/toll-calculator/aggregator/cluster"
+	"github.com/0x0Glitch/toll-calculator/aggregator/metrics"
+	"github.com/0x0Glitch/toll-calculator/apperr"
+	"github.com/0x0Glitch/toll-calculator/billing"
+	"github.com/0x0Glitch/toll-calculator/config"
+	"github.com/0x0Glitch/toll-calculator/contract"
+	"github.com/0x0Glitch/toll-calculator/geofence"
+	"github.com/0x0Glitch/toll-calculator/health"
+	"github.com/0x0Glitch/toll-calculator/money"
+	"github.com/0x0Glitch/toll-calculator/mtls"
+	"github.com/0x0Glitch/toll-calculator/privacy"
+	"github.com/0x0Glitch/toll-calculator/shutdown"
+	"github.com/0x0Glitch/toll-calculator/tax"
+	"github.com/0x0Glitch/toll-calculator/tracing"
+	"github.com/0x0Glitch/toll-calculator/trip"
 	"github.com/0x0Glitch/toll-calculator/types"
-	"github.com/joho/godotenv"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
 func main() {
+	watcher, err := config.NewWatcher[config.Aggregator](os.Args[1:], prometheus.DefaultRegisterer)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cfg := watcher.Current()
+	logrus.SetLevel(cfg.Level())
+	logrus.AddHook(privacy.RedactHook{})
+	logrus.Infof("effective config:\n%s", config.Dump(cfg))
+	ctx, stop := shutdown.Signals(context.Background())
+	defer stop()
+	tp, err := tracing.Init(context.Background(), "aggregator", cfg.TracesExporter)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var (
+		serverTLS  *tls.Config
+		clientOpts []client.Option
+	)
+	if cfg.TLS.Enabled() {
+		certs, err := mtls.NewReloader(cfg.TLS.CAFile, cfg.TLS.CertFile, cfg.TLS.KeyFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		go certs.Run(ctx, config.WatchInterval)
+		serverTLS = certs.ServerConfig()
+		// Nodes forwarding to each other are clients of one another.
+		clientOpts = append(clientOpts, client.WithTLS(certs.ClientConfig()))
+	}
 
-	if err := godotenv.Load(); err != nil {
+	var zones *geofence.Fences
+	if cfg.Zones != "" {
+		zones, err = geofence.LoadFences(cfg.Zones)
+		if err != nil {
+			log.Fatal(err)
+		}
+		go zones.Run(ctx, config.WatchInterval)
+	}
+
+	// Tariffs in other currencies than the invoices are converted at the
+	// rates of the day, dropped into the rates file.
+	currency := money.Currency(cfg.Currency)
+	pricing := cfg.Billing.Pricing(currency)
+	if cfg.Billing.Rates != "" {
+		pricing.Rates, err = money.LoadRates(cfg.Billing.Rates)
+		if err != nil {
+			log.Fatal(err)
+		}
+		go pricing.Rates.Run(ctx, config.WatchInterval)
+	}
+	if cfg.Billing.Taxes != "" {
+		pricing.Taxes, err = tax.LoadRulesFile(cfg.Billing.Taxes)
+		if err != nil {
+			log.Fatal(err)
+		}
+		go pricing.Taxes.Run(ctx, config.WatchInterval)
+		// Zones are taxed by the country they lie in.
+		if zones != nil {
+			pricing.Places = zones
+		}
+	}
+
+	// Contracts of fleet customers discount their vehicles' invoices;
+	// the off-peak hours mark the distance off-peak discounts apply to.
+	registry := contract.NewRegistry()
+	pricing.Contracts = registry
+	offPeak, err := cfg.Billing.OffPeakHours()
+	if err != nil {
 		log.Fatal(err)
 	}
 
 	store := NewMemoryStore()
-	svc := NewInvoiceAggregator(store)
-	grpcListenAddr := os.Getenv("AGG_GRPC_LISTEN_ADDR")
-	httpListenAddr := os.Getenv("AGG_HTTP_LISTEN_ADDR")
+	trips := trip.NewStore()
+	local := NewInvoiceAggregator(store, cfg.Tariff, currency, zones, pricing, trips)
+	var tripLister trip.Lister = trips
+	cycle, err := billing.ParseCycle(cfg.Billing.Cycle)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Each period closes into invoices that never change, and the running
+	// totals start over for the next one.
+	book := billing.NewBook()
+	var node *cluster.Node
+	var source billing.Source = store
+	// Leaving cluster.self unset runs a single node.
+	if self := cfg.Cluster.Self; self != "" {
+		node = cluster.NewNode(self, local, store, func(addr string) (client.Client, error) {
+			return client.NewHTTPClient(addr, clientOpts...), nil
+		})
+		source = node.Owned(store)
+	}
+	// Only the members may mark requests as forwarded; a single node has
+	// none.
+	peers := func() []string { return nil }
+	if node != nil {
+		peers = node.Members
+	}
+	closer := billing.NewCloser(book, source, local, pricing, cycle, cfg.Billing.Review)
+	localInv := localInvoicing{Book: book, Closer: closer}
+	var invoicing Invoicing = localInv
+	localCon := localContracts{registry: registry}
+	var contracts Contracts = localCon
+	go closer.Run(ctx, time.Minute)
+	var svc Aggregator = local
+	watcher.OnReload(func(_, next *config.Aggregator) error {
+		logrus.SetLevel(next.Level())
+		local.SetPrice(next.Tariff)
+		return nil
+	})
+	go watcher.Run(ctx, config.WatchInterval)
+	grpcListenAddr := cfg.GRPCAddr
+	httpListenAddr := cfg.HTTPAddr
 
-	svc = NewMetricsMiddleware(svc)
+	// Erasing a vehicle drops its total and destroys its data key, which
+	// leaves its fixes in Kafka unreadable.
+	var erasure privacy.Erasure
+	erasure.Add("distances", store)
+	erasure.Add("trips", trips)
+	if cfg.Privacy.Enabled() {
+		keys, err := privacy.NewKeyring(cfg.Privacy.KeyringDir, cfg.Privacy.Key())
+		if err != nil {
+			log.Fatal(err)
+		}
+		erasure.Add("keyring", keys)
+	}
+	if cfg.Retention > 0 {
+		go privacy.RunRetention(ctx, "distances", store, cfg.Retention, time.Minute)
+		go privacy.RunRetention(ctx, "trips", trips, cfg.Retention, time.Minute)
+	}
+
+	var leave shutdown.Hook
+	if node != nil {
+		members, err := client.ParseResolver(cfg.Cluster.Members)
+		if err != nil {
+			log.Fatal(err)
+		}
+		go node.Watch(ctx, members, cfg.Cluster.PollInterval)
+		svc = node
+		erasure.Add("cluster", clusterEraser(node, clientOpts))
+		tripLister = clusterTrips(node, trips, clientOpts)
+		invoicing = &clusterInvoicing{node: node, local: localInv, opts: clientOpts}
+		// Contracts only live in memory too; a member joining later has
+		// none until they are put again.
+		contracts = &clusterContracts{node: node, local: localCon, opts: clientOpts}
+		// The totals only live in memory, so a leaving node hands them to
+		// the remaining members once no request can change them anymore.
+		leave = shutdown.Func("cluster leave", node.Leave)
+	}
+
+	// Only distance arriving from the calculators is marked off-peak;
+	// distance forwarded by another node goes to local and keeps its mark.
+	svc = NewOffPeakMiddleware(svc, offPeak)
+	m := metrics.New(prometheus.DefaultRegisterer)
+	svc = NewMetricsMiddleware(svc, m)
 	svc = NewLogMiddleware(svc)
 
-	// Start gRPC server in a separate goroutine
-	serverErrCh := make(chan error, 1)
+	checker := health.NewChecker()
+	checker.AddPinger("store", store)
+
+	// Bind the gRPC listener before serving anything, so a bad address fails
+	// startup right away and clients can connect as soon as we're ready.
+	grpcLn, err := net.Listen("tcp", grpcListenAddr)
+	if err != nil {
+		log.Fatalf("Failed to start gRPC server: %v", err)
+	}
+	grpcServer := makeGRPCTransport(ctx, svc, local, m, checker, serverTLS, peers)
 	go func() {
 		fmt.Println("Starting gRPC server on", grpcListenAddr)
-		if err := makeGRPCTransport(grpcListenAddr, svc); err != nil {
-			serverErrCh <- err
+		if err := grpcServer.Serve(grpcLn); err != nil {
+			log.Fatalf("gRPC server stopped: %v", err)
 		}
 	}()
-
-	// Give the server time to start
-	fmt.Println("Waiting for gRPC server to start...")
-	time.Sleep(time.Second * 2)
-
-	// Check if server failed to start
-	select {
-	case err := <-serverErrCh:
-		log.Fatalf("Failed to start gRPC server: %v", err)
-	default:
-		// Server started successfully or is still starting
+	httpServer, admin := makeHTTPTransport(httpListenAddr, svc, local, tripLister, trips, invoicing, localInv, contracts, localCon, m, checker, watcher, &erasure, peers)
+	httpServer.TLSConfig = serverTLS
+	go func() {
+		fmt.Println("HTTP transport running on port:", httpListenAddr)
+		if err := mtls.ListenAndServe(httpServer); err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+	// The admin routes are served to operators on a listener of their own,
+	// and only with the token.
+	var adminServer *http.Server
+	if cfg.Admin.Enabled() {
+		adminServer = &http.Server{
+			Addr:      cfg.Admin.Addr,
+			Handler:   client.TrustForwarded(withAdminToken(admin, cfg.Admin.Token), peers),
+			TLSConfig: serverTLS,
+		}
+		go func() {
+			fmt.Println("admin transport running on port:", cfg.Admin.Addr)
+			if err := mtls.ListenAndServe(adminServer); err != http.ErrServerClosed {
+				log.Fatal(err)
+			}
+		}()
 	}
-	makeHTTPTransport(httpListenAddr, svc)
+	<-ctx.Done()
 
+	// Both transports stop taking requests and finish the ones in flight
+	// before the node leaves the cluster.
+	fmt.Println("shutting down")
+	hooks := []shutdown.Hook{
+		shutdown.HTTPServer("http", httpServer),
+		shutdown.GRPCServer("grpc", grpcServer),
+	}
+	if adminServer != nil {
+		hooks = append(hooks, shutdown.HTTPServer("admin", adminServer))
+	}
+	if leave.Fn != nil {
+		hooks = append(hooks, leave)
+	}
+	hooks = append(hooks, shutdown.Hook{Name: "tracing", Fn: tp.Shutdown})
+	if err := shutdown.Run(cfg.ShutdownTimeout, hooks...); err != nil {
+		log.Fatal(err)
+	}
 }
 
-func makeHTTPTransport(listenAddr string, svc Aggregator) error {
-	aggMetricHandler := NewHTTPMetricHandler("aggregate")
-	invMetricHandler := NewHTTPMetricHandler("invoice")
-	aggregateHandler := makeHTTPHandlerFunc(aggMetricHandler.Instrument(handleAggregate(svc)))
-	invoiceHandler 	 := makeHTTPHandlerFunc(invMetricHandler.Instrument(handleGetInvoice(svc)))
+// makeHTTPTransport returns the public server and the handler of the admin
+// routes. The public server only serves those to the other members, which
+// forward admin requests to each other.
+func makeHTTPTransport(listenAddr string, svc, local Aggregator, trips, localTrips trip.Lister, invoicing, localInv Invoicing, contracts, localCon Contracts, m *metrics.Metrics, checker *health.Checker, watcher *config.Watcher[config.Aggregator], erasure *privacy.Erasure, peers func() []string) (*http.Server, http.Handler) {
+	aggregateHandler := makeHTTPHandlerFunc(withRequestLog(forwardedTo(handleAggregate(svc), handleAggregate(local))))
+	invoiceHandler 	 := makeHTTPHandlerFunc(withRequestLog(forwardedTo(handleGetInvoice(svc), handleGetInvoice(local))))
+	tripsHandler 	 := makeHTTPHandlerFunc(withRequestLog(forwardedTo(handleGetTrips(trips), handleGetTrips(localTrips))))
+	invoicesHandler  := makeHTTPHandlerFunc(withRequestLog(forwardedTo(handleGetInvoices(invoicing), handleGetInvoices(localInv))))
+	creditsHandler 	 := makeHTTPHandlerFunc(withRequestLog(forwardedTo(handleGetCreditNotes(invoicing), handleGetCreditNotes(localInv))))
+	exportHandler 	 := makeHTTPHandlerFunc(withRequestLog(forwardedTo(handleExportInvoices(invoicing), handleExportInvoices(localInv))))
+	documentHandler  := makeHTTPHandlerFunc(withRequestLog(forwardedTo(handleGetInvoiceDocument(invoicing, trips), handleGetInvoiceDocument(localInv, localTrips))))
+	taxHandler       := makeHTTPHandlerFunc(withRequestLog(forwardedTo(handleGetTaxSummary(invoicing), handleGetTaxSummary(localInv))))
 
-	http.HandleFunc("/aggregate", aggregateHandler)
-	http.HandleFunc("/invoice", invoiceHandler)
+	// The HTTP routes are labelled with the names of the matching RPCs, so
+	// both transports share one set of series.
+	http.Handle("/aggregate", tracing.HTTPHandler(m.HTTPHandler("Aggregate", aggregateHandler), "aggregate"))
+	http.Handle("/invoice", tracing.HTTPHandler(m.HTTPHandler("GetInvoice", invoiceHandler), "invoice"))
+	http.Handle("/trips", tracing.HTTPHandler(m.HTTPHandler("ListTrips", tripsHandler), "trips"))
+	http.Handle("/invoices", tracing.HTTPHandler(m.HTTPHandler("ListInvoices", invoicesHandler), "invoices"))
+	http.Handle("/credit-notes", tracing.HTTPHandler(m.HTTPHandler("ListCreditNotes", creditsHandler), "credit-notes"))
+	http.Handle("/invoices/export", tracing.HTTPHandler(m.HTTPHandler("ExportInvoices", exportHandler), "invoices-export"))
+	http.Handle("/invoices/document", tracing.HTTPHandler(m.HTTPHandler("GetInvoiceDocument", documentHandler), "invoices-document"))
+	http.Handle("/invoices/tax-summary", tracing.HTTPHandler(m.HTTPHandler("GetTaxSummary", taxHandler), "invoices-tax-summary"))
 	http.Handle("/metrics", promhttp.Handler())
+	checker.Register(http.DefaultServeMux)
 
-	fmt.Println("HTTP transport running on port:", listenAddr)
-	return http.ListenAndServe(listenAddr, nil)
-}
+	admin := http.NewServeMux()
+	watcher.Register(admin)
+	admin.Handle("/admin/erase", forwardedContext(erasure.Handler()))
+	admin.Handle("/admin/invoices/close", makeHTTPHandlerFunc(withRequestLog(forwardedTo(handleCloseInvoices(invoicing), handleCloseInvoices(localInv)))))
+	admin.Handle("/admin/invoices/", makeHTTPHandlerFunc(withRequestLog(forwardedTo(handleInvoiceAction(invoicing), handleInvoiceAction(localInv)))))
+	admin.Handle("/admin/contracts", makeHTTPHandlerFunc(withRequestLog(forwardedTo(handleContracts(contracts), handleContracts(localCon)))))
+	http.Handle("/admin/", forwardedOnly(admin))
 
-func makeGRPCTransport(listenAddr string, svc Aggregator) error {
-	// make a TCP listener
-	ln, err := net.Listen("tcp", listenAddr)
+	return &http.Server{Addr: listenAddr, Handler: client.TrustForwarded(http.DefaultServeMux, peers)}, admin
+}
 
-	if err != nil {
-		return err
-	}
-	defer ln.Close()
+func makeGRPCTransport(ctx context.Context, svc, local Aggregator, m *metrics.Metrics, checker *health.Checker, serverTLS *tls.Config, peers func() []string) *grpc.Server {
 	// Make a new GRPC native server with options
-	server := grpc.NewServer([]grpc.ServerOption{}...)
+	opts := []grpc.ServerOption{
+		tracing.GRPCServerOption(),
+		grpc.ChainUnaryInterceptor(client.TrustForwardedInterceptor(peers), m.UnaryServerInterceptor()),
+	}
+	if serverTLS != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(serverTLS)))
+	}
+	server := grpc.NewServer(opts...)
 	//Register our GRPC server implementation to the GRPC package
-	types.RegisterAggregatorServer(server, NewAggregatorGRPCServer(svc))
-	return server.Serve(ln)
+	types.RegisterAggregatorServer(server, NewAggregatorGRPCServer(svc, local))
+	// Serve the standard gRPC health service, so clients and orchestrators
+	// can wait for the Aggregator service to be SERVING. On shutdown every
+	// service turns NOT_SERVING before the server stops.
+	hs := grpchealth.NewServer()
+	healthpb.RegisterHealthServer(server, hs)
+	go func() {
+		checker.ServeGRPC(ctx, hs, 5*time.Second, types.Aggregator_ServiceDesc.ServiceName)
+		hs.Shutdown()
+	}()
+	return server
 }
 
 func writeJSON(rw http.ResponseWriter, status int, v any) error {
@@ -86,3 +323,63 @@ func writeJSON(rw http.ResponseWriter, status int, v any) error {
 	rw.Header().Add("Content-Type", "application/json")
 	return json.NewEncoder(rw).Encode(v)
 }
+
+// clusterEraser erases a vehicle from the other cluster members too. Totals
+// may sit on a member other than the owner while a handoff is pending, so
+// every member is asked rather than just the owner.
+func clusterEraser(node *cluster.Node, opts []client.Option) privacy.Eraser {
+	return privacy.EraserFunc(func(ctx context.Context, obuID int32) error {
+		// A forwarded erasure was already sent to every member.
+		if client.IsForwarded(ctx) {
+			return nil
+		}
+		var errs []error
+		for _, addr := range node.Members() {
+			if addr == node.Self() {
+				continue
+			}
+			err := client.NewHTTPClient(addr, opts...).Erase(client.WithForwarded(ctx), obuID)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", addr, err))
+			}
+		}
+		return errors.Join(errs...)
+	})
+}
+
+// forwardedContext marks the context of requests another node forwarded, so
+// the handler doesn't forward them again.
+func forwardedContext(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(client.ForwardedHeader) != "" {
+			r = r.WithContext(client.WithForwarded(r.Context()))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// forwardedOnly serves the requests other members forwarded and answers
+// everyone else as if there was no such route. The forwarded header has
+// been dropped from the requests of anyone but the members by then.
+func forwardedOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(client.ForwardedHeader) == "" {
+			http.NotFound(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// withAdminToken only serves requests carrying the admin token as a bearer
+// token.
+func withAdminToken(next http.Handler, token string) http.Handler {
+	want := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			writeJSON(w, http.StatusUnauthorized, apperr.BodyOf(apperr.InvalidArgumentf("missing or wrong admin token")))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,130 @@
+// Package metrics holds the aggregator's Prometheus metrics. Every series
+// lives under the toll_aggregator_ prefix and is labelled by method, and by
+// transport and status code where they apply, so one query covers every
+// route on both transports.
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/0x0Glitch/toll-calculator/apperr"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	namespace = "toll"
+	subsystem = "aggregator"
+)
+
+// Buckets covers an in-memory lookup at the low end up to a slow forward
+// to another cluster node at the high end.
+var Buckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5}
+
+// Metrics are the series the aggregator's transports and service report to.
+type Metrics struct {
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	inFlight        *prometheus.GaugeVec
+	calls           *prometheus.CounterVec
+	callDuration    *prometheus.HistogramVec
+}
+
+// New registers the aggregator's metrics with reg.
+func New(reg prometheus.Registerer) *Metrics {
+	m := &Metrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "requests_total",
+			Help:      "Requests served, by transport, method and status code.",
+		}, []string{"transport", "method", "status_code"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "request_duration_seconds",
+			Help:      "Time taken to serve a request, by transport, method and status code.",
+			Buckets:   Buckets,
+		}, []string{"transport", "method", "status_code"}),
+		inFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "requests_in_flight",
+			Help:      "Requests currently being served, by transport.",
+		}, []string{"transport"}),
+		calls: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "service_calls_total",
+			Help:      "Calls into the aggregator service, by method and error code.",
+		}, []string{"method", "code"}),
+		callDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "service_call_duration_seconds",
+			Help:      "Time taken by the aggregator service, by method.",
+			Buckets:   Buckets,
+		}, []string{"method"}),
+	}
+	reg.MustRegister(m.requests, m.requestDuration, m.inFlight, m.calls, m.callDuration)
+	return m
+}
+
+// ObserveCall records a call into the service. Successful calls get the
+// code "ok", failed ones their apperr code.
+func (m *Metrics) ObserveCall(method string, start time.Time, err error) {
+	code := "ok"
+	if err != nil {
+		code = string(apperr.CodeOf(err))
+	}
+	m.calls.WithLabelValues(method, code).Inc()
+	m.callDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+}
+
+func (m *Metrics) observeRequest(transport, method, code string, start time.Time) {
+	m.requests.WithLabelValues(transport, method, code).Inc()
+	m.requestDuration.WithLabelValues(transport, method, code).Observe(time.Since(start).Seconds())
+}
+
+// HTTPHandler records every request to next under the given method name.
+func (m *Metrics) HTTPHandler(method string, next http.Handler) http.Handler {
+	inFlight := m.inFlight.WithLabelValues("http")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inFlight.Inc()
+		defer inFlight.Dec()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		defer func(start time.Time) {
+			m.observeRequest("http", method, strconv.Itoa(rec.status), start)
+		}(time.Now())
+		next.ServeHTTP(rec, r)
+	})
+}
+
+// UnaryServerInterceptor records every unary call under the RPC's method
+// name, with the gRPC status code.
+func (m *Metrics) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	inFlight := m.inFlight.WithLabelValues("grpc")
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+		inFlight.Inc()
+		defer inFlight.Dec()
+		defer func(start time.Time) {
+			m.observeRequest("grpc", path.Base(info.FullMethod), status.Code(err).String(), start)
+		}(time.Now())
+		return handler(ctx, req)
+	}
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.status = code
+	r.ResponseWriter.WriteHeader(code)
+}
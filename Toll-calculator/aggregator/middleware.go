@@ -3,9 +3,9 @@ package main
 import (
 	"time"
 
+	"github.com/0x0Glitch/toll-calculator/aggregator/metrics"
+	"github.com/0x0Glitch/toll-calculator/contract"
 	"github.com/0x0Glitch/toll-calculator/types"
-	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/sirupsen/logrus"
 )
 
@@ -14,61 +14,20 @@ type LogMiddleware struct {
 }
 
 type MetricsMiddleware struct {
-	next          Aggregator
-	reqCounterAgg prometheus.Counter
-	reqCounterInv prometheus.Counter
-	reqLatencyAgg prometheus.Histogram
-	reqLatencyInv prometheus.Histogram
-	errCounterAgg prometheus.Counter
-	errCounterInv prometheus.Counter
+	next    Aggregator
+	metrics *metrics.Metrics
 }
 
-func NewMetricsMiddleware(next Aggregator) Aggregator {
-	errCounterAgg := promauto.NewCounter(prometheus.CounterOpts{
-		Namespace: "aggregator_error_counter",
-		Name:      "aggregator",
-	})
-	errCounterInv := promauto.NewCounter(prometheus.CounterOpts{
-		Namespace: "invoice_err_counter",
-		Name:      "invoice",
-	})
-
-	reqCounterAgg := promauto.NewCounter(prometheus.CounterOpts{
-		Namespace: "aggregator_request_counter",
-		Name:      "aggregator",
-	})
-	reqCounterInv := promauto.NewCounter(prometheus.CounterOpts{
-		Namespace: "invoice_request_counter",
-		Name:      "invoice",
-	})
-	reqLatencyAgg := promauto.NewHistogram(prometheus.HistogramOpts{
-		Namespace: "aggregator_request_latency",
-		Name:      "aggregator_request_latency",
-		Buckets:   []float64{0.1, 0.5, 1},
-	})
-	reqLatencyInv := promauto.NewHistogram(prometheus.HistogramOpts{
-		Namespace: "invoice_request_latency",
-		Name:      "invoice_request_latency",
-		Buckets:   []float64{0.1, 0.5, 1},
-	})
+func NewMetricsMiddleware(next Aggregator, m *metrics.Metrics) Aggregator {
 	return &MetricsMiddleware{
-		errCounterAgg: errCounterAgg,
-		errCounterInv: errCounterInv,
-		next:          next,
-		reqCounterAgg: reqCounterAgg,
-		reqCounterInv: reqCounterInv,
-		reqLatencyAgg: reqLatencyAgg,
-		reqLatencyInv: reqLatencyInv,
+		next:    next,
+		metrics: m,
 	}
 }
 
 func (m *MetricsMiddleware) AggregateDistance(distance *types.Distance) (err error) {
 	defer func(start time.Time) {
-		m.reqCounterAgg.Inc()
-		m.reqLatencyAgg.Observe(time.Since(start).Seconds())
-		if err != nil {
-			m.errCounterAgg.Inc()
-		}
+		m.metrics.ObserveCall("AggregateDistance", start, err)
 	}(time.Now())
 
 	err = m.next.AggregateDistance(distance)
@@ -77,11 +36,7 @@ func (m *MetricsMiddleware) AggregateDistance(distance *types.Distance) (err err
 
 func (m *MetricsMiddleware) CalculateInvoice(obuID int32) (inv *types.Invoice, err error) {
 	defer func(start time.Time) {
-		m.reqCounterInv.Inc()
-		m.reqLatencyInv.Observe(time.Since(start).Seconds())
-		if err != nil {
-			m.errCounterInv.Inc()
-		}
+		m.metrics.ObserveCall("CalculateInvoice", start, err)
 	}(time.Now())
 	inv, err = m.next.CalculateInvoice(obuID)
 	return
@@ -112,7 +67,7 @@ func (m *LogMiddleware) CalculateInvoice(obuID int32) (inv *types.Invoice, err e
 		)
 		if inv != nil {
 			distance = inv.TotalDistance
-			amount = inv.Amount
+			amount = inv.Amount.Float64()
 		}
 		logrus.WithFields(logrus.Fields{
 			"time":     time.Since(start),
@@ -125,3 +80,34 @@ func (m *LogMiddleware) CalculateInvoice(obuID int32) (inv *types.Invoice, err e
 	inv, err = m.next.CalculateInvoice(obuID)
 	return
 }
+
+// OffPeakMiddleware marks the distance travelled in the off-peak hours,
+// which contracts may discount, so the store totals it apart.
+type OffPeakMiddleware struct {
+	next  Aggregator
+	hours *contract.Hours
+}
+
+// NewOffPeakMiddleware marks the distance next takes by hours; nil hours
+// mark none. Distance is marked by its Unix, the time of the fix that ended
+// it, so a backlog in the calculator doesn't move it. It only wraps the
+// service distance first arrives at: distance forwarded by another cluster
+// node was marked there, by the time it was travelled rather than the time
+// it was handed on.
+func NewOffPeakMiddleware(next Aggregator, hours *contract.Hours) Aggregator {
+	return &OffPeakMiddleware{
+		next:  next,
+		hours: hours,
+	}
+}
+
+func (m *OffPeakMiddleware) AggregateDistance(distance *types.Distance) error {
+	if m.hours.Contains(time.Unix(0, distance.Unix)) {
+		distance.OffPeak = true
+	}
+	return m.next.AggregateDistance(distance)
+}
+
+func (m *OffPeakMiddleware) CalculateInvoice(obuID int32) (*types.Invoice, error) {
+	return m.next.CalculateInvoice(obuID)
+}
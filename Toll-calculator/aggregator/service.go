@@ -2,8 +2,17 @@ package main
 
 import (
 	"fmt"
+	"hash/fnv"
+	"math"
+	"sync/atomic"
+	"time"
 
+	"github.com/0x0Glitch/toll-calculator/billing"
+	"github.com/0x0Glitch/toll-calculator/geofence"
+	"github.com/0x0Glitch/toll-calculator/money"
+	"github.com/0x0Glitch/toll-calculator/trip"
 	"github.com/0x0Glitch/toll-calculator/types"
+	"github.com/sirupsen/logrus"
 )
 
 type Aggregator interface {
@@ -13,35 +22,127 @@ type Aggregator interface {
 
 type Storer interface {
 	Insert(*types.Distance) error
-	Get(int32) (float64, error)
+	Get(int32) (map[string]types.Total, error)
 }
 
 type InvoiceAggregator struct {
 	store Storer
+	// price holds the float64 bits of the price per unit of distance, which
+	// a config reload may change while invoices are calculated.
+	price atomic.Uint64
+	// currency is the currency of price and of the zones' tariffs without
+	// a currency of their own.
+	currency money.Currency
+	// zones prices the distance of each toll zone; nil prices all of it
+	// the same.
+	zones *geofence.Fences
+	// pricing converts and rounds the prices into the billing currency.
+	pricing billing.Pricing
+	// trips records the distance and cost of every trip; nil doesn't
+	// keep trips.
+	trips *trip.Store
 }
 
-const basePrice = 315
+// NewInvoiceAggregator prices every unit of distance at price in the
+// currency, or, with zones, prices each toll zone at its own tariff and
+// leaves the distance outside every zone untolled. Invoices are billed by
+// pricing. Distances tagged with a trip are added to trips as well.
+func NewInvoiceAggregator(store Storer, price float64, currency money.Currency, zones *geofence.Fences, pricing billing.Pricing, trips *trip.Store) *InvoiceAggregator {
+	agg := &InvoiceAggregator{
+		store:    store,
+		currency: currency,
+		zones:    zones,
+		pricing:  pricing,
+		trips:    trips,
+	}
+	agg.SetPrice(price)
+	return agg
+}
 
+// SetPrice changes the price of the invoices calculated from now on.
+func (i *InvoiceAggregator) SetPrice(price float64) {
+	i.price.Store(math.Float64bits(price))
+}
 
-func NewInvoiceAggregator(store Storer) Aggregator {
-	return &InvoiceAggregator{
-		store: store,
-	}
+func (i *InvoiceAggregator) Price() float64 {
+	return math.Float64frombits(i.price.Load())
 }
+
 func (i *InvoiceAggregator) AggregateDistance(distance *types.Distance) error {
-	fmt.Println("processing and inserting distance in the storage:", distance)
-	return i.store.Insert(distance)
+	if err := i.store.Insert(distance); err != nil {
+		return err
+	}
+	if i.trips != nil {
+		amount, err := i.pricing.Amount(distance.ZoneID, distance.Values, i)
+		if err != nil {
+			logrus.WithError(err).Warn("trip amount left out")
+		}
+		i.trips.Record(distance, amount.Float64())
+	}
+	return nil
 }
 
 func (i *InvoiceAggregator) CalculateInvoice(obuID int32) (*types.Invoice, error) {
-	dist, err := i.store.Get(obuID)
+	totals, err := i.store.Get(obuID)
+	if err != nil {
+		return nil, err
+	}
+	bill, err := i.pricing.Bill(obuID, time.Now(), totals, i)
 	if err != nil {
 		return nil, err
 	}
 	inv := &types.Invoice{
 		OBUID:         obuID,
-		TotalDistance: dist,
-		Amount:        basePrice * dist,
+		TariffVersion: bill.TariffVersion,
+		Currency:      bill.Currency,
+		Amount:        bill.Amount,
+		Tax:           bill.Tax,
+		Gross:         bill.Gross,
+	}
+	for _, l := range bill.Lines {
+		inv.Zones = append(inv.Zones, types.ZoneTotal{
+			ZoneID:    l.ZoneID,
+			Distance:  l.Distance,
+			Amount:    l.Amount,
+			Estimated: l.Estimated,
+		})
+		inv.TotalDistance += l.Distance
+		inv.EstimatedDistance += l.Estimated
 	}
 	return inv, nil
 }
+
+// UnitPrice returns the price per unit of distance in the zone. Zones
+// without a tariff of their own, and zones missing from the aggregator's
+// file, cost the default price, and tariffs without a currency are in the
+// aggregator's.
+func (i *InvoiceAggregator) UnitPrice(zone string) money.Money {
+	price := money.Money{Amount: money.FromFloat(i.Price()), Currency: i.currency}
+	if i.zones == nil {
+		return price
+	}
+	if zone == "" {
+		return money.Money{Currency: i.currency}
+	}
+	if z, ok := i.zones.Index().Zone(zone); ok && z.Tariff > 0 {
+		price.Amount = money.FromFloat(z.Tariff)
+		if z.Currency != "" {
+			price.Currency = z.Currency
+		}
+	}
+	return price
+}
+
+// TariffVersion identifies the default price and the tariffs of the zones,
+// which a config or zones reload may change. It is a hash of them, so it
+// comes back when they do.
+func (i *InvoiceAggregator) TariffVersion() string {
+	h := fnv.New32a()
+	fmt.Fprintf(h, "%v %s", i.Price(), i.currency)
+	if i.zones != nil {
+		for _, z := range i.zones.Index().Zones() {
+			fmt.Fprintf(h, "|%s=%v %s", z.ID, z.Tariff, z.Currency)
+		}
+	}
+	return fmt.Sprintf("%08x", h.Sum32())
+}
@@ -1,30 +1,130 @@
 package main
 
 import (
-	"fmt"
+	"context"
+	"sync"
+	"time"
 
+	"github.com/0x0Glitch/toll-calculator/apperr"
 	"github.com/0x0Glitch/toll-calculator/types"
 )
 
 type MemoryStore struct {
-	data map[int32]float64
+	mu sync.RWMutex
+	// data holds the distance totals of each OBU by the UTC day it was
+	// travelled on and toll zone.
+	data map[int32]types.DailyTotals
+	// updated is when each total last changed, for retention.
+	updated map[int32]time.Time
 }
 
 func NewMemoryStore() *MemoryStore {
 	return &MemoryStore{
-		data: make(map[int32]float64),
+		data:    make(map[int32]types.DailyTotals),
+		updated: make(map[int32]time.Time),
 	}
 }
 
+// Ping always succeeds, as the memory store can't become unreachable. It
+// is here so readiness checks don't have to special case it.
+func (m *MemoryStore) Ping(ctx context.Context) error {
+	return nil
+}
+
 func (m *MemoryStore) Insert(d *types.Distance) error {
-	m.data[d.OBUID] += d.Values
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	days, ok := m.data[d.OBUID]
+	if !ok {
+		days = make(types.DailyTotals)
+		m.data[d.OBUID] = days
+	}
+	days.Add(d)
+	m.updated[d.OBUID] = time.Now()
 	return nil
 }
 
-func (m *MemoryStore) Get(id int32) (float64, error) {
-	dist, ok := m.data[id]
+// Get returns the distance totals of the OBU by toll zone.
+func (m *MemoryStore) Get(id int32) (map[string]types.Total, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	days, ok := m.data[id]
+	if !ok {
+		return nil, apperr.NotFoundf("couldn't find distance for id: %d", id)
+	}
+	return days.Sum(), nil
+}
+
+// IDs returns the OBU IDs the store holds a total for.
+func (m *MemoryStore) IDs() []int32 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	ids := make([]int32, 0, len(m.data))
+	for id := range m.data {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// Take removes the totals for id and returns them by day, so they can be
+// handed over to another cluster node.
+func (m *MemoryStore) Take(id int32) (types.DailyTotals, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	days, ok := m.data[id]
 	if !ok {
-		return 0.0, fmt.Errorf("couldn't find distance for id: %d", id)
+		return nil, apperr.NotFoundf("couldn't find distance for id: %d", id)
+	}
+	delete(m.data, id)
+	delete(m.updated, id)
+	return days, nil
+}
+
+// TakeBefore removes the totals for id of the days before end, a midnight
+// UTC, and returns them by day, so a billing period can be closed without
+// the distance travelled since.
+func (m *MemoryStore) TakeBefore(id int32, end time.Time) (types.DailyTotals, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	days := m.data[id]
+	taken := make(types.DailyTotals)
+	for day, zones := range days {
+		if day.Before(end) {
+			taken[day] = zones
+			delete(days, day)
+		}
+	}
+	if len(taken) == 0 {
+		return nil, apperr.NotFoundf("couldn't find distance for id %d before %s", id, end)
+	}
+	if len(days) == 0 {
+		delete(m.data, id)
+		delete(m.updated, id)
+	}
+	return taken, nil
+}
+
+// Erase drops the total for id.
+func (m *MemoryStore) Erase(ctx context.Context, id int32) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.data, id)
+	delete(m.updated, id)
+	return nil
+}
+
+// Purge drops the totals that haven't changed since before and returns how
+// many it dropped.
+func (m *MemoryStore) Purge(ctx context.Context, before time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for id, t := range m.updated {
+		if t.Before(before) {
+			delete(m.data, id)
+			delete(m.updated, id)
+			n++
+		}
 	}
-	return dist, nil
+	return n, nil
 }
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/0x0Glitch/toll-calculator/aggregator/client"
+	"github.com/0x0Glitch/toll-calculator/aggregator/cluster"
+	"github.com/0x0Glitch/toll-calculator/apperr"
+	"github.com/0x0Glitch/toll-calculator/trip"
+	"github.com/0x0Glitch/toll-calculator/types"
+)
+
+// clusterTrips lists trips from every cluster member. Trips stay on the
+// node that owned the vehicle while it drove, so a trip may be split
+// across the members that owned it before and after a rebalance.
+func clusterTrips(node *cluster.Node, local trip.Lister, opts []client.Option) trip.Lister {
+	return tripListerFunc(func(ctx context.Context, obuID int32, from, to time.Time) ([]types.Trip, error) {
+		trips, err := local.Trips(ctx, obuID, from, to)
+		if err != nil {
+			return nil, err
+		}
+		var errs []error
+		for _, addr := range node.Members() {
+			if addr == node.Self() {
+				continue
+			}
+			more, err := client.NewHTTPClient(addr, opts...).Trips(client.WithForwarded(ctx), obuID, from, to)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", addr, err))
+				continue
+			}
+			trips = append(trips, more...)
+		}
+		if err := errors.Join(errs...); err != nil {
+			return nil, apperr.Wrap(apperr.Unavailable, err, "listing trips of OBU %d", obuID)
+		}
+		return trip.Merge(trips), nil
+	})
+}
+
+type tripListerFunc func(ctx context.Context, obuID int32, from, to time.Time) ([]types.Trip, error)
+
+func (f tripListerFunc) Trips(ctx context.Context, obuID int32, from, to time.Time) ([]types.Trip, error) {
+	return f(ctx, obuID, from, to)
+}
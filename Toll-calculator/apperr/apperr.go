@@ -0,0 +1,82 @@
+// Package apperr is the error model shared by every service. Errors carry a
+// code that transports map to an HTTP status or gRPC code on the server,
+// and clients map back to the same typed error.
+package apperr
+
+import (
+	"errors"
+	"fmt"
+)
+
+type Code string
+
+const (
+	Internal        Code = "internal"
+	NotFound        Code = "not_found"
+	InvalidArgument Code = "invalid_argument"
+	Unavailable     Code = "unavailable"
+	Conflict        Code = "conflict"
+)
+
+type Error struct {
+	Code    Code
+	Message string
+	Err     error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %s", e.Message, e.Err)
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func New(code Code, format string, args ...any) error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// Wrap annotates err with a code and message. A nil err stays nil.
+func Wrap(code Code, err error, format string, args ...any) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...), Err: err}
+}
+
+func NotFoundf(format string, args ...any) error {
+	return New(NotFound, format, args...)
+}
+
+func InvalidArgumentf(format string, args ...any) error {
+	return New(InvalidArgument, format, args...)
+}
+
+func Unavailablef(format string, args ...any) error {
+	return New(Unavailable, format, args...)
+}
+
+func Conflictf(format string, args ...any) error {
+	return New(Conflict, format, args...)
+}
+
+// CodeOf returns the code of the first *Error in err's chain, or Internal
+// for errors that were never given one. A nil error has no code.
+func CodeOf(err error) Code {
+	if err == nil {
+		return ""
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	return Internal
+}
+
+// IsCode reports whether err carries the given code.
+func IsCode(err error, code Code) bool {
+	return err != nil && CodeOf(err) == code
+}
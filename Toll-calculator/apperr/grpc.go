@@ -0,0 +1,66 @@
+package apperr
+
+import (
+	"errors"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// GRPCStatus lets grpc-go send an *Error with the matching status code.
+func (e *Error) GRPCStatus() *status.Status {
+	return status.New(grpcCode(e.Code), e.Error())
+}
+
+func grpcCode(code Code) codes.Code {
+	switch code {
+	case NotFound:
+		return codes.NotFound
+	case InvalidArgument:
+		return codes.InvalidArgument
+	case Unavailable:
+		return codes.Unavailable
+	case Conflict:
+		return codes.FailedPrecondition
+	}
+	return codes.Internal
+}
+
+// ToGRPC converts err into a gRPC status error on the server side. Errors
+// wrapping an *Error keep its code.
+func ToGRPC(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return status.Error(grpcCode(e.Code), err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+// FromGRPC turns the error returned by a gRPC call back into a typed error.
+func FromGRPC(err error) error {
+	if err == nil {
+		return nil
+	}
+	st, ok := status.FromError(err)
+	if !ok {
+		return Wrap(Unavailable, err, "gRPC call failed")
+	}
+	code := Internal
+	switch st.Code() {
+	case codes.NotFound:
+		code = NotFound
+	case codes.InvalidArgument, codes.OutOfRange:
+		code = InvalidArgument
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Canceled:
+		code = Unavailable
+	case codes.FailedPrecondition, codes.Aborted, codes.AlreadyExists:
+		code = Conflict
+	}
+	return &Error{Code: code, Message: st.Message()}
+}
@@ -0,0 +1,61 @@
+package apperr
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+)
+
+// Body is the JSON error body every HTTP API answers with.
+type Body struct {
+	Error string `json:"error"`
+	Code  Code   `json:"code"`
+}
+
+func BodyOf(err error) Body {
+	return Body{Error: err.Error(), Code: CodeOf(err)}
+}
+
+func HTTPStatus(err error) int {
+	switch CodeOf(err) {
+	case "":
+		return http.StatusOK
+	case NotFound:
+		return http.StatusNotFound
+	case InvalidArgument:
+		return http.StatusBadRequest
+	case Unavailable:
+		return http.StatusServiceUnavailable
+	case Conflict:
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+func codeFromHTTPStatus(status int) Code {
+	switch {
+	case status == http.StatusNotFound:
+		return NotFound
+	case status == http.StatusConflict:
+		return Conflict
+	case status == http.StatusServiceUnavailable, status == http.StatusBadGateway, status == http.StatusGatewayTimeout, status == http.StatusTooManyRequests:
+		return Unavailable
+	case status >= 400 && status < 500:
+		return InvalidArgument
+	}
+	return Internal
+}
+
+// FromHTTPResponse turns a non-2xx response into a typed error. The code is
+// taken from the body when the server sent one, and from the status otherwise.
+func FromHTTPResponse(resp *http.Response) error {
+	var body Body
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err := json.Unmarshal(b, &body); err != nil || body.Error == "" {
+		body.Error = "the service responded with status " + resp.Status
+	}
+	if body.Code == "" {
+		body.Code = codeFromHTTPStatus(resp.StatusCode)
+	}
+	return &Error{Code: body.Code, Message: body.Error}
+}
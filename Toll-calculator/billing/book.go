@@ -0,0 +1,275 @@
+package billing
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/0x0Glitch/toll-calculator/apperr"
+	"github.com/0x0Glitch/toll-calculator/money"
+	"github.com/0x0Glitch/toll-calculator/types"
+)
+
+// InvoiceID returns the ID of the invoice billing the vehicle for the
+// period. Every vehicle gets one invoice per period, so closing a period
+// twice can't bill it twice in one book. In a cluster only the member
+// owning the vehicle bills it, see cluster.OwnedStore.
+func InvoiceID(obuID int32, p Period) string {
+	return fmt.Sprintf("INV-%s-%d", p.Label(), obuID)
+}
+
+// Book keeps the invoice documents and credit notes. It hands out copies,
+// so an invoice only changes through the lifecycle methods.
+type Book struct {
+	now func() time.Time
+
+	mu       sync.RWMutex
+	invoices map[string]*types.Invoice
+	// byOBU holds the IDs of each OBU's invoices in the order of their
+	// periods.
+	byOBU   map[int32][]string
+	credits map[string][]types.CreditNote
+}
+
+func NewBook() *Book {
+	return &Book{
+		now:      time.Now,
+		invoices: make(map[string]*types.Invoice),
+		byOBU:    make(map[int32][]string),
+		credits:  make(map[string][]types.CreditNote),
+	}
+}
+
+// Draft records the bill of the vehicle for the period as a draft invoice,
+// replacing an earlier draft. A finalized invoice is a conflict.
+func (b *Book) Draft(obuID int32, p Period, bill Bill) (*types.Invoice, error) {
+	id := InvoiceID(obuID, p)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	inv, ok := b.invoices[id]
+	if ok && inv.Status != types.InvoiceDraft {
+		return nil, apperr.Conflictf("invoice %s is %s", id, inv.Status)
+	}
+	if !ok {
+		inv = &types.Invoice{
+			ID:          id,
+			OBUID:       obuID,
+			Status:      types.InvoiceDraft,
+			Period:      p.Label(),
+			PeriodStart: p.Start,
+			PeriodEnd:   p.End,
+		}
+		b.invoices[id] = inv
+		ids := append(b.byOBU[obuID], id)
+		sort.SliceStable(ids, func(a, c int) bool {
+			return b.invoices[ids[a]].PeriodStart.Before(b.invoices[ids[c]].PeriodStart)
+		})
+		b.byOBU[obuID] = ids
+	}
+	inv.TariffVersion = bill.TariffVersion
+	inv.Currency = bill.Currency
+	inv.Lines = slices.Clone(bill.Lines)
+	inv.Amount, inv.Tax, inv.Gross = bill.Amount, bill.Tax, bill.Gross
+	inv.TotalDistance, inv.EstimatedDistance = 0, 0
+	for _, l := range bill.Lines {
+		inv.TotalDistance += l.Distance
+		inv.EstimatedDistance += l.Estimated
+	}
+	return clone(inv), nil
+}
+
+// Invoice returns the invoice with the ID.
+func (b *Book) Invoice(ctx context.Context, id string) (*types.Invoice, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	inv, ok := b.invoices[id]
+	if !ok {
+		return nil, apperr.NotFoundf("couldn't find invoice %s", id)
+	}
+	return clone(inv), nil
+}
+
+// ExportInvoices calls fn with every invoice of the period with the label,
+// in the order of the OBU IDs, and stops at the first error. The book isn't
+// locked while fn runs, so a slow reader doesn't hold up billing.
+func (b *Book) ExportInvoices(ctx context.Context, period string, fn func(types.Invoice) error) error {
+	b.mu.RLock()
+	var ids []string
+	for _, inv := range b.invoices {
+		if inv.Period == period {
+			ids = append(ids, inv.ID)
+		}
+	}
+	sort.Slice(ids, func(a, c int) bool { return b.invoices[ids[a]].OBUID < b.invoices[ids[c]].OBUID })
+	b.mu.RUnlock()
+
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		inv, err := b.Invoice(ctx, id)
+		if err != nil {
+			return err
+		}
+		if err := fn(*inv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Invoices lists the invoices of the vehicle whose periods overlap from to
+// to. A zero from or to leaves that end open.
+func (b *Book) Invoices(ctx context.Context, obuID int32, from, to time.Time) ([]types.Invoice, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	out := []types.Invoice{}
+	for _, id := range b.byOBU[obuID] {
+		inv := b.invoices[id]
+		if !from.IsZero() && !inv.PeriodEnd.After(from) {
+			continue
+		}
+		if !to.IsZero() && !inv.PeriodStart.Before(to) {
+			continue
+		}
+		out = append(out, *clone(inv))
+	}
+	return out, nil
+}
+
+// CreditNotes lists the credit notes of the vehicle's invoices.
+func (b *Book) CreditNotes(ctx context.Context, obuID int32) ([]types.CreditNote, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	out := []types.CreditNote{}
+	for _, id := range b.byOBU[obuID] {
+		for _, n := range b.credits[id] {
+			n.Lines = slices.Clone(n.Lines)
+			out = append(out, n)
+		}
+	}
+	return out, nil
+}
+
+// FinalizeInvoice freezes a draft invoice.
+func (b *Book) FinalizeInvoice(ctx context.Context, id string) (*types.Invoice, error) {
+	return b.transition(id, types.InvoiceFinalized, []types.InvoiceStatus{types.InvoiceDraft}, func(inv *types.Invoice, now time.Time) error {
+		inv.FinalizedAt = now
+		return nil
+	})
+}
+
+// PayInvoice records the payment of a finalized invoice.
+func (b *Book) PayInvoice(ctx context.Context, id string) (*types.Invoice, error) {
+	return b.transition(id, types.InvoicePaid, []types.InvoiceStatus{types.InvoiceFinalized}, func(inv *types.Invoice, now time.Time) error {
+		inv.PaidAt = now
+		return nil
+	})
+}
+
+// VoidInvoice cancels an invoice that wasn't paid. An invoice with credit
+// notes was partly corrected already and can't be voided; credit the rest
+// of it instead.
+func (b *Book) VoidInvoice(ctx context.Context, id, reason string) (*types.Invoice, error) {
+	return b.transition(id, types.InvoiceVoid, []types.InvoiceStatus{types.InvoiceDraft, types.InvoiceFinalized}, func(inv *types.Invoice, now time.Time) error {
+		if len(b.credits[id]) > 0 {
+			return apperr.Conflictf("invoice %s has credit notes", id)
+		}
+		inv.VoidedAt, inv.VoidReason = now, reason
+		return nil
+	})
+}
+
+// transition moves the invoice from one of the from statuses to status to,
+// once apply accepted and updated it.
+func (b *Book) transition(id string, to types.InvoiceStatus, from []types.InvoiceStatus, apply func(*types.Invoice, time.Time) error) (*types.Invoice, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	inv, ok := b.invoices[id]
+	if !ok {
+		return nil, apperr.NotFoundf("couldn't find invoice %s", id)
+	}
+	if !slices.Contains(from, inv.Status) {
+		return nil, apperr.Conflictf("invoice %s is %s and can't become %s", id, inv.Status, to)
+	}
+	if err := apply(inv, b.now().UTC()); err != nil {
+		return nil, err
+	}
+	inv.Status = to
+	return clone(inv), nil
+}
+
+// CreditInvoice issues a credit note against a finalized or paid invoice.
+// Its lines must credit positive amounts, and all the notes of an invoice
+// together no more than the invoice's amount. Each line credits the tax
+// of the invoice's line of its zone, at the same rate, rounded half-even.
+func (b *Book) CreditInvoice(ctx context.Context, id, reason string, lines []types.InvoiceLine) (*types.CreditNote, error) {
+	if len(lines) == 0 {
+		return nil, apperr.InvalidArgumentf("credit note without lines")
+	}
+	var amount money.Decimal
+	for _, l := range lines {
+		if l.Amount.Sign() <= 0 {
+			return nil, apperr.InvalidArgumentf("credit note line of zone %q: amount must be positive", l.ZoneID)
+		}
+		amount = amount.Add(l.Amount)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	inv, ok := b.invoices[id]
+	if !ok {
+		return nil, apperr.NotFoundf("couldn't find invoice %s", id)
+	}
+	if inv.Status != types.InvoiceFinalized && inv.Status != types.InvoicePaid {
+		return nil, apperr.Conflictf("invoice %s is %s and can't be credited", id, inv.Status)
+	}
+	left := inv.Amount
+	for _, n := range b.credits[id] {
+		left = left.Sub(n.Amount)
+	}
+	if amount.Cmp(left) > 0 {
+		return nil, apperr.Conflictf("invoice %s has %s %s left to credit, not %s", id, left, inv.Currency, amount)
+	}
+	note := types.CreditNote{
+		ID:        fmt.Sprintf("CN-%s-%d", strings.TrimPrefix(id, "INV-"), len(b.credits[id])+1),
+		InvoiceID: id,
+		OBUID:     inv.OBUID,
+		Reason:    reason,
+		Lines:     slices.Clone(lines),
+		Amount:    amount,
+		Currency:  inv.Currency,
+		IssuedAt:  b.now().UTC(),
+	}
+	for i := range note.Lines {
+		l := &note.Lines[i]
+		l.Jurisdiction, l.TaxTreatment, l.TaxRate, l.Tax = "", "", money.Decimal{}, money.Decimal{}
+		l.ListAmount, l.Discounts = money.Decimal{}, nil
+		j := slices.IndexFunc(inv.Lines, func(il types.InvoiceLine) bool { return il.ZoneID == l.ZoneID })
+		if j < 0 {
+			continue
+		}
+		taxed := inv.Lines[j]
+		l.Jurisdiction, l.TaxTreatment, l.TaxRate = taxed.Jurisdiction, taxed.TaxTreatment, taxed.TaxRate
+		if taxed.TaxTreatment == types.TaxStandard {
+			l.Tax = l.Amount.Mul(taxed.TaxRate).Round(inv.Currency.MinorUnits(), money.HalfEven)
+		}
+		note.Tax = note.Tax.Add(l.Tax)
+	}
+	note.Tax = note.Tax.Round(inv.Currency.MinorUnits(), money.HalfEven)
+	note.Gross = note.Amount.Add(note.Tax)
+	b.credits[id] = append(b.credits[id], note)
+	note.Lines = slices.Clone(note.Lines)
+	return &note, nil
+}
+
+func clone(inv *types.Invoice) *types.Invoice {
+	c := *inv
+	c.Lines = slices.Clone(inv.Lines)
+	c.Zones = slices.Clone(inv.Zones)
+	return &c
+}
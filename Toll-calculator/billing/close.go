@@ -0,0 +1,171 @@
+package billing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/0x0Glitch/toll-calculator/apperr"
+	"github.com/0x0Glitch/toll-calculator/types"
+	"github.com/sirupsen/logrus"
+)
+
+// Source holds the running distance totals a period is closed from.
+type Source interface {
+	Insert(*types.Distance) error
+	IDs() []int32
+	// TakeBefore removes the totals of the distance travelled before end
+	// and returns them by day, leaving the distance travelled since.
+	TakeBefore(id int32, end time.Time) (types.DailyTotals, error)
+}
+
+// Closer closes billing periods. Closing a period takes the totals of the
+// distance every vehicle travelled before the period's end out of the
+// source and bills them on the vehicle's invoice for the period, so the
+// totals start over for the next one. Distance arriving after its period
+// was closed is billed in the next.
+type Closer struct {
+	book    *Book
+	source  Source
+	tariff  Tariff
+	pricing Pricing
+	cycle   Cycle
+	// review leaves the invoices drafts, to be finalized one by one.
+	review bool
+	now    func() time.Time
+
+	mu     sync.Mutex
+	closed map[string]bool
+}
+
+// NewCloser returns a closer billing the totals of source into book at
+// the tariff current when the period closes, priced by pricing. With
+// review, closed invoices stay drafts until finalized.
+func NewCloser(book *Book, source Source, tariff Tariff, pricing Pricing, cycle Cycle, review bool) *Closer {
+	return &Closer{
+		book:    book,
+		source:  source,
+		tariff:  tariff,
+		pricing: pricing,
+		cycle:   cycle,
+		review:  review,
+		now:     time.Now,
+		closed:  make(map[string]bool),
+	}
+}
+
+// CloseInvoices closes the period with the label and returns how many
+// invoices it issued. Only a period that has ended can be closed, and only
+// once; a close that failed for some vehicles may be repeated to bill
+// them.
+func (c *Closer) CloseInvoices(ctx context.Context, period string) (int, error) {
+	p, err := c.cycle.Parse(period)
+	if err != nil {
+		return 0, apperr.InvalidArgumentf("%v", err)
+	}
+	if p.End.After(c.now()) {
+		return 0, apperr.InvalidArgumentf("period %s hasn't ended yet", period)
+	}
+	return c.close(p)
+}
+
+func (c *Closer) close(p Period) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed[p.Label()] {
+		return 0, apperr.Conflictf("period %s is closed already", p.Label())
+	}
+
+	n := 0
+	var errs []error
+	for _, id := range c.source.IDs() {
+		// A vehicle billed by an earlier attempt keeps its invoice; the
+		// distance that arrived for the period since is billed in the next.
+		if _, err := c.book.Invoice(context.Background(), InvoiceID(id, p)); err == nil {
+			continue
+		}
+		days, err := c.source.TakeBefore(id, p.End)
+		if apperr.IsCode(err, apperr.NotFound) {
+			// Handed to another node, erased meanwhile, or only travelled
+			// after the period.
+			continue
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("OBU %d: %w", id, err))
+			continue
+		}
+		// The period is billed under the contract in force at its end.
+		bill, err := c.pricing.Bill(id, p.End.Add(-time.Nanosecond), days.Sum(), c.tariff)
+		if err != nil {
+			// Put the totals back so they aren't lost; they are billed
+			// once the tariff can be priced.
+			c.putBack(id, days)
+			errs = append(errs, fmt.Errorf("OBU %d: %w", id, err))
+			continue
+		}
+		inv, err := c.book.Draft(id, p, bill)
+		if err != nil {
+			c.putBack(id, days)
+			errs = append(errs, fmt.Errorf("OBU %d: %w", id, err))
+			continue
+		}
+		n++
+		// The totals are billed on the draft now, which is left to be
+		// finalized by hand if finalizing it fails.
+		if !c.review {
+			if _, err := c.book.FinalizeInvoice(context.Background(), inv.ID); err != nil {
+				errs = append(errs, fmt.Errorf("OBU %d: %w", id, err))
+			}
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return n, err
+	}
+	c.closed[p.Label()] = true
+	return n, nil
+}
+
+// putBack returns totals taken out of the source on the days they were
+// travelled, so closing the period again bills them.
+func (c *Closer) putBack(id int32, days types.DailyTotals) {
+	for _, part := range days.Parts(id) {
+		c.source.Insert(part)
+	}
+}
+
+// Run closes every period once it ended, until ctx is done. It checks
+// every interval, and only closes the periods that end while it runs.
+func (c *Closer) Run(ctx context.Context, interval time.Duration) {
+	open := c.cycle.Period(c.now())
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		open = c.CloseEnded(open, c.now())
+	}
+}
+
+// CloseEnded closes the periods from open on that ended by now, oldest
+// first, and returns the first period left open. A period that fails to
+// close is returned, so it is retried before any later one; one closed by
+// hand meanwhile is skipped.
+func (c *Closer) CloseEnded(open Period, now time.Time) Period {
+	for ; !now.Before(open.End); open = open.Next() {
+		n, err := c.close(open)
+		if apperr.IsCode(err, apperr.Conflict) {
+			continue
+		}
+		if err != nil {
+			logrus.WithError(err).Errorf("closing period %s, retrying", open.Label())
+			return open
+		}
+		logrus.Infof("closed period %s with %d invoices", open.Label(), n)
+	}
+	return open
+}
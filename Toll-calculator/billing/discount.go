@@ -0,0 +1,146 @@
+package billing
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/0x0Glitch/toll-calculator/contract"
+	"github.com/0x0Glitch/toll-calculator/money"
+	"github.com/0x0Glitch/toll-calculator/types"
+)
+
+// Contracts finds the contract covering a vehicle, see package contract.
+type Contracts interface {
+	For(obuID int32, at time.Time) (types.Contract, bool)
+}
+
+// percent is 1%.
+var percent = money.New(1, 2)
+
+// Discount applies the contract covering the vehicle at the time to its
+// lines. A subscription waives the tolls of the zones it covers and adds
+// a line with its fee; the discounts then take their share of what is
+// left of the toll lines, in the order of the contract. Every line keeps
+// its list amount and the discounts it got, so the invoice tells how its
+// amount came about. The totals are those the lines bill.
+func (p Pricing) Discount(obuID int32, at time.Time, totals map[string]types.Total, lines []types.InvoiceLine) ([]types.InvoiceLine, error) {
+	if p.Contracts == nil {
+		return lines, nil
+	}
+	c, ok := p.Contracts.For(obuID, at)
+	if !ok {
+		return lines, nil
+	}
+	currency := cmp.Or(c.Currency, p.Currency)
+	rate, err := p.rate(currency)
+	if err != nil {
+		return nil, err
+	}
+	tolls := lines
+	// The fee line is appended once the tolls are discounted, as appending
+	// may move the toll lines to a new array.
+	var fee *types.InvoiceLine
+	if s := c.Subscription; s != nil {
+		for i := range tolls {
+			if covers(s.Zones, tolls[i].ZoneID) {
+				p.discount(&tolls[i], c.ID, types.AppliedDiscount{
+					Kind:        types.DiscountSubscription,
+					Description: "Covered by the subscription",
+				}, tolls[i].Amount)
+			}
+		}
+		fee = &types.InvoiceLine{
+			Description: fmt.Sprintf("Subscription of contract %s", c.ID),
+			UnitPrice:   s.Fee,
+			Currency:    currency,
+			Amount:      p.Rounding.Line(s.Fee.Mul(rate), p.Currency),
+		}
+		if currency != p.Currency {
+			fee.ExchangeRate = rate
+		}
+	}
+
+	var distance float64
+	for _, t := range totals {
+		distance += t.Distance
+	}
+	for _, d := range c.Discounts {
+		if !contract.DiscountActive(d, at) {
+			continue
+		}
+		applied := types.AppliedDiscount{Kind: d.Kind, Code: d.Code}
+		share := d.Percent.Mul(percent)
+		switch d.Kind {
+		case types.DiscountVolume:
+			if distance <= d.Threshold {
+				continue
+			}
+			applied.Description = fmt.Sprintf("%s%% off the distance beyond %g", d.Percent, d.Threshold)
+			beyond := money.FromFloat((distance - d.Threshold) / distance)
+			for i := range tolls {
+				if covers(d.Zones, tolls[i].ZoneID) {
+					p.discount(&tolls[i], c.ID, applied, tolls[i].Amount.Mul(beyond).Mul(share))
+				}
+			}
+		case types.DiscountOffPeak:
+			applied.Description = fmt.Sprintf("%s%% off the off-peak distance", d.Percent)
+			for i := range tolls {
+				t := totals[tolls[i].ZoneID]
+				if t.OffPeak == 0 || !covers(d.Zones, tolls[i].ZoneID) {
+					continue
+				}
+				offPeak := money.FromFloat(t.OffPeak / t.Distance)
+				p.discount(&tolls[i], c.ID, applied, tolls[i].Amount.Mul(offPeak).Mul(share))
+			}
+		case types.DiscountPromo:
+			if d.Amount.IsZero() {
+				applied.Description = fmt.Sprintf("Promo code %s: %s%% off", d.Code, d.Percent)
+				for i := range tolls {
+					if covers(d.Zones, tolls[i].ZoneID) {
+						p.discount(&tolls[i], c.ID, applied, tolls[i].Amount.Mul(share))
+					}
+				}
+				continue
+			}
+			// A fixed amount is taken off the lines in order until it is
+			// used up.
+			applied.Description = fmt.Sprintf("Promo code %s: %s %s off", d.Code, d.Amount, currency)
+			left := p.Rounding.Line(d.Amount.Mul(rate), p.Currency)
+			for i := range tolls {
+				if left.Sign() > 0 && covers(d.Zones, tolls[i].ZoneID) {
+					left = left.Sub(p.discount(&tolls[i], c.ID, applied, left))
+				}
+			}
+		}
+	}
+	if fee != nil {
+		lines = append(slices.Clip(tolls), *fee)
+	}
+	return lines, nil
+}
+
+// discount takes up to off off the line, never below zero, records it and
+// returns what was taken off.
+func (p Pricing) discount(l *types.InvoiceLine, contract string, d types.AppliedDiscount, off money.Decimal) money.Decimal {
+	off = p.Rounding.Line(off, p.Currency)
+	if off.Cmp(l.Amount) > 0 {
+		off = l.Amount
+	}
+	if off.Sign() <= 0 {
+		return money.Decimal{}
+	}
+	if len(l.Discounts) == 0 {
+		l.ListAmount = l.Amount
+	}
+	d.Contract, d.Amount = contract, off
+	l.Discounts = append(l.Discounts, d)
+	l.Amount = l.Amount.Sub(off)
+	return off
+}
+
+// covers reports whether zones, all of them if empty, include the zone.
+func covers(zones []string, zone string) bool {
+	return len(zones) == 0 || slices.Contains(zones, zone)
+}
@@ -0,0 +1,112 @@
+// Package billing turns the running distance totals of the aggregator into
+// invoice documents. A period-close job bills each vehicle's totals for the
+// period that just ended, and the book keeps the invoices through their
+// lifecycle, from draft to finalized to paid or void, along with the credit
+// notes correcting them.
+package billing
+
+import (
+	"fmt"
+	"time"
+)
+
+// Cycle is how long a billing period lasts: a day, an ISO week or a
+// calendar month. Periods start and end at midnight UTC.
+type Cycle string
+
+const (
+	Daily   Cycle = "day"
+	Weekly  Cycle = "week"
+	Monthly Cycle = "month"
+)
+
+func ParseCycle(s string) (Cycle, error) {
+	switch c := Cycle(s); c {
+	case Daily, Weekly, Monthly:
+		return c, nil
+	}
+	return "", fmt.Errorf("billing: unknown cycle %q: want day, week or month", s)
+}
+
+// Period is a billing period, from Start up to but excluding End.
+type Period struct {
+	Cycle      Cycle
+	Start, End time.Time
+}
+
+// Period returns the period of the cycle t falls in.
+func (c Cycle) Period(t time.Time) Period {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	var start time.Time
+	switch c {
+	case Daily:
+		start = day
+	case Weekly:
+		start = day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	default:
+		start = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+	return Period{Cycle: c, Start: start, End: c.next(start)}
+}
+
+func (c Cycle) next(start time.Time) time.Time {
+	switch c {
+	case Daily:
+		return start.AddDate(0, 0, 1)
+	case Weekly:
+		return start.AddDate(0, 0, 7)
+	}
+	return start.AddDate(0, 1, 0)
+}
+
+// Parse parses the label of a period of the cycle, as returned by Label.
+func (c Cycle) Parse(label string) (Period, error) {
+	var start time.Time
+	switch c {
+	case Daily:
+		t, err := time.Parse(time.DateOnly, label)
+		if err != nil {
+			return Period{}, fmt.Errorf("billing: invalid day %q: want 2006-01-02", label)
+		}
+		start = t
+	case Weekly:
+		var year, week int
+		if n, _ := fmt.Sscanf(label, "%d-W%d", &year, &week); n != 2 || week < 1 || week > 53 {
+			return Period{}, fmt.Errorf("billing: invalid week %q: want 2006-W01", label)
+		}
+		// The first ISO week is the one with the year's first Thursday,
+		// which always holds the 4th of January.
+		jan4 := time.Date(year, time.January, 4, 0, 0, 0, 0, time.UTC)
+		start = c.Period(jan4).Start.AddDate(0, 0, 7*(week-1))
+	default:
+		t, err := time.Parse("2006-01", label)
+		if err != nil {
+			return Period{}, fmt.Errorf("billing: invalid month %q: want 2006-01", label)
+		}
+		start = t
+	}
+	p := c.Period(start)
+	if p.Label() != label {
+		return Period{}, fmt.Errorf("billing: invalid %s %q", c, label)
+	}
+	return p, nil
+}
+
+// Label names the period: 2006-01-02 for a day, 2006-W01 for an ISO week
+// and 2006-01 for a month.
+func (p Period) Label() string {
+	switch p.Cycle {
+	case Daily:
+		return p.Start.Format(time.DateOnly)
+	case Weekly:
+		year, week := p.Start.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	}
+	return p.Start.Format("2006-01")
+}
+
+// Next returns the period following p.
+func (p Period) Next() Period {
+	return Period{Cycle: p.Cycle, Start: p.End, End: p.Cycle.next(p.End)}
+}
@@ -0,0 +1,175 @@
+package billing
+
+import (
+	"sort"
+	"time"
+
+	"github.com/0x0Glitch/toll-calculator/apperr"
+	"github.com/0x0Glitch/toll-calculator/money"
+	"github.com/0x0Glitch/toll-calculator/tax"
+	"github.com/0x0Glitch/toll-calculator/types"
+)
+
+// Tariff prices the distance of each zone.
+type Tariff interface {
+	// UnitPrice returns the price per unit of distance in the zone, in the
+	// currency of the zone's tariff.
+	UnitPrice(zone string) money.Money
+	// TariffVersion identifies the prices, so an invoice tells which ones it
+	// was billed at.
+	TariffVersion() string
+}
+
+// Places tells the country each zone lies in.
+type Places interface {
+	Country(zone string) string
+}
+
+// Pricing bills distance in one currency. Tariffs in other currencies are
+// converted at the exchange rates, the contracts of fleet customers
+// discount the lines, which are then taxed by the tax rules, and the
+// amounts are rounded to the currency's minor units by the
+// rounding rules.
+type Pricing struct {
+	Currency money.Currency
+	Rounding money.Rounding
+	// Rates converts the tariffs in other currencies; nil only bills
+	// tariffs in Currency.
+	Rates *money.Rates
+	// Taxes decides the tax of each line by the country Places puts its
+	// zone in; nil bills without tax.
+	Taxes  *tax.RulesFile
+	Places Places
+	// Contracts discounts the lines of the vehicles they cover; nil bills
+	// every vehicle at the tariff.
+	Contracts Contracts
+}
+
+// Bill is what a vehicle owes for a period: the line items billing its
+// distance at the tariff with the version, and their totals net of tax,
+// of tax and with it.
+type Bill struct {
+	TariffVersion string
+	Currency      money.Currency
+	Lines         []types.InvoiceLine
+	Amount        money.Decimal
+	Tax           money.Decimal
+	Gross         money.Decimal
+}
+
+// Bill prices the totals of the vehicle at the tariff, discounts them by
+// the contract covering the vehicle at the time and taxes them.
+func (p Pricing) Bill(obuID int32, at time.Time, totals map[string]types.Total, tariff Tariff) (Bill, error) {
+	lines, err := p.Lines(totals, tariff)
+	if err != nil {
+		return Bill{}, err
+	}
+	if lines, err = p.Discount(obuID, at, totals, lines); err != nil {
+		return Bill{}, err
+	}
+	if err := p.Tax(obuID, lines); err != nil {
+		return Bill{}, err
+	}
+	b := Bill{
+		TariffVersion: tariff.TariffVersion(),
+		Currency:      p.Currency,
+		Lines:         lines,
+		Amount:        p.Total(lines),
+		Tax:           p.TotalTax(lines),
+	}
+	b.Gross = b.Amount.Add(b.Tax)
+	return b, nil
+}
+
+// Lines returns the line items billing the totals, one per zone in the
+// order of the zone IDs.
+func (p Pricing) Lines(totals map[string]types.Total, tariff Tariff) ([]types.InvoiceLine, error) {
+	lines := make([]types.InvoiceLine, 0, len(totals))
+	for zone, total := range totals {
+		unit := tariff.UnitPrice(zone)
+		rate, err := p.rate(unit.Currency)
+		if err != nil {
+			return nil, err
+		}
+		l := types.InvoiceLine{
+			ZoneID:    zone,
+			Distance:  total.Distance,
+			Estimated: total.Estimated,
+			UnitPrice: unit.Amount,
+			Currency:  unit.Currency,
+		}
+		amount := unit.Amount.Mul(money.FromFloat(total.Distance))
+		if unit.Currency != p.Currency {
+			l.ExchangeRate = rate
+			amount = amount.Mul(rate)
+		}
+		l.Amount = p.Rounding.Line(amount, p.Currency)
+		lines = append(lines, l)
+	}
+	sort.Slice(lines, func(a, b int) bool { return lines[a].ZoneID < lines[b].ZoneID })
+	return lines, nil
+}
+
+// Total returns the amount of an invoice with the lines.
+func (p Pricing) Total(lines []types.InvoiceLine) money.Decimal {
+	var sum money.Decimal
+	for _, l := range lines {
+		sum = sum.Add(l.Amount)
+	}
+	return p.Rounding.Total(sum, p.Currency)
+}
+
+// Tax assesses the tax of the vehicle's lines in place. Without tax rules
+// it leaves them untaxed.
+func (p Pricing) Tax(obuID int32, lines []types.InvoiceLine) error {
+	rules := p.Taxes.Rules()
+	if rules == nil {
+		return nil
+	}
+	for i := range lines {
+		l := &lines[i]
+		var country string
+		if p.Places != nil {
+			country = p.Places.Country(l.ZoneID)
+		}
+		a, err := rules.Assess(obuID, l.ZoneID, country)
+		if err != nil {
+			return apperr.Wrap(apperr.Internal, err, "taxing OBU %d", obuID)
+		}
+		l.Jurisdiction, l.TaxTreatment, l.TaxRate = a.Jurisdiction, a.Treatment, a.Rate
+		l.Tax = money.Decimal{}
+		if a.Treatment == types.TaxStandard {
+			l.Tax = p.Rounding.Line(l.Amount.Mul(a.Rate), p.Currency)
+		}
+	}
+	return nil
+}
+
+// TotalTax returns the tax of an invoice with the lines.
+func (p Pricing) TotalTax(lines []types.InvoiceLine) money.Decimal {
+	var sum money.Decimal
+	for _, l := range lines {
+		sum = sum.Add(l.Tax)
+	}
+	return p.Rounding.Total(sum, p.Currency)
+}
+
+// Amount returns what the distance in the zone costs in the currency,
+// converted but not rounded.
+func (p Pricing) Amount(zone string, distance float64, tariff Tariff) (money.Decimal, error) {
+	unit := tariff.UnitPrice(zone)
+	rate, err := p.rate(unit.Currency)
+	if err != nil {
+		return money.Decimal{}, err
+	}
+	return unit.Amount.Mul(money.FromFloat(distance)).Mul(rate), nil
+}
+
+// rate returns the units of the billing currency a unit of c buys.
+func (p Pricing) rate(c money.Currency) (money.Decimal, error) {
+	rate, err := p.Rates.Table().Rate(c, p.Currency)
+	if err != nil {
+		return money.Decimal{}, apperr.Wrap(apperr.Internal, err, "pricing a tariff in %s", c)
+	}
+	return rate, nil
+}
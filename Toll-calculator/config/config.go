@@ -0,0 +1,324 @@
+// Package config loads the typed configuration of the services. A service
+// declares its settings as a struct whose fields are tagged with where they
+// come from:
+//
+//	HTTPAddr string `yaml:"httpAddr" env:"AGG_HTTP_LISTEN_ADDR" flag:"http-addr" default:":3000" usage:"HTTP listen address"`
+//
+// Load fills the struct from, in increasing precedence, the default tag, a
+// YAML file, the environment and the command line. Fields tagged
+// secret:"true" are redacted by Dump and can't be set by a flag, so they
+// never show up in a process listing. Fields tagged reload:"true" may change
+// while the service runs, see Watcher.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FileEnv names the environment variable that points at the config file
+// when the -config flag isn't given.
+const FileEnv = "TOLL_CONFIG"
+
+// Validator is implemented by configs that check themselves once loaded.
+type Validator interface {
+	Validate() error
+}
+
+// field is a single setting of a config struct.
+type field struct {
+	path   string
+	env    string
+	flag   string
+	def    string
+	usage  string
+	secret bool
+	reload bool
+	value  reflect.Value
+}
+
+// Load fills cfg, a pointer to a config struct, and validates it. args are
+// the command line arguments without the program name.
+func Load(cfg any, args []string) error {
+	_, err := load(cfg, args)
+	return err
+}
+
+// load is Load, also returning the config file it read, if any.
+func load(cfg any, args []string) (string, error) {
+	fields, err := fieldsOf(cfg)
+	if err != nil {
+		return "", err
+	}
+	for _, f := range fields {
+		if f.def == "" {
+			continue
+		}
+		if err := set(f.value, f.def); err != nil {
+			return "", fmt.Errorf("config: default of %s: %w", f.path, err)
+		}
+	}
+
+	// Flags are only recorded while parsing and applied last, so they win
+	// over the file they may name and over the environment.
+	fs := flag.NewFlagSet(filepath.Base(os.Args[0]), flag.ContinueOnError)
+	file := fs.String("config", os.Getenv(FileEnv), "YAML config file, overridden by the environment and flags (env "+FileEnv+")")
+	flagged := make(map[string]*rawFlag)
+	for _, f := range fields {
+		if f.flag == "" {
+			continue
+		}
+		raw := &rawFlag{def: f.def, isBool: f.value.Kind() == reflect.Bool}
+		flagged[f.flag] = raw
+		usage := f.usage
+		if f.env != "" {
+			usage += " (env " + f.env + ")"
+		}
+		fs.Var(raw, f.flag, usage)
+	}
+	if err := fs.Parse(args); err != nil {
+		return "", err
+	}
+
+	if *file != "" {
+		if err := loadFile(*file, cfg); err != nil {
+			return "", err
+		}
+	}
+	for _, f := range fields {
+		if f.env == "" {
+			continue
+		}
+		if s, ok := os.LookupEnv(f.env); ok {
+			if err := set(f.value, s); err != nil {
+				return "", fmt.Errorf("config: %s=%q: %w", f.env, redact(f, s), err)
+			}
+		}
+	}
+	for _, f := range fields {
+		if raw, ok := flagged[f.flag]; ok && raw.set {
+			if err := set(f.value, raw.value); err != nil {
+				return "", fmt.Errorf("config: -%s %q: %w", f.flag, raw.value, err)
+			}
+		}
+	}
+
+	if v, ok := cfg.(Validator); ok {
+		if err := v.Validate(); err != nil {
+			return "", fmt.Errorf("config: %w", err)
+		}
+	}
+	return *file, nil
+}
+
+// Dump returns the effective settings of cfg, one "path: value" line each,
+// with secrets redacted. Services log it at startup.
+func Dump(cfg any) string {
+	fields, err := fieldsOf(cfg)
+	if err != nil {
+		return err.Error()
+	}
+	var b strings.Builder
+	for _, f := range fields {
+		fmt.Fprintf(&b, "%s: %s\n", f.path, redact(f, format(f.value)))
+	}
+	return b.String()
+}
+
+// Values returns the effective settings of cfg by path, with secrets
+// redacted.
+func Values(cfg any) map[string]string {
+	fields, err := fieldsOf(cfg)
+	if err != nil {
+		return nil
+	}
+	values := make(map[string]string, len(fields))
+	for _, f := range fields {
+		values[f.path] = redact(f, format(f.value))
+	}
+	return values
+}
+
+func redact(f field, s string) string {
+	if f.secret && s != "" {
+		return "[redacted]"
+	}
+	return s
+}
+
+func loadFile(path string, cfg any) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	defer file.Close()
+	dec := yaml.NewDecoder(file)
+	// A misspelt key would otherwise silently leave its default in place.
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && err != io.EOF {
+		return fmt.Errorf("config: %s: %w", path, err)
+	}
+	return nil
+}
+
+func fieldsOf(cfg any) ([]field, error) {
+	v := reflect.ValueOf(cfg)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("config: %T is not a pointer to a struct", cfg)
+	}
+	return walk(v.Elem(), ""), nil
+}
+
+// walk lists the settings of the struct v. Nested structs add their YAML key
+// to the path of their fields, unless they are inlined.
+func walk(v reflect.Value, prefix string) []field {
+	var fields []field
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		key, opts, _ := strings.Cut(sf.Tag.Get("yaml"), ",")
+		if key == "-" {
+			continue
+		}
+		if key == "" {
+			key = strings.ToLower(sf.Name)
+		}
+		path := key
+		if prefix != "" {
+			path = prefix + "." + key
+		}
+		fv := v.Field(i)
+		if fv.Kind() == reflect.Struct && sf.Type != reflect.TypeOf(time.Duration(0)) {
+			if opts == "inline" {
+				fields = append(fields, walk(fv, prefix)...)
+			} else {
+				fields = append(fields, walk(fv, path)...)
+			}
+			continue
+		}
+		f := field{
+			path:   path,
+			env:    sf.Tag.Get("env"),
+			flag:   sf.Tag.Get("flag"),
+			def:    sf.Tag.Get("default"),
+			usage:  sf.Tag.Get("usage"),
+			secret: sf.Tag.Get("secret") == "true",
+			reload: sf.Tag.Get("reload") == "true",
+			value:  fv,
+		}
+		if f.secret {
+			f.flag = ""
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func set(v reflect.Value, s string) error {
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported type %s", v.Type())
+		}
+		var items []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+func format(v reflect.Value) string {
+	if v.Type() == durationType {
+		return time.Duration(v.Int()).String()
+	}
+	if v.Kind() == reflect.Slice {
+		return strings.Join(v.Interface().([]string), ",")
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// rawFlag records the value of a flag so it can be applied after the file
+// and the environment.
+type rawFlag struct {
+	def    string
+	value  string
+	set    bool
+	isBool bool
+}
+
+func (f *rawFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	return f.def
+}
+
+func (f *rawFlag) Set(s string) error {
+	f.value, f.set = s, true
+	return nil
+}
+
+func (f *rawFlag) IsBoolFlag() bool { return f.isBool }
+
+// errs collects validation problems so all of them are reported at once.
+type errs []error
+
+func (e *errs) add(format string, args ...any) {
+	*e = append(*e, fmt.Errorf(format, args...))
+}
+
+func (e errs) err(name string) error {
+	if len(e) == 0 {
+		return nil
+	}
+	return fmt.Errorf("invalid %s config: %w", name, errors.Join(e...))
+}
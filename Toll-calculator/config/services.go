@@ -0,0 +1,538 @@
+package config
+
+import (
+	"encoding/base64"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/0x0Glitch/toll-calculator/billing"
+	"github.com/0x0Glitch/toll-calculator/contract"
+	"github.com/0x0Glitch/toll-calculator/money"
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+)
+
+// Common holds the settings every service has.
+type Common struct {
+	LogLevel        string        `yaml:"logLevel" env:"LOG_LEVEL" flag:"log-level" default:"info" usage:"log level: debug, info, warn or error" reload:"true"`
+	TracesExporter  string        `yaml:"tracesExporter" env:"OTEL_TRACES_EXPORTER" flag:"traces-exporter" default:"none" usage:"trace exporter: none, stdout or otlp"`
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout" env:"SHUTDOWN_TIMEOUT" flag:"shutdown-timeout" default:"15s" usage:"how long the service gets to drain on SIGTERM"`
+}
+
+// Level returns the parsed log level. It is info if LogLevel doesn't parse,
+// which Validate reports.
+func (c Common) Level() logrus.Level {
+	level, err := logrus.ParseLevel(c.LogLevel)
+	if err != nil {
+		return logrus.InfoLevel
+	}
+	return level
+}
+
+func (c Common) validate(e *errs) {
+	if _, err := logrus.ParseLevel(c.LogLevel); err != nil {
+		e.add("logLevel: %w", err)
+	}
+	switch c.TracesExporter {
+	case "none", "stdout", "otlp":
+	default:
+		e.add("tracesExporter: unknown exporter %q", c.TracesExporter)
+	}
+	if c.ShutdownTimeout <= 0 {
+		e.add("shutdownTimeout: must be positive")
+	}
+}
+
+// Kafka is the connection to the Kafka cluster carrying the OBU fixes.
+type Kafka struct {
+	Brokers      string `yaml:"brokers" env:"KAFKA_BROKERS" flag:"kafka-brokers" default:"localhost:9092" usage:"comma separated Kafka bootstrap servers"`
+	Topic        string `yaml:"topic" env:"KAFKA_TOPIC" flag:"kafka-topic" default:"obudata" usage:"topic the OBU fixes are produced to"`
+	SASLUsername string `yaml:"saslUsername" env:"KAFKA_SASL_USERNAME" usage:"SASL/PLAIN user, enables SASL over TLS"`
+	SASLPassword string `yaml:"saslPassword" env:"KAFKA_SASL_PASSWORD" secret:"true"`
+}
+
+// ConfigMap returns the client settings for connecting to the cluster.
+func (k Kafka) ConfigMap() *kafka.ConfigMap {
+	m := &kafka.ConfigMap{"bootstrap.servers": k.Brokers}
+	if k.SASLUsername != "" {
+		m.SetKey("security.protocol", "SASL_SSL")
+		m.SetKey("sasl.mechanisms", "PLAIN")
+		m.SetKey("sasl.username", k.SASLUsername)
+		m.SetKey("sasl.password", k.SASLPassword)
+	}
+	return m
+}
+
+func (k Kafka) validate(e *errs) {
+	if k.Brokers == "" {
+		e.add("kafka.brokers: must not be empty")
+	}
+	if k.Topic == "" {
+		e.add("kafka.topic: must not be empty")
+	}
+	if (k.SASLUsername == "") != (k.SASLPassword == "") {
+		e.add("kafka: saslUsername and saslPassword must be set together")
+	}
+}
+
+// TLS names the PEM files a service secures its internal traffic with. When
+// set, listeners require client certificates signed by the CA and clients
+// present the certificate. Replaced files are picked up without a restart.
+type TLS struct {
+	CAFile   string `yaml:"caFile" env:"TLS_CA_FILE" flag:"tls-ca" usage:"CA bundle peers are verified against, enables mutual TLS"`
+	CertFile string `yaml:"certFile" env:"TLS_CERT_FILE" flag:"tls-cert" usage:"certificate presented to peers"`
+	KeyFile  string `yaml:"keyFile" env:"TLS_KEY_FILE" flag:"tls-key" usage:"private key of the certificate"`
+}
+
+// Enabled reports whether mutual TLS is configured.
+func (t TLS) Enabled() bool {
+	return t.CAFile != ""
+}
+
+func (t TLS) validate(e *errs) {
+	if (t.CAFile == "") != (t.CertFile == "") || (t.CAFile == "") != (t.KeyFile == "") {
+		e.add("tls: caFile, certFile and keyFile must be set together")
+	}
+}
+
+// Privacy configures the encryption of the coordinates in Kafka. The data
+// receiver, the distance calculator and the aggregator must share the
+// keyring directory and the master key.
+type Privacy struct {
+	KeyringDir string `yaml:"keyringDir" env:"PRIVACY_KEYRING_DIR" flag:"keyring-dir" usage:"directory of the per vehicle data keys, enables encrypted coordinates"`
+	MasterKey  string `yaml:"masterKey" env:"PRIVACY_MASTER_KEY" secret:"true" usage:"base64 encoded 32 byte key wrapping the data keys"`
+}
+
+// Enabled reports whether coordinates are encrypted.
+func (p Privacy) Enabled() bool {
+	return p.KeyringDir != ""
+}
+
+// Key returns the decoded master key. It is nil if MasterKey doesn't
+// decode, which Validate reports.
+func (p Privacy) Key() []byte {
+	key, err := base64.StdEncoding.DecodeString(p.MasterKey)
+	if err != nil {
+		return nil
+	}
+	return key
+}
+
+func (p Privacy) validate(e *errs) {
+	if (p.KeyringDir == "") != (p.MasterKey == "") {
+		e.add("privacy: keyringDir and masterKey must be set together")
+	}
+	if p.MasterKey != "" && len(p.Key()) != 32 {
+		e.add("privacy.masterKey: must be 32 bytes of base64")
+	}
+}
+
+// GPSFilter configures the rejection of implausible fixes. Every check is
+// off at zero.
+type GPSFilter struct {
+	MaxSpeed    float64 `yaml:"maxSpeed" env:"CALCULATOR_MAX_SPEED" flag:"max-speed" default:"0" usage:"fastest plausible speed in km/h, faster fixes are rejected"`
+	MaxHDOP     float64 `yaml:"maxHDOP" env:"CALCULATOR_MAX_HDOP" flag:"max-hdop" default:"0" usage:"worst horizontal dilution of precision accepted"`
+	MaxAccuracy float64 `yaml:"maxAccuracy" env:"CALCULATOR_MAX_ACCURACY" flag:"max-accuracy" default:"0" usage:"largest accuracy radius in metres accepted"`
+	Jitter      float64 `yaml:"jitter" env:"CALCULATOR_JITTER" flag:"jitter" default:"0" usage:"metres a vehicle must move before it is taken to be moving"`
+	Smoothing   float64 `yaml:"smoothing" env:"CALCULATOR_SMOOTHING" flag:"smoothing" default:"0" usage:"expected acceleration in m/s², enables the Kalman filter"`
+}
+
+// Enabled reports whether fixes are filtered at all.
+func (f GPSFilter) Enabled() bool {
+	return f.MaxSpeed > 0 || f.MaxHDOP > 0 || f.MaxAccuracy > 0 || f.Jitter > 0 || f.Smoothing > 0
+}
+
+func (f GPSFilter) validate(e *errs) {
+	for _, v := range []struct {
+		name  string
+		value float64
+	}{
+		{"maxSpeed", f.MaxSpeed},
+		{"maxHDOP", f.MaxHDOP},
+		{"maxAccuracy", f.MaxAccuracy},
+		{"jitter", f.Jitter},
+		{"smoothing", f.Smoothing},
+	} {
+		if v.value < 0 {
+			e.add("gpsFilter.%s: must not be negative", v.name)
+		}
+	}
+}
+
+// Gaps configures the detection of gaps in the fixes of a vehicle, such as
+// in a tunnel. Every threshold is off at zero.
+type Gaps struct {
+	MaxTime     time.Duration `yaml:"maxTime" env:"CALCULATOR_GAP_TIME" flag:"gap-time" default:"0" usage:"time between two fixes beyond which the leg between them is estimated"`
+	MaxDistance float64       `yaml:"maxDistance" env:"CALCULATOR_GAP_DISTANCE" flag:"gap-distance" default:"0" usage:"metres between two fixes beyond which the leg between them is estimated"`
+	Interpolate bool          `yaml:"interpolate" env:"CALCULATOR_INTERPOLATE_GAPS" flag:"interpolate-gaps" usage:"route legs across gaps over the road network"`
+}
+
+func (g Gaps) validate(e *errs, roads bool) {
+	if g.MaxTime < 0 {
+		e.add("gaps.maxTime: must not be negative")
+	}
+	if g.MaxDistance < 0 {
+		e.add("gaps.maxDistance: must not be negative")
+	}
+	if g.Interpolate && !roads {
+		e.add("gaps.interpolate: needs mapMatch.roads")
+	}
+}
+
+// MapMatch configures the matching of fixes to a road network.
+type MapMatch struct {
+	Roads  string  `yaml:"roads" env:"CALCULATOR_ROADS" flag:"roads" usage:"road network, an .osm extract or GeoJSON, enables map matching"`
+	Radius float64 `yaml:"radius" env:"CALCULATOR_MATCH_RADIUS" flag:"match-radius" default:"50" usage:"metres from a fix roads are considered within"`
+	Sigma  float64 `yaml:"sigma" env:"CALCULATOR_MATCH_SIGMA" flag:"match-sigma" default:"10" usage:"standard deviation of the GPS error in metres"`
+	Audit  string  `yaml:"audit" env:"CALCULATOR_MATCH_AUDIT" flag:"match-audit" usage:"file the matched road IDs of every leg are appended to, logged at debug level when empty"`
+	// AuditRetention is how long the audit log keeps a leg, since the roads
+	// a vehicle drove are where it went.
+	AuditRetention time.Duration `yaml:"auditRetention" env:"CALCULATOR_MATCH_AUDIT_RETENTION" flag:"match-audit-retention" default:"0" usage:"how long the audit log keeps a leg, 0 for ever"`
+}
+
+// Enabled reports whether fixes are matched to roads.
+func (m MapMatch) Enabled() bool {
+	return m.Roads != ""
+}
+
+func (m MapMatch) validate(e *errs) {
+	if m.Radius <= 0 {
+		e.add("mapMatch.radius: must be positive")
+	}
+	if m.Sigma <= 0 {
+		e.add("mapMatch.sigma: must be positive")
+	}
+	if m.AuditRetention < 0 {
+		e.add("mapMatch.auditRetention: must not be negative")
+	}
+}
+
+// Upstream is how a service reaches the aggregator.
+type Upstream struct {
+	Target  string `yaml:"target" flag:"aggregator" env:"AGGREGATOR_TARGET" default:"http://localhost:3000" usage:"aggregator endpoints: comma separated list, srv://_service._proto.name or file:///path"`
+	Balance string `yaml:"balance" flag:"balance" env:"AGGREGATOR_BALANCE" default:"roundrobin" usage:"aggregator balancing policy: roundrobin or leastloaded"`
+}
+
+func (u Upstream) validate(e *errs) {
+	if u.Target == "" {
+		e.add("aggregator.target: must not be empty")
+	}
+	switch u.Balance {
+	case "roundrobin", "leastloaded":
+	default:
+		e.add("aggregator.balance: unknown policy %q", u.Balance)
+	}
+}
+
+// Receiver configures the data receiver.
+type Receiver struct {
+	Common     `yaml:",inline"`
+	ListenAddr string `yaml:"listenAddr" env:"RECEIVER_LISTEN_ADDR" flag:"listenAddr" default:":30000" usage:"listen address of /ws, /metrics, /healthz and /readyz"`
+	Kafka      Kafka  `yaml:"kafka"`
+	TLS        TLS    `yaml:"tls"`
+	// Devices is the vehicle registry. Without it any client can send
+	// fixes for any OBU.
+	Devices      string        `yaml:"devices" env:"RECEIVER_DEVICES" flag:"devices" usage:"vehicle registry of OBU credentials, enables signed fixes"`
+	ReplayWindow time.Duration `yaml:"replayWindow" env:"RECEIVER_REPLAY_WINDOW" flag:"replay-window" default:"5m" usage:"how far the timestamp of a signed fix may be off"`
+	Privacy      Privacy       `yaml:"privacy"`
+	// RawRetention is how long Kafka keeps the raw fixes, the trajectories
+	// of the vehicles.
+	RawRetention time.Duration `yaml:"rawRetention" env:"RECEIVER_RAW_RETENTION" flag:"raw-retention" default:"0" usage:"retention set on the Kafka topic, 0 keeps the topic's own"`
+}
+
+func (c *Receiver) Validate() error {
+	var e errs
+	c.Common.validate(&e)
+	validAddr(&e, "listenAddr", c.ListenAddr)
+	c.Kafka.validate(&e)
+	c.TLS.validate(&e)
+	if c.ReplayWindow <= 0 {
+		e.add("replayWindow: must be positive")
+	}
+	c.Privacy.validate(&e)
+	if c.RawRetention < 0 {
+		e.add("rawRetention: must not be negative")
+	}
+	return e.err("receiver")
+}
+
+// Calculator configures the distance calculator.
+type Calculator struct {
+	Common      `yaml:",inline"`
+	MetricsAddr string    `yaml:"metricsAddr" env:"CALCULATOR_METRICS_ADDR" flag:"metrics" default:":9091" usage:"listen address of /metrics, /healthz, /readyz and /admin/erase"`
+	Kafka       Kafka     `yaml:"kafka"`
+	GroupID     string    `yaml:"groupID" env:"KAFKA_GROUP_ID" flag:"kafka-group" default:"myGroup" usage:"Kafka consumer group"`
+	Aggregator  Upstream  `yaml:"aggregator"`
+	RateLimit   float64   `yaml:"rateLimit" env:"CALCULATOR_RATE_LIMIT" flag:"rate-limit" default:"0" usage:"max messages handled per second, 0 for unlimited" reload:"true"`
+	TLS         TLS       `yaml:"tls"`
+	Privacy     Privacy   `yaml:"privacy"`
+	Zones       string    `yaml:"zones" env:"CALCULATOR_ZONES" flag:"zones" usage:"GeoJSON file of the toll zones and tolled roads distance is split by"`
+	GPSFilter   GPSFilter `yaml:"gpsFilter"`
+	MapMatch    MapMatch  `yaml:"mapMatch"`
+	Gaps        Gaps      `yaml:"gaps"`
+	// TripIdle is how long a vehicle may stand still before its trip ends.
+	TripIdle time.Duration `yaml:"tripIdle" env:"CALCULATOR_TRIP_IDLE" flag:"trip-idle" default:"10m" usage:"how long a vehicle stands still before its trip ends, 0 doesn't detect trips"`
+	// RetryBackoff is how long distance the aggregator failed to take waits
+	// before it is sent again.
+	RetryBackoff time.Duration `yaml:"retryBackoff" env:"CALCULATOR_RETRY_BACKOFF" flag:"retry-backoff" default:"1s" usage:"wait before sending distance the aggregator failed to take again"`
+}
+
+func (c *Calculator) Validate() error {
+	var e errs
+	c.Common.validate(&e)
+	validAddr(&e, "metricsAddr", c.MetricsAddr)
+	c.Kafka.validate(&e)
+	if c.GroupID == "" {
+		e.add("groupID: must not be empty")
+	}
+	if c.RetryBackoff <= 0 {
+		e.add("retryBackoff: must be positive")
+	}
+	c.Aggregator.validate(&e)
+	validRate(&e, c.RateLimit)
+	c.TLS.validate(&e)
+	c.Privacy.validate(&e)
+	c.GPSFilter.validate(&e)
+	c.MapMatch.validate(&e)
+	c.Gaps.validate(&e, c.MapMatch.Enabled())
+	if c.TripIdle < 0 {
+		e.add("tripIdle: must not be negative")
+	}
+	return e.err("calculator")
+}
+
+// Cluster is the membership of a sharded aggregator.
+type Cluster struct {
+	Self         string        `yaml:"self" env:"AGG_CLUSTER_SELF" flag:"cluster-self" usage:"HTTP address other nodes reach this one on, empty for a single node"`
+	Members      string        `yaml:"members" env:"AGG_CLUSTER_MEMBERS" flag:"cluster-members" usage:"resolver target listing every node, e.g. file:///etc/toll/aggregators"`
+	PollInterval time.Duration `yaml:"pollInterval" env:"AGG_CLUSTER_POLL_INTERVAL" flag:"cluster-poll-interval" default:"10s" usage:"how often the membership is resolved"`
+}
+
+// Admin configures the listener of the /admin routes, which close periods,
+// act on invoices, manage contracts and erase vehicles. It is kept apart
+// from the public listener and every request needs the token.
+type Admin struct {
+	Addr  string `yaml:"addr" env:"AGG_ADMIN_LISTEN_ADDR" flag:"admin-addr" usage:"listen address of the /admin routes, empty to serve none"`
+	Token string `yaml:"token" env:"AGG_ADMIN_TOKEN" secret:"true" usage:"bearer token every /admin request must carry"`
+}
+
+// Enabled reports whether the admin routes are served.
+func (a Admin) Enabled() bool {
+	return a.Addr != ""
+}
+
+func (a Admin) validate(e *errs) {
+	if !a.Enabled() {
+		return
+	}
+	validAddr(e, "admin.addr", a.Addr)
+	if a.Token == "" {
+		e.add("admin.token: must be set with admin.addr")
+	}
+}
+
+// Billing configures the closing of billing periods into invoices, the
+// currency, rounding and taxes of their amounts, and the off-peak hours
+// contracts may discount.
+type Billing struct {
+	Cycle           string `yaml:"cycle" env:"AGG_BILLING_CYCLE" flag:"billing-cycle" default:"month" usage:"billing period, closed into invoices when it ends: day, week or month"`
+	Review          bool   `yaml:"review" env:"AGG_BILLING_REVIEW" flag:"billing-review" usage:"leave the invoices of a closed period drafts until finalized"`
+	Currency        string `yaml:"currency" env:"AGG_BILLING_CURRENCY" flag:"billing-currency" usage:"currency invoices are billed in, the tariff's if empty"`
+	Rounding        string `yaml:"rounding" env:"AGG_BILLING_ROUNDING" flag:"billing-rounding" default:"half-even" usage:"how amounts are rounded to the currency's minor units: half-even, half-up or down"`
+	RoundingScope   string `yaml:"roundingScope" env:"AGG_BILLING_ROUNDING_SCOPE" flag:"billing-rounding-scope" default:"line" usage:"what is rounded: every line item or only the invoice total"`
+	Rates           string `yaml:"rates" env:"AGG_FX_RATES" flag:"fx-rates" usage:"YAML file of exchange rates converting tariffs in other currencies"`
+	Taxes           string `yaml:"taxes" env:"AGG_TAX_RULES" flag:"tax-rules" usage:"YAML file of the tax jurisdictions, the zones they tax and the vehicles' classes and VAT IDs; empty bills without tax"`
+	OffPeak         string `yaml:"offPeak" env:"AGG_OFF_PEAK" flag:"off-peak" usage:"daily off-peak hours contracts may discount, e.g. 22:00-06:00"`
+	OffPeakWeekends bool   `yaml:"offPeakWeekends" env:"AGG_OFF_PEAK_WEEKENDS" flag:"off-peak-weekends" usage:"count all of Saturday and Sunday as off-peak"`
+	TimeZone        string `yaml:"timeZone" env:"AGG_OFF_PEAK_TZ" flag:"off-peak-tz" default:"UTC" usage:"time zone of the off-peak hours"`
+}
+
+func (b Billing) validate(e *errs) {
+	switch b.Cycle {
+	case "day", "week", "month":
+	default:
+		e.add("billing.cycle: unknown cycle %q", b.Cycle)
+	}
+	if b.Currency != "" {
+		if _, err := money.ParseCurrency(b.Currency); err != nil {
+			e.add("billing.currency: %v", err)
+		}
+	}
+	if _, err := money.ParseMode(b.Rounding); err != nil {
+		e.add("billing.rounding: %v", err)
+	}
+	if _, err := money.ParseScope(b.RoundingScope); err != nil {
+		e.add("billing.roundingScope: %v", err)
+	}
+	if _, err := b.OffPeakHours(); err != nil {
+		e.add("billing.offPeak: %v", err)
+	}
+}
+
+// OffPeakHours returns the off-peak hours, nil if there are none.
+func (b Billing) OffPeakHours() (*contract.Hours, error) {
+	if b.OffPeak == "" && !b.OffPeakWeekends {
+		return nil, nil
+	}
+	loc, err := time.LoadLocation(b.TimeZone)
+	if err != nil {
+		return nil, err
+	}
+	return contract.ParseHours(b.OffPeak, b.OffPeakWeekends, loc)
+}
+
+// Pricing returns the pricing of the invoices, billed in the tariff's
+// currency unless the billing currency is set.
+func (b Billing) Pricing(tariff money.Currency) billing.Pricing {
+	p := billing.Pricing{
+		Currency: tariff,
+		Rounding: money.Rounding{Mode: money.Mode(b.Rounding), Scope: money.Scope(b.RoundingScope)},
+	}
+	if b.Currency != "" {
+		p.Currency = money.Currency(b.Currency)
+	}
+	return p
+}
+
+// Aggregator configures the aggregator.
+type Aggregator struct {
+	Common   `yaml:",inline"`
+	HTTPAddr string  `yaml:"httpAddr" env:"AGG_HTTP_LISTEN_ADDR" flag:"http-addr" default:":3000" usage:"HTTP listen address"`
+	GRPCAddr string  `yaml:"grpcAddr" env:"AGG_GRPC_LISTEN_ADDR" flag:"grpc-addr" default:":3001" usage:"gRPC listen address"`
+	Admin    Admin   `yaml:"admin"`
+	Tariff   float64 `yaml:"tariff" env:"AGG_TARIFF" flag:"tariff" default:"315" usage:"price per kilometre" reload:"true"`
+	Currency string  `yaml:"currency" env:"AGG_CURRENCY" flag:"currency" default:"EUR" usage:"ISO 4217 currency of the tariff and of zone tariffs without one"`
+	Cluster  Cluster `yaml:"cluster"`
+	TLS      TLS     `yaml:"tls"`
+	Privacy  Privacy `yaml:"privacy"`
+	Zones    string  `yaml:"zones" env:"AGG_ZONES" flag:"zones" usage:"GeoJSON file of the toll zones, prices each at its tariff and leaves distance outside them untolled"`
+	// Retention is how long the distance total of a vehicle is kept after
+	// its last fix.
+	Retention time.Duration `yaml:"retention" env:"AGG_RETENTION" flag:"retention" default:"0" usage:"how long totals are kept after a vehicle's last fix, 0 for ever"`
+	Billing   Billing       `yaml:"billing"`
+}
+
+func (c *Aggregator) Validate() error {
+	var e errs
+	c.Common.validate(&e)
+	validAddr(&e, "httpAddr", c.HTTPAddr)
+	validAddr(&e, "grpcAddr", c.GRPCAddr)
+	if c.Tariff <= 0 {
+		e.add("tariff: must be positive")
+	}
+	if _, err := money.ParseCurrency(c.Currency); err != nil {
+		e.add("currency: %v", err)
+	}
+	if c.Cluster.Self != "" {
+		if c.Cluster.Members == "" {
+			e.add("cluster.members: must be set with cluster.self")
+		}
+		if c.Cluster.PollInterval <= 0 {
+			e.add("cluster.pollInterval: must be positive")
+		}
+		// Members only trust each other's forwarded requests over mutual
+		// TLS; without it they would forward every request again.
+		if !c.TLS.Enabled() {
+			e.add("cluster.self: needs tls to authenticate the members")
+		}
+	}
+	c.Admin.validate(&e)
+	c.TLS.validate(&e)
+	c.Privacy.validate(&e)
+	if c.Retention < 0 {
+		e.add("retention: must not be negative")
+	}
+	c.Billing.validate(&e)
+	return e.err("aggregator")
+}
+
+// Gateway configures the invoice gateway.
+type Gateway struct {
+	Common     `yaml:",inline"`
+	ListenAddr string   `yaml:"listenAddr" env:"GATEWAY_LISTEN_ADDR" flag:"listenAddr" default:":6000" usage:"the listen address of the http server"`
+	Aggregator Upstream `yaml:"aggregator"`
+	RateLimit  float64  `yaml:"rateLimit" env:"GATEWAY_RATE_LIMIT" flag:"rate-limit" default:"0" usage:"max requests per second, 0 for unlimited" reload:"true"`
+	TLS        TLS      `yaml:"tls"`
+}
+
+func (c *Gateway) Validate() error {
+	var e errs
+	c.Common.validate(&e)
+	validAddr(&e, "listenAddr", c.ListenAddr)
+	c.Aggregator.validate(&e)
+	validRate(&e, c.RateLimit)
+	c.TLS.validate(&e)
+	return e.err("gateway")
+}
+
+// AggSvc configures the go-kit aggregator service.
+type AggSvc struct {
+	Common    `yaml:",inline"`
+	HTTPAddr  string  `yaml:"httpAddr" env:"AGGSVC_HTTP_ADDR" flag:"http-addr" default:":3000" usage:"HTTP listen address"`
+	GRPCAddr  string  `yaml:"grpcAddr" env:"AGGSVC_GRPC_ADDR" flag:"grpc-addr" default:":3001" usage:"gRPC listen address"`
+	RateLimit float64 `yaml:"rateLimit" env:"AGGSVC_RATE_LIMIT" flag:"rate-limit" default:"0" usage:"max requests per second per endpoint, 0 for unlimited"`
+}
+
+func (c *AggSvc) Validate() error {
+	var e errs
+	c.Common.validate(&e)
+	validAddr(&e, "httpAddr", c.HTTPAddr)
+	validAddr(&e, "grpcAddr", c.GRPCAddr)
+	validRate(&e, c.RateLimit)
+	return e.err("aggsvc")
+}
+
+// OBU configures the OBU simulator.
+type OBU struct {
+	Endpoint string        `yaml:"endpoint" env:"OBU_ENDPOINT" flag:"endpoint" default:"ws://127.0.0.1:30000/ws" usage:"WebSocket endpoint of the data receiver"`
+	Count    int           `yaml:"count" env:"OBU_COUNT" flag:"count" default:"20" usage:"number of simulated OBUs"`
+	Interval time.Duration `yaml:"interval" env:"OBU_INTERVAL" flag:"interval" default:"5s" usage:"time between two rounds of fixes"`
+	TLS      TLS           `yaml:"tls"`
+	Devices  string        `yaml:"devices" env:"OBU_DEVICES" flag:"devices" usage:"credentials of the simulated OBUs, which then sign their fixes; replaces count"`
+}
+
+func (c *OBU) Validate() error {
+	var e errs
+	if u, err := url.Parse(c.Endpoint); err != nil || (u.Scheme != "ws" && u.Scheme != "wss") {
+		e.add("endpoint: %q is not a ws:// or wss:// URL", c.Endpoint)
+	}
+	if c.Count <= 0 {
+		e.add("count: must be positive")
+	}
+	if c.Interval <= 0 {
+		e.add("interval: must be positive")
+	}
+	c.TLS.validate(&e)
+	if c.TLS.Enabled() && !strings.HasPrefix(c.Endpoint, "wss://") {
+		e.add("endpoint: must be a wss:// URL with tls set")
+	}
+	return e.err("obu")
+}
+
+func validAddr(e *errs, name, addr string) {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		e.add("%s: %q is not a host:port address", name, addr)
+	}
+}
+
+func validRate(e *errs, perSecond float64) {
+	if perSecond < 0 {
+		e.add("rateLimit: must not be negative")
+	}
+}
+
+// Limit converts a rateLimit setting to a limit and burst for a
+// rate.Limiter: 0 is unlimited and bursts allow up to one second's worth of
+// requests.
+func Limit(perSecond float64) (rate.Limit, int) {
+	if perSecond <= 0 {
+		return rate.Inf, 1
+	}
+	if perSecond < 1 {
+		return rate.Limit(perSecond), 1
+	}
+	return rate.Limit(perSecond), int(perSecond)
+}
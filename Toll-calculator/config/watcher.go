@@ -0,0 +1,243 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/0x0Glitch/toll-calculator/apperr"
+	"github.com/0x0Glitch/toll-calculator/filewatch"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+)
+
+// WatchInterval is how often services check their config file for changes.
+const WatchInterval = 5 * time.Second
+
+// ApplyFunc makes a service use the reloadable settings of next. Watchers
+// also call it with the arguments swapped to roll a failed reload back.
+type ApplyFunc[T any] func(prev, next *T) error
+
+// Watcher reloads the config of a running service on SIGHUP or when its
+// config file changes. A reload is all or nothing: the new config must
+// load and validate, may only differ in settings tagged reload:"true", and
+// every apply step must succeed, otherwise the running config stays.
+type Watcher[T any] struct {
+	args  []string
+	files *filewatch.Files
+
+	mu      sync.Mutex
+	steps   []ApplyFunc[T]
+	current atomic.Pointer[T]
+	version atomic.Int64
+	loaded  atomic.Int64
+
+	versionGauge prometheus.Gauge
+	reloads      *prometheus.CounterVec
+}
+
+// NewWatcher loads the config from args like Load and returns a watcher
+// holding it as version 1. Its metrics are registered with reg.
+func NewWatcher[T any](args []string, reg prometheus.Registerer) (*Watcher[T], error) {
+	cfg := new(T)
+	file, err := load(cfg, args)
+	if err != nil {
+		return nil, err
+	}
+	factory := promauto.With(reg)
+	w := &Watcher[T]{
+		args:  args,
+		files: filewatch.New(),
+		versionGauge: factory.NewGauge(prometheus.GaugeOpts{
+			Namespace: "toll",
+			Subsystem: "config",
+			Name:      "version",
+			Help:      "Version of the active config, incremented by every applied reload.",
+		}),
+		reloads: factory.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "toll",
+			Subsystem: "config",
+			Name:      "reloads_total",
+			Help:      "Config reloads by result: applied, unchanged or failed.",
+		}, []string{"result"}),
+	}
+	if file != "" {
+		// The file was read by load just now; later edits reload it.
+		w.files = filewatch.New(file)
+		if err := w.files.Load(func() error { return nil }); err != nil {
+			return nil, err
+		}
+	}
+	w.swap(cfg)
+	return w, nil
+}
+
+// OnReload adds a step applying the reloadable settings. Steps run in the
+// order they were added.
+func (w *Watcher[T]) OnReload(step ApplyFunc[T]) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.steps = append(w.steps, step)
+}
+
+// Current returns the active config. It must not be modified.
+func (w *Watcher[T]) Current() *T {
+	return w.current.Load()
+}
+
+// Version returns the version of the active config.
+func (w *Watcher[T]) Version() int64 {
+	return w.version.Load()
+}
+
+// Reload loads the config again and applies it. It returns an
+// InvalidArgument error when the new config is rejected.
+func (w *Watcher[T]) Reload() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	prev := w.current.Load()
+	next := new(T)
+	if _, err := load(next, w.args); err != nil {
+		return w.fail(err)
+	}
+	fixed, changed := diff(prev, next)
+	if len(fixed) > 0 {
+		return w.fail(fmt.Errorf("%s can't change without a restart", strings.Join(fixed, ", ")))
+	}
+	if !changed {
+		w.reloads.WithLabelValues("unchanged").Inc()
+		return nil
+	}
+	for i, step := range w.steps {
+		if err := step(prev, next); err != nil {
+			for j := i - 1; j >= 0; j-- {
+				if rerr := w.steps[j](next, prev); rerr != nil {
+					logrus.WithError(rerr).Error("config rollback failed")
+				}
+			}
+			return w.fail(fmt.Errorf("applying reload: %w", err))
+		}
+	}
+	w.swap(next)
+	w.reloads.WithLabelValues("applied").Inc()
+	return nil
+}
+
+func (w *Watcher[T]) fail(err error) error {
+	w.reloads.WithLabelValues("failed").Inc()
+	return apperr.Wrap(apperr.InvalidArgument, err, "config reload rejected")
+}
+
+func (w *Watcher[T]) swap(cfg *T) {
+	w.current.Store(cfg)
+	w.versionGauge.Set(float64(w.version.Add(1)))
+	w.loaded.Store(time.Now().Unix())
+}
+
+// diff lists the settings that differ between prev and next but can't be
+// reloaded, and reports whether anything differs at all.
+func diff[T any](prev, next *T) (fixed []string, changed bool) {
+	a, _ := fieldsOf(prev)
+	b, _ := fieldsOf(next)
+	for i := range a {
+		if reflect.DeepEqual(a[i].value.Interface(), b[i].value.Interface()) {
+			continue
+		}
+		changed = true
+		if !a[i].reload {
+			fixed = append(fixed, a[i].path)
+		}
+	}
+	return fixed, changed
+}
+
+// Run reloads on SIGHUP and, if the config came from a file, whenever the
+// file's modification time changes, checking every interval. It returns
+// when ctx is done.
+func (w *Watcher[T]) Run(ctx context.Context, interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+		case <-ticker.C:
+			if !w.files.Changed() {
+				continue
+			}
+		}
+		// A rejected config still counts as loaded, so it is reported once
+		// rather than at every check, until the file is edited again.
+		var err error
+		if lerr := w.files.Load(func() error { err = w.Reload(); return nil }); lerr != nil {
+			err = lerr
+		}
+		if err != nil {
+			logrus.WithError(err).Errorf("keeping config version %d", w.Version())
+			continue
+		}
+		logrus.Infof("config version %d active", w.Version())
+	}
+}
+
+// Status is the body of the admin endpoint.
+type Status struct {
+	Version  int64             `json:"version"`
+	LoadedAt time.Time         `json:"loadedAt"`
+	Config   map[string]string `json:"config"`
+}
+
+// Status returns the version and settings of the active config, with
+// secrets redacted.
+func (w *Watcher[T]) Status() Status {
+	return Status{
+		Version:  w.Version(),
+		LoadedAt: time.Unix(w.loaded.Load(), 0).UTC(),
+		Config:   Values(w.Current()),
+	}
+}
+
+// Register mounts the admin endpoint on mux: GET /admin/config returns the
+// active config and POST /admin/config/reload reloads it.
+func (w *Watcher[T]) Register(mux *http.ServeMux) {
+	mux.HandleFunc("/admin/config", func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSON(rw, http.StatusMethodNotAllowed, apperr.Body{Error: "method not allowed", Code: apperr.InvalidArgument})
+			return
+		}
+		writeJSON(rw, http.StatusOK, w.Status())
+	})
+	mux.HandleFunc("/admin/config/reload", func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(rw, http.StatusMethodNotAllowed, apperr.Body{Error: "method not allowed", Code: apperr.InvalidArgument})
+			return
+		}
+		if err := w.Reload(); err != nil {
+			writeJSON(rw, apperr.HTTPStatus(err), apperr.BodyOf(err))
+			return
+		}
+		writeJSON(rw, http.StatusOK, w.Status())
+	})
+}
+
+func writeJSON(rw http.ResponseWriter, code int, v any) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(code)
+	json.NewEncoder(rw).Encode(v)
+}
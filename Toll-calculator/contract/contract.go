@@ -0,0 +1,182 @@
+// Package contract keeps the contracts fleet customers negotiated:
+// flat-rate subscriptions, volume, off-peak and promo discounts, and the
+// vehicles they cover. Package billing applies them to the invoices.
+package contract
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/0x0Glitch/toll-calculator/apperr"
+	"github.com/0x0Glitch/toll-calculator/money"
+	"github.com/0x0Glitch/toll-calculator/types"
+)
+
+// Validate checks that the contract covers vehicles for a period and that
+// its subscription and discounts are well formed.
+func Validate(c types.Contract) error {
+	switch {
+	case c.ID == "":
+		return apperr.InvalidArgumentf("contract has no id")
+	case len(c.OBUIDs) == 0:
+		return apperr.InvalidArgumentf("contract %s covers no vehicles", c.ID)
+	case c.From.IsZero():
+		return apperr.InvalidArgumentf("contract %s has no start", c.ID)
+	case !c.To.IsZero() && !c.To.After(c.From):
+		return apperr.InvalidArgumentf("contract %s ends before it starts", c.ID)
+	case c.Subscription == nil && len(c.Discounts) == 0:
+		return apperr.InvalidArgumentf("contract %s has neither a subscription nor discounts", c.ID)
+	}
+	if c.Currency != "" {
+		if _, err := money.ParseCurrency(string(c.Currency)); err != nil {
+			return apperr.Wrap(apperr.InvalidArgument, err, "contract %s", c.ID)
+		}
+	}
+	if s := c.Subscription; s != nil && s.Fee.Sign() < 0 {
+		return apperr.InvalidArgumentf("contract %s: subscription fee is negative", c.ID)
+	}
+	for i, d := range c.Discounts {
+		if err := validateDiscount(d); err != nil {
+			return apperr.Wrap(apperr.InvalidArgument, err, "contract %s: discount %d", c.ID, i)
+		}
+	}
+	return nil
+}
+
+func validateDiscount(d types.Discount) error {
+	switch d.Kind {
+	case types.DiscountVolume:
+		if d.Threshold < 0 {
+			return apperr.InvalidArgumentf("threshold is negative")
+		}
+	case types.DiscountOffPeak:
+	case types.DiscountPromo:
+		if d.Code == "" {
+			return apperr.InvalidArgumentf("promo discount has no code")
+		}
+		if d.Amount.IsZero() == d.Percent.IsZero() {
+			return apperr.InvalidArgumentf("promo discount %s needs either a percent or an amount", d.Code)
+		}
+	default:
+		return apperr.InvalidArgumentf("unknown kind %q", d.Kind)
+	}
+	if !d.To.IsZero() && !d.To.After(d.From) {
+		return apperr.InvalidArgumentf("%s discount ends before it starts", d.Kind)
+	}
+	if !d.Amount.IsZero() {
+		if d.Kind != types.DiscountPromo {
+			return apperr.InvalidArgumentf("%s discount can't take a fixed amount off", d.Kind)
+		}
+		if d.Amount.Sign() < 0 {
+			return apperr.InvalidArgumentf("promo discount %s: amount is negative", d.Code)
+		}
+		return nil
+	}
+	if d.Percent.Sign() <= 0 || d.Percent.Cmp(money.NewFromInt(100)) > 0 {
+		return apperr.InvalidArgumentf("%s discount: percent must be above 0 and at most 100", d.Kind)
+	}
+	return nil
+}
+
+// Active reports whether the contract applies at t.
+func Active(c types.Contract, t time.Time) bool {
+	return !t.Before(c.From) && (c.To.IsZero() || t.Before(c.To))
+}
+
+// DiscountActive reports whether the discount is valid at t.
+func DiscountActive(d types.Discount, t time.Time) bool {
+	return (d.From.IsZero() || !t.Before(d.From)) && (d.To.IsZero() || t.Before(d.To))
+}
+
+// overlap reports whether the contracts apply at some time both.
+func overlap(a, b types.Contract) bool {
+	return (b.To.IsZero() || a.From.Before(b.To)) && (a.To.IsZero() || b.From.Before(a.To))
+}
+
+// Registry keeps the contracts in memory. A vehicle is covered by at most
+// one contract at a time, so which one prices its invoice is never in
+// doubt. A nil Registry has no contracts.
+type Registry struct {
+	mu        sync.RWMutex
+	contracts map[string]types.Contract
+}
+
+func NewRegistry() *Registry {
+	return &Registry{contracts: make(map[string]types.Contract)}
+}
+
+// Put adds the contract, or replaces the one with its ID. A contract
+// covering a vehicle another one covers at the same time is a conflict.
+func (r *Registry) Put(c types.Contract) error {
+	if err := Validate(c); err != nil {
+		return err
+	}
+	c.OBUIDs = slices.Clone(c.OBUIDs)
+	c.Discounts = slices.Clone(c.Discounts)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, other := range r.contracts {
+		if other.ID == c.ID || !overlap(c, other) {
+			continue
+		}
+		for _, id := range c.OBUIDs {
+			if slices.Contains(other.OBUIDs, id) {
+				return apperr.Conflictf("OBU %d is covered by contract %s at the same time", id, other.ID)
+			}
+		}
+	}
+	r.contracts[c.ID] = c
+	return nil
+}
+
+// Contract returns the contract with the ID.
+func (r *Registry) Contract(id string) (types.Contract, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	c, ok := r.contracts[id]
+	if !ok {
+		return types.Contract{}, apperr.NotFoundf("couldn't find contract %s", id)
+	}
+	return c, nil
+}
+
+// Contracts returns every contract in the order of their IDs.
+func (r *Registry) Contracts() []types.Contract {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make([]types.Contract, 0, len(r.contracts))
+	for _, c := range r.contracts {
+		out = append(out, c)
+	}
+	slices.SortFunc(out, func(a, b types.Contract) int { return cmp.Compare(a.ID, b.ID) })
+	return out
+}
+
+// Delete removes the contract with the ID. Invoices it already priced
+// keep their discounts.
+func (r *Registry) Delete(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.contracts[id]; !ok {
+		return apperr.NotFoundf("couldn't find contract %s", id)
+	}
+	delete(r.contracts, id)
+	return nil
+}
+
+// For returns the contract covering the vehicle at t.
+func (r *Registry) For(obuID int32, t time.Time) (types.Contract, bool) {
+	if r == nil {
+		return types.Contract{}, false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, c := range r.contracts {
+		if Active(c, t) && slices.Contains(c.OBUIDs, obuID) {
+			return c, true
+		}
+	}
+	return types.Contract{}, false
+}
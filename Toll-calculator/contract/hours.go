@@ -0,0 +1,54 @@
+package contract
+
+import (
+	"fmt"
+	"time"
+)
+
+// Hours are the off-peak hours: a daily window of local time, which may
+// run past midnight, and optionally all of the weekend. A nil Hours has
+// no off-peak hours.
+type Hours struct {
+	// start and end are minutes after midnight; start == end is no window.
+	start, end int
+	weekends   bool
+	loc        *time.Location
+}
+
+// ParseHours parses a window such as "22:00-06:00" in loc; an empty window
+// leaves only the weekends, if they are off-peak.
+func ParseHours(window string, weekends bool, loc *time.Location) (*Hours, error) {
+	h := &Hours{weekends: weekends, loc: loc}
+	if window == "" {
+		return h, nil
+	}
+	var sh, sm, eh, em int
+	if _, err := fmt.Sscanf(window, "%d:%d-%d:%d", &sh, &sm, &eh, &em); err != nil {
+		return nil, fmt.Errorf("contract: off-peak hours %q: want HH:MM-HH:MM", window)
+	}
+	for _, v := range [][2]int{{sh, sm}, {eh, em}} {
+		if v[0] < 0 || v[0] > 24 || v[1] < 0 || v[1] > 59 || v[0] == 24 && v[1] != 0 {
+			return nil, fmt.Errorf("contract: off-peak hours %q: %02d:%02d isn't a time of day", window, v[0], v[1])
+		}
+	}
+	h.start, h.end = sh*60+sm, eh*60+em
+	return h, nil
+}
+
+// Contains reports whether t falls in the off-peak hours.
+func (h *Hours) Contains(t time.Time) bool {
+	if h == nil {
+		return false
+	}
+	if h.loc != nil {
+		t = t.In(h.loc)
+	}
+	if h.weekends && (t.Weekday() == time.Saturday || t.Weekday() == time.Sunday) {
+		return true
+	}
+	m := t.Hour()*60 + t.Minute()
+	if h.start <= h.end {
+		return h.start <= m && m < h.end
+	}
+	return m >= h.start || m < h.end
+}
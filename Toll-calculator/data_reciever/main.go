@@ -1,82 +1,300 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"math/rand"
 	"net/http"
+	"os"
+	"sync"
+	"time"
 
+	"github.com/0x0Glitch/toll-calculator/config"
+	"github.com/0x0Glitch/toll-calculator/health"
+	"github.com/0x0Glitch/toll-calculator/mtls"
+	"github.com/0x0Glitch/toll-calculator/obuauth"
+	"github.com/0x0Glitch/toll-calculator/privacy"
+	"github.com/0x0Glitch/toll-calculator/shutdown"
+	"github.com/0x0Glitch/toll-calculator/tracing"
 	"github.com/0x0Glitch/toll-calculator/types"
 	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
-var kafkaTopic = "obudata"
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1028,
 	WriteBufferSize: 1028,
 }
 
 type DataReceiver struct {
-	msg  chan types.OBUData
-	conn *websocket.Conn
-	prod DataProducer
+	msg   chan types.OBUData
+	prod  DataProducer
+	kafka *kafkaProducer
+
+	// verifier rejects fixes that aren't signed by the OBU they claim to
+	// come from; nil accepts every fix.
+	verifier *obuauth.Verifier
+	rejected *prometheus.CounterVec
+
+	// Each OBU connection counts as work in flight until its receive loop
+	// returns, so draining waits for the fixes it already sent.
+	inflight shutdown.InFlight
+	mu       sync.Mutex
+	conns    map[*websocket.Conn]struct{}
 }
 
+var tracer = otel.Tracer("data_reciever")
+
 func main() {
-	recv, err := NewDataReciever()
+	var cfg config.Receiver
+	if err := config.Load(&cfg, os.Args[1:]); err != nil {
+		log.Fatal(err)
+	}
+	logrus.SetLevel(cfg.Level())
+	logrus.AddHook(privacy.RedactHook{})
+	logrus.Infof("effective config:\n%s", config.Dump(&cfg))
+
+	ctx, stop := shutdown.Signals(context.Background())
+	defer stop()
+
+	tp, err := tracing.Init(context.Background(), "data_reciever", cfg.TracesExporter)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var verifier *obuauth.Verifier
+	if cfg.Devices != "" {
+		registry, err := obuauth.LoadRegistry(cfg.Devices)
+		if err != nil {
+			log.Fatal(err)
+		}
+		go registry.Run(ctx, config.WatchInterval)
+		verifier = obuauth.NewVerifier(registry, cfg.ReplayWindow)
+	} else {
+		logrus.Warn("no vehicle registry configured, accepting unsigned fixes")
+	}
+
+	var keys *privacy.Keyring
+	if cfg.Privacy.Enabled() {
+		keys, err = privacy.NewKeyring(cfg.Privacy.KeyringDir, cfg.Privacy.Key())
+		if err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		logrus.Warn("no keyring configured, producing plaintext coordinates")
+	}
+
+	checker := health.NewChecker()
+	recv, err := NewDataReciever(cfg.Kafka, checker, verifier, keys)
+	if err != nil {
+		log.Fatal(err)
+	}
+	http.Handle("/metrics", promhttp.Handler())
+	checker.Register(http.DefaultServeMux)
+	srv := &http.Server{Addr: cfg.ListenAddr}
+	// With TLS the OBUs connect over wss and, like every other peer, need a
+	// certificate signed by the CA.
+	if cfg.TLS.Enabled() {
+		certs, err := mtls.NewReloader(cfg.TLS.CAFile, cfg.TLS.CertFile, cfg.TLS.KeyFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		go certs.Run(ctx, config.WatchInterval)
+		srv.TLSConfig = certs.ServerConfig()
+	}
+	go func() {
+		if err := mtls.ListenAndServe(srv); err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	// Only accept OBUs once Kafka is reachable, so fixes aren't read off the
+	// socket just to pile up in the producer queue.
+	logrus.Info("waiting for Kafka to become ready")
+	if err := checker.WaitReady(ctx, time.Second); err == nil {
+		if cfg.RawRetention > 0 {
+			setRetention(ctx, recv.kafka, cfg.RawRetention)
+		}
+		http.HandleFunc("/ws", recv.WsHandler)
+		logrus.Info("accepting OBU connections on /ws")
+	}
+	<-ctx.Done()
+
+	// Stop accepting OBUs first, then let the connected ones hang up, and
+	// only flush the producer once no fix can be produced anymore.
+	logrus.Info("shutting down")
+	err = shutdown.Run(cfg.ShutdownTimeout,
+		shutdown.HTTPServer("http", srv),
+		shutdown.Hook{Name: "websockets", Fn: recv.Close},
+		shutdown.Hook{Name: "kafka producer", Fn: recv.kafka.Flush},
+		shutdown.Hook{Name: "tracing", Fn: tp.Shutdown},
+	)
 	if err != nil {
 		log.Fatal(err)
 	}
-	http.HandleFunc("/ws", recv.WsHandler)
-	http.ListenAndServe(":30000", nil)
 }
 
-func (dr *DataReceiver) produceData(data types.OBUData) error {
-	return dr.prod.ProduceData(data)
+// setRetention applies the retention of the raw fixes to the topic. Failing
+// to is logged rather than fatal, as the topic keeps its previous retention.
+func setRetention(ctx context.Context, kp *kafkaProducer, retention time.Duration) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if err := kp.SetRetention(ctx, retention); err != nil {
+		logrus.WithError(err).Error("keeping the retention of the Kafka topic")
+		return
+	}
+	logrus.Infof("raw fixes are kept for %s", retention)
 }
 
-func NewDataReciever() (*DataReceiver, error) {
-	var (
-		p   DataProducer
-		err error
-	)
+func (dr *DataReceiver) produceData(ctx context.Context, data types.OBUData) error {
+	return dr.prod.ProduceData(ctx, data)
+}
 
-	p, err = NewKafkaProducer(kafkaTopic)
+func NewDataReciever(cfg config.Kafka, checker *health.Checker, verifier *obuauth.Verifier, keys *privacy.Keyring) (*DataReceiver, error) {
+	kp, err := NewKafkaProducer(cfg)
 	if err != nil {
 		return nil, err
 	}
+	checker.Add("kafka", health.KafkaCheck(kp.producer, cfg.Topic))
 
+	var p DataProducer = kp
+	if keys != nil {
+		p = NewSealMiddleware(p, keys)
+	}
+	p = NewMetricsMiddleware(p)
 	p = NewLogMiddleware(p)
 	return &DataReceiver{
-		msg:  make(chan types.OBUData, 128),
-		prod: p,
+		msg:   make(chan types.OBUData, 128),
+		prod:  p,
+		kafka: kp,
+		conns: make(map[*websocket.Conn]struct{}),
+
+		verifier: verifier,
+		rejected: promauto.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "toll",
+			Subsystem: "receiver",
+			Name:      "rejected_fixes_total",
+			Help:      "Fixes dropped because they failed authentication, by reason.",
+		}, []string{"reason"}),
 	}, nil
 }
 
 func (dr *DataReceiver) WsHandler(w http.ResponseWriter, r *http.Request) {
-	fmt.Println("New OBU connected!")
+	if !dr.inflight.Begin() {
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		return
+	}
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Fatalf("websocket upgrade: %v", err)
+		dr.inflight.Done()
+		log.Println("websocket upgrade:", err)
 		return
 	}
-	dr.conn = conn
-	go dr.WsReceiveLoop()
+	fmt.Println("New OBU connected!")
+	dr.mu.Lock()
+	dr.conns[conn] = struct{}{}
+	dr.mu.Unlock()
+	go dr.WsReceiveLoop(conn)
 
 }
 
-func (dr *DataReceiver) WsReceiveLoop() {
+func (dr *DataReceiver) WsReceiveLoop(conn *websocket.Conn) {
+	defer dr.inflight.Done()
+	defer func() {
+		dr.mu.Lock()
+		delete(dr.conns, conn)
+		dr.mu.Unlock()
+		conn.Close()
+	}()
 	for {
 		var data types.OBUData
-		reqID := rand.Intn(10000000)
-		data.RequestID = reqID
-		if err := dr.conn.ReadJSON(&data); err != nil {
-			log.Println("read error:", err)
-			continue
+		if err := conn.ReadJSON(&data); err != nil {
+			if isDecodeError(err) {
+				log.Println("read error:", err)
+				continue
+			}
+			// Once the connection failed every further read fails too.
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Println("connection error:", err)
+			}
+			return
+		}
+		if dr.verifier != nil {
+			if err := dr.verifier.Verify(data); err != nil {
+				dr.rejected.WithLabelValues(obuauth.Reason(err)).Inc()
+				logrus.WithError(err).Warn("rejected fix")
+				continue
+			}
+		}
+		// Fixes of OBUs that don't sign them carry the time they arrived,
+		// which the calculator's speed check needs.
+		if data.Unix == 0 {
+			data.Unix = time.Now().UnixMilli()
 		}
-		fmt.Printf("data is %+v\n", data)
-		if err := dr.prod.ProduceData(data); err != nil {
-			fmt.Println("kafka producer err:", err)
+		// Assign the request ID after decoding, otherwise the zero value the
+		// OBU sends overwrites it.
+		if data.RequestID == 0 {
+			data.RequestID = rand.Intn(10000000)
 		}
+		dr.receive(data)
+	}
+}
+
+// isDecodeError reports whether err is a malformed fix rather than a broken
+// connection.
+func isDecodeError(err error) bool {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	return errors.As(err, &syntaxErr) || errors.As(err, &typeErr)
+}
+
+// Close asks every connected OBU to hang up and waits until the fixes they
+// sent before the close handshake are produced. Connections still open when
+// ctx expires are dropped.
+func (dr *DataReceiver) Close(ctx context.Context) error {
+	msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "shutting down")
+	deadline := time.Now().Add(time.Second)
+	dr.mu.Lock()
+	for conn := range dr.conns {
+		conn.WriteControl(websocket.CloseMessage, msg, deadline)
+	}
+	dr.mu.Unlock()
+
+	if err := dr.inflight.Drain(ctx); err != nil {
+		dr.mu.Lock()
+		for conn := range dr.conns {
+			conn.Close()
+		}
+		dr.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// receive starts the trace for a single fix and hands it to the producer.
+func (dr *DataReceiver) receive(data types.OBUData) {
+	ctx, span := tracer.Start(context.Background(), "obudata receive",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.Int("obu.id", int(data.OBUID)),
+			attribute.Int("request.id", data.RequestID),
+		),
+	)
+	defer span.End()
+
+	if err := dr.produceData(ctx, data); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		fmt.Println("kafka producer err:", err)
 	}
 }
@@ -1,16 +1,68 @@
 package main
 
 import (
+	"context"
 	"time"
 
+	"github.com/0x0Glitch/toll-calculator/privacy"
 	"github.com/0x0Glitch/toll-calculator/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type LogMiddleware struct {
 	next DataProducer
 }
 
+type MetricsMiddleware struct {
+	next       DataProducer
+	reqCounter prometheus.Counter
+	errCounter prometheus.Counter
+	reqLatency prometheus.Histogram
+}
+
+func NewMetricsMiddleware(next DataProducer) DataProducer {
+	reqCounter := promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "toll",
+		Subsystem: "receiver",
+		Name:      "fixes_total",
+		Help:      "OBU fixes handed to the Kafka producer.",
+	})
+	errCounter := promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "toll",
+		Subsystem: "receiver",
+		Name:      "produce_errors_total",
+		Help:      "Fixes the Kafka producer refused to enqueue.",
+	})
+	reqLatency := promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "toll",
+		Subsystem: "receiver",
+		Name:      "produce_duration_seconds",
+		Help:      "Time taken to enqueue a fix on the Kafka producer.",
+		Buckets:   []float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1},
+	})
+	return &MetricsMiddleware{
+		next:       next,
+		reqCounter: reqCounter,
+		errCounter: errCounter,
+		reqLatency: reqLatency,
+	}
+}
+
+func (m *MetricsMiddleware) ProduceData(ctx context.Context, data types.OBUData) (err error) {
+	defer func(start time.Time) {
+		m.reqCounter.Inc()
+		m.reqLatency.Observe(time.Since(start).Seconds())
+		if err != nil {
+			m.errCounter.Inc()
+		}
+	}(time.Now())
+	err = m.next.ProduceData(ctx, data)
+	return
+}
+
 func NewLogMiddleware(next DataProducer) *LogMiddleware {
 	return &LogMiddleware{
 		next: next,
@@ -18,16 +70,38 @@ func NewLogMiddleware(next DataProducer) *LogMiddleware {
 }
 
 
-func (l *LogMiddleware) ProduceData(data types.OBUData) error {
+func (l *LogMiddleware) ProduceData(ctx context.Context, data types.OBUData) error {
 	
 	defer func(start time.Time) {
 		logrus.WithFields(logrus.Fields{
 			"obuID": data.OBUID,
-			"lat":   data.Lat,
-			"long":  data.Long,
+			"requestID": data.RequestID,
+			"traceID": trace.SpanContextFromContext(ctx).TraceID(),
 			"took": time.Since(start),
 		}).Info("producing to kafka")
 	}(time.Now())
 
-	return l.next.ProduceData(data)
+	return l.next.ProduceData(ctx, data)
+}
+
+// SealMiddleware encrypts the coordinates of every fix before it is
+// produced, so Kafka only ever holds them encrypted.
+type SealMiddleware struct {
+	next DataProducer
+	keys *privacy.Keyring
+}
+
+func NewSealMiddleware(next DataProducer, keys *privacy.Keyring) *SealMiddleware {
+	return &SealMiddleware{
+		next: next,
+		keys: keys,
+	}
+}
+
+func (s *SealMiddleware) ProduceData(ctx context.Context, data types.OBUData) error {
+	sealed, err := privacy.Seal(s.keys, data)
+	if err != nil {
+		return err
+	}
+	return s.next.ProduceData(ctx, sealed)
 }
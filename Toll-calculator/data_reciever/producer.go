@@ -1,14 +1,25 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
 
+	"github.com/0x0Glitch/toll-calculator/config"
+	"github.com/0x0Glitch/toll-calculator/shutdown"
+	"github.com/0x0Glitch/toll-calculator/tracing"
 	"github.com/0x0Glitch/toll-calculator/types"
 	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type DataProducer interface {
-	ProduceData(types.OBUData) error
+	ProduceData(context.Context, types.OBUData) error
 }
 
 type kafkaProducer struct {
@@ -16,21 +27,28 @@ type kafkaProducer struct {
 	topic string
 }
 
-func NewKafkaProducer(topic string) (DataProducer, error) {
+func NewKafkaProducer(cfg config.Kafka) (*kafkaProducer, error) {
 
-	p, err := kafka.NewProducer(&kafka.ConfigMap{"bootstrap.servers": "localhost"})
+	p, err := kafka.NewProducer(cfg.ConfigMap())
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
+	deliveries := promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "toll",
+		Subsystem: "receiver",
+		Name:      "deliveries_total",
+		Help:      "Delivery reports from the Kafka broker by result.",
+	}, []string{"result"})
+	delivered, failed := deliveries.WithLabelValues("delivered"), deliveries.WithLabelValues("failed")
 	// start another go routine to check if we have delivered the data
 	go func() {
 		for e := range p.Events() {
 			switch ev := e.(type) {
 			case *kafka.Message:
 				if ev.TopicPartition.Error != nil {
-					// fmt.Printf("Delivery failed: %v\n", ev.TopicPartition)
+					failed.Inc()
 				} else {
-					// fmt.Printf("Delivered message to %v\n", ev.TopicPartition)
+					delivered.Inc()
 				}
 			}
 		}
@@ -38,18 +56,67 @@ func NewKafkaProducer(topic string) (DataProducer, error) {
 
 	return &kafkaProducer{
 		producer: p,
-		topic: topic,
+		topic: cfg.Topic,
 	}, nil
 }
-func (p *kafkaProducer) ProduceData(data types.OBUData) error {
+func (p *kafkaProducer) ProduceData(ctx context.Context, data types.OBUData) error {
+	ctx, span := tracer.Start(ctx, p.topic+" publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "kafka"),
+			attribute.String("messaging.destination.name", p.topic),
+		),
+	)
+	defer span.End()
+
 	b, err := json.Marshal(data)
 	if err != nil {
 		return err
 	}
-	return p.producer.Produce(&kafka.Message{
+	msg := &kafka.Message{
 		TopicPartition: kafka.TopicPartition{
 			Topic:     &p.topic,
 			Partition: kafka.PartitionAny},
 		Value: b,
-	}, nil)
+	}
+	// The trace context travels in the message headers so the distance
+	// calculator can continue the trace.
+	tracing.InjectKafka(ctx, msg)
+	return p.producer.Produce(msg, nil)
+}
+
+// Flush waits until every queued fix was delivered to Kafka, or ctx expires,
+// and then closes the producer. Nothing may be produced after it.
+func (p *kafkaProducer) Flush(ctx context.Context) error {
+	err := shutdown.FlushProducer(ctx, p.producer)
+	p.producer.Close()
+	return err
+}
+
+// SetRetention makes Kafka drop the raw fixes on the topic once they are
+// older than retention.
+func (p *kafkaProducer) SetRetention(ctx context.Context, retention time.Duration) error {
+	admin, err := kafka.NewAdminClientFromProducer(p.producer)
+	if err != nil {
+		return err
+	}
+	defer admin.Close()
+	results, err := admin.IncrementalAlterConfigs(ctx, []kafka.ConfigResource{{
+		Type: kafka.ResourceTopic,
+		Name: p.topic,
+		Config: []kafka.ConfigEntry{{
+			Name:                 "retention.ms",
+			Value:                strconv.FormatInt(retention.Milliseconds(), 10),
+			IncrementalOperation: kafka.AlterConfigOpTypeSet,
+		}},
+	}})
+	if err != nil {
+		return err
+	}
+	for _, r := range results {
+		if r.Error.Code() != kafka.ErrNoError {
+			return fmt.Errorf("setting the retention of %s: %w", p.topic, r.Error)
+		}
+	}
+	return nil
 }
@@ -1,85 +0,0 @@
-package main
-
-import (
-	"context"
-	"encoding/json"
-	"fmt"
-	"time"
-
-	"github.com/0x0Glitch/toll-calculator/aggregator/client"
-	"github.com/0x0Glitch/toll-calculator/types"
-	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
-	"github.com/sirupsen/logrus"
-)
-
-// This can also be called kafka Transport
-type KafkaConsumer struct {
-	consumer    *kafka.Consumer
-	isRunning   bool
-	calcService CalculatorServicer
-	aggClient   client.Client
-}
-
-func NewKafkaConsumer(topic string, svc CalculatorServicer, aggClient client.Client) (*KafkaConsumer, error) {
-	c, err := kafka.NewConsumer(&kafka.ConfigMap{
-		"bootstrap.servers": "localhost",
-		"group.id":          "myGroup",
-		"auto.offset.reset": "earliest",
-	})
-	if err != nil {
-		return nil, err
-	}
-
-	c.SubscribeTopics([]string{topic}, nil)
-	return &KafkaConsumer{
-		consumer:    c,
-		calcService: svc,
-		aggClient:   aggClient,
-	}, nil
-}
-func (c *KafkaConsumer) Start() {
-	fmt.Println("kafka consumer started")
-	c.isRunning = true
-	c.readMessageLoop()
-}
-
-
-func (c *KafkaConsumer) Stop() {
-	c.isRunning = false
-	c.consumer.Close()
-}
-
-func (c *KafkaConsumer) readMessageLoop() {
-	for c.isRunning {
-		msg, err := c.consumer.ReadMessage(-1)
-
-		if err != nil {
-			logrus.Errorf("kafka consume error %s", err)
-			continue
-		}
-		var data types.OBUData
-		if err := json.Unmarshal(msg.Value, &data); err != nil {
-			logrus.Errorf("JSON serialization error: %s", err)
-			logrus.WithFields(logrus.Fields{
-				"error": err,
-				"requestID": data.RequestID,
-			}).Info("failed to unmarshal message")
-			continue
-		}
-		distance, err := c.calcService.CalculateDistance(data)
-		if err != nil {
-			logrus.Errorf("calculation error: %s", err)
-			continue
-		}
-		req := types.AggregatorRequest{
-			Value: distance,
-			Unix:   time.Now().UnixNano(),
-			ObuID:  int32(data.OBUID),
-			
-		}
-		if err := c.aggClient.Aggregate(context.Background(),&req); err != nil {
-			logrus.Error("aggregate error:", err)
-			continue
-		}
-	}
-}
@@ -0,0 +1,349 @@
+// Package consumer takes the fixes off the Kafka topic, turns them into
+// distance and sends it to the aggregator. A fix's offset is only stored
+// once its distance was delivered, or it failed in a way retrying can't
+// fix, so nothing in flight is lost to an aggregator outage or a shutdown.
+package consumer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/0x0Glitch/toll-calculator/aggregator/client"
+	"github.com/0x0Glitch/toll-calculator/apperr"
+	"github.com/0x0Glitch/toll-calculator/config"
+	"github.com/0x0Glitch/toll-calculator/gpsfilter"
+	"github.com/0x0Glitch/toll-calculator/privacy"
+	"github.com/0x0Glitch/toll-calculator/shutdown"
+	"github.com/0x0Glitch/toll-calculator/tracing"
+	"github.com/0x0Glitch/toll-calculator/types"
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/time/rate"
+)
+
+var tracer = otel.Tracer("distance_calculator")
+
+// Source is the Kafka consumer messages are read from, a *kafka.Consumer
+// made by Subscribe.
+type Source interface {
+	ReadMessage(timeout time.Duration) (*kafka.Message, error)
+	StoreMessage(m *kafka.Message) ([]kafka.TopicPartition, error)
+	GetWatermarkOffsets(topic string, partition int32) (low, high int64, err error)
+	shutdown.Committer
+	Close() error
+}
+
+// Calculator returns the distance travelled since the OBU's previous fix,
+// split by the toll zones the leg crosses.
+type Calculator interface {
+	CalculateDistance(types.OBUData) ([]types.Distance, error)
+}
+
+// This can also be called kafka Transport
+type KafkaConsumer struct {
+	consumer    Source
+	calcService Calculator
+	aggClient   client.Client
+
+	// handleTimeout bounds the handling of a single message, including
+	// the message still in flight on shutdown.
+	handleTimeout time.Duration
+	// retryBackoff is the wait before distance the aggregator failed to
+	// take is sent again.
+	retryBackoff time.Duration
+	// limiter paces the messages handled, easing the load on the
+	// aggregator; a config reload may change its rate.
+	limiter *rate.Limiter
+	// keys decrypts the coordinates sealed by the data receiver; nil only
+	// reads plaintext fixes.
+	keys *privacy.Keyring
+
+	messages   *prometheus.CounterVec
+	rejected   *prometheus.CounterVec
+	lag        *prometheus.GaugeVec
+	aggLatency prometheus.Histogram
+}
+
+// Subscribe returns a consumer of the topic in the group. Offsets are
+// stored by hand once a message was handled, so the auto commit never
+// commits a fix that didn't reach the aggregator.
+func Subscribe(cfg config.Calculator) (*kafka.Consumer, error) {
+	cm := cfg.Kafka.ConfigMap()
+	cm.SetKey("group.id", cfg.GroupID)
+	cm.SetKey("auto.offset.reset", "earliest")
+	cm.SetKey("enable.auto.offset.store", false)
+	c, err := kafka.NewConsumer(cm)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.SubscribeTopics([]string{cfg.Kafka.Topic}, nil); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// NewKafkaConsumer hands the fixes read from src to svc and sends their
+// distance on to the aggregator. Its metrics are registered with reg.
+func NewKafkaConsumer(cfg config.Calculator, src Source, svc Calculator, aggClient client.Client, keys *privacy.Keyring, reg prometheus.Registerer) *KafkaConsumer {
+	f := promauto.With(reg)
+	return &KafkaConsumer{
+		consumer:      src,
+		calcService:   svc,
+		aggClient:     aggClient,
+		handleTimeout: cfg.ShutdownTimeout,
+		retryBackoff:  cfg.RetryBackoff,
+		limiter:       rate.NewLimiter(config.Limit(cfg.RateLimit)),
+		keys:          keys,
+		messages: f.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "toll",
+			Subsystem: "calculator",
+			Name:      "messages_total",
+			Help:      "Kafka messages consumed by result: processed, consume_error, decode_error, erased, decrypt_error, rejected, calculation_error, or aggregate_error.",
+		}, []string{"result"}),
+		rejected: f.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "toll",
+			Subsystem: "calculator",
+			Name:      "rejected_fixes_total",
+			Help:      "Fixes the GPS filter dropped, by reason.",
+		}, []string{"reason"}),
+		lag: f.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "toll",
+			Subsystem: "calculator",
+			Name:      "consumer_lag",
+			Help:      "Messages between the last consumed offset and the high watermark.",
+		}, []string{"topic", "partition"}),
+		aggLatency: f.NewHistogram(prometheus.HistogramOpts{
+			Namespace: "toll",
+			Subsystem: "calculator",
+			Name:      "aggregate_duration_seconds",
+			Help:      "Time taken to send a distance to the aggregator.",
+			Buckets:   prometheus.DefBuckets,
+		}),
+	}
+}
+
+// pollTimeout bounds how long a read blocks, so Start notices ctx is done.
+const pollTimeout = 100 * time.Millisecond
+
+// Start reads messages until ctx is done. The message being handled when ctx
+// is cancelled is still finished, but if its distance can't be delivered
+// its offset is left unstored, so it is read again after a restart.
+func (c *KafkaConsumer) Start(ctx context.Context) {
+	fmt.Println("kafka consumer started")
+	c.readMessageLoop(ctx)
+}
+
+// Stop commits the stored offsets and leaves the consumer group. It must be
+// called after Start returned.
+func (c *KafkaConsumer) Stop(ctx context.Context) error {
+	err := shutdown.CommitConsumer(c.consumer)
+	return errors.Join(err, c.consumer.Close())
+}
+
+// SetRateLimit changes how many messages per second are handled, 0 for
+// unlimited.
+func (c *KafkaConsumer) SetRateLimit(perSecond float64) {
+	limit, burst := config.Limit(perSecond)
+	c.limiter.SetLimit(limit)
+	c.limiter.SetBurst(burst)
+}
+
+func (c *KafkaConsumer) readMessageLoop(ctx context.Context) {
+	for ctx.Err() == nil {
+		// Waiting before the read leaves unhandled messages on the topic
+		// rather than holding one whose offset shutdown would skip.
+		if err := c.limiter.Wait(ctx); err != nil {
+			return
+		}
+		msg, err := c.consumer.ReadMessage(pollTimeout)
+		if err != nil {
+			if kerr, ok := err.(kafka.Error); ok && kerr.IsTimeout() {
+				continue
+			}
+			c.messages.WithLabelValues("consume_error").Inc()
+			logrus.Errorf("kafka consume error %s", err)
+			continue
+		}
+		c.recordLag(msg.TopicPartition)
+		// The aggregate call isn't cancelled by the shutdown, so the distance
+		// of the message in flight isn't lost, but it can't outlast the
+		// shutdown deadline either.
+		hctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), c.handleTimeout)
+		pending, err := c.handleMessage(hctx, msg)
+		cancel()
+		// Distance the aggregator failed to take is sent again, without
+		// calculating the fix again, until it is delivered. Shutting down
+		// meanwhile leaves the offset unstored, so the fix is read again
+		// after a restart.
+		for len(pending) > 0 {
+			logrus.WithError(err).Warnf("sending %d legs again in %s", len(pending), c.retryBackoff)
+			if !sleep(ctx, c.retryBackoff) {
+				return
+			}
+			hctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), c.handleTimeout)
+			pending, err = c.aggregate(hctx, pending)
+			cancel()
+			if err == nil {
+				c.messages.WithLabelValues("processed").Inc()
+			} else {
+				c.messages.WithLabelValues("aggregate_error").Inc()
+			}
+		}
+		if err != nil {
+			logrus.Error(err)
+		}
+		if _, err := c.consumer.StoreMessage(msg); err != nil {
+			logrus.Errorf("kafka store offset error %s", err)
+		}
+	}
+}
+
+// sleep waits for d, and reports false if ctx is done first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+// handleMessage continues the trace started by the data receiver, which it
+// finds in the message headers, and carries it on to the aggregator. It
+// returns the legs the aggregator failed to take for a reason that may
+// pass; any other error is for good, and the fix is dropped.
+func (c *KafkaConsumer) handleMessage(ctx context.Context, msg *kafka.Message) (pending []*types.AggregatorRequest, err error) {
+	ctx = tracing.ExtractKafka(ctx, msg)
+	ctx, span := tracer.Start(ctx, *msg.TopicPartition.Topic+" process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "kafka"),
+			attribute.String("messaging.destination.name", *msg.TopicPartition.Topic),
+		),
+	)
+	result := "processed"
+	defer func() {
+		c.messages.WithLabelValues(result).Inc()
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
+	var data types.OBUData
+	if err := json.Unmarshal(msg.Value, &data); err != nil {
+		result = "decode_error"
+		return nil, fmt.Errorf("JSON serialization error: %w", err)
+	}
+	if len(data.Sealed) > 0 {
+		if c.keys == nil {
+			result = "decrypt_error"
+			return nil, fmt.Errorf("fix of OBU %d is encrypted but no keyring is configured", data.OBUID)
+		}
+		data, err = privacy.Open(c.keys, data)
+		// Fixes of an erased vehicle can't be read anymore and are skipped.
+		if errors.Is(err, privacy.ErrKeyDestroyed) {
+			result = "erased"
+			return nil, nil
+		}
+		if err != nil {
+			result = "decrypt_error"
+			return nil, err
+		}
+	}
+	span.SetAttributes(
+		attribute.Int("obu.id", int(data.OBUID)),
+		attribute.Int("request.id", data.RequestID),
+	)
+	legs, err := c.calcService.CalculateDistance(data)
+	if reason := gpsfilter.Reason(err); reason != "" {
+		result = "rejected"
+		c.rejected.WithLabelValues(reason).Inc()
+		entry := logrus.WithError(err).WithFields(logrus.Fields{
+			"obuID":  data.OBUID,
+			"seq":    data.Seq,
+			"reason": reason,
+		})
+		// A parked vehicle sends nothing but stationary fixes.
+		if errors.Is(err, gpsfilter.ErrStationary) {
+			entry.Debug("rejected fix")
+		} else {
+			entry.Warn("rejected fix")
+		}
+		return nil, nil
+	}
+	if err != nil {
+		result = "calculation_error"
+		return nil, fmt.Errorf("calculation error: %w", err)
+	}
+	// Legs are dated by the fix that ended them, when they were driven, and
+	// not by when they are handled here, which a backlog or a retry delays.
+	// The data receiver stamps every fix; older messages fall back to now.
+	unix := time.UnixMilli(data.Unix).UnixNano()
+	if data.Unix == 0 {
+		unix = time.Now().UnixNano()
+	}
+	// A leg crossing toll zones is aggregated once per zone.
+	reqs := make([]*types.AggregatorRequest, 0, len(legs))
+	for _, leg := range legs {
+		reqs = append(reqs, &types.AggregatorRequest{
+			Value:     leg.Values,
+			Unix:      unix,
+			ObuID:     int32(data.OBUID),
+			ZoneID:    leg.ZoneID,
+			Estimated: leg.Estimated,
+			Trip:      leg.Trip.Proto(),
+		})
+	}
+	if pending, err = c.aggregate(ctx, reqs); err != nil {
+		result = "aggregate_error"
+	}
+	return pending, err
+}
+
+// aggregate sends the legs to the aggregator in order. It stops at the
+// first it fails to take and returns the rest, unless the aggregator
+// rejected the leg, which sending again won't change.
+func (c *KafkaConsumer) aggregate(ctx context.Context, reqs []*types.AggregatorRequest) ([]*types.AggregatorRequest, error) {
+	for i, req := range reqs {
+		start := time.Now()
+		err := c.aggClient.Aggregate(ctx, req)
+		c.aggLatency.Observe(time.Since(start).Seconds())
+		if err == nil {
+			continue
+		}
+		if apperr.IsCode(err, apperr.InvalidArgument) {
+			return nil, fmt.Errorf("aggregate error: %w", err)
+		}
+		return reqs[i:], fmt.Errorf("aggregate error: %w", err)
+	}
+	return nil, nil
+}
+
+// recordLag updates the lag of the message's partition from the locally
+// cached high watermark, so it costs no round trip to the broker.
+func (c *KafkaConsumer) recordLag(tp kafka.TopicPartition) {
+	_, high, err := c.consumer.GetWatermarkOffsets(*tp.Topic, tp.Partition)
+	if err != nil || high < 0 {
+		return
+	}
+	lag := high - int64(tp.Offset) - 1
+	if lag < 0 {
+		lag = 0
+	}
+	c.lag.WithLabelValues(*tp.Topic, strconv.Itoa(int(tp.Partition))).Set(float64(lag))
+}
@@ -0,0 +1,67 @@
+// Package leg measures the legs a vehicle drives between two of its fixes,
+// split by the toll zones they cross, and tells the legs that span a gap
+// in the fixes. Legs are measured in kilometres, the unit distance is
+// billed in.
+package leg
+
+import (
+	"time"
+
+	"github.com/0x0Glitch/toll-calculator/geo"
+	"github.com/0x0Glitch/toll-calculator/geofence"
+	"github.com/0x0Glitch/toll-calculator/types"
+)
+
+// Kilometres returns the length of the straight leg from a to b.
+func Kilometres(a, b geo.Point) float64 {
+	return geo.Meters(a, b) / 1000
+}
+
+// Split returns the length of the path split by the toll zones it
+// crosses, with consecutive pieces in the same zone merged. A nil zones
+// leaves every piece outside any zone.
+func Split(obuID int32, zones *geofence.Index, path []geofence.Point) []types.Distance {
+	var legs []types.Distance
+	for i := 1; i < len(path); i++ {
+		a, b := path[i-1], path[i]
+		distance := Kilometres(a, b)
+		for _, piece := range zones.Split(a, b) {
+			values := distance * (piece.To - piece.From)
+			if n := len(legs); n > 0 && legs[n-1].ZoneID == piece.ZoneID {
+				legs[n-1].Values += values
+				continue
+			}
+			legs = append(legs, types.Distance{OBUID: obuID, Values: values, ZoneID: piece.ZoneID})
+		}
+	}
+	return legs
+}
+
+// Fix is where a vehicle was placed, and when in milliseconds, 0 if the
+// fix carried no time.
+type Fix struct {
+	Point geofence.Point
+	Unix  int64
+}
+
+// GapPolicy tells when two fixes of a vehicle are too far apart for the
+// leg between them to count as measured, and whether that leg is routed
+// over the roads. A zero threshold is off.
+type GapPolicy struct {
+	MaxTime time.Duration
+	// MaxDistance is in metres. The straight leg is measured as Split
+	// measures it, so a leg Split bills as 2 km spans a 1500 m threshold.
+	MaxDistance float64
+	// Interpolate routes the leg over the road network of the matcher when
+	// the fixes can't be matched along it.
+	Interpolate bool
+}
+
+// Spans reports whether the leg from prev to next spans a gap.
+func (g GapPolicy) Spans(prev, next Fix) bool {
+	if g.MaxTime > 0 && prev.Unix != 0 && next.Unix != 0 &&
+		time.Duration(next.Unix-prev.Unix)*time.Millisecond > g.MaxTime {
+		return true
+	}
+	return g.MaxDistance > 0 && Kilometres(prev.Point, next.Point)*1000 > g.MaxDistance
+}
@@ -1,33 +1,170 @@
 package main
 
 import (
-	"flag"
-	"fmt"
+	"context"
 	"log"
+	"net/http"
+	"os"
+	"time"
 
 	"github.com/0x0Glitch/toll-calculator/aggregator/client"
+	"github.com/0x0Glitch/toll-calculator/config"
+	"github.com/0x0Glitch/toll-calculator/distance_calculator/consumer"
+	"github.com/0x0Glitch/toll-calculator/distance_calculator/leg"
+	"github.com/0x0Glitch/toll-calculator/geofence"
+	"github.com/0x0Glitch/toll-calculator/gpsfilter"
+	"github.com/0x0Glitch/toll-calculator/health"
+	"github.com/0x0Glitch/toll-calculator/mapmatch"
+	"github.com/0x0Glitch/toll-calculator/mtls"
+	"github.com/0x0Glitch/toll-calculator/privacy"
+	"github.com/0x0Glitch/toll-calculator/shutdown"
+	"github.com/0x0Glitch/toll-calculator/tracing"
+	"github.com/0x0Glitch/toll-calculator/trip"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
 )
 
 //	type DistanceCalculator struct {
 //		consumer DataConsumer
 //	}
-const kafkaTopic = "obudata"
 
 func main() {
+	var svc CalculatorServicer
+	watcher, err := config.NewWatcher[config.Calculator](os.Args[1:], prometheus.DefaultRegisterer)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cfg := watcher.Current()
+	logrus.SetLevel(cfg.Level())
+	logrus.AddHook(privacy.RedactHook{})
+	logrus.Infof("effective config:\n%s", config.Dump(cfg))
+	ctx, stop := shutdown.Signals(context.Background())
+	defer stop()
+	tp, err := tracing.Init(context.Background(), "distance_calculator", cfg.TracesExporter)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var zones *geofence.Fences
+	if cfg.Zones != "" {
+		zones, err = geofence.LoadFences(cfg.Zones)
+		if err != nil {
+			log.Fatal(err)
+		}
+		go zones.Run(ctx, config.WatchInterval)
+	}
+	var filter *gpsfilter.Filter
+	if cfg.GPSFilter.Enabled() {
+		filter = gpsfilter.New(gpsfilter.Options{
+			MaxSpeed:    cfg.GPSFilter.MaxSpeed / 3.6,
+			MaxHDOP:     cfg.GPSFilter.MaxHDOP,
+			MaxAccuracy: cfg.GPSFilter.MaxAccuracy,
+			Jitter:      cfg.GPSFilter.Jitter,
+			Noise:       cfg.GPSFilter.Smoothing,
+		})
+	}
 	var (
-		svc CalculatorServicer
-		aggregateEndpoint = "http://127.0.0.1:3000"
+		matcher *mapmatch.Matcher
+		audit   *mapmatch.AuditLog
 	)
-	// httplistenAddr := flag.String("httplistenaddr", ":3001", "the listen address of the gRPC server")
-	flag.Parse()
-	svc = NewCalculatorService()
+	if cfg.MapMatch.Enabled() {
+		roads, err := mapmatch.LoadGraph(cfg.MapMatch.Roads)
+		if err != nil {
+			log.Fatal(err)
+		}
+		nodes, edges := roads.Size()
+		logrus.Infof("matching fixes to %d road segments between %d vertices", edges, nodes)
+		matcher = mapmatch.NewMatcher(roads, mapmatch.Options{Radius: cfg.MapMatch.Radius, Sigma: cfg.MapMatch.Sigma})
+		if cfg.MapMatch.Audit != "" {
+			audit, err = mapmatch.OpenAuditLog(cfg.MapMatch.Audit)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+	svc = NewCalculatorService(zones, filter, matcher, audit, leg.GapPolicy{
+		MaxTime:     cfg.Gaps.MaxTime,
+		MaxDistance: cfg.Gaps.MaxDistance,
+		Interpolate: cfg.Gaps.Interpolate,
+	}, trip.NewTracker(cfg.TripIdle))
+	svc = NewMetricsMiddleware(svc)
 	svc = NewLogMiddleware(svc)
-	c := client.NewHTTPClient(aggregateEndpoint)
-	
-	KafkaConsumer, err := NewKafkaConsumer(kafkaTopic, svc,c)
+	resolver, err := client.ParseResolver(cfg.Aggregator.Target)
+	if err != nil {
+		log.Fatal(err)
+	}
+	picker, err := client.ParsePicker(cfg.Aggregator.Balance)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var clientOpts []client.Option
+	if cfg.TLS.Enabled() {
+		certs, err := mtls.NewReloader(cfg.TLS.CAFile, cfg.TLS.CertFile, cfg.TLS.KeyFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		go certs.Run(ctx, config.WatchInterval)
+		clientOpts = append(clientOpts, client.WithTLS(certs.ClientConfig()))
+	}
+	c := client.NewBalancedHTTPClient(client.NewBalancer(resolver, client.WithPicker(picker)), clientOpts...)
+
+	var keys *privacy.Keyring
+	if cfg.Privacy.Enabled() {
+		keys, err = privacy.NewKeyring(cfg.Privacy.KeyringDir, cfg.Privacy.Key())
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	kc, err := consumer.Subscribe(*cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	KafkaConsumer := consumer.NewKafkaConsumer(*cfg, kc, svc, c, keys, prometheus.DefaultRegisterer)
+	watcher.OnReload(func(_, next *config.Calculator) error {
+		logrus.SetLevel(next.Level())
+		KafkaConsumer.SetRateLimit(next.RateLimit)
+		return nil
+	})
+	go watcher.Run(ctx, config.WatchInterval)
+	// The audit log keeps the roads every vehicle drove, so it is erased
+	// and purged like the aggregator's stores.
+	var erasure privacy.Erasure
+	erasure.Add("match audit", audit)
+	if cfg.MapMatch.AuditRetention > 0 {
+		go privacy.RunRetention(ctx, "match audit", audit, cfg.MapMatch.AuditRetention, time.Minute)
+	}
+	checker := health.NewChecker()
+	checker.Add("kafka", health.KafkaCheck(kc, cfg.Kafka.Topic))
+	checker.AddPinger("aggregator", c)
+	http.Handle("/metrics", promhttp.Handler())
+	http.Handle("/admin/erase", erasure.Handler())
+	checker.Register(http.DefaultServeMux)
+	watcher.Register(http.DefaultServeMux)
+	srv := &http.Server{Addr: cfg.MetricsAddr}
+	go func() {
+		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	// Don't take fixes off the topic before both Kafka and the aggregator
+	// are ready, otherwise the first distances fail to aggregate.
+	logrus.Info("waiting for Kafka and the aggregator to become ready")
+	if err := checker.WaitReady(ctx, time.Second); err == nil {
+		// Start returns on shutdown, once the message in flight is handled.
+		KafkaConsumer.Start(ctx)
+	}
+
+	logrus.Info("shutting down")
+	err = shutdown.Run(cfg.ShutdownTimeout,
+		shutdown.Hook{Name: "kafka consumer", Fn: KafkaConsumer.Stop},
+		shutdown.HTTPServer("http", srv),
+		shutdown.Hook{Name: "tracing", Fn: tp.Shutdown},
+		shutdown.Hook{Name: "match audit log", Fn: func(context.Context) error { return audit.Close() }},
+	)
 	if err != nil {
 		log.Fatal(err)
 	}
-	KafkaConsumer.Start()
-	fmt.Println("everything working fine")
 }
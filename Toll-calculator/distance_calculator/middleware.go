@@ -3,7 +3,10 @@ package main
 import (
 	"time"
 
+	"github.com/0x0Glitch/toll-calculator/gpsfilter"
 	"github.com/0x0Glitch/toll-calculator/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/sirupsen/logrus"
 )
 
@@ -12,6 +15,54 @@ type LogMiddleware struct {
 	next CalculatorServicer
 }
 
+type MetricsMiddleware struct {
+	next       CalculatorServicer
+	reqCounter prometheus.Counter
+	errCounter prometheus.Counter
+	reqLatency prometheus.Histogram
+}
+
+func NewMetricsMiddleware(next CalculatorServicer) CalculatorServicer {
+	reqCounter := promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "toll",
+		Subsystem: "calculator",
+		Name:      "calculations_total",
+		Help:      "Distance calculations performed.",
+	})
+	errCounter := promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "toll",
+		Subsystem: "calculator",
+		Name:      "calculation_errors_total",
+		Help:      "Distance calculations that failed.",
+	})
+	reqLatency := promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "toll",
+		Subsystem: "calculator",
+		Name:      "calculation_duration_seconds",
+		Help:      "Time taken to calculate a distance.",
+		Buckets:   []float64{0.00001, 0.0001, 0.001, 0.01, 0.1},
+	})
+	return &MetricsMiddleware{
+		next:       next,
+		reqCounter: reqCounter,
+		errCounter: errCounter,
+		reqLatency: reqLatency,
+	}
+}
+
+func (m *MetricsMiddleware) CalculateDistance(data types.OBUData) (legs []types.Distance, err error) {
+	defer func(start time.Time) {
+		m.reqCounter.Inc()
+		m.reqLatency.Observe(time.Since(start).Seconds())
+		// Rejected fixes are counted by the consumer, by reason.
+		if err != nil && gpsfilter.Reason(err) == "" {
+			m.errCounter.Inc()
+		}
+	}(time.Now())
+	legs, err = m.next.CalculateDistance(data)
+	return
+}
+
 func NewLogMiddleware(next CalculatorServicer) CalculatorServicer{
 	return &LogMiddleware{
 		next:next,
@@ -19,14 +70,19 @@ func NewLogMiddleware(next CalculatorServicer) CalculatorServicer{
 }
 
 
-func (m *LogMiddleware) CalculateDistance(data types.OBUData) (dist float64, err error){
+func (m *LogMiddleware) CalculateDistance(data types.OBUData) (legs []types.Distance, err error){
 	defer func(start time.Time){
+		dist := 0.0
+		for _, leg := range legs {
+			dist += leg.Values
+		}
 		logrus.WithFields(logrus.Fields{
 			"took":time.Since(start),
 			"err":err,
 			"dist":dist,
+			"zones":len(legs),
 		}).Info("calculating distance")
 	}(time.Now())
-	dist ,err = m.next.CalculateDistance(data)
+	legs ,err = m.next.CalculateDistance(data)
 	return 
 }
\ No newline at end of file
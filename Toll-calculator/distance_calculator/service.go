@@ -1,34 +1,176 @@
 package main
 
 import (
-	"math"
+	"errors"
+	"sync"
 
+	"github.com/0x0Glitch/toll-calculator/distance_calculator/leg"
+	"github.com/0x0Glitch/toll-calculator/geofence"
+	"github.com/0x0Glitch/toll-calculator/gpsfilter"
+	"github.com/0x0Glitch/toll-calculator/mapmatch"
+	"github.com/0x0Glitch/toll-calculator/trip"
 	"github.com/0x0Glitch/toll-calculator/types"
 )
 
+// CalculatorServicer returns the distance travelled since the OBU's previous
+// fix, split by the toll zones the leg crosses. With map matching, the leg
+// runs along the roads the fixes were matched to. A fix the GPS filter
+// rejects returns an error gpsfilter.Reason labels. A leg across a gap in
+// the fixes is flagged as estimated. When trips are detected, every leg is
+// tagged with the trip it belongs to.
 type CalculatorServicer interface {
-	CalculateDistance(types.OBUData) (float64, error)
+	CalculateDistance(types.OBUData) ([]types.Distance, error)
 }
 
 type CalculatorService struct {
-	prevPoint []float64
+	mu sync.Mutex
+	// prevPoint is the last fix of every OBU, as a leg only makes sense
+	// between two fixes of the same vehicle.
+	prevPoint map[int32]leg.Fix
+	// zones is nil when distance isn't split by zone.
+	zones *geofence.Fences
+	// filter is nil when every fix is trusted.
+	filter *gpsfilter.Filter
+	// matcher is nil when legs are straight lines between fixes.
+	matcher *mapmatch.Matcher
+	audit   *mapmatch.AuditLog
+	gaps    leg.GapPolicy
+	// trips is nil when trips aren't detected.
+	trips *trip.Tracker
 }
 
-func NewCalculatorService() CalculatorServicer {
+func NewCalculatorService(zones *geofence.Fences, filter *gpsfilter.Filter, matcher *mapmatch.Matcher, audit *mapmatch.AuditLog, gaps leg.GapPolicy, trips *trip.Tracker) CalculatorServicer {
 	return &CalculatorService{
+		prevPoint: make(map[int32]leg.Fix),
+		zones:     zones,
+		filter:    filter,
+		matcher:   matcher,
+		audit:     audit,
+		gaps:      gaps,
+		trips:     trips,
 	}
 }
 
-func (s *CalculatorService) CalculateDistance(data types.OBUData) (float64, error) {
-	distance := 0.0
-	
-	if len(s.prevPoint) > 0{
-		distance = calculateDistancer(s.prevPoint[0],s.prevPoint[1],data.Lat,data.Long)
+func (s *CalculatorService) CalculateDistance(data types.OBUData) ([]types.Distance, error) {
+	point := geofence.Point{Lat: data.Lat, Long: data.Long}
+	// A rejected fix travels no distance, and the next leg starts from the
+	// last fix accepted.
+	if s.filter != nil {
+		var err error
+		if point, err = s.filter.Accept(data); err != nil {
+			// A parked vehicle still reports in, so waking up after a long
+			// stop isn't taken for a gap.
+			if errors.Is(err, gpsfilter.ErrStationary) {
+				s.touch(data.OBUID, data.Unix)
+			}
+			// The ignition going off still ends the trip, where the
+			// vehicle was last placed.
+			if data.IgnitionOff {
+				if leg, ok := s.endTrip(data); ok {
+					return []types.Distance{leg}, nil
+				}
+			}
+			return nil, err
+		}
+	}
+	next := leg.Fix{Point: point, Unix: data.Unix}
+	s.mu.Lock()
+	prev, ok := s.prevPoint[data.OBUID]
+	tripLeg := s.trips.Move(data.OBUID, types.TripPoint{Unix: data.Unix, Lat: point.Lat, Long: point.Long}, data.IgnitionOff)
+	s.prevPoint[data.OBUID] = next
+	s.mu.Unlock()
+
+	var zones *geofence.Index
+	if s.zones != nil {
+		zones = s.zones.Index()
+	}
+	// The first fix of an OBU travelled no distance yet, but still opens
+	// its invoice.
+	if !ok {
+		if s.matcher != nil {
+			s.matcher.Match(data.OBUID, point)
+		}
+		return onTrip([]types.Distance{{OBUID: data.OBUID, ZoneID: zones.Locate(point)}}, tripLeg), nil
+	}
+	gap := s.gaps.Spans(prev, next)
+	if s.matcher == nil {
+		return onTrip(estimated(leg.Split(data.OBUID, zones, []geofence.Point{prev.Point, point}), gap), tripLeg), nil
+	}
+
+	// Fixes the matcher can't place on a road fall back to a straight leg,
+	// or across a gap, to the shortest route if interpolating.
+	record := mapmatch.AuditRecord{OBUID: data.OBUID, Seq: data.Seq, Unix: data.Unix, Estimated: gap}
+	path := []geofence.Point{prev.Point, point}
+	if m, ok := s.matcher.Match(data.OBUID, point); ok {
+		path, record.Matched, record.Roads = m.Path, true, m.Roads
+	} else if gap && s.gaps.Interpolate {
+		if m, ok := s.matcher.Route(prev.Point, point); ok {
+			path, record.Matched, record.Roads = m.Path, true, m.Roads
+		}
+	}
+	legs := onTrip(estimated(leg.Split(data.OBUID, zones, path), gap), tripLeg)
+	for _, d := range legs {
+		record.Distance += d.Values
+	}
+	if err := s.audit.Record(record); err != nil {
+		return nil, err
+	}
+	return legs, nil
+}
+
+// touch moves the time of the OBU's last fix on without moving the OBU.
+func (s *CalculatorService) touch(obuID int32, unix int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if prev, ok := s.prevPoint[obuID]; ok && unix != 0 {
+		prev.Unix = unix
+		s.prevPoint[obuID] = prev
 	}
-	s.prevPoint = []float64{data.Lat,data.Long}
-	return distance, nil
 }
-func calculateDistancer(x1, x2, y1, y2 float64) float64 {
-	return math.Sqrt(math.Pow(x2-x1, 2) + math.Pow(y2-y1, 2))
+
+// endTrip ends the trip of a vehicle whose fix switching the ignition off
+// was rejected, returning the empty leg that tells the aggregator so.
+func (s *CalculatorService) endTrip(data types.OBUData) (types.Distance, bool) {
+	s.mu.Lock()
+	prev, ok := s.prevPoint[data.OBUID]
+	if !ok {
+		s.mu.Unlock()
+		return types.Distance{}, false
+	}
+	if data.Unix != 0 {
+		prev.Unix = data.Unix
+	}
+	tripLeg, ok := s.trips.End(data.OBUID, prev.Unix)
+	if ok {
+		s.prevPoint[data.OBUID] = prev
+	}
+	s.mu.Unlock()
+	if !ok {
+		return types.Distance{}, false
+	}
+
+	var zones *geofence.Index
+	if s.zones != nil {
+		zones = s.zones.Index()
+	}
+	return types.Distance{OBUID: data.OBUID, ZoneID: zones.Locate(prev.Point), Trip: tripLeg}, true
+}
+
+// onTrip tags the legs that brought the vehicle to the fix with its trip,
+// nil when trips aren't detected.
+func onTrip(legs []types.Distance, leg *types.TripLeg) []types.Distance {
+	if leg == nil {
+		return legs
+	}
+	for i := range legs {
+		legs[i].Trip = leg
+	}
+	return legs
+}
+
+func estimated(legs []types.Distance, gap bool) []types.Distance {
+	for i := range legs {
+		legs[i].Estimated = gap
+	}
+	return legs
 }
- 
\ No newline at end of file
@@ -0,0 +1,157 @@
+// Package export writes invoice documents for finance: many at a time as
+// CSV or JSON Lines, streamed as they are read, or one at a time as a
+// printable PDF.
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/0x0Glitch/toll-calculator/types"
+)
+
+// Format is a bulk export format.
+type Format string
+
+const (
+	CSV   Format = "csv"
+	JSONL Format = "jsonl"
+)
+
+// ParseFormat parses a format name, JSON Lines if empty.
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(s); f {
+	case CSV, JSONL:
+		return f, nil
+	case "":
+		return JSONL, nil
+	}
+	return "", fmt.Errorf("export: unknown format %q: want csv or jsonl", s)
+}
+
+func (f Format) ContentType() string {
+	if f == CSV {
+		return "text/csv; charset=utf-8"
+	}
+	return "application/jsonl"
+}
+
+// Filename returns the name of the export of a period.
+func (f Format) Filename(period string) string {
+	return fmt.Sprintf("invoices-%s.%s", period, f)
+}
+
+// Writer writes invoices one at a time. Nothing written is guaranteed to
+// reach the underlying writer before Flush.
+type Writer interface {
+	Write(types.Invoice) error
+	Flush() error
+}
+
+func NewWriter(w io.Writer, f Format) Writer {
+	if f == CSV {
+		return &csvWriter{w: csv.NewWriter(w)}
+	}
+	return &jsonlWriter{enc: json.NewEncoder(w)}
+}
+
+type jsonlWriter struct {
+	enc *json.Encoder
+}
+
+func (j *jsonlWriter) Write(inv types.Invoice) error {
+	return j.enc.Encode(inv)
+}
+
+func (j *jsonlWriter) Flush() error {
+	return nil
+}
+
+// csvHeader names the columns of the CSV export, which has a row per line
+// item, repeating the invoice's columns on each.
+var csvHeader = []string{
+	"invoice_id", "obu_id", "period", "period_start", "period_end", "status", "tariff_version", "currency",
+	"zone_id", "description", "distance", "estimated", "unit_price", "unit_currency", "exchange_rate", "amount",
+	"jurisdiction", "tax_treatment", "tax_rate", "tax", "list_amount", "contract", "discounts",
+}
+
+type csvWriter struct {
+	w      *csv.Writer
+	header bool
+}
+
+func (c *csvWriter) Write(inv types.Invoice) error {
+	if err := c.writeHeader(); err != nil {
+		return err
+	}
+	head := []string{
+		inv.ID, strconv.Itoa(int(inv.OBUID)), inv.Period, timestamp(inv.PeriodStart), timestamp(inv.PeriodEnd),
+		string(inv.Status), inv.TariffVersion, string(inv.Currency),
+	}
+	lines := inv.Lines
+	// An invoice without lines still gets a row, so it isn't lost.
+	if len(lines) == 0 {
+		return c.w.Write(append(head, make([]string, len(csvHeader)-len(head))...))
+	}
+	for _, l := range lines {
+		var rate, taxRate, tax, list, contract string
+		if !l.ExchangeRate.IsZero() {
+			rate = l.ExchangeRate.String()
+		}
+		if l.Jurisdiction != "" {
+			taxRate, tax = l.TaxRate.String(), l.Tax.String()
+		}
+		// Discounts are listed as kind=amount, or kind:code=amount for
+		// promo codes, all under the one contract covering the vehicle.
+		discounts := make([]string, 0, len(l.Discounts))
+		for _, d := range l.Discounts {
+			list, contract = l.ListAmount.String(), d.Contract
+			kind := string(d.Kind)
+			if d.Code != "" {
+				kind += ":" + d.Code
+			}
+			discounts = append(discounts, kind+"="+d.Amount.String())
+		}
+		row := append(head[:len(head):len(head)], l.ZoneID, l.Description,
+			number(l.Distance), number(l.Estimated), l.UnitPrice.String(), string(l.Currency), rate, l.Amount.String(),
+			l.Jurisdiction, string(l.TaxTreatment), taxRate, tax, list, contract, strings.Join(discounts, ";"))
+		if err := c.w.Write(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush writes the header even if no invoice was, so an empty export is
+// still a valid CSV file.
+func (c *csvWriter) Flush() error {
+	if err := c.writeHeader(); err != nil {
+		return err
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func (c *csvWriter) writeHeader() error {
+	if c.header {
+		return nil
+	}
+	c.header = true
+	return c.w.Write(csvHeader)
+}
+
+func timestamp(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func number(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
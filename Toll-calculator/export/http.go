@@ -0,0 +1,47 @@
+package export
+
+import (
+	"net/http"
+
+	"github.com/0x0Glitch/toll-calculator/types"
+	"github.com/sirupsen/logrus"
+)
+
+// Source yields invoices to fn one at a time, stopping at the first error.
+type Source func(fn func(types.Invoice) error) error
+
+// Serve streams the invoices of the period from src to w in the format, as
+// a download named after the period. An error before anything was written
+// is returned for the caller to answer. Once the download has started the
+// status can't change anymore, so Serve aborts the response instead: a
+// truncated export then fails on the client rather than passing for a
+// complete one.
+func Serve(w http.ResponseWriter, f Format, period string, src Source) error {
+	var ew Writer
+	start := func() {
+		w.Header().Set("Content-Type", f.ContentType())
+		w.Header().Set("Content-Disposition", `attachment; filename="`+f.Filename(period)+`"`)
+		w.WriteHeader(http.StatusOK)
+		ew = NewWriter(w, f)
+	}
+	err := src(func(inv types.Invoice) error {
+		if ew == nil {
+			start()
+		}
+		return ew.Write(inv)
+	})
+	if err != nil && ew == nil {
+		return err
+	}
+	if ew == nil {
+		start()
+	}
+	if err == nil {
+		err = ew.Flush()
+	}
+	if err != nil {
+		logrus.WithError(err).WithField("period", period).Error("invoice export cut short")
+		panic(http.ErrAbortHandler)
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+// Command invoices exports every invoice of a billing period from the
+// aggregator: as one CSV or JSON Lines file, or as a printable PDF per
+// invoice.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+
+	"github.com/0x0Glitch/toll-calculator/aggregator/client"
+	"github.com/0x0Glitch/toll-calculator/export"
+	"github.com/0x0Glitch/toll-calculator/mtls"
+	"github.com/0x0Glitch/toll-calculator/types"
+)
+
+func main() {
+	target := flag.String("aggregator", "http://localhost:3000", "aggregator endpoints: comma separated list, srv://_service._proto.name or file:///path")
+	period := flag.String("period", "", "label of the billing period, such as 2025-09, 2025-W38 or 2025-09-15")
+	format := flag.String("format", "csv", "export format: csv, jsonl or pdf")
+	out := flag.String("out", "-", "file written, - for stdout; with -format pdf, the directory the PDFs are written to")
+	caFile := flag.String("tls-ca", "", "CA certificate verifying the aggregator, enables mutual TLS")
+	certFile := flag.String("tls-cert", "", "client certificate presented to the aggregator")
+	keyFile := flag.String("tls-key", "", "key of the client certificate")
+	flag.Parse()
+	if *period == "" {
+		log.Fatal("missing -period")
+	}
+
+	resolver, err := client.ParseResolver(*target)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var opts []client.Option
+	if *caFile != "" {
+		certs, err := mtls.NewReloader(*caFile, *certFile, *keyFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts = append(opts, client.WithTLS(certs.ClientConfig()))
+	}
+	c := client.NewBalancedHTTPClient(client.NewBalancer(resolver), opts...)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	var n int
+	if *format == "pdf" {
+		n, err = exportPDF(ctx, c, *period, *out)
+	} else {
+		n, err = exportFile(ctx, c, *period, *format, *out)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("exported %d invoices of period %s", n, *period)
+}
+
+// exportFile writes the invoices of the period to one file as they are
+// read from the aggregator.
+func exportFile(ctx context.Context, c *client.HTTPClient, period, format, path string) (int, error) {
+	f, err := export.ParseFormat(format)
+	if err != nil {
+		return 0, err
+	}
+	var file *os.File
+	w := io.Writer(os.Stdout)
+	if path != "-" {
+		if file, err = os.Create(path); err != nil {
+			return 0, err
+		}
+		defer file.Close()
+		w = file
+	}
+	ew := export.NewWriter(w, f)
+	var n int
+	err = c.ExportInvoices(ctx, period, func(inv types.Invoice) error {
+		n++
+		return ew.Write(inv)
+	})
+	if err == nil {
+		err = ew.Flush()
+	}
+	if err == nil && file != nil {
+		err = file.Close()
+	}
+	return n, err
+}
+
+// exportPDF writes a PDF named after its ID for every invoice of the period
+// into dir, with the trips the vehicle made in the period.
+func exportPDF(ctx context.Context, c *client.HTTPClient, period, dir string) (int, error) {
+	if dir == "-" {
+		dir = "invoices-" + period
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return 0, err
+	}
+	var n int
+	err := c.ExportInvoices(ctx, period, func(inv types.Invoice) error {
+		trips, err := c.Trips(ctx, inv.OBUID, inv.PeriodStart, inv.PeriodEnd)
+		if err != nil {
+			return fmt.Errorf("listing trips of invoice %s: %w", inv.ID, err)
+		}
+		file, err := os.Create(filepath.Join(dir, inv.ID+".pdf"))
+		if err != nil {
+			return err
+		}
+		if err := export.WritePDF(file, inv, trips); err != nil {
+			file.Close()
+			return err
+		}
+		if err := file.Close(); err != nil {
+			return err
+		}
+		n++
+		return nil
+	})
+	return n, err
+}
@@ -0,0 +1,309 @@
+package export
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/0x0Glitch/toll-calculator/money"
+	"github.com/0x0Glitch/toll-calculator/types"
+)
+
+// The PDF is laid out on A4 pages in points, with a line of text every
+// lineHeight points between the margins.
+const (
+	pageWidth  = 595
+	pageHeight = 842
+	margin     = 50
+	lineHeight = 14
+)
+
+// cell is a piece of text starting x points from the left margin.
+type cell struct {
+	x    float64
+	text string
+}
+
+// line is a line of the document. Bold lines are set in bold, and a title
+// in a larger bold font.
+type line struct {
+	cells []cell
+	bold  bool
+	title bool
+}
+
+func text(s string) line {
+	return line{cells: []cell{{0, s}}}
+}
+
+// row lays the texts out in columns starting at the offsets.
+func row(offsets []float64, texts ...string) line {
+	l := line{cells: make([]cell, len(texts))}
+	for i, t := range texts {
+		l.cells[i] = cell{offsets[i], t}
+	}
+	return l
+}
+
+var (
+	lineColumns = []float64{0, 70, 250, 320, 390, 450}
+	tripColumns = []float64{0, 90, 200, 310, 370, 430}
+)
+
+// WritePDF writes the invoice as a printable PDF: its line items, the
+// trips the vehicle made in the period and the tariff version it was
+// billed at. Trips overlapping the start or end of the period are listed
+// whole.
+func WritePDF(w io.Writer, inv types.Invoice, trips []types.Trip) error {
+	title := "Invoice"
+	if inv.ID != "" {
+		title += " " + inv.ID
+	}
+	lines := []line{
+		{cells: []cell{{0, title}}, title: true},
+		{},
+		text(fmt.Sprintf("OBU: %d", inv.OBUID)),
+		text(fmt.Sprintf("Period: %s, %s up to %s", inv.Period, date(inv.PeriodStart), date(inv.PeriodEnd))),
+		text(fmt.Sprintf("Status: %s", inv.Status)),
+		text(fmt.Sprintf("Tariff version: %s", inv.TariffVersion)),
+		text(fmt.Sprintf("Currency: %s", inv.Currency)),
+	}
+	for _, d := range []struct {
+		name string
+		t    time.Time
+	}{{"Finalized", inv.FinalizedAt}, {"Paid", inv.PaidAt}, {"Voided", inv.VoidedAt}} {
+		if !d.t.IsZero() {
+			lines = append(lines, text(fmt.Sprintf("%s: %s", d.name, timestamp(d.t))))
+		}
+	}
+	if inv.VoidReason != "" {
+		lines = append(lines, text("Void reason: "+inv.VoidReason))
+	}
+
+	lines = append(lines, line{}, line{cells: []cell{{0, "Line items"}}, bold: true})
+	header := row(lineColumns, "Zone", "Description", "Distance", "Estimated", "Unit price", "Amount")
+	header.bold = true
+	lines = append(lines, header)
+	var rates []line
+	for _, l := range inv.Lines {
+		unit := l.UnitPrice.String()
+		if l.Currency != "" && l.Currency != inv.Currency {
+			unit += " " + string(l.Currency)
+			rates = append(rates, text(fmt.Sprintf("%s: 1 %s = %s %s", l.ZoneID, l.Currency, l.ExchangeRate, inv.Currency)))
+		}
+		// A discounted line shows its list amount less each discount.
+		amount := l.Amount
+		if len(l.Discounts) > 0 {
+			amount = l.ListAmount
+		}
+		lines = append(lines, row(lineColumns, l.ZoneID, l.Description,
+			fmt.Sprintf("%.2f", l.Distance), fmt.Sprintf("%.2f", l.Estimated), unit, amount.String()))
+		for _, d := range l.Discounts {
+			lines = append(lines, row(lineColumns, "", d.Description+", contract "+d.Contract, "", "", "", d.Amount.Neg().String()))
+		}
+	}
+	total := row(lineColumns, "Total", "", fmt.Sprintf("%.2f", inv.TotalDistance),
+		fmt.Sprintf("%.2f", inv.EstimatedDistance), "", inv.Amount.String()+" "+string(inv.Currency))
+	total.bold = true
+	lines = append(lines, total)
+	if taxes := taxLines(inv); len(taxes) > 0 {
+		lines = append(lines, line{}, line{cells: []cell{{0, "Taxes"}}, bold: true})
+		lines = append(lines, taxes...)
+		due := text(fmt.Sprintf("Total due: %s %s", inv.Gross, inv.Currency))
+		due.bold = true
+		lines = append(lines, due)
+	}
+	if len(rates) > 0 {
+		lines = append(lines, line{}, line{cells: []cell{{0, "Exchange rates"}}, bold: true})
+		lines = append(lines, rates...)
+	}
+
+	lines = append(lines, line{}, line{cells: []cell{{0, "Trips"}}, bold: true})
+	if len(trips) == 0 {
+		lines = append(lines, text("No trips recorded in the period."))
+	} else {
+		header := row(tripColumns, "Trip", "Started", "Ended", "Distance", "Estimated", "Amount")
+		header.bold = true
+		lines = append(lines, header)
+		for _, t := range trips {
+			end := millis(t.End.Unix)
+			if !t.Ended {
+				end = "ongoing"
+			}
+			lines = append(lines, row(tripColumns, t.ID, millis(t.Start.Unix), end,
+				fmt.Sprintf("%.2f", t.Distance), fmt.Sprintf("%.2f", t.Estimated), fmt.Sprintf("%.2f", t.Amount)))
+		}
+	}
+	return writePDF(w, lines)
+}
+
+// taxLines returns a line per jurisdiction, treatment and rate taxing the
+// invoice's lines, with the amount taxed and the tax.
+func taxLines(inv types.Invoice) []line {
+	type key struct {
+		jurisdiction string
+		treatment    types.TaxTreatment
+		rate         string
+	}
+	var keys []key
+	net, tax := make(map[key]money.Decimal), make(map[key]money.Decimal)
+	for _, l := range inv.Lines {
+		if l.Jurisdiction == "" {
+			continue
+		}
+		k := key{l.Jurisdiction, l.TaxTreatment, l.TaxRate.String()}
+		if _, ok := net[k]; !ok {
+			keys = append(keys, k)
+		}
+		net[k] = net[k].Add(l.Amount)
+		tax[k] = tax[k].Add(l.Tax)
+	}
+	out := make([]line, 0, len(keys))
+	for _, k := range keys {
+		var s string
+		switch k.treatment {
+		case types.TaxExempt:
+			s = fmt.Sprintf("%s: exempt on %s %s", k.jurisdiction, net[k], inv.Currency)
+		case types.TaxReverseCharge:
+			s = fmt.Sprintf("%s: reverse charge, VAT at %s%% on %s %s is accounted for by the customer", k.jurisdiction, percent(k.rate), net[k], inv.Currency)
+		default:
+			s = fmt.Sprintf("%s: VAT at %s%% on %s %s: %s %s", k.jurisdiction, percent(k.rate), net[k], inv.Currency, tax[k], inv.Currency)
+		}
+		out = append(out, text(s))
+	}
+	return out
+}
+
+// percent prints a rate such as 0.21 as 21 and 0.055 as 5.5.
+func percent(rate string) string {
+	s := money.MustParse(rate).Mul(money.NewFromInt(100)).String()
+	if strings.Contains(s, ".") {
+		s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	}
+	return s
+}
+
+// writePDF writes the lines as a PDF document, breaking them into as many
+// pages as they take.
+func writePDF(w io.Writer, lines []line) error {
+	perPage := (pageHeight - 2*margin) / lineHeight
+	var pages [][]line
+	for len(lines) > perPage {
+		pages = append(pages, lines[:perPage])
+		lines = lines[perPage:]
+	}
+	pages = append(pages, lines)
+
+	pw := &pdfWriter{w: bufio.NewWriter(w)}
+	pw.printf("%%PDF-1.4\n")
+	// Objects 1 to 4 are the catalog, the page tree and the two fonts; each
+	// page then takes two, itself and its content.
+	pw.object(1, "<< /Type /Catalog /Pages 2 0 R >>")
+	kids := make([]string, len(pages))
+	for i := range pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 5+2*i)
+	}
+	pw.object(2, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)))
+	pw.object(3, "<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	pw.object(4, "<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	for i, page := range pages {
+		n := 5 + 2*i
+		pw.object(n, fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+			pageWidth, pageHeight, n+1))
+		content := pageContent(page)
+		pw.object(n+1, fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content))
+	}
+
+	xref := pw.n
+	pw.printf("xref\n0 %d\n0000000000 65535 f \n", len(pw.offsets)+1)
+	for _, off := range pw.offsets {
+		pw.printf("%010d 00000 n \n", off)
+	}
+	pw.printf("trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(pw.offsets)+1, xref)
+	if pw.err != nil {
+		return pw.err
+	}
+	return pw.w.Flush()
+}
+
+// pageContent returns the content stream drawing the lines top down.
+func pageContent(lines []line) string {
+	var b strings.Builder
+	b.WriteString("BT\n")
+	y := float64(pageHeight - margin)
+	for _, l := range lines {
+		font, size := "/F1", 10
+		switch {
+		case l.title:
+			font, size = "/F2", 16
+		case l.bold:
+			font = "/F2"
+		}
+		for _, c := range l.cells {
+			fmt.Fprintf(&b, "%s %d Tf 1 0 0 1 %g %g Tm (%s) Tj\n", font, size, margin+c.x, y, escape(c.text))
+		}
+		y -= lineHeight
+	}
+	b.WriteString("ET")
+	return b.String()
+}
+
+// escape escapes the text for a PDF string. The standard fonts only cover
+// Latin-1, so other characters print as a question mark.
+func escape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '\\' || r == '(' || r == ')':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 0x20 || r > 0xff:
+			b.WriteByte('?')
+		case r < 0x80:
+			b.WriteRune(r)
+		default:
+			fmt.Fprintf(&b, "\\%03o", r)
+		}
+	}
+	return b.String()
+}
+
+// pdfWriter writes numbered objects, keeping their offsets for the cross
+// reference table. The first error sticks.
+type pdfWriter struct {
+	w       *bufio.Writer
+	n       int
+	offsets []int
+	err     error
+}
+
+func (p *pdfWriter) printf(format string, args ...any) {
+	if p.err != nil {
+		return
+	}
+	n, err := fmt.Fprintf(p.w, format, args...)
+	p.n += n
+	p.err = err
+}
+
+func (p *pdfWriter) object(num int, body string) {
+	p.offsets = append(p.offsets, p.n)
+	p.printf("%d 0 obj\n%s\nendobj\n", num, body)
+}
+
+func date(t time.Time) string {
+	if t.IsZero() {
+		return "?"
+	}
+	return t.UTC().Format(time.DateOnly)
+}
+
+// millis formats a time in milliseconds.
+func millis(ms int64) string {
+	if ms == 0 {
+		return ""
+	}
+	return time.UnixMilli(ms).UTC().Format("2006-01-02 15:04")
+}
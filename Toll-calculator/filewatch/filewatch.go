@@ -0,0 +1,97 @@
+// Package filewatch reloads the files a service reads at startup, such as
+// zones, rates or certificates, when they change while it runs. Files are
+// polled by their modification time, which works on every file system and
+// for files replaced by a rename.
+package filewatch
+
+import (
+	"context"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Files tracks the modification times of a set of files as of the last
+// time they were loaded successfully.
+type Files struct {
+	paths []string
+
+	mu     sync.Mutex
+	loaded []time.Time
+}
+
+// New returns a tracker of the files at paths, which haven't been loaded
+// yet.
+func New(paths ...string) *Files {
+	return &Files{paths: paths}
+}
+
+// Load calls read and, if it succeeds, records the modification times the
+// files had before it. A file changing while it is read is thus loaded
+// again at the next check. Loads don't overlap.
+func (f *Files) Load(read func() error) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	times, err := f.stat()
+	if err != nil {
+		return err
+	}
+	if err := read(); err != nil {
+		return err
+	}
+	f.loaded = times
+	return nil
+}
+
+// Changed reports whether any of the files was modified since it was last
+// loaded. A file that can't be read isn't reported, so one that is being
+// replaced is only reloaded once it is back.
+func (f *Files) Changed() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	times, err := f.stat()
+	if err != nil {
+		return false
+	}
+	return !slices.EqualFunc(times, f.loaded, time.Time.Equal)
+}
+
+func (f *Files) stat() ([]time.Time, error) {
+	times := make([]time.Time, len(f.paths))
+	for i, path := range f.paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		times[i] = info.ModTime()
+	}
+	return times, nil
+}
+
+// Run calls reload whenever the files changed, checking every interval,
+// until ctx is done. reload is expected to load the files with Load. If it
+// fails, what is kept as it was and the files are reloaded again at the
+// next check, so a file caught halfway through being written is picked up
+// once it is complete.
+func (f *Files) Run(ctx context.Context, interval time.Duration, what string, reload func() error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if !f.Changed() {
+			continue
+		}
+		if err := reload(); err != nil {
+			logrus.WithError(err).Errorf("keeping the current %s", what)
+			continue
+		}
+		logrus.Infof("reloaded %s", what)
+	}
+}
@@ -1,28 +1,106 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
-	"flag"
 	"log"
 	"net/http"
+	"os"
+	"strconv"
+	"time"
 
 	"github.com/0x0Glitch/toll-calculator/aggregator/client"
+	"github.com/0x0Glitch/toll-calculator/apperr"
+	"github.com/0x0Glitch/toll-calculator/config"
+	"github.com/0x0Glitch/toll-calculator/export"
+	"github.com/0x0Glitch/toll-calculator/health"
+	"github.com/0x0Glitch/toll-calculator/mtls"
+	"github.com/0x0Glitch/toll-calculator/shutdown"
+	"github.com/0x0Glitch/toll-calculator/tracing"
+	"github.com/0x0Glitch/toll-calculator/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
 )
 
 type apiFunc func(w http.ResponseWriter, r *http.Request) error
 
 func main() {
-	listenAddr := flag.String("listenAddr", ":6000", "the listen address of the http server")
-	flag.Parse()
+	watcher, err := config.NewWatcher[config.Gateway](os.Args[1:], prometheus.DefaultRegisterer)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cfg := watcher.Current()
+	logrus.SetLevel(cfg.Level())
+	logrus.Infof("effective config:\n%s", config.Dump(cfg))
+
+	ctx, stop := shutdown.Signals(context.Background())
+	defer stop()
+	tp, err := tracing.Init(context.Background(), "gateway", cfg.TracesExporter)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	resolver, err := client.ParseResolver(cfg.Aggregator.Target)
+	if err != nil {
+		log.Fatal(err)
+	}
+	picker, err := client.ParsePicker(cfg.Aggregator.Balance)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var clientOpts []client.Option
+	if cfg.TLS.Enabled() {
+		certs, err := mtls.NewReloader(cfg.TLS.CAFile, cfg.TLS.CertFile, cfg.TLS.KeyFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		go certs.Run(ctx, config.WatchInterval)
+		clientOpts = append(clientOpts, client.WithTLS(certs.ClientConfig()))
+	}
+	aggClient := client.NewBalancedHTTPClient(client.NewBalancer(resolver, client.WithPicker(picker)), clientOpts...)
+	invHandler := newInvoiceHandler(aggClient)
+	tripsHandler := newTripsHandler(aggClient)
+	exportHandler := newExportHandler(aggClient)
+
+	limiter := rate.NewLimiter(config.Limit(cfg.RateLimit))
+	watcher.OnReload(func(_, next *config.Gateway) error {
+		logrus.SetLevel(next.Level())
+		limit, burst := config.Limit(next.RateLimit)
+		limiter.SetLimit(limit)
+		limiter.SetBurst(burst)
+		return nil
+	})
+	go watcher.Run(ctx, config.WatchInterval)
 
-	client := client.NewHTTPClient("http://localhost:3000")
-	invHandler := newInvoiceHandler(client)
+	http.Handle("/invoice", tracing.HTTPHandler(makeAPIFunc(rateLimited(limiter, invHandler.handleGetInvoice)), "invoice"))
+	http.Handle("/trips", tracing.HTTPHandler(makeAPIFunc(rateLimited(limiter, tripsHandler.handleGetTrips)), "trips"))
+	http.Handle("/invoices/export", tracing.HTTPHandler(makeAPIFunc(rateLimited(limiter, exportHandler.handleExportInvoices)), "invoices-export"))
+	http.Handle("/invoices/document", tracing.HTTPHandler(makeAPIFunc(rateLimited(limiter, exportHandler.handleGetInvoiceDocument)), "invoices-document"))
+	http.Handle("/metrics", promhttp.Handler())
+	checker := health.NewChecker()
+	checker.AddPinger("aggregator", aggClient)
+	checker.Register(http.DefaultServeMux)
+	watcher.Register(http.DefaultServeMux)
+	srv := &http.Server{Addr: cfg.ListenAddr}
+	go func() {
+		logrus.Infof("gateway HTTP server running on port %s", cfg.ListenAddr)
+		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+	<-ctx.Done()
 
-	http.HandleFunc("/invoice", makeAPIFunc(invHandler.handleGetInvoice))
-	logrus.Infof("gateway HTTP server running on port %s", *listenAddr)
-	log.Fatal(http.ListenAndServe(*listenAddr, nil))
+	logrus.Info("shutting down")
+	err = shutdown.Run(cfg.ShutdownTimeout,
+		shutdown.HTTPServer("http", srv),
+		shutdown.Hook{Name: "tracing", Fn: tp.Shutdown},
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
 }
 
 type InvoiceHandler struct{
@@ -36,15 +114,129 @@ func newInvoiceHandler(c client.Client) *InvoiceHandler{
 }
 
 func (h *InvoiceHandler) handleGetInvoice(w http.ResponseWriter, r *http.Request) error {
-	// return fmt.Errorf("Something went wrong")
-	// access agg client
-	inv, err := h.client.GetInvoice(context.Background(),1669557248)
+	values, ok := r.URL.Query()["obu"]
+	if !ok {
+		return apperr.InvalidArgumentf("missing OBU ID")
+	}
+	obuID, err := strconv.Atoi(values[0])
+	if err != nil {
+		return apperr.InvalidArgumentf("invalid OBU ID %v", values[0])
+	}
+	inv, err := h.client.GetInvoice(r.Context(), obuID)
 	if err != nil{
 		return err
 	}
 	return writeJSON(w, http.StatusOK, inv)
 }
 
+// tripLister is the part of the aggregator client listing trips, which
+// only the HTTP transport serves.
+type tripLister interface {
+	Trips(ctx context.Context, obuID int32, from, to time.Time) ([]types.Trip, error)
+}
+
+type TripsHandler struct {
+	client tripLister
+}
+
+func newTripsHandler(c tripLister) *TripsHandler {
+	return &TripsHandler{
+		client: c,
+	}
+}
+
+func (h *TripsHandler) handleGetTrips(w http.ResponseWriter, r *http.Request) error {
+	q := r.URL.Query()
+	if !q.Has("obu") {
+		return apperr.InvalidArgumentf("missing OBU ID")
+	}
+	obuID, err := strconv.Atoi(q.Get("obu"))
+	if err != nil {
+		return apperr.InvalidArgumentf("invalid OBU ID %v", q.Get("obu"))
+	}
+	var period [2]time.Time
+	for i, name := range []string{"from", "to"} {
+		if v := q.Get(name); v != "" {
+			if period[i], err = time.Parse(time.RFC3339Nano, v); err != nil {
+				return apperr.InvalidArgumentf("invalid %s %q: want RFC 3339", name, v)
+			}
+		}
+	}
+	trips, err := h.client.Trips(r.Context(), int32(obuID), period[0], period[1])
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, trips)
+}
+
+// invoiceExporter is the part of the aggregator client reading invoice
+// documents, which only the HTTP transport serves.
+type invoiceExporter interface {
+	tripLister
+	Invoice(ctx context.Context, id string) (*types.Invoice, error)
+	ExportInvoices(ctx context.Context, period string, fn func(types.Invoice) error) error
+}
+
+type ExportHandler struct {
+	client invoiceExporter
+}
+
+func newExportHandler(c invoiceExporter) *ExportHandler {
+	return &ExportHandler{
+		client: c,
+	}
+}
+
+// handleExportInvoices streams the invoices of a period as CSV or JSON
+// Lines, converting them from the aggregator's stream as they come in.
+func (h *ExportHandler) handleExportInvoices(w http.ResponseWriter, r *http.Request) error {
+	q := r.URL.Query()
+	period := q.Get("period")
+	if period == "" {
+		return apperr.InvalidArgumentf("missing period")
+	}
+	format, err := export.ParseFormat(q.Get("format"))
+	if err != nil {
+		return apperr.InvalidArgumentf("%v", err)
+	}
+	return export.Serve(w, format, period, func(fn func(types.Invoice) error) error {
+		return h.client.ExportInvoices(r.Context(), period, fn)
+	})
+}
+
+// handleGetInvoiceDocument answers an invoice as JSON or, with format=pdf,
+// renders it here as a PDF with the vehicle's trips in the period.
+func (h *ExportHandler) handleGetInvoiceDocument(w http.ResponseWriter, r *http.Request) error {
+	q := r.URL.Query()
+	id := q.Get("id")
+	if id == "" {
+		return apperr.InvalidArgumentf("missing invoice ID")
+	}
+	format := q.Get("format")
+	if format != "" && format != "json" && format != "pdf" {
+		return apperr.InvalidArgumentf("unknown format %q: want json or pdf", format)
+	}
+	inv, err := h.client.Invoice(r.Context(), id)
+	if err != nil {
+		return err
+	}
+	if format != "pdf" {
+		return writeJSON(w, http.StatusOK, inv)
+	}
+	trips, err := h.client.Trips(r.Context(), inv.OBUID, inv.PeriodStart, inv.PeriodEnd)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := export.WritePDF(&buf, *inv, trips); err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", `inline; filename="`+id+`.pdf"`)
+	w.WriteHeader(http.StatusOK)
+	_, err = buf.WriteTo(w)
+	return err
+}
 
 func writeJSON(w http.ResponseWriter, code int, v any) error {
 	w.Header().Add("Content-Type", "application/json")
@@ -52,10 +244,21 @@ func writeJSON(w http.ResponseWriter, code int, v any) error {
 	return json.NewEncoder(w).Encode(v)
 }
 
+// rateLimited rejects requests coming in faster than limiter allows, so the
+// gateway sheds load instead of queueing it on the aggregator.
+func rateLimited(limiter *rate.Limiter, fn apiFunc) apiFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		if !limiter.Allow() {
+			return apperr.Unavailablef("rate limit exceeded")
+		}
+		return fn(w, r)
+	}
+}
+
 func makeAPIFunc(fn apiFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if err := fn(w, r); err != nil {
-			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			writeJSON(w, apperr.HTTPStatus(err), apperr.BodyOf(err))
 		}
 	}
 }
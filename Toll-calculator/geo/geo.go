@@ -0,0 +1,66 @@
+// Package geo holds the geometry shared by the location packages: points,
+// bounding boxes and an R-tree to find shapes near a position.
+package geo
+
+import "math"
+
+// Point is a position in degrees.
+type Point struct {
+	Lat  float64 `json:"lat"`
+	Long float64 `json:"long"`
+}
+
+// Lerp returns the point at fraction t of the way from a to b.
+func Lerp(a, b Point, t float64) Point {
+	return Point{Lat: a.Lat + t*(b.Lat-a.Lat), Long: a.Long + t*(b.Long-a.Long)}
+}
+
+// MetersPerDegree is the length of a degree of latitude, and of longitude
+// at the equator.
+const MetersPerDegree = 111_320.0
+
+// Meters returns the distance between a and b in metres. It treats the
+// earth as flat around them, which is accurate for the short distances
+// between consecutive fixes and along road segments.
+func Meters(a, b Point) float64 {
+	kx := math.Cos((a.Lat + b.Lat) / 2 * math.Pi / 180)
+	return math.Hypot((b.Long-a.Long)*kx, b.Lat-a.Lat) * MetersPerDegree
+}
+
+// Rect is a bounding box, with X the longitude and Y the latitude.
+type Rect struct {
+	MinX, MinY, MaxX, MaxY float64
+}
+
+// BoundsOf returns the smallest box holding the points.
+func BoundsOf(pts ...Point) Rect {
+	r := Rect{MinX: math.Inf(1), MinY: math.Inf(1), MaxX: math.Inf(-1), MaxY: math.Inf(-1)}
+	for _, p := range pts {
+		r = r.Union(Rect{MinX: p.Long, MinY: p.Lat, MaxX: p.Long, MaxY: p.Lat})
+	}
+	return r
+}
+
+// Around returns the box holding every point within meters of p.
+func Around(p Point, meters float64) Rect {
+	dy := meters / MetersPerDegree
+	dx := meters / (MetersPerDegree * math.Max(math.Cos(p.Lat*math.Pi/180), 1e-6))
+	return Rect{MinX: p.Long - dx, MinY: p.Lat - dy, MaxX: p.Long + dx, MaxY: p.Lat + dy}
+}
+
+func (r Rect) Union(o Rect) Rect {
+	return Rect{
+		MinX: math.Min(r.MinX, o.MinX),
+		MinY: math.Min(r.MinY, o.MinY),
+		MaxX: math.Max(r.MaxX, o.MaxX),
+		MaxY: math.Max(r.MaxY, o.MaxY),
+	}
+}
+
+func (r Rect) Intersects(o Rect) bool {
+	return r.MinX <= o.MaxX && o.MinX <= r.MaxX && r.MinY <= o.MaxY && o.MinY <= r.MaxY
+}
+
+func (r Rect) center() (x, y float64) {
+	return (r.MinX + r.MaxX) / 2, (r.MinY + r.MaxY) / 2
+}
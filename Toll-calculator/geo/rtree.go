@@ -0,0 +1,96 @@
+package geo
+
+import (
+	"math"
+	"sort"
+)
+
+// nodeCapacity is the number of entries per R-tree node.
+const nodeCapacity = 16
+
+// RTree is an R-tree over bounding boxes. The shapes it indexes, like toll
+// zones and road segments, only change on a reload, which builds a new
+// tree, so it is bulk loaded with Sort-Tile-Recursive packing rather than
+// grown by inserts.
+type RTree struct {
+	root  *rtreeNode
+	boxes []Rect
+}
+
+type rtreeNode struct {
+	bounds   Rect
+	children []*rtreeNode
+	// items are the indexes of the boxes in a leaf.
+	items []int
+}
+
+// NewRTree indexes the boxes, which are found again by their index.
+func NewRTree(boxes []Rect) *RTree {
+	if len(boxes) == 0 {
+		return &RTree{}
+	}
+	level := make([]*rtreeNode, len(boxes))
+	for i, b := range boxes {
+		level[i] = &rtreeNode{bounds: b, items: []int{i}}
+	}
+	leaves := true
+	for len(level) > 1 || leaves {
+		level = pack(level, leaves)
+		leaves = false
+	}
+	return &RTree{root: level[0], boxes: boxes}
+}
+
+// pack groups nodes into parents of up to nodeCapacity entries: sorted by
+// X into vertical slices, then by Y within each slice, so each parent covers
+// a compact tile.
+func pack(nodes []*rtreeNode, leaves bool) []*rtreeNode {
+	parents := int(math.Ceil(float64(len(nodes)) / nodeCapacity))
+	slices := int(math.Ceil(math.Sqrt(float64(parents))))
+	perSlice := slices * nodeCapacity
+	sortBy(nodes, func(r Rect) float64 { x, _ := r.center(); return x })
+	var out []*rtreeNode
+	for start := 0; start < len(nodes); start += perSlice {
+		slice := nodes[start:min(start+perSlice, len(nodes))]
+		sortBy(slice, func(r Rect) float64 { _, y := r.center(); return y })
+		for i := 0; i < len(slice); i += nodeCapacity {
+			group := slice[i:min(i+nodeCapacity, len(slice))]
+			parent := &rtreeNode{bounds: group[0].bounds}
+			for _, n := range group {
+				parent.bounds = parent.bounds.Union(n.bounds)
+				if leaves {
+					parent.items = append(parent.items, n.items...)
+				} else {
+					parent.children = append(parent.children, n)
+				}
+			}
+			out = append(out, parent)
+		}
+	}
+	return out
+}
+
+func sortBy(nodes []*rtreeNode, key func(Rect) float64) {
+	sort.Slice(nodes, func(i, j int) bool { return key(nodes[i].bounds) < key(nodes[j].bounds) })
+}
+
+// Search calls fn with the index of every box intersecting r.
+func (t *RTree) Search(r Rect, fn func(int)) {
+	if t.root != nil {
+		t.searchNode(t.root, r, fn)
+	}
+}
+
+func (t *RTree) searchNode(n *rtreeNode, r Rect, fn func(int)) {
+	if !n.bounds.Intersects(r) {
+		return
+	}
+	for _, i := range n.items {
+		if t.boxes[i].Intersects(r) {
+			fn(i)
+		}
+	}
+	for _, c := range n.children {
+		t.searchNode(c, r, fn)
+	}
+}
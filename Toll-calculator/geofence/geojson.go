@@ -0,0 +1,233 @@
+package geofence
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+
+	"github.com/0x0Glitch/toll-calculator/money"
+)
+
+// defaultRoadWidth is the width in metres of a road without a width
+// property. It is generous, as GPS fixes scatter around the carriageway.
+const defaultRoadWidth = 30.0
+
+type featureCollection struct {
+	Type     string    `json:"type"`
+	Features []feature `json:"features"`
+}
+
+type feature struct {
+	ID         any        `json:"id"`
+	Geometry   *geometry  `json:"geometry"`
+	Properties properties `json:"properties"`
+}
+
+type geometry struct {
+	Type        string          `json:"type"`
+	Coordinates json.RawMessage `json:"coordinates"`
+}
+
+// properties are the feature properties the subsystem reads. Everything
+// else is ignored.
+type properties struct {
+	ZoneID   string   `json:"zoneID"`
+	Name     string   `json:"name"`
+	Priority *int     `json:"priority"`
+	Tariff   *float64 `json:"tariff"`
+	Currency string   `json:"currency"`
+	Country  string   `json:"country"`
+	Width    *float64 `json:"width"`
+}
+
+// Load reads the zones from a GeoJSON file, see Parse.
+func Load(path string) (*Index, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("geofence: %w", err)
+	}
+	ix, err := Parse(b)
+	if err != nil {
+		return nil, fmt.Errorf("%w in %s", err, path)
+	}
+	return ix, nil
+}
+
+// Parse reads the zones from a GeoJSON FeatureCollection. Polygon and
+// MultiPolygon features are toll zones, LineString and MultiLineString
+// features tolled roads. Each feature names its zone in the zoneID property
+// or its id; features sharing a zone, like the segments of a road, make up
+// one zone. Optional properties are the name, the tariff per unit of
+// distance and its currency, the country the zone lies in, the priority
+// deciding between overlapping zones, and the width of a road in metres.
+func Parse(b []byte) (*Index, error) {
+	var fc featureCollection
+	if err := json.Unmarshal(b, &fc); err != nil {
+		return nil, fmt.Errorf("geofence: %w", err)
+	}
+	if fc.Type != "FeatureCollection" {
+		return nil, fmt.Errorf("geofence: want a FeatureCollection, got %q", fc.Type)
+	}
+	var shapes []shape
+	zones := make(map[string]Zone)
+	for i, f := range fc.Features {
+		zone, polygons, err := f.parse()
+		if err != nil {
+			return nil, fmt.Errorf("geofence: feature %d: %w", i, err)
+		}
+		if prev, ok := zones[zone.ID]; ok && prev != zone {
+			return nil, fmt.Errorf("geofence: feature %d: zone %q is defined differently by an earlier feature", i, zone.ID)
+		}
+		zones[zone.ID] = zone
+		for _, pg := range polygons {
+			shapes = append(shapes, shape{zone: zone.ID, polygon: pg})
+		}
+	}
+	return newIndex(zones, shapes), nil
+}
+
+func (f feature) parse() (Zone, []polygon, error) {
+	z := Zone{ID: f.Properties.ZoneID, Name: f.Properties.Name, Country: f.Properties.Country}
+	if z.ID == "" {
+		switch id := f.ID.(type) {
+		case string:
+			z.ID = id
+		case float64:
+			z.ID = strconv.FormatFloat(id, 'f', -1, 64)
+		}
+	}
+	if z.ID == "" {
+		return Zone{}, nil, fmt.Errorf("no zoneID property or id")
+	}
+	if f.Geometry == nil {
+		return Zone{}, nil, fmt.Errorf("zone %q has no geometry", z.ID)
+	}
+	if t := f.Properties.Tariff; t != nil {
+		if *t < 0 {
+			return Zone{}, nil, fmt.Errorf("zone %q: tariff must not be negative", z.ID)
+		}
+		z.Tariff = *t
+	}
+	if c := f.Properties.Currency; c != "" {
+		cur, err := money.ParseCurrency(c)
+		if err != nil {
+			return Zone{}, nil, fmt.Errorf("zone %q: %w", z.ID, err)
+		}
+		z.Currency = cur
+	}
+
+	var (
+		polygons []polygon
+		err      error
+	)
+	switch f.Geometry.Type {
+	case "Polygon", "MultiPolygon":
+		z.Kind = KindZone
+		polygons, err = f.Geometry.polygons()
+	case "LineString", "MultiLineString":
+		z.Kind = KindRoad
+		// Roads outrank the zones they run through by default, so a tolled
+		// motorway is billed as such inside a city zone.
+		z.Priority = 1
+		width := defaultRoadWidth
+		if w := f.Properties.Width; w != nil {
+			if *w <= 0 {
+				return Zone{}, nil, fmt.Errorf("zone %q: width must be positive", z.ID)
+			}
+			width = *w
+		}
+		var lines [][]Point
+		lines, err = f.Geometry.lines()
+		for _, line := range lines {
+			polygons = append(polygons, corridor(line, width/2)...)
+		}
+	default:
+		return Zone{}, nil, fmt.Errorf("zone %q: unsupported geometry %q", z.ID, f.Geometry.Type)
+	}
+	if err != nil {
+		return Zone{}, nil, fmt.Errorf("zone %q: %w", z.ID, err)
+	}
+	if p := f.Properties.Priority; p != nil {
+		z.Priority = *p
+	}
+	return z, polygons, nil
+}
+
+func (g *geometry) polygons() ([]polygon, error) {
+	var multi [][][][]float64
+	if g.Type == "Polygon" {
+		var rings [][][]float64
+		if err := json.Unmarshal(g.Coordinates, &rings); err != nil {
+			return nil, err
+		}
+		multi = [][][][]float64{rings}
+	} else if err := json.Unmarshal(g.Coordinates, &multi); err != nil {
+		return nil, err
+	}
+	var out []polygon
+	for _, rings := range multi {
+		if len(rings) == 0 {
+			return nil, fmt.Errorf("polygon without rings")
+		}
+		var pg [][]Point
+		for _, ring := range rings {
+			pts, err := points(ring)
+			if err != nil {
+				return nil, err
+			}
+			// GeoJSON rings repeat their first point at the end.
+			if len(pts) > 1 && pts[0] == pts[len(pts)-1] {
+				pts = pts[:len(pts)-1]
+			}
+			if len(pts) < 3 {
+				return nil, fmt.Errorf("ring with fewer than 3 points")
+			}
+			pg = append(pg, pts)
+		}
+		out = append(out, newPolygon(pg))
+	}
+	return out, nil
+}
+
+func (g *geometry) lines() ([][]Point, error) {
+	var multi [][][]float64
+	if g.Type == "LineString" {
+		var line [][]float64
+		if err := json.Unmarshal(g.Coordinates, &line); err != nil {
+			return nil, err
+		}
+		multi = [][][]float64{line}
+	} else if err := json.Unmarshal(g.Coordinates, &multi); err != nil {
+		return nil, err
+	}
+	var out [][]Point
+	for _, line := range multi {
+		pts, err := points(line)
+		if err != nil {
+			return nil, err
+		}
+		if len(pts) < 2 {
+			return nil, fmt.Errorf("line with fewer than 2 points")
+		}
+		out = append(out, pts)
+	}
+	return out, nil
+}
+
+// points converts GeoJSON positions, longitude first, to points.
+func points(coords [][]float64) ([]Point, error) {
+	pts := make([]Point, 0, len(coords))
+	for _, c := range coords {
+		if len(c) < 2 {
+			return nil, fmt.Errorf("position with fewer than 2 coordinates")
+		}
+		p := Point{Lat: c[1], Long: c[0]}
+		if math.Abs(p.Lat) > 90 || math.Abs(p.Long) > 180 {
+			return nil, fmt.Errorf("position %v out of range", c)
+		}
+		pts = append(pts, p)
+	}
+	return pts, nil
+}
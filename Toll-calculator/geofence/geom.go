@@ -0,0 +1,125 @@
+package geofence
+
+import (
+	"math"
+	"sort"
+
+	"github.com/0x0Glitch/toll-calculator/geo"
+)
+
+// polygon is an outer ring followed by its holes. Rings aren't closed, the
+// last point connects back to the first.
+type polygon struct {
+	rings  [][]Point
+	bounds geo.Rect
+}
+
+func newPolygon(rings [][]Point) polygon {
+	return polygon{rings: rings, bounds: geo.BoundsOf(rings[0]...)}
+}
+
+// contains reports whether p lies inside the polygon, by the even-odd rule,
+// so holes are excluded.
+func (pg *polygon) contains(p Point) bool {
+	in := false
+	for _, ring := range pg.rings {
+		for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+			a, b := ring[i], ring[j]
+			if (a.Lat > p.Lat) != (b.Lat > p.Lat) &&
+				p.Long < (b.Long-a.Long)*(p.Lat-a.Lat)/(b.Lat-a.Lat)+a.Long {
+				in = !in
+			}
+		}
+	}
+	return in
+}
+
+// span is the part of a leg between two fractions of its length.
+type span struct {
+	from, to float64
+}
+
+// spans returns the parts of the leg from a to b inside the polygon, in
+// order. The leg is cut wherever it crosses an edge and each piece is
+// tested at its midpoint, which handles concave polygons and holes alike.
+func (pg *polygon) spans(a, b Point) []span {
+	cuts := []float64{0, 1}
+	for _, ring := range pg.rings {
+		for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+			if t, ok := crossing(a, b, ring[j], ring[i]); ok {
+				cuts = append(cuts, t)
+			}
+		}
+	}
+	sort.Float64s(cuts)
+	var out []span
+	for i := 0; i+1 < len(cuts); i++ {
+		from, to := cuts[i], cuts[i+1]
+		if to-from < 1e-12 || !pg.contains(geo.Lerp(a, b, (from+to)/2)) {
+			continue
+		}
+		if n := len(out); n > 0 && out[n-1].to == from {
+			out[n-1].to = to
+			continue
+		}
+		out = append(out, span{from, to})
+	}
+	return out
+}
+
+// crossing returns the fraction of the way from a to b at which the leg
+// crosses the edge from c to d. Legs parallel to the edge never cross it.
+func crossing(a, b, c, d Point) (float64, bool) {
+	rx, ry := b.Long-a.Long, b.Lat-a.Lat
+	sx, sy := d.Long-c.Long, d.Lat-c.Lat
+	denom := rx*sy - ry*sx
+	if denom == 0 {
+		return 0, false
+	}
+	qx, qy := c.Long-a.Long, c.Lat-a.Lat
+	t := (qx*sy - qy*sx) / denom
+	u := (qx*ry - qy*rx) / denom
+	if t <= 0 || t >= 1 || u < 0 || u > 1 {
+		return 0, false
+	}
+	return t, true
+}
+
+// corridor returns the polygons covering everything within halfWidth
+// metres of the road: a rectangle along every segment and an octagon around
+// every vertex, so bends leave no gaps.
+func corridor(line []Point, halfWidth float64) []polygon {
+	var out []polygon
+	for i, p := range line {
+		kx := geo.MetersPerDegree * math.Cos(p.Lat*math.Pi/180)
+		var oct []Point
+		for k := 0; k < 8; k++ {
+			angle := float64(k) * math.Pi / 4
+			oct = append(oct, Point{
+				Lat:  p.Lat + halfWidth*math.Sin(angle)/geo.MetersPerDegree,
+				Long: p.Long + halfWidth*math.Cos(angle)/kx,
+			})
+		}
+		out = append(out, newPolygon([][]Point{oct}))
+		if i == 0 {
+			continue
+		}
+		q := line[i-1]
+		// Offsets are worked out in metres around the segment, so the
+		// corridor has the same width whichever way the road runs.
+		kx = geo.MetersPerDegree * math.Cos((p.Lat+q.Lat)/2*math.Pi/180)
+		dx, dy := (p.Long-q.Long)*kx, (p.Lat-q.Lat)*geo.MetersPerDegree
+		length := math.Hypot(dx, dy)
+		if length == 0 {
+			continue
+		}
+		nx, ny := -dy/length*halfWidth/kx, dx/length*halfWidth/geo.MetersPerDegree
+		out = append(out, newPolygon([][]Point{{
+			{Lat: q.Lat + ny, Long: q.Long + nx},
+			{Lat: p.Lat + ny, Long: p.Long + nx},
+			{Lat: p.Lat - ny, Long: p.Long - nx},
+			{Lat: q.Lat - ny, Long: q.Long - nx},
+		}}))
+	}
+	return out
+}
@@ -0,0 +1,218 @@
+// Package geofence attributes travelled distance to toll zones and tolled
+// roads. Zones are read from GeoJSON and indexed in an R-tree; a leg between
+// two fixes is cut wherever it crosses a zone boundary, so each piece can be
+// priced by the zone it lies in.
+package geofence
+
+import (
+	"context"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/0x0Glitch/toll-calculator/filewatch"
+	"github.com/0x0Glitch/toll-calculator/geo"
+	"github.com/0x0Glitch/toll-calculator/money"
+)
+
+// Kind tells toll zones from tolled roads.
+type Kind string
+
+const (
+	KindZone Kind = "zone"
+	KindRoad Kind = "road"
+)
+
+// Point is a position in degrees.
+type Point = geo.Point
+
+// Zone is a toll zone or a tolled road.
+type Zone struct {
+	ID   string
+	Name string
+	Kind Kind
+	// Priority decides which zone a piece of road lying in several is
+	// billed to: the highest wins, ties go to the lowest ID.
+	Priority int
+	// Tariff is the price per unit of distance, 0 for the default tariff.
+	Tariff float64
+	// Currency is the currency of Tariff, empty for the default tariff's.
+	Currency money.Currency
+	// Country is the ISO 3166 code of the country the zone lies in, which
+	// taxes its tolls, if known.
+	Country string
+}
+
+// Piece is the part of a leg inside one zone, or outside all of them if
+// ZoneID is empty. From and To are fractions of the leg's length.
+type Piece struct {
+	ZoneID   string
+	From, To float64
+}
+
+// shape is a polygon of a zone. Zones with several polygons, like the
+// corridors around the segments of a road, have one shape each.
+type shape struct {
+	zone    string
+	polygon polygon
+}
+
+// Index finds the zones legs cross. A nil index has no zones.
+type Index struct {
+	zones  map[string]Zone
+	shapes []shape
+	tree   *geo.RTree
+}
+
+func newIndex(zones map[string]Zone, shapes []shape) *Index {
+	boxes := make([]geo.Rect, len(shapes))
+	for i, s := range shapes {
+		boxes[i] = s.polygon.bounds
+	}
+	return &Index{zones: zones, shapes: shapes, tree: geo.NewRTree(boxes)}
+}
+
+// Zone returns the zone with the given ID.
+func (ix *Index) Zone(id string) (Zone, bool) {
+	if ix == nil {
+		return Zone{}, false
+	}
+	z, ok := ix.zones[id]
+	return z, ok
+}
+
+// Zones returns every zone, ordered by ID.
+func (ix *Index) Zones() []Zone {
+	if ix == nil {
+		return nil
+	}
+	out := make([]Zone, 0, len(ix.zones))
+	for _, z := range ix.zones {
+		out = append(out, z)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// Locate returns the ID of the zone p lies in, empty if none.
+func (ix *Index) Locate(p Point) string {
+	if ix == nil {
+		return ""
+	}
+	best := ""
+	ix.tree.Search(geo.BoundsOf(p), func(i int) {
+		s := &ix.shapes[i]
+		if s.polygon.contains(p) && ix.outranks(s.zone, best) {
+			best = s.zone
+		}
+	})
+	return best
+}
+
+// Split cuts the leg from a to b at every zone boundary it crosses. The
+// pieces cover the whole leg in order, and neighbouring pieces always lie
+// in different zones.
+func (ix *Index) Split(a, b Point) []Piece {
+	if ix == nil {
+		return []Piece{{From: 0, To: 1}}
+	}
+	if a == b {
+		return []Piece{{ZoneID: ix.Locate(a), From: 0, To: 1}}
+	}
+	type zoneSpan struct {
+		span
+		zone string
+	}
+	var spans []zoneSpan
+	cuts := []float64{0, 1}
+	ix.tree.Search(geo.BoundsOf(a, b), func(i int) {
+		s := &ix.shapes[i]
+		for _, sp := range s.polygon.spans(a, b) {
+			spans = append(spans, zoneSpan{sp, s.zone})
+			cuts = append(cuts, sp.from, sp.to)
+		}
+	})
+	sort.Float64s(cuts)
+
+	var pieces []Piece
+	for i := 0; i+1 < len(cuts); i++ {
+		from, to := cuts[i], cuts[i+1]
+		if to <= from {
+			continue
+		}
+		mid := (from + to) / 2
+		zone := ""
+		for _, s := range spans {
+			if s.from <= mid && mid <= s.to && ix.outranks(s.zone, zone) {
+				zone = s.zone
+			}
+		}
+		if n := len(pieces); n > 0 && pieces[n-1].ZoneID == zone {
+			pieces[n-1].To = to
+			continue
+		}
+		pieces = append(pieces, Piece{ZoneID: zone, From: from, To: to})
+	}
+	return pieces
+}
+
+// outranks reports whether zone a takes precedence over zone b, where the
+// empty b is no zone at all.
+func (ix *Index) outranks(a, b string) bool {
+	if b == "" {
+		return true
+	}
+	za, zb := ix.zones[a], ix.zones[b]
+	if za.Priority != zb.Priority {
+		return za.Priority > zb.Priority
+	}
+	return a < b
+}
+
+// Fences holds the zones read from a GeoJSON file and reloads them when the
+// file changes.
+type Fences struct {
+	path  string
+	files *filewatch.Files
+	index atomic.Pointer[Index]
+}
+
+// LoadFences reads the zones from the GeoJSON file at path.
+func LoadFences(path string) (*Fences, error) {
+	f := &Fences{path: path, files: filewatch.New(path)}
+	if err := f.Reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Index returns the current zones.
+func (f *Fences) Index() *Index {
+	return f.index.Load()
+}
+
+// Country returns the country the zone lies in, "" for a zone without one
+// or missing from the file.
+func (f *Fences) Country(zone string) string {
+	z, _ := f.Index().Zone(zone)
+	return z.Country
+}
+
+// Reload reads the file again. An invalid file leaves the zones as they
+// were.
+func (f *Fences) Reload() error {
+	return f.files.Load(func() error {
+		ix, err := Load(f.path)
+		if err != nil {
+			return err
+		}
+		f.index.Store(ix)
+		return nil
+	})
+}
+
+// Run reloads the file whenever it changes, checking every interval, so
+// zones can be redrawn without a restart. It returns when ctx is done.
+func (f *Fences) Run(ctx context.Context, interval time.Duration) {
+	f.files.Run(ctx, interval, "toll zones", f.Reload)
+}
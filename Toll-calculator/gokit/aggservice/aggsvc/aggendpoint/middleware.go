@@ -0,0 +1,101 @@
+package aggendpoint
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/0x0Glitch/toll-calculator/apperr"
+	"github.com/go-kit/kit/circuitbreaker"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/ratelimit"
+	"github.com/go-kit/log"
+	"github.com/sony/gobreaker"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/time/rate"
+)
+
+// LoggingMiddleware returns an endpoint middleware that logs the
+// duration of each invocation, and the resulting error, if any.
+func LoggingMiddleware(logger log.Logger) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+			defer func(begin time.Time) {
+				logger.Log("transport_error", err, "took", time.Since(begin))
+			}(time.Now())
+			return next(ctx, request)
+		}
+	}
+}
+
+// RateLimitMiddleware rejects requests with ratelimit.ErrLimited once more
+// than limit requests per second, with bursts of up to burst, come in.
+// The rejection is marked unavailable so clients know to back off.
+func RateLimitMiddleware(limit rate.Limit, burst int) endpoint.Middleware {
+	limiter := ratelimit.NewErroringLimiter(rate.NewLimiter(limit, burst))
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return unavailable(limiter(next), ratelimit.ErrLimited)
+	}
+}
+
+// CircuitBreakerMiddleware opens the circuit after five consecutive
+// failures and lets a trial request through again after thirty seconds.
+// Only transport-level errors count; a failed response such as an unknown
+// OBU is a valid answer and never trips the breaker.
+func CircuitBreakerMiddleware(name string) endpoint.Middleware {
+	breaker := circuitbreaker.Gobreaker(gobreaker.NewCircuitBreaker(gobreaker.Settings{
+		Name:    name,
+		Timeout: 30 * time.Second,
+		ReadyToTrip: func(counts gobreaker.Counts) bool {
+			return counts.ConsecutiveFailures >= 5
+		},
+	}))
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return unavailable(breaker(next), gobreaker.ErrOpenState, gobreaker.ErrTooManyRequests)
+	}
+}
+
+// unavailable marks the given sentinel errors returned by next as
+// apperr.Unavailable, keeping them in the chain for errors.Is.
+func unavailable(next endpoint.Endpoint, sentinels ...error) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		response, err := next(ctx, request)
+		for _, sentinel := range sentinels {
+			if errors.Is(err, sentinel) {
+				return response, apperr.Wrap(apperr.Unavailable, err, "endpoint unavailable")
+			}
+		}
+		return response, err
+	}
+}
+
+// TracingMiddleware wraps every invocation in a span named after the
+// operation. Failed responses are recorded on the span as errors.
+func TracingMiddleware(tracer trace.Tracer, operation string) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+			ctx, span := tracer.Start(ctx, operation)
+			defer span.End()
+
+			switch req := request.(type) {
+			case AggregateRequest:
+				span.SetAttributes(attribute.Int("obu.id", int(req.OBUID)))
+			case CalculateRequest:
+				span.SetAttributes(attribute.Int("obu.id", int(req.OBUID)))
+			}
+
+			response, err = next(ctx, request)
+			failed := err
+			if f, ok := response.(endpoint.Failer); ok && failed == nil {
+				failed = f.Failed()
+			}
+			if failed != nil {
+				span.RecordError(failed)
+				span.SetStatus(codes.Error, failed.Error())
+			}
+			return response, err
+		}
+	}
+}
@@ -4,8 +4,12 @@ import (
 	"context"
 
 	"github.com/0x0Glitch/toll-calculator/gokit/aggservice/aggsvc/aggservice"
+	"github.com/0x0Glitch/toll-calculator/money"
 	"github.com/0x0Glitch/toll-calculator/types"
 	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/log"
+	"go.opentelemetry.io/otel"
+	"golang.org/x/time/rate"
 )
 
 type Set struct {
@@ -13,73 +17,158 @@ type Set struct {
 	CalculateEndpoint endpoint.Endpoint
 }
 
-type calculateRequest struct {
+// New returns a Set that wraps the provided service, and wires in all of the
+// expected endpoint middlewares. Every endpoint gets its own rate limiter
+// and circuit breaker; pass rate.Inf to disable rate limiting.
+func New(svc aggservice.Service, logger log.Logger, limit rate.Limit) Set {
+	tracer := otel.Tracer("aggsvc")
+
+	var aggregateEndpoint endpoint.Endpoint
+	{
+		aggregateEndpoint = MakeAggregateEndpoint(svc)
+		aggregateEndpoint = CircuitBreakerMiddleware("Aggregate")(aggregateEndpoint)
+		aggregateEndpoint = RateLimitMiddleware(limit, burst(limit))(aggregateEndpoint)
+		aggregateEndpoint = TracingMiddleware(tracer, "Aggregate")(aggregateEndpoint)
+		aggregateEndpoint = LoggingMiddleware(log.With(logger, "method", "Aggregate"))(aggregateEndpoint)
+	}
+	var calculateEndpoint endpoint.Endpoint
+	{
+		calculateEndpoint = MakeCalculateEndpoint(svc)
+		calculateEndpoint = CircuitBreakerMiddleware("Calculate")(calculateEndpoint)
+		calculateEndpoint = RateLimitMiddleware(limit, burst(limit))(calculateEndpoint)
+		calculateEndpoint = TracingMiddleware(tracer, "Calculate")(calculateEndpoint)
+		calculateEndpoint = LoggingMiddleware(log.With(logger, "method", "Calculate"))(calculateEndpoint)
+	}
+	return Set{
+		AggregateEndpoint: aggregateEndpoint,
+		CalculateEndpoint: calculateEndpoint,
+	}
+}
+
+// burst allows short spikes of up to one second's worth of requests.
+func burst(limit rate.Limit) int {
+	if limit == rate.Inf || limit < 1 {
+		return 1
+	}
+	return int(limit)
+}
+
+type CalculateRequest struct {
 	OBUID int32 `json:"obuID"`
 }
 
-type aggregateRequest struct {
-	Values float64 `json:"value"`
-	OBUID  int32   `json:"obuID"`
-	Unix   int64   `json:"unix"`
+// AggregateRequest is a types.Distance, decoded from the same JSON the
+// aggregator takes.
+type AggregateRequest struct {
+	Values    float64        `json:"value"`
+	OBUID     int32          `json:"obuID"`
+	Unix      int64          `json:"unix"`
+	ZoneID    string         `json:"zoneID,omitempty"`
+	Estimated bool           `json:"estimated,omitempty"`
+	OffPeak   bool           `json:"offPeak,omitempty"`
+	Trip      *types.TripLeg `json:"trip,omitempty"`
 }
 
+func (r AggregateRequest) distance() types.Distance {
+	return types.Distance{
+		Values:    r.Values,
+		OBUID:     r.OBUID,
+		Unix:      r.Unix,
+		ZoneID:    r.ZoneID,
+		Estimated: r.Estimated,
+		OffPeak:   r.OffPeak,
+		Trip:      r.Trip,
+	}
+}
+
+// CalculateResponse is a types.Invoice, encoded as the aggregator encodes
+// the running invoice.
 type CalculateResponse struct {
-	OBUID         int32   `json:"obuID"`
-	TotalDistance float64 `json:"totalDistance"`
-	Amount        float64 `json:"amount"`
-	Err           error   `json:"err"`
+	OBUID             int32             `json:"obuID"`
+	TotalDistance     float64           `json:"totalDistance"`
+	Amount            money.Decimal     `json:"amount"`
+	Tax               money.Decimal     `json:"tax"`
+	Gross             money.Decimal     `json:"gross"`
+	Currency          money.Currency    `json:"currency,omitempty"`
+	EstimatedDistance float64           `json:"estimatedDistance,omitempty"`
+	Zones             []types.ZoneTotal `json:"zones,omitempty"`
+	Err               error             `json:"-"`
 }
 
 type AggregateResponse struct {
-	Err error `json:"err"`
+	Err error `json:"-"`
 }
 
+// Failed implements endpoint.Failer.
+func (r CalculateResponse) Failed() error { return r.Err }
+
+// Failed implements endpoint.Failer.
+func (r AggregateResponse) Failed() error { return r.Err }
+
 func (s Set) Aggregate(ctx context.Context, distance types.Distance) error {
-	_, err := s.AggregateEndpoint(ctx, aggregateRequest{
-		Values: distance.Values,
-		OBUID:  distance.OBUID,
-		Unix:   distance.Unix,
+	resp, err := s.AggregateEndpoint(ctx, AggregateRequest{
+		Values:    distance.Values,
+		OBUID:     distance.OBUID,
+		Unix:      distance.Unix,
+		ZoneID:    distance.ZoneID,
+		Estimated: distance.Estimated,
+		OffPeak:   distance.OffPeak,
+		Trip:      distance.Trip,
 	})
-
-	return err
+	if err != nil {
+		return err
+	}
+	return resp.(AggregateResponse).Err
 }
 
 func (s Set) Calculate(ctx context.Context, obuID int32) (*types.Invoice, error) {
-	resp, err := s.CalculateEndpoint(ctx, calculateRequest{OBUID: obuID})
+	resp, err := s.CalculateEndpoint(ctx, CalculateRequest{OBUID: obuID})
 	if err != nil {
 		return nil, err
 	}
 	result := resp.(CalculateResponse)
+	if result.Err != nil {
+		return nil, result.Err
+	}
 
 	return &types.Invoice{
-		OBUID:         result.OBUID,
-		TotalDistance: result.TotalDistance,
-		Amount:        result.Amount,
-	}, result.Err
+		OBUID:             result.OBUID,
+		TotalDistance:     result.TotalDistance,
+		Amount:            result.Amount,
+		Tax:               result.Tax,
+		Gross:             result.Gross,
+		Currency:          result.Currency,
+		EstimatedDistance: result.EstimatedDistance,
+		Zones:             result.Zones,
+	}, nil
 }
 
+// MakeAggregateEndpoint constructs an Aggregate endpoint wrapping the service.
 func MakeAggregateEndpoint(s aggservice.Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
-		req := request.(aggregateRequest)
-		err = s.Aggregate(ctx, types.Distance{
-			Values: req.Values,
-			OBUID:  req.OBUID,
-			Unix:   req.Unix,
-		})
+		req := request.(AggregateRequest)
+		err = s.Aggregate(ctx, req.distance())
 		return AggregateResponse{Err: err}, nil
 	}
 }
 
-// MakeConcatEndpoint constructs a Concat endpoint wrapping the service.
+// MakeCalculateEndpoint constructs a Calculate endpoint wrapping the service.
 func MakeCalculateEndpoint(s aggservice.Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
-		req := request.(calculateRequest)
+		req := request.(CalculateRequest)
 		v, err := s.Calculate(ctx, req.OBUID)
+		if err != nil {
+			return CalculateResponse{OBUID: req.OBUID, Err: err}, nil
+		}
 		return CalculateResponse{
-			OBUID:         v.OBUID,
-			TotalDistance: v.TotalDistance,
-			Amount:        v.Amount,
-			Err:           err,
+			OBUID:             v.OBUID,
+			TotalDistance:     v.TotalDistance,
+			Amount:            v.Amount,
+			Tax:               v.Tax,
+			Gross:             v.Gross,
+			Currency:          v.Currency,
+			EstimatedDistance: v.EstimatedDistance,
+			Zones:             v.Zones,
 		}, nil
 	}
 }
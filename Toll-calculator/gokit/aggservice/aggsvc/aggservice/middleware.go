@@ -2,48 +2,96 @@ package aggservice
 
 import (
 	"context"
+	"fmt"
+	"time"
 
 	"github.com/0x0Glitch/toll-calculator/types"
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/log"
 )
 
 type Middleware func(Service) Service
 
 type loggingMiddleware struct {
-	next Service
+	logger log.Logger
+	next   Service
 }
 
-func newLoggingMiddleware() Middleware {
+// LoggingMiddleware logs the method, OBU ID, latency and error of every call.
+func LoggingMiddleware(logger log.Logger) Middleware {
 	return func(next Service) Service {
 		return &loggingMiddleware{
-			next: next,
+			logger: logger,
+			next:   next,
 		}
 	}
 }
 
-func (lm *loggingMiddleware) Aggregate(_ context.Context, distance types.Distance) error {
-	return nil
+func (lm *loggingMiddleware) Aggregate(ctx context.Context, distance types.Distance) (err error) {
+	defer func(start time.Time) {
+		lm.logger.Log(
+			"method", "Aggregate",
+			"obuID", distance.OBUID,
+			"value", distance.Values,
+			"took", time.Since(start),
+			"err", err,
+		)
+	}(time.Now())
+	return lm.next.Aggregate(ctx, distance)
 }
 
-func (lm *loggingMiddleware) Calculate(_ context.Context, obuID int32) (*types.Invoice, error) {
-	return nil, nil
+func (lm *loggingMiddleware) Calculate(ctx context.Context, obuID int32) (inv *types.Invoice, err error) {
+	defer func(start time.Time) {
+		var amount float64
+		if inv != nil {
+			amount = inv.Amount.Float64()
+		}
+		lm.logger.Log(
+			"method", "Calculate",
+			"obuID", obuID,
+			"amount", amount,
+			"took", time.Since(start),
+			"err", err,
+		)
+	}(time.Now())
+	return lm.next.Calculate(ctx, obuID)
 }
 
 type instrumentationMiddleware struct {
-	next Service
+	requests metrics.Counter
+	latency  metrics.Histogram
+	next     Service
 }
 
-func newInstrumentationMiddleware() Middleware {
+// InstrumentationMiddleware counts calls and records their latency, both
+// labelled by method and whether the call failed. The OBU ID is left out on
+// purpose; one series per vehicle would overwhelm Prometheus.
+func InstrumentationMiddleware(requests metrics.Counter, latency metrics.Histogram) Middleware {
 	return func(next Service) Service {
 		return &instrumentationMiddleware{
-			next: next,
+			requests: requests,
+			latency:  latency,
+			next:     next,
 		}
 	}
 }
 
-func (lm *instrumentationMiddleware) Aggregate(_ context.Context, distance types.Distance) error {
-	return nil
+func (im *instrumentationMiddleware) Aggregate(ctx context.Context, distance types.Distance) (err error) {
+	defer func(start time.Time) {
+		im.observe("Aggregate", start, err)
+	}(time.Now())
+	return im.next.Aggregate(ctx, distance)
+}
+
+func (im *instrumentationMiddleware) Calculate(ctx context.Context, obuID int32) (inv *types.Invoice, err error) {
+	defer func(start time.Time) {
+		im.observe("Calculate", start, err)
+	}(time.Now())
+	return im.next.Calculate(ctx, obuID)
 }
 
-func (lm *instrumentationMiddleware) Calculate(_ context.Context, obuID int32) (*types.Invoice, error) {
-	return nil, nil
+func (im *instrumentationMiddleware) observe(method string, start time.Time, err error) {
+	lvs := []string{"method", method, "error", fmt.Sprint(err != nil)}
+	im.requests.With(lvs...).Add(1)
+	im.latency.With(lvs...).Observe(time.Since(start).Seconds())
 }
@@ -2,46 +2,77 @@ package aggservice
 
 import (
 	"context"
+	"maps"
+	"slices"
 
+	"github.com/0x0Glitch/toll-calculator/money"
 	"github.com/0x0Glitch/toll-calculator/types"
 )
 
+// basePrice and baseCurrency match the default tariff of the aggregator.
+const (
+	basePrice    = 315
+	baseCurrency = money.Currency("EUR")
+)
+
 type Service interface {
 	Aggregate(context.Context, types.Distance) error
 	Calculate(context.Context, int32) (*types.Invoice, error)
 }
 
-
 type BasicService struct {
 	store Storer
 }
 
-func newBasicService(store Storer) Service{
+// NewBasicService returns a service with no middlewares attached.
+func NewBasicService(store Storer) Service {
 	return &BasicService{
 		store: store,
 	}
 }
 
-func NewAggregatorService(store Storer) Service { 
+// NewAggregatorService returns the basic service wrapped in mws. The first
+// middleware is the innermost one.
+func NewAggregatorService(store Storer, mws ...Middleware) Service {
 	var svc Service
-	svc = newBasicService(store)
-	svc = newLoggingMiddleware()(svc)
-	svc = newInstrumentationMiddleware()(svc)
+	svc = NewBasicService(store)
+	for _, mw := range mws {
+		svc = mw(svc)
+	}
 	return svc
 }
 
+// Aggregate adds the distance to the vehicle's total in its zone. Its trip
+// and off-peak mark reach the middlewares, but the basic service keeps no
+// trips and grants no off-peak discount, having no contracts.
 func (b *BasicService) Aggregate(_ context.Context, distance types.Distance) error {
 	return b.store.Insert(&distance)
 }
 
+// Calculate prices the distance of every zone at the base price; there are
+// no zone tariffs or taxes, so the gross is the amount.
 func (b *BasicService) Calculate(_ context.Context, obuID int32) (*types.Invoice, error) {
-	distance, err := b.store.Get(obuID)
+	totals, err := b.store.Totals(obuID)
 	if err != nil {
 		return nil, err
 	}
-	return &types.Invoice{
-		OBUID:         obuID,
-		TotalDistance: distance,
-		Amount:        distance * 9,
-	}, nil
-}
\ No newline at end of file
+	inv := &types.Invoice{
+		OBUID:    obuID,
+		Currency: baseCurrency,
+	}
+	for _, zone := range slices.Sorted(maps.Keys(totals)) {
+		t := totals[zone]
+		amount := money.FromFloat(t.Distance).Mul(money.NewFromInt(basePrice)).Round(baseCurrency.MinorUnits(), money.HalfEven)
+		inv.Zones = append(inv.Zones, types.ZoneTotal{
+			ZoneID:    zone,
+			Distance:  t.Distance,
+			Amount:    amount,
+			Estimated: t.Estimated,
+		})
+		inv.TotalDistance += t.Distance
+		inv.EstimatedDistance += t.Estimated
+		inv.Amount = inv.Amount.Add(amount)
+	}
+	inv.Gross = inv.Amount
+	return inv, nil
+}
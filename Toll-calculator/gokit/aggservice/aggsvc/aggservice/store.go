@@ -1,35 +1,70 @@
 package aggservice
 
 import (
-	"fmt"
+	"context"
+	"maps"
+	"sync"
 
+	"github.com/0x0Glitch/toll-calculator/apperr"
 	"github.com/0x0Glitch/toll-calculator/types"
 )
 
+// Storer keeps the distance of every vehicle, totalled by toll zone.
 type Storer interface {
 	Insert(*types.Distance) error
-	Get(int32) (float64, error)
+	Totals(int32) (map[string]types.Total, error)
 }
 
 type MemoryStore struct {
-	data map[int32]float64
+	mu   sync.RWMutex
+	data map[int32]map[string]types.Total
 }
 
 func NewMemoryStore() *MemoryStore {
 	return &MemoryStore{
-		data: make(map[int32]float64),
+		data: make(map[int32]map[string]types.Total),
 	}
 }
 
+// Ping always succeeds, as the memory store can't become unreachable.
+func (m *MemoryStore) Ping(ctx context.Context) error {
+	return nil
+}
+
 func (m *MemoryStore) Insert(d *types.Distance) error {
-	m.data[d.OBUID] += d.Values
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	zones, ok := m.data[d.OBUID]
+	if !ok {
+		zones = make(map[string]types.Total)
+		m.data[d.OBUID] = zones
+	}
+	t := zones[d.ZoneID]
+	t.Add(d)
+	zones[d.ZoneID] = t
 	return nil
 }
 
+// Get returns the distance id travelled in every zone.
 func (m *MemoryStore) Get(id int32) (float64, error) {
-	dist, ok := m.data[id]
-	if !ok {
-		return 0.0, fmt.Errorf("couldn't find distance for id: %d", id)
+	totals, err := m.Totals(id)
+	if err != nil {
+		return 0.0, err
+	}
+	var dist float64
+	for _, t := range totals {
+		dist += t.Distance
 	}
 	return dist, nil
 }
+
+// Totals returns a copy of the totals of id by zone.
+func (m *MemoryStore) Totals(id int32) (map[string]types.Total, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	zones, ok := m.data[id]
+	if !ok {
+		return nil, apperr.NotFoundf("couldn't find distance for id: %d", id)
+	}
+	return maps.Clone(zones), nil
+}
@@ -0,0 +1,104 @@
+package aggtransport
+
+import (
+	"context"
+
+	"github.com/0x0Glitch/toll-calculator/apperr"
+	"github.com/0x0Glitch/toll-calculator/gokit/aggservice/aggsvc/aggendpoint"
+	"github.com/0x0Glitch/toll-calculator/types"
+	"github.com/go-kit/kit/transport"
+	grpctransport "github.com/go-kit/kit/transport/grpc"
+	"github.com/go-kit/log"
+)
+
+type grpcServer struct {
+	types.UnimplementedAggregatorServer
+	aggregate  grpctransport.Handler
+	getInvoice grpctransport.Handler
+}
+
+// NewGRPCServer makes the endpoints available as the Aggregator gRPC
+// service defined in types/ptypes.proto.
+func NewGRPCServer(endpoints aggendpoint.Set, logger log.Logger) types.AggregatorServer {
+	options := []grpctransport.ServerOption{
+		grpctransport.ServerErrorHandler(transport.NewLogErrorHandler(logger)),
+	}
+	return &grpcServer{
+		aggregate: grpctransport.NewServer(
+			endpoints.AggregateEndpoint,
+			decodeGRPCAggregateRequest,
+			encodeGRPCAggregateResponse,
+			options...,
+		),
+		getInvoice: grpctransport.NewServer(
+			endpoints.CalculateEndpoint,
+			decodeGRPCCalculateRequest,
+			encodeGRPCCalculateResponse,
+			options...,
+		),
+	}
+}
+
+func (s *grpcServer) Aggregate(ctx context.Context, req *types.AggregatorRequest) (*types.Empty, error) {
+	_, rep, err := s.aggregate.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, apperr.ToGRPC(err)
+	}
+	return rep.(*types.Empty), nil
+}
+
+func (s *grpcServer) GetInvoice(ctx context.Context, req *types.GetInvoiceRequest) (*types.InvoiceResponse, error) {
+	_, rep, err := s.getInvoice.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, apperr.ToGRPC(err)
+	}
+	return rep.(*types.InvoiceResponse), nil
+}
+
+func decodeGRPCAggregateRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*types.AggregatorRequest)
+	return aggendpoint.AggregateRequest{
+		Values:    req.Value,
+		OBUID:     req.ObuID,
+		Unix:      req.Unix,
+		ZoneID:    req.ZoneID,
+		Estimated: req.Estimated,
+		OffPeak:   req.OffPeak,
+		Trip:      req.Trip.Leg(),
+	}, nil
+}
+
+func decodeGRPCCalculateRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*types.GetInvoiceRequest)
+	return aggendpoint.CalculateRequest{OBUID: req.ObuID}, nil
+}
+
+// The protobuf messages have no error field, so failed responses are
+// returned as the gRPC call's error instead, carrying the apperr code.
+func encodeGRPCAggregateResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(aggendpoint.AggregateResponse)
+	if resp.Err != nil {
+		return nil, apperr.ToGRPC(resp.Err)
+	}
+	return &types.Empty{}, nil
+}
+
+func encodeGRPCCalculateResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(aggendpoint.CalculateResponse)
+	if resp.Err != nil {
+		return nil, apperr.ToGRPC(resp.Err)
+	}
+	rep := &types.InvoiceResponse{
+		ObuID:             resp.OBUID,
+		TotalDistance:     resp.TotalDistance,
+		Amount:            resp.Amount.String(),
+		Tax:               resp.Tax.String(),
+		Gross:             resp.Gross.String(),
+		Currency:          string(resp.Currency),
+		EstimatedDistance: resp.EstimatedDistance,
+	}
+	for _, z := range resp.Zones {
+		rep.Zones = append(rep.Zones, &types.ZoneCharge{ZoneID: z.ZoneID, Distance: z.Distance, Amount: z.Amount.String(), Estimated: z.Estimated})
+	}
+	return rep, nil
+}
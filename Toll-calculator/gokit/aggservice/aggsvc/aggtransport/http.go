@@ -0,0 +1,109 @@
+package aggtransport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/0x0Glitch/toll-calculator/apperr"
+	"github.com/0x0Glitch/toll-calculator/gokit/aggservice/aggsvc/aggendpoint"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/transport"
+	httptransport "github.com/go-kit/kit/transport/http"
+	"github.com/go-kit/log"
+)
+
+// NewHTTPHandler returns an HTTP handler that serves the same routes as
+// the aggregator, so existing HTTP clients can talk to either.
+func NewHTTPHandler(endpoints aggendpoint.Set, logger log.Logger) http.Handler {
+	options := []httptransport.ServerOption{
+		httptransport.ServerErrorEncoder(errorEncoder),
+		httptransport.ServerErrorHandler(transport.NewLogErrorHandler(logger)),
+	}
+	m := http.NewServeMux()
+	m.Handle("/aggregate", httptransport.NewServer(
+		endpoints.AggregateEndpoint,
+		decodeHTTPAggregateRequest,
+		encodeHTTPAggregateResponse,
+		options...,
+	))
+	m.Handle("/invoice", httptransport.NewServer(
+		endpoints.CalculateEndpoint,
+		decodeHTTPCalculateRequest,
+		encodeHTTPGenericResponse,
+		options...,
+	))
+	return m
+}
+
+// httpError carries a status code that has no apperr equivalent, such as
+// 405 for a wrong method.
+type httpError struct {
+	code int
+	err  error
+}
+
+func (e httpError) Error() string   { return e.err.Error() }
+func (e httpError) StatusCode() int { return e.code }
+func (e httpError) Unwrap() error   { return e.err }
+
+// errorEncoder answers with the status of the error's apperr code, unless
+// the error carries its own status code.
+func errorEncoder(_ context.Context, err error, w http.ResponseWriter) {
+	code := apperr.HTTPStatus(err)
+	var sc httptransport.StatusCoder
+	if errors.As(err, &sc) {
+		code = sc.StatusCode()
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(apperr.BodyOf(err))
+}
+
+func decodeHTTPAggregateRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	if r.Method != http.MethodPost {
+		return nil, httpError{http.StatusMethodNotAllowed, apperr.InvalidArgumentf("invalid HTTP method %v", r.Method)}
+	}
+	var req aggendpoint.AggregateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, apperr.InvalidArgumentf("failed to decode distance: %v", err)
+	}
+	return req, nil
+}
+
+func decodeHTTPCalculateRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	if r.Method != http.MethodGet {
+		return nil, httpError{http.StatusMethodNotAllowed, apperr.InvalidArgumentf("invalid HTTP method %v", r.Method)}
+	}
+	values, ok := r.URL.Query()["obu"]
+	if !ok {
+		return nil, apperr.InvalidArgumentf("missing OBU ID")
+	}
+	obuID, err := strconv.Atoi(values[0])
+	if err != nil {
+		return nil, apperr.InvalidArgumentf("invalid OBU ID %v", values[0])
+	}
+	return aggendpoint.CalculateRequest{OBUID: int32(obuID)}, nil
+}
+
+// encodeHTTPAggregateResponse answers like the aggregator does, with a
+// confirmation message instead of the empty response.
+func encodeHTTPAggregateResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	if f, ok := response.(endpoint.Failer); ok && f.Failed() != nil {
+		return encodeHTTPGenericResponse(ctx, w, response)
+	}
+	return encodeHTTPGenericResponse(ctx, w, map[string]string{"message": "distance aggregated successfully"})
+}
+
+// encodeHTTPGenericResponse writes the response as JSON, or the error of a
+// failed response through errorEncoder.
+func encodeHTTPGenericResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	if f, ok := response.(endpoint.Failer); ok && f.Failed() != nil {
+		errorEncoder(ctx, f.Failed(), w)
+		return nil
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	return json.NewEncoder(w).Encode(response)
+}
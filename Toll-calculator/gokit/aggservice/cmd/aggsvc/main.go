@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/0x0Glitch/toll-calculator/config"
+	"github.com/0x0Glitch/toll-calculator/gokit/aggservice/aggsvc/aggendpoint"
+	"github.com/0x0Glitch/toll-calculator/gokit/aggservice/aggsvc/aggservice"
+	"github.com/0x0Glitch/toll-calculator/gokit/aggservice/aggsvc/aggtransport"
+	"github.com/0x0Glitch/toll-calculator/health"
+	"github.com/0x0Glitch/toll-calculator/shutdown"
+	"github.com/0x0Glitch/toll-calculator/tracing"
+	"github.com/0x0Glitch/toll-calculator/types"
+	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func main() {
+	var cfg config.AggSvc
+	if err := config.Load(&cfg, os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	var logger log.Logger
+	{
+		logger = log.NewLogfmtLogger(os.Stderr)
+		logger = level.NewFilter(logger, allowLevel(cfg.Level()))
+		logger = log.With(logger, "ts", log.DefaultTimestampUTC)
+		logger = log.With(logger, "caller", log.DefaultCaller)
+	}
+	level.Info(logger).Log("config", config.Dump(&cfg))
+
+	ctx, stop := shutdown.Signals(context.Background())
+	defer stop()
+	tp, err := tracing.Init(context.Background(), "aggsvc", cfg.TracesExporter)
+	if err != nil {
+		logger.Log("during", "tracing", "err", err)
+		os.Exit(1)
+	}
+
+	var requests, latency = serviceMetrics()
+
+	limit := rate.Inf
+	if cfg.RateLimit > 0 {
+		limit = rate.Limit(cfg.RateLimit)
+	}
+
+	var (
+		store   = aggservice.NewMemoryStore()
+		service = aggservice.NewAggregatorService(store,
+			aggservice.LoggingMiddleware(logger),
+			aggservice.InstrumentationMiddleware(requests, latency),
+		)
+		endpoints   = aggendpoint.New(service, logger, limit)
+		httpHandler = aggtransport.NewHTTPHandler(endpoints, logger)
+		grpcServer  = aggtransport.NewGRPCServer(endpoints, logger)
+	)
+
+	mux := http.NewServeMux()
+	mux.Handle("/", tracing.HTTPHandler(httpHandler, "aggsvc"))
+	mux.Handle("/metrics", promhttp.Handler())
+	checker := health.NewChecker()
+	checker.AddPinger("store", store)
+	checker.Register(mux)
+
+	ln, err := net.Listen("tcp", cfg.GRPCAddr)
+	if err != nil {
+		logger.Log("during", "listen", "err", err)
+		os.Exit(1)
+	}
+	server := grpc.NewServer(tracing.GRPCServerOption())
+	types.RegisterAggregatorServer(server, grpcServer)
+	hs := grpchealth.NewServer()
+	healthpb.RegisterHealthServer(server, hs)
+	go func() {
+		checker.ServeGRPC(ctx, hs, 5*time.Second, types.Aggregator_ServiceDesc.ServiceName)
+		hs.Shutdown()
+	}()
+	httpServer := &http.Server{Addr: cfg.HTTPAddr, Handler: mux}
+
+	errs := make(chan error, 2)
+	go func() {
+		logger.Log("transport", "gRPC", "addr", cfg.GRPCAddr)
+		errs <- server.Serve(ln)
+	}()
+	go func() {
+		logger.Log("transport", "HTTP", "addr", cfg.HTTPAddr)
+		errs <- httpServer.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		logger.Log("exit", fmt.Sprint(err))
+		os.Exit(1)
+	case <-ctx.Done():
+	}
+	logger.Log("exit", "shutting down")
+	err = shutdown.Run(cfg.ShutdownTimeout,
+		shutdown.HTTPServer("http", httpServer),
+		shutdown.GRPCServer("grpc", server),
+		shutdown.Hook{Name: "tracing", Fn: tp.Shutdown},
+	)
+	if err != nil {
+		logger.Log("during", "shutdown", "err", err)
+		os.Exit(1)
+	}
+}
+
+// allowLevel maps the configured log level onto the go-kit level filter.
+func allowLevel(l logrus.Level) level.Option {
+	switch {
+	case l >= logrus.DebugLevel:
+		return level.AllowDebug()
+	case l == logrus.InfoLevel:
+		return level.AllowInfo()
+	case l == logrus.WarnLevel:
+		return level.AllowWarn()
+	}
+	return level.AllowError()
+}
+
+// serviceMetrics registers the Prometheus series the instrumentation
+// middleware reports to.
+func serviceMetrics() (*kitprometheus.Counter, *kitprometheus.Histogram) {
+	fieldKeys := []string{"method", "error"}
+	requests := kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Namespace: "toll",
+		Subsystem: "aggsvc",
+		Name:      "requests_total",
+		Help:      "Number of requests received.",
+	}, fieldKeys)
+	latency := kitprometheus.NewHistogramFrom(stdprometheus.HistogramOpts{
+		Namespace: "toll",
+		Subsystem: "aggsvc",
+		Name:      "request_duration_seconds",
+		Help:      "Request duration in seconds.",
+		Buckets:   stdprometheus.DefBuckets,
+	}, fieldKeys)
+	return requests, latency
+}
@@ -0,0 +1,240 @@
+// Package gpsfilter rejects implausible GPS fixes and smooths the rest
+// before distance is measured between them, so a single fix that jumps
+// kilometres away isn't billed.
+package gpsfilter
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/0x0Glitch/toll-calculator/geo"
+	"github.com/0x0Glitch/toll-calculator/types"
+)
+
+var (
+	ErrPoorHDOP     = errors.New("gpsfilter: horizontal dilution of precision above the limit")
+	ErrPoorAccuracy = errors.New("gpsfilter: accuracy radius above the limit")
+	ErrOutOfOrder   = errors.New("gpsfilter: fix not newer than the previous one")
+	ErrTooFast      = errors.New("gpsfilter: implausible speed since the previous fix")
+	ErrStationary   = errors.New("gpsfilter: vehicle stationary")
+)
+
+// Reason returns a short label for a rejection returned by Accept, suitable
+// for a metric, or "" if err isn't a rejection.
+func Reason(err error) string {
+	switch {
+	case errors.Is(err, ErrPoorHDOP):
+		return "hdop"
+	case errors.Is(err, ErrPoorAccuracy):
+		return "accuracy"
+	case errors.Is(err, ErrOutOfOrder):
+		return "out_of_order"
+	case errors.Is(err, ErrTooFast):
+		return "speed"
+	case errors.Is(err, ErrStationary):
+		return "stationary"
+	}
+	return ""
+}
+
+// Options choose the checks of a filter. A zero limit disables its check.
+type Options struct {
+	// MaxSpeed is the fastest plausible speed in metres per second.
+	MaxSpeed float64
+	// MaxHDOP is the worst horizontal dilution of precision accepted.
+	MaxHDOP float64
+	// MaxAccuracy is the largest accuracy radius in metres accepted.
+	MaxAccuracy float64
+	// Jitter is how far in metres a vehicle must move from where it was
+	// last placed before it is taken to be moving.
+	Jitter float64
+	// Noise is how sharply in metres per second squared vehicles are
+	// expected to change speed. It enables the Kalman filter.
+	Noise float64
+}
+
+const (
+	// sigma is the GPS error in metres assumed for fixes that don't report
+	// their accuracy.
+	sigma = 10.0
+	// uere is the error in metres of a single satellite range, which HDOP
+	// scales into the error of a fix.
+	uere = 5.0
+	// restartAfter is how many fixes in a row may be too fast before the
+	// filter concludes the fix it compares them against was the bad one
+	// and starts over.
+	restartAfter = 3
+)
+
+// Filter keeps the state of every vehicle between its fixes.
+type Filter struct {
+	opts Options
+
+	mu     sync.Mutex
+	tracks map[int32]*track
+}
+
+// track is what the filter knows of one vehicle.
+type track struct {
+	// unix is the time of the last accepted fix in milliseconds, 0 if it
+	// carried none.
+	unix int64
+	// last is the last accepted fix and placed where the vehicle was last
+	// placed.
+	last, placed geo.Point
+	kalman       *kalman
+	// tooFast counts the fixes in a row rejected for their speed.
+	tooFast int
+}
+
+func New(opts Options) *Filter {
+	return &Filter{opts: opts, tracks: make(map[int32]*track)}
+}
+
+// Accept returns where to place the vehicle given its fix, or the reason
+// the fix is rejected. The speed check and the Kalman filter need the
+// fix's timestamp and skip fixes without one.
+func (f *Filter) Accept(d types.OBUData) (geo.Point, error) {
+	fix := geo.Point{Lat: d.Lat, Long: d.Long}
+	if f.opts.MaxHDOP > 0 && d.HDOP > f.opts.MaxHDOP {
+		return geo.Point{}, fmt.Errorf("%w: %.1f > %.1f", ErrPoorHDOP, d.HDOP, f.opts.MaxHDOP)
+	}
+	if f.opts.MaxAccuracy > 0 && d.Accuracy > f.opts.MaxAccuracy {
+		return geo.Point{}, fmt.Errorf("%w: %.0fm > %.0fm", ErrPoorAccuracy, d.Accuracy, f.opts.MaxAccuracy)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t, ok := f.tracks[d.OBUID]
+	if !ok {
+		t = &track{placed: fix}
+		f.tracks[d.OBUID] = t
+		f.start(t, d, fix)
+		return fix, nil
+	}
+
+	timed := d.Unix != 0 && t.unix != 0
+	dt := time.Duration(d.Unix-t.unix) * time.Millisecond
+	if timed && dt <= 0 {
+		return geo.Point{}, fmt.Errorf("%w: %s after it", ErrOutOfOrder, -dt)
+	}
+	if timed && f.opts.MaxSpeed > 0 {
+		if speed := geo.Meters(t.last, fix) / dt.Seconds(); speed > f.opts.MaxSpeed {
+			if t.tooFast++; t.tooFast < restartAfter {
+				return geo.Point{}, fmt.Errorf("%w: %.0f m/s", ErrTooFast, speed)
+			}
+			// The fixes agree with each other but not with the one before
+			// them, which is the one to drop.
+			f.start(t, d, fix)
+			t.placed = fix
+			return fix, nil
+		}
+	}
+	t.tooFast = 0
+
+	estimate := fix
+	if t.kalman != nil && timed {
+		estimate = t.kalman.update(dt.Seconds(), fix, errorOf(d))
+	} else {
+		f.start(t, d, fix)
+	}
+	t.unix, t.last = d.Unix, fix
+
+	if f.opts.Jitter > 0 && geo.Meters(t.placed, estimate) < f.opts.Jitter {
+		return geo.Point{}, ErrStationary
+	}
+	t.placed = estimate
+	return estimate, nil
+}
+
+// Forget drops the state kept for the vehicle.
+func (f *Filter) Forget(obuID int32) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.tracks, obuID)
+}
+
+// start restarts the track from the fix.
+func (f *Filter) start(t *track, d types.OBUData, fix geo.Point) {
+	t.unix, t.last, t.tooFast, t.kalman = d.Unix, fix, 0, nil
+	if f.opts.Noise > 0 {
+		t.kalman = newKalman(fix, errorOf(d), f.opts.Noise)
+	}
+}
+
+// errorOf returns the standard deviation in metres of the fix's error.
+func errorOf(d types.OBUData) float64 {
+	switch {
+	case d.Accuracy > 0:
+		return d.Accuracy
+	case d.HDOP > 0:
+		return d.HDOP * uere
+	}
+	return sigma
+}
+
+// kalman tracks position and velocity along the two axes of a plane
+// tangent to the earth at the first fix, with constant velocity between
+// fixes disturbed by random acceleration.
+type kalman struct {
+	origin geo.Point
+	// scale converts degrees of longitude to metres at the origin.
+	scale float64
+	noise float64
+	x, y  axis
+}
+
+// axis is the estimate along one axis: position and velocity, and their
+// covariance.
+type axis struct {
+	pos, vel      float64
+	ppp, ppv, pvv float64
+}
+
+func newKalman(fix geo.Point, err, noise float64) *kalman {
+	k := &kalman{
+		origin: fix,
+		scale:  geo.MetersPerDegree * math.Cos(fix.Lat*math.Pi/180),
+		noise:  noise,
+	}
+	// Nothing is known of the velocity yet, so it starts out as uncertain
+	// as any plausible speed.
+	k.x = axis{ppp: err * err, pvv: 100 * 100}
+	k.y = k.x
+	return k
+}
+
+// update moves the estimate dt seconds on and corrects it by a fix with a
+// standard deviation of err metres.
+func (k *kalman) update(dt float64, fix geo.Point, err float64) geo.Point {
+	q := k.noise * k.noise
+	r := err * err
+	k.x.step(dt, q, (fix.Long-k.origin.Long)*k.scale, r)
+	k.y.step(dt, q, (fix.Lat-k.origin.Lat)*geo.MetersPerDegree, r)
+	return geo.Point{
+		Lat:  k.origin.Lat + k.y.pos/geo.MetersPerDegree,
+		Long: k.origin.Long + k.x.pos/k.scale,
+	}
+}
+
+func (a *axis) step(dt, q, z, r float64) {
+	// Predict.
+	a.pos += a.vel * dt
+	dt2 := dt * dt
+	ppp := a.ppp + 2*dt*a.ppv + dt2*a.pvv + q*dt2*dt2/4
+	ppv := a.ppv + dt*a.pvv + q*dt2*dt/2
+	pvv := a.pvv + q*dt2
+
+	// Correct.
+	s := ppp + r
+	kp, kv := ppp/s, ppv/s
+	innovation := z - a.pos
+	a.pos += kp * innovation
+	a.vel += kv * innovation
+	a.ppp = (1 - kp) * ppp
+	a.ppv = (1 - kp) * ppv
+	a.pvv = pvv - kv*ppv
+}
@@ -0,0 +1,182 @@
+// Package health serves the liveness and readiness endpoints of every
+// service. Liveness only says the process is up; readiness runs the checks
+// of the service's dependencies, such as Kafka or the aggregator.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// DefaultTimeout bounds how long a single readiness check may take.
+const DefaultTimeout = 2 * time.Second
+
+// Check reports whether a dependency is usable.
+type Check func(ctx context.Context) error
+
+// Pinger is implemented by dependencies that can check themselves.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// Checker runs the named readiness checks of a service.
+type Checker struct {
+	timeout time.Duration
+
+	mu     sync.RWMutex
+	names  []string
+	checks map[string]Check
+}
+
+func NewChecker() *Checker {
+	return &Checker{
+		timeout: DefaultTimeout,
+		checks:  make(map[string]Check),
+	}
+}
+
+// Add registers a readiness check under name, replacing any earlier one.
+func (c *Checker) Add(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.checks[name]; !ok {
+		c.names = append(c.names, name)
+		sort.Strings(c.names)
+	}
+	c.checks[name] = check
+}
+
+// AddPinger registers p.Ping as a readiness check under name.
+func (c *Checker) AddPinger(name string, p Pinger) {
+	c.Add(name, p.Ping)
+}
+
+// Run runs every check concurrently and returns the failures by name. An
+// empty result means the service is ready.
+func (c *Checker) Run(ctx context.Context) map[string]error {
+	c.mu.RLock()
+	checks := make(map[string]Check, len(c.checks))
+	for name, check := range c.checks {
+		checks[name] = check
+	}
+	c.mu.RUnlock()
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		failures = make(map[string]error)
+	)
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check Check) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, c.timeout)
+			defer cancel()
+			if err := check(ctx); err != nil {
+				mu.Lock()
+				failures[name] = err
+				mu.Unlock()
+			}
+		}(name, check)
+	}
+	wg.Wait()
+	return failures
+}
+
+// Ready reports whether every check passes.
+func (c *Checker) Ready(ctx context.Context) bool {
+	return len(c.Run(ctx)) == 0
+}
+
+// WaitReady blocks until every check passes or ctx is done, retrying every
+// interval. Services use it to order their startup on their dependencies.
+func (c *Checker) WaitReady(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if c.Ready(ctx) {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Status is the body of the health endpoints.
+type Status struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// LivenessHandler answers 200 as long as the process can serve HTTP.
+func LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeStatus(w, http.StatusOK, Status{Status: "ok"})
+	})
+}
+
+// ReadinessHandler answers 200 when every check passes and 503 otherwise,
+// listing the result of each check.
+func (c *Checker) ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		failures := c.Run(r.Context())
+		c.mu.RLock()
+		status := Status{Status: "ready", Checks: make(map[string]string, len(c.names))}
+		for _, name := range c.names {
+			status.Checks[name] = "ok"
+		}
+		c.mu.RUnlock()
+		code := http.StatusOK
+		for name, err := range failures {
+			status.Checks[name] = err.Error()
+			status.Status = "not ready"
+			code = http.StatusServiceUnavailable
+		}
+		writeStatus(w, code, status)
+	})
+}
+
+// Register mounts /healthz and /readyz on mux.
+func (c *Checker) Register(mux *http.ServeMux) {
+	mux.Handle("/healthz", LivenessHandler())
+	mux.Handle("/readyz", c.ReadinessHandler())
+}
+
+// ServeGRPC keeps the serving status of the given services on the gRPC
+// health server in line with the checks until ctx is done. The overall
+// server status, the empty service name, is always updated.
+func (c *Checker) ServeGRPC(ctx context.Context, hs *health.Server, interval time.Duration, services ...string) {
+	services = append([]string{""}, services...)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		status := healthpb.HealthCheckResponse_SERVING
+		if !c.Ready(ctx) {
+			status = healthpb.HealthCheckResponse_NOT_SERVING
+		}
+		for _, svc := range services {
+			hs.SetServingStatus(svc, status)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func writeStatus(w http.ResponseWriter, code int, status Status) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,36 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+// MetadataGetter is implemented by both Kafka producers and consumers.
+type MetadataGetter interface {
+	GetMetadata(topic *string, allTopics bool, timeoutMs int) (*kafka.Metadata, error)
+}
+
+// KafkaCheck returns a check that asks the brokers for the metadata of
+// topic, which fails when no broker can be reached in time.
+func KafkaCheck(client MetadataGetter, topic string) Check {
+	return func(ctx context.Context) error {
+		timeout := DefaultTimeout
+		if deadline, ok := ctx.Deadline(); ok {
+			timeout = time.Until(deadline)
+		}
+		md, err := client.GetMetadata(&topic, false, int(timeout.Milliseconds()))
+		if err != nil {
+			return err
+		}
+		if len(md.Brokers) == 0 {
+			return fmt.Errorf("no Kafka brokers available")
+		}
+		if t, ok := md.Topics[topic]; ok && t.Error.Code() != kafka.ErrNoError {
+			return fmt.Errorf("topic %s: %w", topic, t.Error)
+		}
+		return nil
+	}
+}
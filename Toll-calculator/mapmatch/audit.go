@@ -0,0 +1,170 @@
+package mapmatch
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// AuditRecord is which roads a leg of a vehicle was matched to. It holds no
+// coordinates, but the roads of a vehicle's legs in order are still where
+// it drove, so the log is erased and purged like the other location data.
+type AuditRecord struct {
+	OBUID int32  `json:"obuID"`
+	Seq   uint64 `json:"seq,omitempty"`
+	// Unix is when the leg ended in milliseconds, the time of the fix or
+	// else when it was recorded. Retention purges records by it.
+	Unix int64 `json:"unix"`
+	// Matched is false when the leg was measured in a straight line.
+	Matched bool `json:"matched"`
+	// Estimated is set when the leg spans a gap in the fixes.
+	Estimated bool     `json:"estimated,omitempty"`
+	Roads     []string `json:"roads,omitempty"`
+	Distance  float64  `json:"distance"`
+}
+
+// AuditLog appends audit records to a file as JSON lines. A nil AuditLog
+// logs them instead.
+type AuditLog struct {
+	path string
+
+	mu  sync.Mutex
+	f   *os.File
+	enc *json.Encoder
+}
+
+// OpenAuditLog opens the audit log at path for appending, creating it if
+// needed.
+func OpenAuditLog(path string) (*AuditLog, error) {
+	l := &AuditLog{path: path}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *AuditLog) open() error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	l.f, l.enc = f, json.NewEncoder(f)
+	return nil
+}
+
+func (l *AuditLog) Record(r AuditRecord) error {
+	if l == nil {
+		logrus.WithFields(logrus.Fields{
+			"obuID":     r.OBUID,
+			"matched":   r.Matched,
+			"estimated": r.Estimated,
+			"roads":     r.Roads,
+			"distance":  r.Distance,
+		}).Debug("matched leg")
+		return nil
+	}
+	if r.Unix == 0 {
+		r.Unix = time.Now().UnixMilli()
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.enc.Encode(r)
+}
+
+// Erase drops the records of id.
+func (l *AuditLog) Erase(ctx context.Context, id int32) error {
+	if l == nil {
+		return nil
+	}
+	_, err := l.rewrite(func(r AuditRecord) bool { return r.OBUID != id })
+	return err
+}
+
+// Purge drops the records of legs that ended before and returns how many
+// vehicles it dropped records of.
+func (l *AuditLog) Purge(ctx context.Context, before time.Time) (int, error) {
+	if l == nil {
+		return 0, nil
+	}
+	cutoff := before.UnixMilli()
+	return l.rewrite(func(r AuditRecord) bool { return r.Unix >= cutoff })
+}
+
+// rewrite replaces the log with the records keep keeps and returns how many
+// vehicles it dropped records of. The log is replaced in one rename, so a
+// failure leaves it as it was.
+func (l *AuditLog) rewrite(keep func(AuditRecord) bool) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	in, err := os.Open(l.path)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+	out, err := os.CreateTemp(filepath.Dir(l.path), filepath.Base(l.path)+".*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(out.Name())
+	defer out.Close()
+
+	dropped := make(map[int32]bool)
+	r, w := bufio.NewReader(in), bufio.NewWriter(out)
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(line) > 0 {
+			var rec AuditRecord
+			if jsonErr := json.Unmarshal(line, &rec); jsonErr != nil {
+				return 0, jsonErr
+			}
+			if !keep(rec) {
+				dropped[rec.OBUID] = true
+			} else if _, err := w.Write(line); err != nil {
+				return 0, err
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	if len(dropped) == 0 {
+		return 0, nil
+	}
+	if err := w.Flush(); err != nil {
+		return 0, err
+	}
+	if err := out.Sync(); err != nil {
+		return 0, err
+	}
+	if err := out.Close(); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(out.Name(), l.path); err != nil {
+		return 0, err
+	}
+	// Records are appended to the new file from now on.
+	if err := l.f.Close(); err != nil {
+		logrus.WithError(err).Warn("closing the replaced match audit log")
+	}
+	return len(dropped), l.open()
+}
+
+func (l *AuditLog) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.f.Close()
+}
@@ -0,0 +1,294 @@
+// Package mapmatch snaps GPS fixes to a road network with a hidden Markov
+// model, so distance is measured along the roads a vehicle actually drove
+// rather than in straight lines between noisy fixes.
+package mapmatch
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/0x0Glitch/toll-calculator/geo"
+)
+
+// Graph is a road network. Every road is broken into straight segments
+// between its vertices, and roads sharing a vertex connect there.
+type Graph struct {
+	nodes []geo.Point
+	edges []edge
+	// adj lists the edges touching each node.
+	adj  [][]int
+	tree *geo.RTree
+}
+
+// edge is a straight segment of a road. Roads are driven both ways.
+type edge struct {
+	from, to int
+	road     string
+	length   float64
+}
+
+func (e edge) other(node int) int {
+	if node == e.from {
+		return e.to
+	}
+	return e.from
+}
+
+// Size returns the number of vertices and of segments in the graph.
+func (g *Graph) Size() (nodes, edges int) {
+	return len(g.nodes), len(g.edges)
+}
+
+// builder joins the roads of a network into a graph, merging vertices with
+// the same key.
+type builder struct {
+	g     *Graph
+	nodes map[any]int
+}
+
+func newBuilder() *builder {
+	return &builder{g: &Graph{}, nodes: make(map[any]int)}
+}
+
+func (b *builder) node(key any, p geo.Point) int {
+	if i, ok := b.nodes[key]; ok {
+		return i
+	}
+	i := len(b.g.nodes)
+	b.g.nodes = append(b.g.nodes, p)
+	b.g.adj = append(b.g.adj, nil)
+	b.nodes[key] = i
+	return i
+}
+
+func (b *builder) road(id string, nodes []int) {
+	for i := 1; i < len(nodes); i++ {
+		from, to := nodes[i-1], nodes[i]
+		if from == to {
+			continue
+		}
+		e := edge{from: from, to: to, road: id, length: geo.Meters(b.g.nodes[from], b.g.nodes[to])}
+		b.g.adj[from] = append(b.g.adj[from], len(b.g.edges))
+		b.g.adj[to] = append(b.g.adj[to], len(b.g.edges))
+		b.g.edges = append(b.g.edges, e)
+	}
+}
+
+func (b *builder) build() (*Graph, error) {
+	if len(b.g.edges) == 0 {
+		return nil, fmt.Errorf("mapmatch: the road network has no roads")
+	}
+	boxes := make([]geo.Rect, len(b.g.edges))
+	for i, e := range b.g.edges {
+		boxes[i] = geo.BoundsOf(b.g.nodes[e.from], b.g.nodes[e.to])
+	}
+	b.g.tree = geo.NewRTree(boxes)
+	return b.g, nil
+}
+
+// LoadGraph reads a road network from an OSM XML extract, ending in .osm,
+// or from GeoJSON otherwise.
+func LoadGraph(path string) (*Graph, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("mapmatch: %w", err)
+	}
+	defer f.Close()
+	var g *Graph
+	if strings.EqualFold(filepath.Ext(path), ".osm") {
+		g, err = ParseOSM(f)
+	} else {
+		var b []byte
+		if b, err = io.ReadAll(f); err == nil {
+			g, err = ParseGeoJSON(b)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w in %s", err, path)
+	}
+	return g, nil
+}
+
+// ParseGeoJSON reads the LineString and MultiLineString features of a
+// FeatureCollection as roads, named by their roadID property or their id.
+// Roads connect where they share a vertex. Other features are ignored.
+func ParseGeoJSON(b []byte) (*Graph, error) {
+	var fc struct {
+		Features []struct {
+			ID       any `json:"id"`
+			Geometry *struct {
+				Type        string          `json:"type"`
+				Coordinates json.RawMessage `json:"coordinates"`
+			} `json:"geometry"`
+			Properties struct {
+				RoadID string `json:"roadID"`
+			} `json:"properties"`
+		} `json:"features"`
+	}
+	if err := json.Unmarshal(b, &fc); err != nil {
+		return nil, fmt.Errorf("mapmatch: %w", err)
+	}
+	bld := newBuilder()
+	for i, f := range fc.Features {
+		if f.Geometry == nil {
+			continue
+		}
+		var lines [][][]float64
+		switch f.Geometry.Type {
+		case "LineString":
+			var line [][]float64
+			if err := json.Unmarshal(f.Geometry.Coordinates, &line); err != nil {
+				return nil, fmt.Errorf("mapmatch: feature %d: %w", i, err)
+			}
+			lines = [][][]float64{line}
+		case "MultiLineString":
+			if err := json.Unmarshal(f.Geometry.Coordinates, &lines); err != nil {
+				return nil, fmt.Errorf("mapmatch: feature %d: %w", i, err)
+			}
+		default:
+			continue
+		}
+		id := f.Properties.RoadID
+		if id == "" {
+			switch v := f.ID.(type) {
+			case string:
+				id = v
+			case float64:
+				id = strconv.FormatFloat(v, 'f', -1, 64)
+			}
+		}
+		if id == "" {
+			return nil, fmt.Errorf("mapmatch: feature %d: no roadID property or id", i)
+		}
+		for _, line := range lines {
+			var nodes []int
+			for _, c := range line {
+				if len(c) < 2 {
+					return nil, fmt.Errorf("mapmatch: feature %d: position with fewer than 2 coordinates", i)
+				}
+				p := geo.Point{Lat: c[1], Long: c[0]}
+				nodes = append(nodes, bld.node(p, p))
+			}
+			bld.road(id, nodes)
+		}
+	}
+	return bld.build()
+}
+
+// ParseOSM reads the ways tagged as highways from an OSM XML extract. Roads
+// are named way/<id> and connect at shared nodes. The extract is read as a
+// stream, but the coordinates of every node are kept until the ways are
+// read.
+func ParseOSM(r io.Reader) (*Graph, error) {
+	type osmNode struct {
+		ID  int64   `xml:"id,attr"`
+		Lat float64 `xml:"lat,attr"`
+		Lon float64 `xml:"lon,attr"`
+	}
+	type osmWay struct {
+		ID  int64 `xml:"id,attr"`
+		Nds []struct {
+			Ref int64 `xml:"ref,attr"`
+		} `xml:"nd"`
+		Tags []struct {
+			K string `xml:"k,attr"`
+		} `xml:"tag"`
+	}
+	points := make(map[int64]geo.Point)
+	bld := newBuilder()
+	dec := xml.NewDecoder(r)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("mapmatch: %w", err)
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch start.Name.Local {
+		case "node":
+			var n osmNode
+			if err := dec.DecodeElement(&n, &start); err != nil {
+				return nil, fmt.Errorf("mapmatch: %w", err)
+			}
+			points[n.ID] = geo.Point{Lat: n.Lat, Long: n.Lon}
+		case "way":
+			var w osmWay
+			if err := dec.DecodeElement(&w, &start); err != nil {
+				return nil, fmt.Errorf("mapmatch: %w", err)
+			}
+			highway := false
+			for _, t := range w.Tags {
+				highway = highway || t.K == "highway"
+			}
+			if !highway {
+				continue
+			}
+			var nodes []int
+			for _, nd := range w.Nds {
+				p, ok := points[nd.Ref]
+				if !ok {
+					return nil, fmt.Errorf("mapmatch: way %d refers to missing node %d", w.ID, nd.Ref)
+				}
+				nodes = append(nodes, bld.node(nd.Ref, p))
+			}
+			bld.road("way/"+strconv.FormatInt(w.ID, 10), nodes)
+		}
+	}
+	return bld.build()
+}
+
+// candidate is a position on the road network a fix may have been taken
+// at: its projection onto a nearby segment.
+type candidate struct {
+	edge int
+	// offset is the distance in metres from the segment's from node.
+	offset float64
+	point  geo.Point
+	// dist is the distance in metres from the fix.
+	dist float64
+}
+
+// candidates returns the projections of p onto the segments within radius
+// metres, nearest first, at most max of them.
+func (g *Graph) candidates(p geo.Point, radius float64, max int) []candidate {
+	var out []candidate
+	g.tree.Search(geo.Around(p, radius), func(i int) {
+		e := g.edges[i]
+		a, b := g.nodes[e.from], g.nodes[e.to]
+		t := project(p, a, b)
+		c := candidate{edge: i, offset: t * e.length, point: geo.Lerp(a, b, t)}
+		if c.dist = geo.Meters(p, c.point); c.dist <= radius {
+			out = append(out, c)
+		}
+	})
+	sortCandidates(out)
+	if len(out) > max {
+		out = out[:max]
+	}
+	return out
+}
+
+// project returns the fraction along the segment from a to b of the point
+// nearest p.
+func project(p, a, b geo.Point) float64 {
+	kx := math.Cos(p.Lat * math.Pi / 180)
+	dx, dy := (b.Long-a.Long)*kx, b.Lat-a.Lat
+	l2 := dx*dx + dy*dy
+	if l2 == 0 {
+		return 0
+	}
+	t := ((p.Long-a.Long)*kx*dx + (p.Lat-a.Lat)*dy) / l2
+	return math.Max(0, math.Min(1, t))
+}
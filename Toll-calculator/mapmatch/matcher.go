@@ -0,0 +1,204 @@
+package mapmatch
+
+import (
+	"math"
+	"sync"
+
+	"github.com/0x0Glitch/toll-calculator/geo"
+)
+
+// Options tune the matcher. Zero values take the defaults.
+type Options struct {
+	// Radius is how far in metres from a fix roads are considered.
+	Radius float64
+	// Sigma is the standard deviation in metres of the GPS error.
+	Sigma float64
+	// Beta scales in metres how much longer than the straight line between
+	// two fixes the route between them may plausibly be.
+	Beta float64
+	// MaxCandidates bounds the road positions considered per fix.
+	MaxCandidates int
+}
+
+func (o Options) withDefaults() Options {
+	if o.Radius <= 0 {
+		o.Radius = 50
+	}
+	if o.Sigma <= 0 {
+		o.Sigma = 10
+	}
+	if o.Beta <= 0 {
+		o.Beta = 5
+	}
+	if o.MaxCandidates <= 0 {
+		o.MaxCandidates = 8
+	}
+	return o
+}
+
+// Match is where a fix was matched to and how the vehicle got there.
+type Match struct {
+	// Path runs along the roads from the previous matched position to this
+	// one.
+	Path []geo.Point
+	// Roads are the IDs of the roads the path runs on, in order.
+	Roads []string
+}
+
+// Matcher matches the fixes of many vehicles, each as it arrives. It is the
+// online form of the Newson and Krumm matcher: the positions on the roads
+// near a fix are the hidden states, the distance from the fix gives their
+// emission probability, and how far the route between two positions differs
+// from the straight line between the fixes gives the transition
+// probability. Every fix is decided without waiting for the next one, so a
+// leg runs from the position the previous fix was matched to.
+type Matcher struct {
+	graph *Graph
+	opts  Options
+
+	mu     sync.Mutex
+	tracks map[int32]*track
+}
+
+// track is the matcher's state for one vehicle.
+type track struct {
+	fix    geo.Point
+	cands  []candidate
+	scores []float64
+	// chosen is the candidate the last fix was matched to.
+	chosen int
+}
+
+func NewMatcher(g *Graph, opts Options) *Matcher {
+	return &Matcher{graph: g, opts: opts.withDefaults(), tracks: make(map[int32]*track)}
+}
+
+// Match matches the vehicle's next fix. It returns false if there is no leg
+// along the roads: for the first fix, for fixes too far from any road, and
+// when no plausible route links the fix to the previous one. The matcher
+// then starts over from this fix.
+func (m *Matcher) Match(obuID int32, fix geo.Point) (Match, bool) {
+	cands := m.graph.candidates(fix, m.opts.Radius, m.opts.MaxCandidates)
+	m.mu.Lock()
+	prev := m.tracks[obuID]
+	m.mu.Unlock()
+
+	next := &track{fix: fix, cands: cands, scores: make([]float64, len(cands))}
+	if len(cands) == 0 {
+		m.store(obuID, nil)
+		return Match{}, false
+	}
+	if prev == nil {
+		for j, c := range cands {
+			next.scores[j] = m.emission(c)
+		}
+		next.chosen = argmax(next.scores)
+		m.store(obuID, next)
+		return Match{}, false
+	}
+
+	// Routes much longer than the straight line are implausible, so the
+	// search stops there.
+	straight := geo.Meters(prev.fix, fix)
+	limit := 2*straight + 2*m.opts.Radius + 10*m.opts.Beta
+	froms := make([]*routes, len(prev.cands))
+	best := make([]int, len(cands))
+	for i, c := range prev.cands {
+		froms[i] = m.graph.shortestRoutes(c, limit)
+	}
+	for j, c := range cands {
+		next.scores[j], best[j] = math.Inf(-1), -1
+		for i := range prev.cands {
+			d := froms[i].to(c)
+			if math.IsInf(d, 1) || d > limit {
+				continue
+			}
+			score := prev.scores[i] - math.Abs(d-straight)/m.opts.Beta
+			if score > next.scores[j] {
+				next.scores[j], best[j] = score, i
+			}
+		}
+		next.scores[j] += m.emission(c)
+	}
+	next.chosen = argmax(next.scores)
+	if math.IsInf(next.scores[next.chosen], -1) {
+		for j, c := range cands {
+			next.scores[j] = m.emission(c)
+		}
+		next.chosen = argmax(next.scores)
+		m.store(obuID, next)
+		return Match{}, false
+	}
+	normalize(next.scores)
+	m.store(obuID, next)
+
+	// The leg continues from where the previous fix was matched, unless the
+	// best explanation of this fix no longer runs through there.
+	from := froms[prev.chosen]
+	if d := from.to(cands[next.chosen]); math.IsInf(d, 1) || d > limit {
+		from = froms[best[next.chosen]]
+	}
+	path, roads := from.path(cands[next.chosen])
+	return Match{Path: path, Roads: roads}, true
+}
+
+// Route returns the shortest route along the roads between the positions
+// nearest to two fixes, for a leg the fixes can't be matched along, such
+// as one across a gap in them. It leaves the vehicles' tracks alone.
+func (m *Matcher) Route(from, to geo.Point) (Match, bool) {
+	a := m.graph.candidates(from, m.opts.Radius, 1)
+	b := m.graph.candidates(to, m.opts.Radius, 1)
+	if len(a) == 0 || len(b) == 0 {
+		return Match{}, false
+	}
+	// A detour more than twice as long as the straight line is no better a
+	// guess than the straight line.
+	limit := 2*geo.Meters(from, to) + 2*m.opts.Radius
+	r := m.graph.shortestRoutes(a[0], limit)
+	if d := r.to(b[0]); math.IsInf(d, 1) || d > limit {
+		return Match{}, false
+	}
+	path, roads := r.path(b[0])
+	return Match{Path: path, Roads: roads}, true
+}
+
+// Forget drops the state kept for the vehicle.
+func (m *Matcher) Forget(obuID int32) {
+	m.store(obuID, nil)
+}
+
+func (m *Matcher) store(obuID int32, t *track) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if t == nil {
+		delete(m.tracks, obuID)
+		return
+	}
+	m.tracks[obuID] = t
+}
+
+// emission is the log probability, up to a constant, of the fix being
+// taken at the candidate, with the GPS error normally distributed.
+func (m *Matcher) emission(c candidate) float64 {
+	z := c.dist / m.opts.Sigma
+	return -0.5 * z * z
+}
+
+func argmax(xs []float64) int {
+	best := 0
+	for i, x := range xs {
+		if x > xs[best] {
+			best = i
+		}
+	}
+	return best
+}
+
+// normalize shifts the log probabilities so the best is 0, keeping long
+// tracks from drifting towards -Inf.
+func normalize(xs []float64) {
+	max := xs[argmax(xs)]
+	for i := range xs {
+		xs[i] -= max
+	}
+}
@@ -0,0 +1,133 @@
+package mapmatch
+
+import (
+	"container/heap"
+	"math"
+	"sort"
+
+	"github.com/0x0Glitch/toll-calculator/geo"
+)
+
+func sortCandidates(cs []candidate) {
+	sort.Slice(cs, func(i, j int) bool { return cs[i].dist < cs[j].dist })
+}
+
+// routes holds the shortest routes from one candidate to every node within
+// reach.
+type routes struct {
+	g    *Graph
+	from candidate
+	dist map[int]float64
+	// prev is the node each node was reached from, -1 for the two ends of
+	// the start segment.
+	prev map[int]int
+	// via is the edge each node was reached over.
+	via map[int]int
+}
+
+// shortestRoutes runs Dijkstra from the candidate, up to limit metres.
+func (g *Graph) shortestRoutes(from candidate, limit float64) *routes {
+	r := &routes{g: g, from: from, dist: make(map[int]float64), prev: make(map[int]int), via: make(map[int]int)}
+	e := g.edges[from.edge]
+	q := &nodeQueue{}
+	for _, start := range []struct {
+		node int
+		dist float64
+	}{{e.from, from.offset}, {e.to, e.length - from.offset}} {
+		if d, ok := r.dist[start.node]; !ok || start.dist < d {
+			r.dist[start.node], r.prev[start.node], r.via[start.node] = start.dist, -1, from.edge
+			heap.Push(q, queued{start.node, start.dist})
+		}
+	}
+	done := make(map[int]bool)
+	for q.Len() > 0 {
+		cur := heap.Pop(q).(queued)
+		if done[cur.node] || cur.dist > limit {
+			continue
+		}
+		done[cur.node] = true
+		for _, ei := range g.adj[cur.node] {
+			next := g.edges[ei].other(cur.node)
+			d := cur.dist + g.edges[ei].length
+			if old, ok := r.dist[next]; ok && old <= d {
+				continue
+			}
+			r.dist[next], r.prev[next], r.via[next] = d, cur.node, ei
+			heap.Push(q, queued{next, d})
+		}
+	}
+	return r
+}
+
+// to returns the length in metres of the shortest route to the candidate,
+// or +Inf if it is out of reach.
+func (r *routes) to(c candidate) float64 {
+	if c.edge == r.from.edge {
+		return math.Abs(c.offset - r.from.offset)
+	}
+	_, d := r.entry(c)
+	return d
+}
+
+// entry returns the node the route to c enters c's segment at, and the
+// length of the route.
+func (r *routes) entry(c candidate) (int, float64) {
+	e := r.g.edges[c.edge]
+	node, best := -1, math.Inf(1)
+	if d, ok := r.dist[e.from]; ok && d+c.offset < best {
+		node, best = e.from, d+c.offset
+	}
+	if d, ok := r.dist[e.to]; ok && d+e.length-c.offset < best {
+		node, best = e.to, d+e.length-c.offset
+	}
+	return node, best
+}
+
+// path returns the geometry of the route to c and the roads it runs on, in
+// order.
+func (r *routes) path(c candidate) ([]geo.Point, []string) {
+	if c.edge == r.from.edge {
+		road := r.g.edges[c.edge].road
+		return []geo.Point{r.from.point, c.point}, []string{road}
+	}
+	node, _ := r.entry(c)
+	var nodes []int
+	var edges []int
+	for n := node; n != -1; n = r.prev[n] {
+		nodes = append(nodes, n)
+		edges = append(edges, r.via[n])
+	}
+	pts := []geo.Point{r.from.point}
+	roads := []string{r.g.edges[r.from.edge].road}
+	for i := len(nodes) - 1; i >= 0; i-- {
+		pts = append(pts, r.g.nodes[nodes[i]])
+		roads = appendRoad(roads, r.g.edges[edges[i]].road)
+	}
+	pts = append(pts, c.point)
+	return pts, appendRoad(roads, r.g.edges[c.edge].road)
+}
+
+func appendRoad(roads []string, road string) []string {
+	if len(roads) > 0 && roads[len(roads)-1] == road {
+		return roads
+	}
+	return append(roads, road)
+}
+
+type queued struct {
+	node int
+	dist float64
+}
+
+type nodeQueue []queued
+
+func (q nodeQueue) Len() int           { return len(q) }
+func (q nodeQueue) Less(i, j int) bool { return q[i].dist < q[j].dist }
+func (q nodeQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+func (q *nodeQueue) Push(x any)        { *q = append(*q, x.(queued)) }
+func (q *nodeQueue) Pop() any {
+	old := *q
+	x := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return x
+}
@@ -0,0 +1,42 @@
+package money
+
+import "fmt"
+
+// Currency is an ISO 4217 currency code, such as EUR.
+type Currency string
+
+// minorUnits are the digits after the point of the currencies of the
+// countries vehicles are billed in.
+var minorUnits = map[Currency]int32{
+	"BGN": 2, "CHF": 2, "CZK": 2, "DKK": 2, "EUR": 2, "GBP": 2, "HUF": 2,
+	"ISK": 0, "NOK": 2, "PLN": 2, "RON": 2, "RSD": 2, "SEK": 2, "TRY": 2,
+	"UAH": 2, "USD": 2, "CAD": 2, "AUD": 2, "JPY": 0, "KRW": 0, "CNY": 2,
+	"INR": 2, "AED": 2, "BHD": 3, "KWD": 3, "OMR": 3, "TND": 3,
+}
+
+func ParseCurrency(s string) (Currency, error) {
+	c := Currency(s)
+	if _, ok := minorUnits[c]; !ok {
+		return "", fmt.Errorf("money: unknown currency %q", s)
+	}
+	return c, nil
+}
+
+// MinorUnits returns the digits after the point amounts in c are rounded
+// to: 2 for EUR cents, 0 for JPY.
+func (c Currency) MinorUnits() int32 {
+	if n, ok := minorUnits[c]; ok {
+		return n
+	}
+	return 2
+}
+
+// Money is an amount in a currency.
+type Money struct {
+	Amount   Decimal  `json:"amount"`
+	Currency Currency `json:"currency"`
+}
+
+func (m Money) String() string {
+	return m.Amount.String() + " " + string(m.Currency)
+}
@@ -0,0 +1,242 @@
+// Package money does the arithmetic of tolls exactly: amounts are decimal
+// numbers rather than floats, so cents don't drift however many invoices
+// are summed. It also knows the currencies tariffs and invoices are in,
+// the rules amounts are rounded by, and the exchange rates converting
+// between currencies.
+package money
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Decimal is an exact decimal number, an integer coefficient times
+// 10^-scale. The zero value is 0. Decimals are values: no operation
+// changes its operands.
+type Decimal struct {
+	// coef is nil for 0.
+	coef *big.Int
+	// scale is the number of digits after the point, never negative.
+	scale int32
+}
+
+// New returns unscaled × 10^-scale, so New(1999, 2) is 19.99.
+func New(unscaled int64, scale int32) Decimal {
+	if scale < 0 {
+		return Decimal{coef: new(big.Int).Mul(big.NewInt(unscaled), pow10(-scale))}
+	}
+	return Decimal{coef: big.NewInt(unscaled), scale: scale}
+}
+
+// NewFromInt returns the integer i.
+func NewFromInt(i int64) Decimal {
+	return New(i, 0)
+}
+
+// FromFloat returns the decimal printed for f, the shortest one that reads
+// back as f. A tariff of 0.1 read from a file is then exactly 0.1. NaN and
+// the infinities, which are no amount or distance, are 0.
+func FromFloat(f float64) Decimal {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return Decimal{}
+	}
+	d, err := Parse(strconv.FormatFloat(f, 'f', -1, 64))
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+// Parse reads a decimal such as 12, -0.5 or 1999.99.
+func Parse(s string) (Decimal, error) {
+	neg := strings.HasPrefix(s, "-")
+	digits := strings.TrimPrefix(s, "-")
+	if !neg {
+		digits = strings.TrimPrefix(s, "+")
+	}
+	intPart, frac, _ := strings.Cut(digits, ".")
+	if intPart == "" && frac == "" || !allDigits(intPart) || !allDigits(frac) {
+		return Decimal{}, fmt.Errorf("money: invalid decimal %q", s)
+	}
+	coef, _ := new(big.Int).SetString(intPart+frac, 10)
+	if neg {
+		coef.Neg(coef)
+	}
+	return Decimal{coef: coef, scale: int32(len(frac))}, nil
+}
+
+// MustParse is like Parse but panics if s isn't a decimal.
+func MustParse(s string) Decimal {
+	d, err := Parse(s)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+func allDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func (d Decimal) int() *big.Int {
+	if d.coef == nil {
+		return new(big.Int)
+	}
+	return d.coef
+}
+
+// rescale returns the coefficient of d with scale digits after the point,
+// which must be at least d's.
+func (d Decimal) rescale(scale int32) *big.Int {
+	if scale == d.scale {
+		return d.int()
+	}
+	return new(big.Int).Mul(d.int(), pow10(scale-d.scale))
+}
+
+func pow10(n int32) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
+
+func (d Decimal) Add(e Decimal) Decimal {
+	scale := max(d.scale, e.scale)
+	return Decimal{coef: new(big.Int).Add(d.rescale(scale), e.rescale(scale)), scale: scale}
+}
+
+func (d Decimal) Sub(e Decimal) Decimal {
+	return d.Add(e.Neg())
+}
+
+func (d Decimal) Neg() Decimal {
+	return Decimal{coef: new(big.Int).Neg(d.int()), scale: d.scale}
+}
+
+// Mul returns d × e, exactly: the product has the digits after the point
+// of both.
+func (d Decimal) Mul(e Decimal) Decimal {
+	return Decimal{coef: new(big.Int).Mul(d.int(), e.int()), scale: d.scale + e.scale}
+}
+
+// Quo returns d / e with scale digits after the point, rounded by mode.
+// It panics if e is 0.
+func (d Decimal) Quo(e Decimal, scale int32, mode Mode) Decimal {
+	// d / e = (d.coef × 10^(scale+e.scale)) / (e.coef × 10^d.scale) × 10^-scale
+	num := new(big.Int).Mul(d.int(), pow10(scale+e.scale))
+	den := new(big.Int).Mul(e.int(), pow10(d.scale))
+	return Decimal{coef: divRound(num, den, mode), scale: scale}
+}
+
+// Round returns d with scale digits after the point, rounded by mode. A d
+// with fewer digits is padded with zeros, so amounts print with all the
+// digits of their currency.
+func (d Decimal) Round(scale int32, mode Mode) Decimal {
+	if d.scale <= scale {
+		return Decimal{coef: d.rescale(scale), scale: scale}
+	}
+	return Decimal{coef: divRound(d.int(), pow10(d.scale-scale), mode), scale: scale}
+}
+
+// divRound returns num / den rounded to an integer by mode.
+func divRound(num, den *big.Int, mode Mode) *big.Int {
+	q, r := new(big.Int).QuoRem(num, den, new(big.Int))
+	if r.Sign() == 0 || mode == Down {
+		return q
+	}
+	// Compare the remainder with half the divisor.
+	twice := new(big.Int).Abs(r)
+	twice.Lsh(twice, 1)
+	c := twice.Cmp(new(big.Int).Abs(den))
+	if c > 0 || c == 0 && (mode == HalfUp || q.Bit(0) == 1) {
+		q.Add(q, big.NewInt(int64(num.Sign()*den.Sign())))
+	}
+	return q
+}
+
+// Cmp returns -1, 0 or +1 as d is less than, equal to or greater than e.
+func (d Decimal) Cmp(e Decimal) int {
+	scale := max(d.scale, e.scale)
+	return d.rescale(scale).Cmp(e.rescale(scale))
+}
+
+// Equal reports whether d and e are the same number, whatever digits they
+// print with: 1.5 equals 1.50.
+func (d Decimal) Equal(e Decimal) bool {
+	return d.Cmp(e) == 0
+}
+
+func (d Decimal) Sign() int {
+	return d.int().Sign()
+}
+
+func (d Decimal) IsZero() bool {
+	return d.Sign() == 0
+}
+
+// Float64 returns the float nearest to d, for metrics and logs.
+func (d Decimal) Float64() float64 {
+	f, _ := strconv.ParseFloat(d.String(), 64)
+	return f
+}
+
+func (d Decimal) String() string {
+	s := new(big.Int).Abs(d.int()).String()
+	if d.scale > 0 {
+		if pad := int(d.scale) + 1 - len(s); pad > 0 {
+			s = strings.Repeat("0", pad) + s
+		}
+		s = s[:len(s)-int(d.scale)] + "." + s[len(s)-int(d.scale):]
+	}
+	if d.Sign() < 0 {
+		s = "-" + s
+	}
+	return s
+}
+
+// MarshalText writes d as a string, so JSON carries amounts as "19.99"
+// rather than a float that may not read back the same.
+func (d Decimal) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func (d *Decimal) UnmarshalText(b []byte) error {
+	v, err := Parse(string(b))
+	if err != nil {
+		return err
+	}
+	*d = v
+	return nil
+}
+
+// UnmarshalJSON reads a string or, for clients sending plain numbers, a
+// number, which is read as the digits it was written with.
+func (d *Decimal) UnmarshalJSON(b []byte) error {
+	if bytes.Equal(b, []byte("null")) {
+		return nil
+	}
+	if len(b) > 0 && b[0] == '"' {
+		var s string
+		if err := json.Unmarshal(b, &s); err != nil {
+			return err
+		}
+		return d.UnmarshalText([]byte(s))
+	}
+	if bytes.ContainsAny(b, "eE") {
+		f, err := strconv.ParseFloat(string(b), 64)
+		if err != nil {
+			return fmt.Errorf("money: invalid decimal %s", b)
+		}
+		*d = FromFloat(f)
+		return nil
+	}
+	return d.UnmarshalText(b)
+}
@@ -0,0 +1,170 @@
+package money
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/0x0Glitch/toll-calculator/filewatch"
+	"gopkg.in/yaml.v3"
+)
+
+// RateScale is the digits after the point of the exchange rate between two
+// currencies that aren't the base of the table.
+const RateScale = 10
+
+// Table holds exchange rates against a base currency, read from a YAML
+// file such as
+//
+//	base: EUR
+//	rates:
+//	  CHF: 0.9412
+//	  GBP: 0.8571
+//
+// where each rate is the units of the currency one unit of the base buys.
+// A nil table has no rates.
+type Table struct {
+	base  Currency
+	rates map[Currency]Decimal
+}
+
+type tableFile struct {
+	Base  string             `yaml:"base"`
+	Rates map[string]Decimal `yaml:"rates"`
+}
+
+// LoadTable reads a table from the YAML file at path.
+func LoadTable(path string) (*Table, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("money: %w", err)
+	}
+	t, err := ParseTable(b)
+	if err != nil {
+		return nil, fmt.Errorf("%w in %s", err, path)
+	}
+	return t, nil
+}
+
+// ParseTable reads a table from YAML.
+func ParseTable(b []byte) (*Table, error) {
+	var f tableFile
+	if err := yaml.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("money: %w", err)
+	}
+	base, err := ParseCurrency(f.Base)
+	if err != nil {
+		return nil, fmt.Errorf("money: base: %w", err)
+	}
+	t := &Table{base: base, rates: map[Currency]Decimal{base: NewFromInt(1)}}
+	for code, rate := range f.Rates {
+		c, err := ParseCurrency(code)
+		if err != nil {
+			return nil, err
+		}
+		if rate.Sign() <= 0 {
+			return nil, fmt.Errorf("money: rate of %s must be positive", c)
+		}
+		if c == base && !rate.Equal(NewFromInt(1)) {
+			return nil, fmt.Errorf("money: rate of the base %s must be 1", c)
+		}
+		t.rates[c] = rate
+	}
+	return t, nil
+}
+
+// Base returns the currency the rates are against.
+func (t *Table) Base() Currency {
+	if t == nil {
+		return ""
+	}
+	return t.base
+}
+
+// Currencies returns the currencies the table converts between, in order.
+func (t *Table) Currencies() []Currency {
+	if t == nil {
+		return nil
+	}
+	out := make([]Currency, 0, len(t.rates))
+	for c := range t.rates {
+		out = append(out, c)
+	}
+	sort.Slice(out, func(a, b int) bool { return out[a] < out[b] })
+	return out
+}
+
+// Rate returns the units of to one unit of from buys. A rate from or to the
+// base is the table's own; any other is worked out through the base and
+// rounded half-even to RateScale digits.
+func (t *Table) Rate(from, to Currency) (Decimal, error) {
+	if from == to {
+		return NewFromInt(1), nil
+	}
+	if t == nil {
+		return Decimal{}, fmt.Errorf("money: no exchange rates to convert %s to %s", from, to)
+	}
+	rf, ok := t.rates[from]
+	if !ok {
+		return Decimal{}, fmt.Errorf("money: no exchange rate for %s", from)
+	}
+	rt, ok := t.rates[to]
+	if !ok {
+		return Decimal{}, fmt.Errorf("money: no exchange rate for %s", to)
+	}
+	switch {
+	case from == t.base:
+		return rt, nil
+	case to == t.base:
+		return NewFromInt(1).Quo(rf, RateScale, HalfEven), nil
+	}
+	return rt.Quo(rf, RateScale, HalfEven), nil
+}
+
+// Rates holds the table read from a YAML file and reloads it when the file
+// changes. A nil Rates has no table.
+type Rates struct {
+	path  string
+	files *filewatch.Files
+	table atomic.Pointer[Table]
+}
+
+// LoadRates reads the table from the YAML file at path.
+func LoadRates(path string) (*Rates, error) {
+	r := &Rates{path: path, files: filewatch.New(path)}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Table returns the current table.
+func (r *Rates) Table() *Table {
+	if r == nil {
+		return nil
+	}
+	return r.table.Load()
+}
+
+// Reload reads the file again. An invalid file leaves the rates as they
+// were.
+func (r *Rates) Reload() error {
+	return r.files.Load(func() error {
+		t, err := LoadTable(r.path)
+		if err != nil {
+			return err
+		}
+		r.table.Store(t)
+		return nil
+	})
+}
+
+// Run reloads the file whenever it changes, checking every interval, so
+// the day's rates can be dropped in without a restart. It returns when ctx
+// is done.
+func (r *Rates) Run(ctx context.Context, interval time.Duration) {
+	r.files.Run(ctx, interval, "exchange rates", r.Reload)
+}
@@ -0,0 +1,69 @@
+package money
+
+import "fmt"
+
+// Mode is how a number is rounded to fewer digits.
+type Mode string
+
+const (
+	// HalfEven rounds halves to the even neighbour, as bankers do, so
+	// rounding many amounts doesn't bias their sum upwards.
+	HalfEven Mode = "half-even"
+	// HalfUp rounds halves away from zero.
+	HalfUp Mode = "half-up"
+	// Down drops the extra digits.
+	Down Mode = "down"
+)
+
+func ParseMode(s string) (Mode, error) {
+	switch m := Mode(s); m {
+	case HalfEven, HalfUp, Down:
+		return m, nil
+	}
+	return "", fmt.Errorf("money: unknown rounding mode %q: want half-even, half-up or down", s)
+}
+
+// Scope is what gets rounded to the minor units of the currency.
+type Scope string
+
+const (
+	// PerLine rounds the amount of every line item, and the invoice is
+	// their sum.
+	PerLine Scope = "line"
+	// PerInvoice keeps the line items exact and rounds their sum.
+	PerInvoice Scope = "invoice"
+)
+
+func ParseScope(s string) (Scope, error) {
+	switch sc := Scope(s); sc {
+	case PerLine, PerInvoice:
+		return sc, nil
+	}
+	return "", fmt.Errorf("money: unknown rounding scope %q: want line or invoice", s)
+}
+
+// Rounding is how the amounts of an invoice are rounded.
+type Rounding struct {
+	Mode  Mode
+	Scope Scope
+}
+
+// Line returns the amount of a line item in the currency.
+func (r Rounding) Line(d Decimal, c Currency) Decimal {
+	if r.Scope == PerInvoice {
+		return d
+	}
+	return d.Round(c.MinorUnits(), r.mode())
+}
+
+// Total returns the amount of an invoice whose line items add up to d.
+func (r Rounding) Total(d Decimal, c Currency) Decimal {
+	return d.Round(c.MinorUnits(), r.mode())
+}
+
+func (r Rounding) mode() Mode {
+	if r.Mode == "" {
+		return HalfEven
+	}
+	return r.Mode
+}
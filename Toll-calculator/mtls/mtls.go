@@ -0,0 +1,144 @@
+// Package mtls secures the traffic between the services with mutual TLS.
+// Every service holds a certificate signed by a shared CA, presents it to
+// its peers and only accepts peers presenting one too. The files are read
+// again when they change, so certificates rotate without a restart.
+package mtls
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/0x0Glitch/toll-calculator/filewatch"
+)
+
+// identity is a certificate together with the CA its peers are checked
+// against. Both are swapped at once, so a rotation to a new CA never pairs
+// a new certificate with the old pool.
+type identity struct {
+	cert *tls.Certificate
+	pool *x509.CertPool
+}
+
+// Reloader serves the certificate and CA read from PEM files and reloads
+// them when they change.
+type Reloader struct {
+	caFile, certFile, keyFile string
+	files                     *filewatch.Files
+
+	current atomic.Pointer[identity]
+}
+
+// NewReloader reads the CA bundle and the key pair. It fails if any of them
+// is missing or invalid.
+func NewReloader(caFile, certFile, keyFile string) (*Reloader, error) {
+	r := &Reloader{
+		caFile:   caFile,
+		certFile: certFile,
+		keyFile:  keyFile,
+		files:    filewatch.New(caFile, certFile, keyFile),
+	}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload reads the files again. If they are invalid, for example because
+// they are halfway through being replaced, the previous ones stay in use.
+func (r *Reloader) Reload() error {
+	return r.files.Load(func() error {
+		ca, err := os.ReadFile(r.caFile)
+		if err != nil {
+			return fmt.Errorf("mtls: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return fmt.Errorf("mtls: no certificates in %s", r.caFile)
+		}
+		cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+		if err != nil {
+			return fmt.Errorf("mtls: %w", err)
+		}
+		r.current.Store(&identity{cert: &cert, pool: pool})
+		return nil
+	})
+}
+
+// Run reloads the files whenever one of them changes, checking every
+// interval. It returns when ctx is done.
+func (r *Reloader) Run(ctx context.Context, interval time.Duration) {
+	r.files.Run(ctx, interval, "TLS certificate", r.Reload)
+}
+
+// ServerConfig returns the config of a listener that only accepts clients
+// with a certificate signed by the CA.
+func (r *Reloader) ServerConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// The client certificate is verified by VerifyConnection against
+		// the current pool; ClientCAs would pin the pool of startup.
+		ClientAuth: tls.RequireAnyClientCert,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return r.current.Load().cert, nil
+		},
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			return r.verify(cs, "", x509.ExtKeyUsageClientAuth)
+		},
+	}
+}
+
+// ClientConfig returns the config of a client presenting its certificate
+// and checking the server's against the CA.
+func (r *Reloader) ClientConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// Skipping the built-in verification only swaps RootCAs, which
+		// would pin the pool of startup, for VerifyConnection, which
+		// checks the chain and host name against the current pool.
+		InsecureSkipVerify: true,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return r.current.Load().cert, nil
+		},
+		// Servers dialed by IP address send no server name, so they are
+		// only checked to hold a certificate of the CA.
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			return r.verify(cs, cs.ServerName, x509.ExtKeyUsageServerAuth)
+		},
+	}
+}
+
+func (r *Reloader) verify(cs tls.ConnectionState, host string, usage x509.ExtKeyUsage) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("mtls: peer presented no certificate")
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range cs.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := cs.PeerCertificates[0].Verify(x509.VerifyOptions{
+		DNSName:       host,
+		Roots:         r.current.Load().pool,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{usage},
+	})
+	if err != nil {
+		return fmt.Errorf("mtls: %w", err)
+	}
+	return nil
+}
+
+// ListenAndServe serves srv over TLS if it has a TLS config and in plaintext
+// otherwise.
+func ListenAndServe(srv *http.Server) error {
+	if srv.TLSConfig != nil {
+		return srv.ListenAndServeTLS("", "")
+	}
+	return srv.ListenAndServe()
+}
@@ -4,16 +4,16 @@ import (
 	"fmt"
 	"log"
 	"math/rand"
+	"os"
 	"time"
 
+	"github.com/0x0Glitch/toll-calculator/config"
+	"github.com/0x0Glitch/toll-calculator/mtls"
+	"github.com/0x0Glitch/toll-calculator/obuauth"
 	"github.com/0x0Glitch/toll-calculator/types"
 	"github.com/gorilla/websocket"
 )
 
-const wsEndpoint = "ws://127.0.0.1:30000/ws"
-
-var sendInterval = time.Second * 5
-
 func genLatLong() (float64, float64) {
 	return genCoord(), genCoord()
 }
@@ -25,11 +25,51 @@ func genCoord() float64 {
 }
 
 func main() {
-	obuIDS := generateOBUIDS(20)
-	conn, _, err := websocket.DefaultDialer.Dial(wsEndpoint, nil)
+	var cfg config.OBU
+	if err := config.Load(&cfg, os.Args[1:]); err != nil {
+		log.Fatal(err)
+	}
+	obuIDS := generateOBUIDS(cfg.Count)
+	// Provisioned OBUs sign their fixes; the IDs then come from the
+	// credentials.
+	var signers []*obuauth.Signer
+	if cfg.Devices != "" {
+		devices, err := obuauth.LoadDevices(cfg.Devices)
+		if err != nil {
+			log.Fatal(err)
+		}
+		obuIDS = obuIDS[:0]
+		for _, d := range devices {
+			signer, err := obuauth.NewSigner(d)
+			if err != nil {
+				log.Fatal(err)
+			}
+			signers = append(signers, signer)
+			obuIDS = append(obuIDS, d.OBUID)
+		}
+	}
+	dialer := *websocket.DefaultDialer
+	if cfg.TLS.Enabled() {
+		certs, err := mtls.NewReloader(cfg.TLS.CAFile, cfg.TLS.CertFile, cfg.TLS.KeyFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		dialer.TLSClientConfig = certs.ClientConfig()
+	}
+	conn, _, err := dialer.Dial(cfg.Endpoint, nil)
 	if err != nil {
 		log.Fatal(err)
 	}
+	// The OBU never reads data, but reading lets it answer the receiver's
+	// close handshake when the receiver shuts down.
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				log.Println("receiver closed the connection:", err)
+				os.Exit(0)
+			}
+		}
+	}()
 
 	for {
 		for i := 0; i < len(obuIDS); i++ {
@@ -39,13 +79,16 @@ func main() {
 				Lat:   lat,
 				Long:  long,
 			}
+			if signers != nil {
+				signers[i].Sign(&data)
+			}
 			if err := conn.WriteJSON(data); err != nil {
 				log.Fatal(err)
 			}
 			fmt.Printf("%+v\n", data)
 		}
 
-		time.Sleep(sendInterval)
+		time.Sleep(cfg.Interval)
 	}
 
 }
@@ -57,9 +100,3 @@ func generateOBUIDS(n int) []int32 {
 	}
 	return ids
 }
-
-// func init() {
-// 	if err := godotenv.Load(); err != nil {
-// 		log.Fatal(err)
-// 	}
-// }
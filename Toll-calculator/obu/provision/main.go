@@ -0,0 +1,43 @@
+// Command provision creates the credentials of simulated OBUs: the vehicle
+// registry for the data receiver and the devices file for the OBU
+// simulator.
+package main
+
+import (
+	"flag"
+	"log"
+	"math/rand"
+
+	"github.com/0x0Glitch/toll-calculator/obuauth"
+)
+
+func main() {
+	count := flag.Int("count", 20, "number of devices")
+	useHMAC := flag.Bool("hmac", false, "provision HMAC secrets instead of Ed25519 key pairs")
+	registryPath := flag.String("registry", "devices/registry.yaml", "vehicle registry written for the data receiver")
+	devicesPath := flag.String("devices", "devices/devices.yaml", "device credentials written for the OBU simulator")
+	flag.Parse()
+
+	var registry, devices []obuauth.Device
+	seen := make(map[int32]bool)
+	for len(registry) < *count {
+		id := int32(rand.Intn(999999))
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		r, d, err := obuauth.Provision(id, *useHMAC)
+		if err != nil {
+			log.Fatal(err)
+		}
+		registry = append(registry, r)
+		devices = append(devices, d)
+	}
+	if err := obuauth.WriteDevices(*registryPath, registry); err != nil {
+		log.Fatal(err)
+	}
+	if err := obuauth.WriteDevices(*devicesPath, devices); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("provisioned %d devices in %s and %s", *count, *registryPath, *devicesPath)
+}
@@ -0,0 +1,100 @@
+// Package obuauth authenticates the fixes sent by the OBUs. Every device is
+// provisioned with a credential, an Ed25519 key pair or an HMAC secret, and
+// signs each fix together with a sequence number and a timestamp. The data
+// receiver looks the device up in the vehicle registry, checks the signature
+// and rejects fixes that are replayed or too old.
+package obuauth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Device is the credential of an OBU. In the vehicle registry it holds the
+// public key or the HMAC secret; on the device it holds the private key or
+// the same secret. Keys are base64 encoded.
+type Device struct {
+	OBUID      int32  `yaml:"obuID"`
+	PublicKey  string `yaml:"publicKey,omitempty"`
+	PrivateKey string `yaml:"privateKey,omitempty"`
+	HMACSecret string `yaml:"hmacSecret,omitempty"`
+}
+
+type devicesFile struct {
+	Devices []Device `yaml:"devices"`
+}
+
+// LoadDevices reads a YAML file listing devices.
+func LoadDevices(path string) ([]Device, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("obuauth: %w", err)
+	}
+	var f devicesFile
+	if err := yaml.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("obuauth: %s: %w", path, err)
+	}
+	return f.Devices, nil
+}
+
+// WriteDevices writes devices to a YAML file readable by LoadDevices. The
+// file holds key material, so only the owner may read it.
+func WriteDevices(path string, devices []Device) error {
+	b, err := yaml.Marshal(devicesFile{Devices: devices})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0o600)
+}
+
+// Provision creates the credential of a new device, an Ed25519 key pair or,
+// with useHMAC, an HMAC secret. It returns the registry entry and the
+// device's own copy.
+func Provision(obuID int32, useHMAC bool) (registry, device Device, err error) {
+	if useHMAC {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return Device{}, Device{}, err
+		}
+		s := base64.StdEncoding.EncodeToString(secret)
+		return Device{OBUID: obuID, HMACSecret: s}, Device{OBUID: obuID, HMACSecret: s}, nil
+	}
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return Device{}, Device{}, err
+	}
+	registry = Device{OBUID: obuID, PublicKey: base64.StdEncoding.EncodeToString(pub)}
+	device = Device{OBUID: obuID, PrivateKey: base64.StdEncoding.EncodeToString(priv.Seed())}
+	return registry, device, nil
+}
+
+// credential is the decoded key material of a registry entry.
+type credential struct {
+	publicKey ed25519.PublicKey
+	secret    []byte
+}
+
+func (d Device) credential() (credential, error) {
+	switch {
+	case d.PublicKey != "" && d.HMACSecret != "":
+		return credential{}, fmt.Errorf("obuauth: device %d has both a public key and an HMAC secret", d.OBUID)
+	case d.PublicKey != "":
+		key, err := base64.StdEncoding.DecodeString(d.PublicKey)
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			return credential{}, fmt.Errorf("obuauth: device %d: invalid public key", d.OBUID)
+		}
+		return credential{publicKey: key}, nil
+	case d.HMACSecret != "":
+		secret, err := base64.StdEncoding.DecodeString(d.HMACSecret)
+		if err != nil || len(secret) < 16 {
+			return credential{}, fmt.Errorf("obuauth: device %d: HMAC secret must be at least 16 bytes of base64", d.OBUID)
+		}
+		return credential{secret: secret}, nil
+	}
+	return credential{}, fmt.Errorf("obuauth: device %d has no credential", d.OBUID)
+}
@@ -0,0 +1,162 @@
+package obuauth
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/0x0Glitch/toll-calculator/filewatch"
+	"github.com/0x0Glitch/toll-calculator/types"
+)
+
+var (
+	ErrUnsigned      = errors.New("obuauth: fix is not signed")
+	ErrUnknownDevice = errors.New("obuauth: unknown device")
+	ErrBadSignature  = errors.New("obuauth: invalid signature")
+	ErrStale         = errors.New("obuauth: timestamp outside the accepted window")
+	ErrReplay        = errors.New("obuauth: sequence number already seen")
+)
+
+// Reason returns a short label for a rejection returned by Verify, suitable
+// for a metric.
+func Reason(err error) string {
+	switch {
+	case errors.Is(err, ErrUnsigned):
+		return "unsigned"
+	case errors.Is(err, ErrUnknownDevice):
+		return "unknown_device"
+	case errors.Is(err, ErrBadSignature):
+		return "bad_signature"
+	case errors.Is(err, ErrStale):
+		return "stale"
+	case errors.Is(err, ErrReplay):
+		return "replay"
+	}
+	return "other"
+}
+
+// Registry is the vehicle registry: the credential of every device allowed
+// to send fixes.
+type Registry struct {
+	path    string
+	files   *filewatch.Files
+	devices atomic.Pointer[map[int32]credential]
+}
+
+// NewRegistry returns a registry of the given devices.
+func NewRegistry(devices []Device) (*Registry, error) {
+	r := &Registry{files: filewatch.New()}
+	if err := r.set(devices); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// LoadRegistry reads the registry from a YAML file written by WriteDevices.
+func LoadRegistry(path string) (*Registry, error) {
+	r := &Registry{path: path, files: filewatch.New(path)}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *Registry) set(devices []Device) error {
+	creds := make(map[int32]credential, len(devices))
+	for _, d := range devices {
+		if _, ok := creds[d.OBUID]; ok {
+			return fmt.Errorf("obuauth: device %d is listed twice", d.OBUID)
+		}
+		c, err := d.credential()
+		if err != nil {
+			return err
+		}
+		creds[d.OBUID] = c
+	}
+	r.devices.Store(&creds)
+	return nil
+}
+
+// Reload reads the registry file again. An invalid file leaves the
+// registry as it was.
+func (r *Registry) Reload() error {
+	return r.files.Load(func() error {
+		devices, err := LoadDevices(r.path)
+		if err != nil {
+			return err
+		}
+		return r.set(devices)
+	})
+}
+
+// Run reloads the registry file whenever it changes, checking every
+// interval, so newly provisioned devices are accepted without a restart. It
+// returns when ctx is done.
+func (r *Registry) Run(ctx context.Context, interval time.Duration) {
+	r.files.Run(ctx, interval, "vehicle registry", r.Reload)
+}
+
+func (r *Registry) lookup(obuID int32) (credential, bool) {
+	c, ok := (*r.devices.Load())[obuID]
+	return c, ok
+}
+
+// Verifier checks the fixes received from the OBUs.
+type Verifier struct {
+	registry *Registry
+	window   time.Duration
+	now      func() time.Time
+
+	mu   sync.Mutex
+	last map[int32]uint64
+}
+
+// NewVerifier returns a verifier accepting fixes signed by devices of the
+// registry whose timestamp is at most window away from now.
+func NewVerifier(registry *Registry, window time.Duration) *Verifier {
+	return &Verifier{
+		registry: registry,
+		window:   window,
+		now:      time.Now,
+		last:     make(map[int32]uint64),
+	}
+}
+
+// Verify accepts d if it is signed by its device, recent, and its sequence
+// number is higher than any seen from the device before. The last sequence
+// numbers only live in memory; the timestamp window bounds what can be
+// replayed after a restart.
+func (v *Verifier) Verify(d types.OBUData) error {
+	if len(d.Sig) == 0 {
+		return ErrUnsigned
+	}
+	cred, ok := v.registry.lookup(d.OBUID)
+	if !ok {
+		return fmt.Errorf("%w %d", ErrUnknownDevice, d.OBUID)
+	}
+	msg := Payload(d)
+	if cred.publicKey != nil {
+		ok = ed25519.Verify(cred.publicKey, msg, d.Sig)
+	} else {
+		ok = hmac.Equal(mac(cred.secret, msg), d.Sig)
+	}
+	if !ok {
+		return fmt.Errorf("%w from device %d", ErrBadSignature, d.OBUID)
+	}
+	if age := v.now().Sub(time.UnixMilli(d.Unix)); age > v.window || age < -v.window {
+		return fmt.Errorf("%w: device %d is %s off", ErrStale, d.OBUID, age.Round(time.Millisecond))
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if d.Seq <= v.last[d.OBUID] {
+		return fmt.Errorf("%w: device %d sent %d after %d", ErrReplay, d.OBUID, d.Seq, v.last[d.OBUID])
+	}
+	v.last[d.OBUID] = d.Seq
+	return nil
+}
@@ -0,0 +1,88 @@
+package privacy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/0x0Glitch/toll-calculator/apperr"
+)
+
+// Eraser removes everything a store holds about a vehicle. Erasing a
+// vehicle the store doesn't know succeeds.
+type Eraser interface {
+	Erase(ctx context.Context, obuID int32) error
+}
+
+// EraserFunc adapts a function to an Eraser.
+type EraserFunc func(ctx context.Context, obuID int32) error
+
+func (f EraserFunc) Erase(ctx context.Context, obuID int32) error {
+	return f(ctx, obuID)
+}
+
+// Erasure erases a vehicle from every store a service holds its data in.
+type Erasure struct {
+	names   []string
+	erasers []Eraser
+}
+
+// Add registers a store under a name reported by the erasure API.
+func (e *Erasure) Add(name string, eraser Eraser) {
+	e.names = append(e.names, name)
+	e.erasers = append(e.erasers, eraser)
+}
+
+// Erase erases the vehicle from every store, carrying on past failures so
+// as much as possible is erased, and returns the stores it was erased from.
+func (e *Erasure) Erase(ctx context.Context, obuID int32) ([]string, error) {
+	erased := []string{}
+	var errs []error
+	for i, eraser := range e.erasers {
+		if err := eraser.Erase(ctx, obuID); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", e.names[i], err))
+			continue
+		}
+		erased = append(erased, e.names[i])
+	}
+	return erased, errors.Join(errs...)
+}
+
+// ErasureResult is the body of a successful erasure request.
+type ErasureResult struct {
+	OBUID  int32    `json:"obuID"`
+	Erased []string `json:"erased"`
+}
+
+// Handler serves the erasure API: POST ?obu=<id> erases the vehicle and
+// answers which stores it was erased from. A failed erasure can be retried.
+func (e *Erasure) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, apperr.Body{Error: "method not allowed", Code: apperr.InvalidArgument})
+			return
+		}
+		obuID, err := strconv.ParseInt(r.URL.Query().Get("obu"), 10, 32)
+		if err != nil {
+			err = apperr.InvalidArgumentf("invalid OBU ID %q", r.URL.Query().Get("obu"))
+			writeJSON(w, apperr.HTTPStatus(err), apperr.BodyOf(err))
+			return
+		}
+		erased, err := e.Erase(r.Context(), int32(obuID))
+		if err != nil {
+			err = apperr.Wrap(apperr.Internal, err, "erasing OBU %d, erased from %v", obuID, erased)
+			writeJSON(w, apperr.HTTPStatus(err), apperr.BodyOf(err))
+			return
+		}
+		writeJSON(w, http.StatusOK, ErasureResult{OBUID: int32(obuID), Erased: erased})
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,213 @@
+// Package privacy protects the location data of the vehicles. Coordinates
+// are encrypted per vehicle before they reach Kafka, kept out of the logs,
+// dropped once their retention expires and erased on request.
+package privacy
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrKeyDestroyed is returned for data encrypted with a key that no longer
+// exists, because the vehicle was erased.
+var ErrKeyDestroyed = errors.New("privacy: data key destroyed")
+
+// cacheTTL bounds how long a key erased by another process stays usable in
+// this one.
+const cacheTTL = 30 * time.Second
+
+// Keyring is a local stand-in for a KMS. It holds a random data key per
+// vehicle, wrapped with the master key, in one file per vehicle. Deleting
+// a vehicle's file makes everything encrypted with its key unreadable, which
+// erases copies that can't be deleted, like the messages in Kafka. Services
+// sharing the directory share the keys.
+type Keyring struct {
+	dir    string
+	master cipher.AEAD
+
+	mu    sync.Mutex
+	cache map[int32]cachedKey
+	now   func() time.Time
+}
+
+type cachedKey struct {
+	id     string
+	key    []byte
+	loaded time.Time
+}
+
+// keyFile is the content of a vehicle's key file.
+type keyFile struct {
+	ID      string `json:"id"`
+	Wrapped []byte `json:"wrapped"`
+}
+
+// NewKeyring returns the keyring stored in dir, creating the directory if
+// needed. master must be 32 bytes.
+func NewKeyring(dir string, master []byte) (*Keyring, error) {
+	aead, err := newAEAD(master)
+	if err != nil {
+		return nil, fmt.Errorf("privacy: master key: %w", err)
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("privacy: %w", err)
+	}
+	return &Keyring{
+		dir:    dir,
+		master: aead,
+		cache:  make(map[int32]cachedKey),
+		now:    time.Now,
+	}, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("need 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// DataKey returns the ID and the key to encrypt the vehicle's data with,
+// creating the key on first use.
+func (k *Keyring) DataKey(obuID int32) (string, []byte, error) {
+	if c, ok := k.cached(obuID); ok {
+		return c.id, c.key, nil
+	}
+	c, err := k.load(obuID)
+	if errors.Is(err, os.ErrNotExist) {
+		c, err = k.create(obuID)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	return c.id, c.key, nil
+}
+
+// Key returns the key with the given ID to decrypt the vehicle's data with.
+// It returns ErrKeyDestroyed if the vehicle was erased since.
+func (k *Keyring) Key(obuID int32, id string) ([]byte, error) {
+	c, ok := k.cached(obuID)
+	if !ok || c.id != id {
+		var err error
+		c, err = k.load(obuID)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrKeyDestroyed
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if c.id != id {
+		return nil, ErrKeyDestroyed
+	}
+	return c.key, nil
+}
+
+// Erase destroys the vehicle's data key.
+func (k *Keyring) Erase(ctx context.Context, obuID int32) error {
+	k.mu.Lock()
+	delete(k.cache, obuID)
+	k.mu.Unlock()
+	if err := os.Remove(k.path(obuID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("privacy: %w", err)
+	}
+	return nil
+}
+
+func (k *Keyring) path(obuID int32) string {
+	return filepath.Join(k.dir, strconv.Itoa(int(obuID))+".key")
+}
+
+func (k *Keyring) cached(obuID int32) (cachedKey, bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	c, ok := k.cache[obuID]
+	if !ok || k.now().Sub(c.loaded) > cacheTTL {
+		return cachedKey{}, false
+	}
+	return c, true
+}
+
+func (k *Keyring) store(obuID int32, c cachedKey) cachedKey {
+	c.loaded = k.now()
+	k.mu.Lock()
+	k.cache[obuID] = c
+	k.mu.Unlock()
+	return c
+}
+
+func (k *Keyring) load(obuID int32) (cachedKey, error) {
+	b, err := os.ReadFile(k.path(obuID))
+	if err != nil {
+		return cachedKey{}, err
+	}
+	var f keyFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return cachedKey{}, fmt.Errorf("privacy: key of %d: %w", obuID, err)
+	}
+	ns := k.master.NonceSize()
+	if len(f.Wrapped) < ns {
+		return cachedKey{}, fmt.Errorf("privacy: key of %d is truncated", obuID)
+	}
+	key, err := k.master.Open(nil, f.Wrapped[:ns], f.Wrapped[ns:], []byte(f.ID))
+	if err != nil {
+		return cachedKey{}, fmt.Errorf("privacy: unwrapping key of %d: %w", obuID, err)
+	}
+	return k.store(obuID, cachedKey{id: f.ID, key: key}), nil
+}
+
+// create makes a new key for the vehicle. If another process creates one at
+// the same time, theirs is used.
+func (k *Keyring) create(obuID int32) (cachedKey, error) {
+	key := make([]byte, 32)
+	idBytes := make([]byte, 8)
+	nonce := make([]byte, k.master.NonceSize())
+	for _, b := range [][]byte{key, idBytes, nonce} {
+		if _, err := rand.Read(b); err != nil {
+			return cachedKey{}, err
+		}
+	}
+	// A fresh ID per key keeps data of an erased vehicle unreadable even if
+	// it comes back and gets a new key.
+	id := strconv.Itoa(int(obuID)) + "-" + hex.EncodeToString(idBytes)
+	b, err := json.Marshal(keyFile{ID: id, Wrapped: k.master.Seal(nonce, nonce, key, []byte(id))})
+	if err != nil {
+		return cachedKey{}, err
+	}
+	// Linking a complete temporary file into place never exposes a
+	// partly written key and fails if the key already exists.
+	tmp, err := os.CreateTemp(k.dir, ".key-*")
+	if err != nil {
+		return cachedKey{}, fmt.Errorf("privacy: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(b)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Link(tmp.Name(), k.path(obuID))
+	}
+	if errors.Is(err, os.ErrExist) {
+		return k.load(obuID)
+	}
+	if err != nil {
+		return cachedKey{}, fmt.Errorf("privacy: %w", err)
+	}
+	return k.store(obuID, cachedKey{id: id, key: key}), nil
+}
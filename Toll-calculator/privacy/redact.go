@@ -0,0 +1,51 @@
+package privacy
+
+import (
+	"strings"
+
+	"github.com/0x0Glitch/toll-calculator/types"
+	"github.com/sirupsen/logrus"
+)
+
+// Redacted replaces location data in the logs.
+const Redacted = "[redacted]"
+
+// RedactHook keeps coordinates out of the logs, whichever code logs them.
+// It replaces fields named like a coordinate and strips the coordinates of
+// fixes logged as a whole.
+type RedactHook struct{}
+
+func (RedactHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (RedactHook) Fire(entry *logrus.Entry) error {
+	for name, value := range entry.Data {
+		if isCoordinate(name) {
+			entry.Data[name] = Redacted
+			continue
+		}
+		switch d := value.(type) {
+		case types.OBUData:
+			entry.Data[name] = redactFix(d)
+		case *types.OBUData:
+			if d != nil {
+				entry.Data[name] = redactFix(*d)
+			}
+		}
+	}
+	return nil
+}
+
+func isCoordinate(name string) bool {
+	switch strings.ToLower(name) {
+	case "lat", "long", "lon", "lng", "latitude", "longitude":
+		return true
+	}
+	return false
+}
+
+func redactFix(d types.OBUData) types.OBUData {
+	d.Lat, d.Long, d.Sealed = 0, 0, nil
+	return d
+}
@@ -0,0 +1,37 @@
+package privacy
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Purger is a store whose data expires.
+type Purger interface {
+	// Purge drops the data last written before the cutoff and returns how
+	// many vehicles it dropped data of.
+	Purge(ctx context.Context, before time.Time) (int, error)
+}
+
+// RunRetention purges what p holds for longer than retention, every
+// interval, until ctx is done.
+func RunRetention(ctx context.Context, name string, p Purger, retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		n, err := p.Purge(ctx, time.Now().Add(-retention))
+		if err != nil {
+			logrus.WithError(err).Errorf("purging %s", name)
+			continue
+		}
+		if n > 0 {
+			logrus.Infof("purged %s of %d vehicles past their %s retention", name, n, retention)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package privacy
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/0x0Glitch/toll-calculator/types"
+)
+
+// sealVersion prefixes the additional data, binding a ciphertext to this
+// format.
+const sealVersion = "toll-loc-v1"
+
+// Seal returns d with its coordinates encrypted with the vehicle's data key
+// and cleared. The ciphertext is bound to the OBU, sequence number and
+// timestamp, so it can't be moved to another fix.
+func Seal(k *Keyring, d types.OBUData) (types.OBUData, error) {
+	id, key, err := k.DataKey(d.OBUID)
+	if err != nil {
+		return d, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return d, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return d, err
+	}
+	plain := binary.BigEndian.AppendUint64(nil, math.Float64bits(d.Lat))
+	plain = binary.BigEndian.AppendUint64(plain, math.Float64bits(d.Long))
+	d.KeyID = id
+	d.Sealed = aead.Seal(nonce, nonce, plain, additionalData(d))
+	d.Lat, d.Long = 0, 0
+	return d, nil
+}
+
+// Open returns d with the coordinates Seal encrypted restored. Fixes that
+// were never sealed are returned as they are. It returns ErrKeyDestroyed
+// for fixes of an erased vehicle.
+func Open(k *Keyring, d types.OBUData) (types.OBUData, error) {
+	if len(d.Sealed) == 0 {
+		return d, nil
+	}
+	key, err := k.Key(d.OBUID, d.KeyID)
+	if err != nil {
+		return d, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return d, err
+	}
+	ns := aead.NonceSize()
+	if len(d.Sealed) < ns {
+		return d, fmt.Errorf("privacy: sealed location of %d is truncated", d.OBUID)
+	}
+	plain, err := aead.Open(nil, d.Sealed[:ns], d.Sealed[ns:], additionalData(d))
+	if err != nil || len(plain) != 16 {
+		return d, fmt.Errorf("privacy: can't open the location of %d", d.OBUID)
+	}
+	d.Lat = math.Float64frombits(binary.BigEndian.Uint64(plain))
+	d.Long = math.Float64frombits(binary.BigEndian.Uint64(plain[8:]))
+	d.KeyID, d.Sealed = "", nil
+	return d, nil
+}
+
+func additionalData(d types.OBUData) []byte {
+	b := append([]byte(sealVersion), d.KeyID...)
+	b = binary.BigEndian.AppendUint32(b, uint32(d.OBUID))
+	b = binary.BigEndian.AppendUint64(b, d.Seq)
+	return binary.BigEndian.AppendUint64(b, uint64(d.Unix))
+}
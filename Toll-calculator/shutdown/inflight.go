@@ -0,0 +1,51 @@
+package shutdown
+
+import (
+	"context"
+	"sync"
+)
+
+// InFlight tracks work that has been accepted but not finished yet, such as
+// a fix read off a WebSocket that hasn't reached the Kafka producer.
+type InFlight struct {
+	mu       sync.Mutex
+	wg       sync.WaitGroup
+	draining bool
+}
+
+// Begin registers a unit of work. It returns false once draining started,
+// in which case the work must be refused and Done not called.
+func (f *InFlight) Begin() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.draining {
+		return false
+	}
+	f.wg.Add(1)
+	return true
+}
+
+// Done marks a unit of work registered with Begin as finished.
+func (f *InFlight) Done() {
+	f.wg.Done()
+}
+
+// Drain refuses new work and waits until the work in flight is done or
+// ctx expires.
+func (f *InFlight) Drain(ctx context.Context) error {
+	f.mu.Lock()
+	f.draining = true
+	f.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		f.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,45 @@
+package shutdown
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+// Flusher is the part of a Kafka producer needed to drain it.
+type Flusher interface {
+	Flush(timeoutMs int) int
+}
+
+// Committer is the part of a Kafka consumer needed to commit its offsets.
+type Committer interface {
+	Commit() ([]kafka.TopicPartition, error)
+}
+
+// flushInterval is how long each Flush call waits, so ctx is checked often.
+const flushInterval = 100
+
+// FlushProducer waits until every queued message was delivered, or ctx
+// expires, in which case it reports how many are left.
+func FlushProducer(ctx context.Context, p Flusher) error {
+	for {
+		left := p.Flush(flushInterval)
+		if left == 0 {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return fmt.Errorf("%d messages not delivered: %w", left, ctx.Err())
+		}
+	}
+}
+
+// CommitConsumer synchronously commits the offsets the consumer stored. It's
+// not an error when there was nothing to commit.
+func CommitConsumer(c Committer) error {
+	_, err := c.Commit()
+	if kerr, ok := err.(kafka.Error); ok && kerr.Code() == kafka.ErrNoOffset {
+		return nil
+	}
+	return err
+}
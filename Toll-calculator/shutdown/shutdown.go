@@ -0,0 +1,84 @@
+// Package shutdown drains the services on SIGINT or SIGTERM. Each service
+// lists the steps of its shutdown as hooks, in the order they have to run:
+// stop taking new work, finish the work in flight, flush what's buffered,
+// then release resources. All hooks share one deadline.
+package shutdown
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// DefaultTimeout is how long a service gets to drain before it exits anyway.
+const DefaultTimeout = 15 * time.Second
+
+// Hook is a single step of a shutdown.
+type Hook struct {
+	Name string
+	Fn   func(ctx context.Context) error
+}
+
+// Signals returns a context that is cancelled on SIGINT or SIGTERM.
+func Signals(ctx context.Context) (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+}
+
+// Run runs the hooks one after the other, giving all of them together at
+// most timeout. A failing hook doesn't stop the ones after it, so resources
+// are still released; the errors are joined.
+func Run(timeout time.Duration, hooks ...Hook) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var errs []error
+	for _, h := range hooks {
+		if err := h.Fn(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", h.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Func adapts a function that can't be interrupted to a hook.
+func Func(name string, fn func() error) Hook {
+	return Hook{Name: name, Fn: func(context.Context) error { return fn() }}
+}
+
+// HTTPServer stops srv from accepting connections and waits for the
+// requests in flight. Connections still busy at the deadline are closed.
+func HTTPServer(name string, srv *http.Server) Hook {
+	return Hook{Name: name, Fn: func(ctx context.Context) error {
+		if err := srv.Shutdown(ctx); err != nil {
+			srv.Close()
+			return err
+		}
+		return nil
+	}}
+}
+
+// GRPCServer stops srv from accepting calls and waits for the ones in
+// flight. Calls still running at the deadline are cancelled.
+func GRPCServer(name string, srv *grpc.Server) Hook {
+	return Hook{Name: name, Fn: func(ctx context.Context) error {
+		done := make(chan struct{})
+		go func() {
+			srv.GracefulStop()
+			close(done)
+		}()
+		select {
+		case <-done:
+			return nil
+		case <-ctx.Done():
+			srv.Stop()
+			return ctx.Err()
+		}
+	}}
+}
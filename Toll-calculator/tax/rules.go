@@ -0,0 +1,236 @@
+// Package tax decides the VAT on tolls: which jurisdiction taxes the
+// distance driven in a zone, at what rate, and whether the vehicle is
+// exempt or its owner accounts for the tax by reverse charge. It also
+// sums the taxes of a period's invoices into a report.
+package tax
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/0x0Glitch/toll-calculator/filewatch"
+	"github.com/0x0Glitch/toll-calculator/money"
+	"github.com/0x0Glitch/toll-calculator/types"
+	"gopkg.in/yaml.v3"
+)
+
+// Jurisdiction is a country, or a region with its own rules, taxing the
+// tolls of the distance driven in it.
+type Jurisdiction struct {
+	Code string `yaml:"code"`
+	// Country is the country of the jurisdiction, its code if empty.
+	Country string        `yaml:"country"`
+	Rate    money.Decimal `yaml:"rate"`
+	// ExemptClasses are the vehicle classes whose tolls aren't taxed.
+	ExemptClasses []string `yaml:"exemptClasses"`
+	// ReverseCharge leaves the tax of business customers established in
+	// another country to them.
+	ReverseCharge bool `yaml:"reverseCharge"`
+}
+
+func (j Jurisdiction) country() string {
+	if j.Country == "" {
+		return j.Code
+	}
+	return j.Country
+}
+
+// Vehicle is what the tax of a vehicle's tolls depends on: its class, and
+// for a business customer its VAT ID and the country it is established in.
+type Vehicle struct {
+	Class   string `yaml:"class"`
+	VATID   string `yaml:"vatID"`
+	Country string `yaml:"country"`
+}
+
+// Business reports whether the vehicle belongs to a business customer.
+func (v Vehicle) Business() bool {
+	return v.VATID != ""
+}
+
+// Assessment is how the tolls of a line item are taxed.
+type Assessment struct {
+	Jurisdiction string
+	Treatment    types.TaxTreatment
+	// Rate is the rate of the jurisdiction, also under reverse charge, and
+	// 0 for an exemption.
+	Rate money.Decimal
+}
+
+// Rules holds the jurisdictions, which one taxes each zone and the
+// vehicles, read from a YAML file such as
+//
+//	jurisdictions:
+//	  - code: NL
+//	    rate: 0.21
+//	    exemptClasses: [bus]
+//	    reverseCharge: true
+//	  - code: DE
+//	    rate: 0.19
+//	zones:
+//	  bridge: NL
+//	default: NL
+//	vehicles:
+//	  7: {class: bus}
+//	  9: {vatID: DE123456789, country: DE}
+//
+// A zone is taxed by the jurisdiction listed under zones, else by the one
+// of its country, else by the default one. A nil Rules taxes nothing.
+type Rules struct {
+	jurisdictions map[string]Jurisdiction
+	zones         map[string]string
+	fallback      string
+	vehicles      map[int32]Vehicle
+}
+
+type rulesFile struct {
+	Jurisdictions []Jurisdiction     `yaml:"jurisdictions"`
+	Zones         map[string]string  `yaml:"zones"`
+	Default       string             `yaml:"default"`
+	Vehicles      map[string]Vehicle `yaml:"vehicles"`
+}
+
+// LoadRules reads the rules from the YAML file at path.
+func LoadRules(path string) (*Rules, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("tax: %w", err)
+	}
+	r, err := ParseRules(b)
+	if err != nil {
+		return nil, fmt.Errorf("%w in %s", err, path)
+	}
+	return r, nil
+}
+
+// ParseRules reads the rules from YAML.
+func ParseRules(b []byte) (*Rules, error) {
+	var f rulesFile
+	if err := yaml.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("tax: %w", err)
+	}
+	r := &Rules{
+		jurisdictions: make(map[string]Jurisdiction, len(f.Jurisdictions)),
+		zones:         f.Zones,
+		fallback:      f.Default,
+		vehicles:      make(map[int32]Vehicle, len(f.Vehicles)),
+	}
+	for i, j := range f.Jurisdictions {
+		if j.Code == "" {
+			return nil, fmt.Errorf("tax: jurisdiction %d has no code", i)
+		}
+		if _, ok := r.jurisdictions[j.Code]; ok {
+			return nil, fmt.Errorf("tax: jurisdiction %s is listed twice", j.Code)
+		}
+		if j.Rate.Sign() < 0 || j.Rate.Cmp(money.NewFromInt(1)) > 0 {
+			return nil, fmt.Errorf("tax: rate of %s must be between 0 and 1", j.Code)
+		}
+		r.jurisdictions[j.Code] = j
+	}
+	for zone, code := range f.Zones {
+		if _, ok := r.jurisdictions[code]; !ok {
+			return nil, fmt.Errorf("tax: zone %q: unknown jurisdiction %q", zone, code)
+		}
+	}
+	if _, ok := r.jurisdictions[f.Default]; f.Default != "" && !ok {
+		return nil, fmt.Errorf("tax: unknown default jurisdiction %q", f.Default)
+	}
+	for id, v := range f.Vehicles {
+		obuID, err := strconv.ParseInt(id, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("tax: vehicle %q: not an OBU ID", id)
+		}
+		if v.VATID != "" && v.Country == "" {
+			return nil, fmt.Errorf("tax: vehicle %s: a VAT ID needs the country it was issued in", id)
+		}
+		r.vehicles[int32(obuID)] = v
+	}
+	return r, nil
+}
+
+// Vehicle returns the vehicle with the OBU ID; vehicles missing from the
+// rules are of no class and belong to private customers.
+func (r *Rules) Vehicle(obuID int32) Vehicle {
+	if r == nil {
+		return Vehicle{}
+	}
+	return r.vehicles[obuID]
+}
+
+// Assess returns how the tolls of the vehicle in the zone, which lies in
+// the country, are taxed. A zone no jurisdiction taxes is an error: its
+// tolls can't be invoiced until the rules cover it.
+func (r *Rules) Assess(obuID int32, zone, country string) (Assessment, error) {
+	if r == nil {
+		return Assessment{}, nil
+	}
+	code, ok := r.zones[zone]
+	if !ok {
+		code = country
+	}
+	j, ok := r.jurisdictions[code]
+	if !ok {
+		if j, ok = r.jurisdictions[r.fallback]; !ok {
+			return Assessment{}, fmt.Errorf("tax: no jurisdiction taxes zone %q", zone)
+		}
+	}
+	a := Assessment{Jurisdiction: j.Code, Treatment: types.TaxStandard, Rate: j.Rate}
+	v := r.Vehicle(obuID)
+	switch {
+	case v.Class != "" && slices.Contains(j.ExemptClasses, v.Class):
+		a.Treatment, a.Rate = types.TaxExempt, money.Decimal{}
+	case j.ReverseCharge && v.Business() && v.Country != j.country():
+		a.Treatment = types.TaxReverseCharge
+	}
+	return a, nil
+}
+
+// RulesFile holds the rules read from a YAML file and reloads them when
+// the file changes. A nil RulesFile has no rules.
+type RulesFile struct {
+	path  string
+	files *filewatch.Files
+	rules atomic.Pointer[Rules]
+}
+
+// LoadRulesFile reads the rules from the YAML file at path.
+func LoadRulesFile(path string) (*RulesFile, error) {
+	f := &RulesFile{path: path, files: filewatch.New(path)}
+	if err := f.Reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Rules returns the current rules.
+func (f *RulesFile) Rules() *Rules {
+	if f == nil {
+		return nil
+	}
+	return f.rules.Load()
+}
+
+// Reload reads the file again. An invalid file leaves the rules as they
+// were.
+func (f *RulesFile) Reload() error {
+	return f.files.Load(func() error {
+		r, err := LoadRules(f.path)
+		if err != nil {
+			return err
+		}
+		f.rules.Store(r)
+		return nil
+	})
+}
+
+// Run reloads the file whenever it changes, checking every interval, so a
+// new rate or vehicle applies without a restart. It returns when ctx is
+// done.
+func (f *RulesFile) Run(ctx context.Context, interval time.Duration) {
+	f.files.Run(ctx, interval, "tax rules", f.Reload)
+}
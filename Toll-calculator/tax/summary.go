@@ -0,0 +1,98 @@
+package tax
+
+import (
+	"sort"
+
+	"github.com/0x0Glitch/toll-calculator/money"
+	"github.com/0x0Glitch/toll-calculator/types"
+)
+
+// Row sums the line items of a period taxed alike: in one jurisdiction,
+// under one treatment, at one rate and in one currency.
+type Row struct {
+	Jurisdiction string             `json:"jurisdiction"`
+	Treatment    types.TaxTreatment `json:"treatment"`
+	Rate         money.Decimal      `json:"rate"`
+	Currency     money.Currency     `json:"currency"`
+	Net          money.Decimal      `json:"net"`
+	Tax          money.Decimal      `json:"tax"`
+	Gross        money.Decimal      `json:"gross"`
+	Lines        int                `json:"lines"`
+}
+
+// Summary is the tax report of a billing period: the issued invoices of
+// the period less their credit notes, summed per jurisdiction, treatment,
+// rate and currency. Drafts and void invoices aren't issued and left out.
+type Summary struct {
+	Period      string `json:"period"`
+	Invoices    int    `json:"invoices"`
+	CreditNotes int    `json:"creditNotes"`
+	Rows        []Row  `json:"rows"`
+}
+
+func NewSummary(period string) *Summary {
+	return &Summary{Period: period, Rows: []Row{}}
+}
+
+// AddInvoice adds the lines of an issued invoice of the period.
+func (s *Summary) AddInvoice(inv types.Invoice) {
+	if inv.Period != s.Period || inv.Status == types.InvoiceDraft || inv.Status == types.InvoiceVoid {
+		return
+	}
+	s.Invoices++
+	for _, l := range inv.Lines {
+		s.add(l, inv.Currency, false)
+	}
+}
+
+// AddCreditNote subtracts the lines of a credit note against an invoice
+// added before.
+func (s *Summary) AddCreditNote(n types.CreditNote) {
+	s.CreditNotes++
+	for _, l := range n.Lines {
+		s.add(l, n.Currency, true)
+	}
+}
+
+func (s *Summary) add(l types.InvoiceLine, c money.Currency, credit bool) {
+	net, tax := l.Amount, l.Tax
+	if credit {
+		net, tax = net.Neg(), tax.Neg()
+	}
+	r := s.row(l, c)
+	r.Net = r.Net.Add(net)
+	r.Tax = r.Tax.Add(tax)
+	r.Gross = r.Net.Add(r.Tax)
+	r.Lines++
+}
+
+// row returns the row of the lines taxed like l, adding it in order.
+func (s *Summary) row(l types.InvoiceLine, c money.Currency) *Row {
+	if i := s.find(l, c); i >= 0 {
+		return &s.Rows[i]
+	}
+	s.Rows = append(s.Rows, Row{Jurisdiction: l.Jurisdiction, Treatment: l.TaxTreatment, Rate: l.TaxRate, Currency: c})
+	sort.SliceStable(s.Rows, func(a, b int) bool {
+		ra, rb := s.Rows[a], s.Rows[b]
+		if ra.Jurisdiction != rb.Jurisdiction {
+			return ra.Jurisdiction < rb.Jurisdiction
+		}
+		if ra.Treatment != rb.Treatment {
+			return ra.Treatment < rb.Treatment
+		}
+		if ra.Currency != rb.Currency {
+			return ra.Currency < rb.Currency
+		}
+		return ra.Rate.Cmp(rb.Rate) < 0
+	})
+	return &s.Rows[s.find(l, c)]
+}
+
+func (s *Summary) find(l types.InvoiceLine, c money.Currency) int {
+	for i, r := range s.Rows {
+		if r.Jurisdiction == l.Jurisdiction && r.Treatment == l.TaxTreatment && r.Rate.Equal(l.TaxRate) && r.Currency == c {
+			return i
+		}
+	}
+	return -1
+}
@@ -62,11 +62,11 @@ func (suite *EndToEndTestSuite) TestCompleteFlow() {
 	assert.NotNil(suite.T(), invoice, "Invoice should not be nil")
 	assert.Equal(suite.T(), fixtures.TestOBUID1, invoice.OBUID)
 	assert.Greater(suite.T(), invoice.TotalDistance, 0.0, "Total distance should be greater than 0")
-	assert.Greater(suite.T(), invoice.Amount, 0.0, "Amount should be greater than 0")
+	assert.Greater(suite.T(), invoice.Amount.Float64(), 0.0, "Amount should be greater than 0")
 
 	// Verify the amount calculation
 	expectedAmount := invoice.TotalDistance * 315 // basePrice = 315
-	helpers.AssertFloatEquals(suite.T(), expectedAmount, invoice.Amount, 0.001)
+	helpers.AssertFloatEquals(suite.T(), expectedAmount, invoice.Amount.Float64(), 0.001)
 }
 
 // TestMultipleOBUs tests the system with multiple OBUs
@@ -110,7 +110,7 @@ func (suite *EndToEndTestSuite) TestMultipleOBUs() {
 		assert.NotNil(suite.T(), invoice, "Invoice should not be nil for OBU %d", obuID)
 		assert.Equal(suite.T(), obuID, invoice.OBUID)
 		assert.Greater(suite.T(), invoice.TotalDistance, 0.0, "Total distance should be greater than 0 for OBU %d", obuID)
-		assert.Greater(suite.T(), invoice.Amount, 0.0, "Amount should be greater than 0 for OBU %d", obuID)
+		assert.Greater(suite.T(), invoice.Amount.Float64(), 0.0, "Amount should be greater than 0 for OBU %d", obuID)
 	}
 }
 
@@ -157,7 +157,7 @@ func (suite *EndToEndTestSuite) TestHighVolumeData() {
 
 	suite.T().Logf("Processed %d data points in %v", numDataPoints, sendDuration)
 	suite.T().Logf("Final invoice: OBU=%d, Distance=%.2f, Amount=%.2f",
-		invoice.OBUID, invoice.TotalDistance, invoice.Amount)
+		invoice.OBUID, invoice.TotalDistance, invoice.Amount.Float64())
 }
 
 // TestErrorHandling tests error handling scenarios
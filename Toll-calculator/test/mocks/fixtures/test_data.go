@@ -3,6 +3,7 @@ package fixtures
 import (
 	"time"
 
+	"github.com/0x0Glitch/toll-calculator/money"
 	"github.com/0x0Glitch/toll-calculator/types"
 )
 
@@ -83,12 +84,12 @@ func GetSampleInvoices() []types.Invoice {
 		{
 			OBUID:         TestOBUID1,
 			TotalDistance: 25.7,
-			Amount:        25.7 * 315, // basePrice = 315
+			Amount:        money.FromFloat(25.7 * 315), // basePrice = 315
 		},
 		{
 			OBUID:         TestOBUID2,
 			TotalDistance: 21.0,
-			Amount:        21.0 * 315,
+			Amount:        money.FromFloat(21.0 * 315),
 		},
 	}
 }
@@ -139,7 +139,7 @@ func NewMockDataProducer() *MockDataProducer {
 	}
 }
 
-func (m *MockDataProducer) ProduceData(data types.OBUData) error {
+func (m *MockDataProducer) ProduceData(ctx context.Context, data types.OBUData) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
@@ -9,6 +9,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/0x0Glitch/toll-calculator/money"
 	"github.com/0x0Glitch/toll-calculator/types"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -117,7 +118,7 @@ func GenerateTestInvoice(obuID int32, totalDistance, amount float64) types.Invoi
 	return types.Invoice{
 		OBUID:         obuID,
 		TotalDistance: totalDistance,
-		Amount:        amount,
+		Amount:        money.FromFloat(amount),
 	}
 }
 
@@ -0,0 +1,281 @@
+package unit
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/0x0Glitch/toll-calculator/aggregator/client"
+	"github.com/0x0Glitch/toll-calculator/money"
+	"github.com/0x0Glitch/toll-calculator/test/fixtures"
+	"github.com/0x0Glitch/toll-calculator/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"google.golang.org/grpc"
+)
+
+// countingAggregator is a fake aggregator replica that counts the calls it receives
+type countingAggregator struct {
+	*httptest.Server
+	mu     sync.Mutex
+	calls  int
+	status int
+}
+
+func newCountingAggregator(status int) *countingAggregator {
+	a := &countingAggregator{status: status}
+	a.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a.mu.Lock()
+		a.calls++
+		status := a.status
+		a.mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	return a
+}
+
+func (a *countingAggregator) Calls() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.calls
+}
+
+// AggregatorClientTestSuite tests resolving and balancing across aggregator replicas
+type AggregatorClientTestSuite struct {
+	suite.Suite
+	replicas []*countingAggregator
+}
+
+// TearDownTest closes the fake replicas after each test
+func (suite *AggregatorClientTestSuite) TearDownTest() {
+	for _, r := range suite.replicas {
+		r.Close()
+	}
+	suite.replicas = nil
+}
+
+func (suite *AggregatorClientTestSuite) startReplicas(statuses ...int) []string {
+	addrs := make([]string, len(statuses))
+	for i, status := range statuses {
+		r := newCountingAggregator(status)
+		suite.replicas = append(suite.replicas, r)
+		addrs[i] = r.URL
+	}
+	return addrs
+}
+
+// invoiceServer is a fake aggregator gRPC server answering every invoice request with resp
+type invoiceServer struct {
+	types.UnimplementedAggregatorServer
+	resp *types.InvoiceResponse
+}
+
+func (s invoiceServer) GetInvoice(context.Context, *types.GetInvoiceRequest) (*types.InvoiceResponse, error) {
+	return s.resp, nil
+}
+
+func (suite *AggregatorClientTestSuite) startInvoiceServer(resp *types.InvoiceResponse) *client.GRPCClient {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(suite.T(), err)
+	srv := grpc.NewServer()
+	types.RegisterAggregatorServer(srv, invoiceServer{resp: resp})
+	go srv.Serve(ln)
+	suite.T().Cleanup(srv.Stop)
+	c, err := client.NewGRPCClient(ln.Addr().String())
+	require.NoError(suite.T(), err)
+	suite.T().Cleanup(func() { c.Close() })
+	return c
+}
+
+// TestGRPCClient_DialsResolvedHTTPAddress tests that an http:// address listed for both transports is dialed by its host
+func (suite *AggregatorClientTestSuite) TestGRPCClient_DialsResolvedHTTPAddress() {
+	// Arrange
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(suite.T(), err)
+	srv := grpc.NewServer()
+	types.RegisterAggregatorServer(srv, invoiceServer{resp: &types.InvoiceResponse{ObuID: 7, Amount: "10.00", Currency: "EUR"}})
+	go srv.Serve(ln)
+	defer srv.Stop()
+	resolver, err := client.ParseResolver("http://" + ln.Addr().String() + "/")
+	require.NoError(suite.T(), err)
+	c := client.NewBalancedGRPCClient(client.NewBalancer(resolver))
+	defer c.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Act
+	inv, err := c.GetInvoice(ctx, 7)
+
+	// Assert
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), int32(7), inv.OBUID)
+	assert.Equal(suite.T(), "10.00", inv.Amount.String())
+}
+
+func aggregateRequest() *types.AggregatorRequest {
+	return &types.AggregatorRequest{ObuID: fixtures.TestOBUID1, Value: 10.5, Unix: time.Now().Unix()}
+}
+
+// TestRoundRobin_SpreadsCallsEvenly tests that every replica gets the same share of calls
+func (suite *AggregatorClientTestSuite) TestRoundRobin_SpreadsCallsEvenly() {
+	// Arrange
+	addrs := suite.startReplicas(http.StatusOK, http.StatusOK, http.StatusOK)
+	c := client.NewBalancedHTTPClient(client.NewBalancer(client.NewStaticResolver(addrs...)))
+
+	// Act
+	for i := 0; i < 9; i++ {
+		assert.NoError(suite.T(), c.Aggregate(context.Background(), aggregateRequest()))
+	}
+
+	// Assert
+	for _, r := range suite.replicas {
+		assert.Equal(suite.T(), 3, r.Calls())
+	}
+}
+
+// TestEjection_FailingReplicaStopsReceivingCalls tests that a replica answering 5xx is ejected
+func (suite *AggregatorClientTestSuite) TestEjection_FailingReplicaStopsReceivingCalls() {
+	// Arrange
+	addrs := suite.startReplicas(http.StatusOK, http.StatusInternalServerError)
+	b := client.NewBalancer(client.NewStaticResolver(addrs...), client.WithEjection(2, time.Minute))
+	c := client.NewBalancedHTTPClient(b)
+
+	// Act
+	for i := 0; i < 20; i++ {
+		c.Aggregate(context.Background(), aggregateRequest())
+	}
+
+	// Assert
+	assert.Equal(suite.T(), 2, suite.replicas[1].Calls())
+	assert.Equal(suite.T(), 18, suite.replicas[0].Calls())
+}
+
+// TestEjection_AllReplicasEjected tests that the client keeps trying when every replica is ejected
+func (suite *AggregatorClientTestSuite) TestEjection_AllReplicasEjected() {
+	// Arrange
+	addrs := suite.startReplicas(http.StatusInternalServerError)
+	c := client.NewBalancedHTTPClient(client.NewBalancer(client.NewStaticResolver(addrs...), client.WithEjection(1, time.Minute)))
+
+	// Act
+	for i := 0; i < 3; i++ {
+		assert.Error(suite.T(), c.Aggregate(context.Background(), aggregateRequest()))
+	}
+
+	// Assert
+	assert.Equal(suite.T(), 3, suite.replicas[0].Calls())
+}
+
+// TestLeastLoaded_PrefersIdleEndpoint tests that the least loaded picker avoids busy endpoints
+func (suite *AggregatorClientTestSuite) TestLeastLoaded_PrefersIdleEndpoint() {
+	// Arrange
+	b := client.NewBalancer(client.NewStaticResolver("a:3000", "b:3000"), client.WithPicker(client.LeastLoaded()))
+	busy, err := b.Pick(context.Background())
+	require.NoError(suite.T(), err)
+
+	// Act
+	picks := make(map[string]int)
+	for i := 0; i < 4; i++ {
+		ep, err := b.Pick(context.Background())
+		require.NoError(suite.T(), err)
+		picks[ep.Addr]++
+		b.Done(ep, nil)
+	}
+
+	// Assert
+	assert.Equal(suite.T(), 0, picks[busy.Addr])
+	assert.Equal(suite.T(), int64(1), busy.Inflight())
+}
+
+// TestFileResolver_ReloadsOnChange tests that edits to the endpoint file are picked up
+func (suite *AggregatorClientTestSuite) TestFileResolver_ReloadsOnChange() {
+	// Arrange
+	path := filepath.Join(suite.T().TempDir(), "aggregators")
+	require.NoError(suite.T(), os.WriteFile(path, []byte("# replicas\nhttp://a:3000\n\nhttp://b:3000\n"), 0o644))
+	r := client.NewFileResolver(path)
+
+	// Act
+	first, err := r.Resolve(context.Background())
+	require.NoError(suite.T(), err)
+	require.NoError(suite.T(), os.WriteFile(path, []byte("http://c:3000\n"), 0o644))
+	require.NoError(suite.T(), os.Chtimes(path, time.Now(), time.Now().Add(time.Second)))
+	second, err := r.Resolve(context.Background())
+	require.NoError(suite.T(), err)
+
+	// Assert
+	assert.Equal(suite.T(), []string{"http://a:3000", "http://b:3000"}, first)
+	assert.Equal(suite.T(), []string{"http://c:3000"}, second)
+}
+
+// TestParseResolver_Targets tests the supported resolver target formats
+func (suite *AggregatorClientTestSuite) TestParseResolver_Targets() {
+	testCases := []struct {
+		target   string
+		expected interface{}
+		wantErr  bool
+	}{
+		{"http://a:3000,http://b:3000", &client.StaticResolver{}, false},
+		{"static://a:3000", &client.StaticResolver{}, false},
+		{"srv://_aggregator._tcp.toll.local", &client.SRVResolver{}, false},
+		{"file:///etc/toll/aggregators", &client.FileResolver{}, false},
+		{"srv://toll.local", nil, true},
+		{"", nil, true},
+	}
+
+	for _, tc := range testCases {
+		r, err := client.ParseResolver(tc.target)
+		if tc.wantErr {
+			assert.Error(suite.T(), err, tc.target)
+			continue
+		}
+		assert.NoError(suite.T(), err, tc.target)
+		assert.IsType(suite.T(), tc.expected, r, tc.target)
+	}
+}
+
+// Run the test suite
+// TestGRPCClientGetInvoice_ExactAmounts tests that amounts read over gRPC are the exact decimals sent, in the invoice's currency
+func (suite *AggregatorClientTestSuite) TestGRPCClientGetInvoice_ExactAmounts() {
+	// Arrange
+	c := suite.startInvoiceServer(&types.InvoiceResponse{
+		ObuID:    7,
+		Amount:   "0.30",
+		Currency: "CHF",
+		Zones: []*types.ZoneCharge{
+			{ZoneID: "city", Distance: 1, Amount: "0.10"},
+			{ZoneID: "A1", Distance: 2, Amount: "0.20"},
+		},
+	})
+
+	// Act
+	inv, err := c.GetInvoice(context.Background(), 7)
+
+	// Assert
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "0.30", inv.Amount.String())
+	assert.Equal(suite.T(), money.Currency("CHF"), inv.Currency)
+	require.Len(suite.T(), inv.Zones, 2)
+	assert.True(suite.T(), inv.Zones[0].Amount.Add(inv.Zones[1].Amount).Equal(inv.Amount))
+}
+
+// TestGRPCClientGetInvoice_InvalidAmount tests that an amount that isn't a decimal fails the call rather than reading as 0
+func (suite *AggregatorClientTestSuite) TestGRPCClientGetInvoice_InvalidAmount() {
+	// Arrange
+	c := suite.startInvoiceServer(&types.InvoiceResponse{ObuID: 7, Amount: "0,30"})
+
+	// Act
+	_, err := c.GetInvoice(context.Background(), 7)
+
+	// Assert
+	assert.Error(suite.T(), err)
+}
+
+func TestAggregatorClientTestSuite(t *testing.T) {
+	suite.Run(t, new(AggregatorClientTestSuite))
+}
@@ -0,0 +1,555 @@
+package unit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/0x0Glitch/toll-calculator/aggregator/client"
+	"github.com/0x0Glitch/toll-calculator/aggregator/cluster"
+	"github.com/0x0Glitch/toll-calculator/apperr"
+	"github.com/0x0Glitch/toll-calculator/billing"
+	"github.com/0x0Glitch/toll-calculator/money"
+	"github.com/0x0Glitch/toll-calculator/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"google.golang.org/grpc"
+)
+
+// shardStore is a thread-safe store of totals by day and toll zone that supports shard handoff
+type shardStore struct {
+	mu   sync.Mutex
+	data map[int32]types.DailyTotals
+}
+
+func newShardStore() *shardStore {
+	return &shardStore{data: make(map[int32]types.DailyTotals)}
+}
+
+func (s *shardStore) Insert(d *types.Distance) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.data[d.OBUID] == nil {
+		s.data[d.OBUID] = make(types.DailyTotals)
+	}
+	s.data[d.OBUID].Add(d)
+	return nil
+}
+
+func (s *shardStore) Get(id int32) (float64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	days, ok := s.data[id]
+	if !ok {
+		return 0, fmt.Errorf("couldn't find distance for id: %d", id)
+	}
+	dist := 0.0
+	for _, t := range days.Sum() {
+		dist += t.Distance
+	}
+	return dist, nil
+}
+
+func (s *shardStore) zone(id int32, zone string) types.Total {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data[id].Sum()[zone]
+}
+
+// days returns the days the store holds distance of id on
+func (s *shardStore) days(id int32) []time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.SortedFunc(maps.Keys(s.data[id]), time.Time.Compare)
+}
+
+func (s *shardStore) IDs() []int32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := make([]int32, 0, len(s.data))
+	for id := range s.data {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func (s *shardStore) Take(id int32) (types.DailyTotals, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	days, ok := s.data[id]
+	if !ok {
+		return nil, fmt.Errorf("couldn't find distance for id: %d", id)
+	}
+	delete(s.data, id)
+	return days, nil
+}
+
+// TakeBefore removes the totals of id of the days before end
+func (s *shardStore) TakeBefore(id int32, end time.Time) (types.DailyTotals, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	taken := make(types.DailyTotals)
+	for day, zones := range s.data[id] {
+		if day.Before(end) {
+			taken[day] = zones
+			delete(s.data[id], day)
+		}
+	}
+	if len(taken) == 0 {
+		return nil, apperr.NotFoundf("couldn't find distance for id %d before %s", id, end)
+	}
+	if len(s.data[id]) == 0 {
+		delete(s.data, id)
+	}
+	return taken, nil
+}
+
+// inProcessPeer lets nodes call each other without a network, honouring the forwarded marker
+type inProcessPeer struct {
+	node *cluster.Node
+}
+
+func (p inProcessPeer) target(ctx context.Context) cluster.Aggregator {
+	if client.IsForwarded(ctx) {
+		return p.node.Local()
+	}
+	return p.node
+}
+
+func (p inProcessPeer) Aggregate(ctx context.Context, req *types.AggregatorRequest) error {
+	return p.target(ctx).AggregateDistance(&types.Distance{OBUID: req.ObuID, Values: req.Value, Unix: req.Unix, ZoneID: req.ZoneID, Estimated: req.Estimated, Trip: req.Trip.Leg(), OffPeak: req.OffPeak})
+}
+
+func (p inProcessPeer) GetInvoice(ctx context.Context, id int) (*types.Invoice, error) {
+	return p.target(ctx).CalculateInvoice(int32(id))
+}
+
+// AggregatorClusterTestSuite tests sharding aggregator state over several in-process nodes
+type AggregatorClusterTestSuite struct {
+	suite.Suite
+	mu     sync.Mutex
+	nodes  map[string]*cluster.Node
+	stores map[string]*shardStore
+	down   map[string]bool
+}
+
+// SetupTest starts a fresh three node cluster before each test
+func (suite *AggregatorClusterTestSuite) SetupTest() {
+	suite.nodes = make(map[string]*cluster.Node)
+	suite.stores = make(map[string]*shardStore)
+	suite.down = make(map[string]bool)
+	for _, addr := range []string{"node-a", "node-b", "node-c"} {
+		suite.addNode(addr)
+	}
+	suite.setMembers("node-a", "node-b", "node-c")
+}
+
+func (suite *AggregatorClusterTestSuite) addNode(addr string) *cluster.Node {
+	store := newShardStore()
+	node := cluster.NewNode(addr, NewInvoiceAggregator(store), store, suite.dial)
+	suite.mu.Lock()
+	suite.nodes[addr] = node
+	suite.stores[addr] = store
+	suite.mu.Unlock()
+	return node
+}
+
+func (suite *AggregatorClusterTestSuite) dial(addr string) (client.Client, error) {
+	suite.mu.Lock()
+	defer suite.mu.Unlock()
+	node, ok := suite.nodes[addr]
+	if !ok || suite.down[addr] {
+		return nil, fmt.Errorf("node %s unreachable", addr)
+	}
+	return inProcessPeer{node: node}, nil
+}
+
+func (suite *AggregatorClusterTestSuite) setMembers(members ...string) {
+	for _, addr := range members {
+		require.NoError(suite.T(), suite.nodes[addr].SetMembers(members))
+	}
+}
+
+func (suite *AggregatorClusterTestSuite) aggregateThroughEveryNode(obuIDs int) {
+	addrs := suite.nodes["node-a"].Members()
+	for id := 1; id <= obuIDs; id++ {
+		for _, addr := range addrs {
+			err := suite.nodes[addr].AggregateDistance(&types.Distance{OBUID: int32(id), Values: 1.5})
+			require.NoError(suite.T(), err)
+		}
+	}
+}
+
+func (suite *AggregatorClusterTestSuite) totalStored() float64 {
+	total := 0.0
+	for _, store := range suite.stores {
+		for _, id := range store.IDs() {
+			dist, _ := store.Get(id)
+			total += dist
+		}
+	}
+	return total
+}
+
+// TestAggregate_LandsOnOwner tests that every total is stored only on the owning node
+func (suite *AggregatorClusterTestSuite) TestAggregate_LandsOnOwner() {
+	// Act
+	suite.aggregateThroughEveryNode(100)
+
+	// Assert
+	for addr, store := range suite.stores {
+		assert.NotEmpty(suite.T(), store.IDs(), "node %s owns no shard", addr)
+		for _, id := range store.IDs() {
+			assert.Equal(suite.T(), addr, suite.nodes["node-a"].Owner(id))
+			dist, _ := store.Get(id)
+			assert.InDelta(suite.T(), 4.5, dist, 0.001)
+		}
+	}
+}
+
+// TestCalculateInvoice_FromAnyNode tests that invoices can be requested from any node
+func (suite *AggregatorClusterTestSuite) TestCalculateInvoice_FromAnyNode() {
+	// Arrange
+	suite.aggregateThroughEveryNode(20)
+
+	// Act & Assert
+	for _, node := range suite.nodes {
+		for id := int32(1); id <= 20; id++ {
+			inv, err := node.CalculateInvoice(id)
+			require.NoError(suite.T(), err)
+			assert.Equal(suite.T(), id, inv.OBUID)
+			assert.InDelta(suite.T(), 4.5, inv.TotalDistance, 0.001)
+		}
+	}
+}
+
+// TestJoin_HandsOffShards tests that a new node receives its shards without losing totals
+func (suite *AggregatorClusterTestSuite) TestJoin_HandsOffShards() {
+	// Arrange
+	suite.aggregateThroughEveryNode(200)
+	before := suite.totalStored()
+	suite.addNode("node-d")
+
+	// Act
+	suite.setMembers("node-a", "node-b", "node-c", "node-d")
+
+	// Assert
+	assert.InDelta(suite.T(), before, suite.totalStored(), 0.001)
+	moved := len(suite.stores["node-d"].IDs())
+	assert.NotZero(suite.T(), moved)
+	assert.Less(suite.T(), moved, 100, "consistent hashing should only move a fraction of the shards")
+	for addr, store := range suite.stores {
+		for _, id := range store.IDs() {
+			assert.Equal(suite.T(), addr, suite.nodes["node-d"].Owner(id))
+		}
+	}
+}
+
+// TestJoin_HandsOffZoneTotals tests that the totals of every toll zone move to the new owner
+func (suite *AggregatorClusterTestSuite) TestJoin_HandsOffZoneTotals() {
+	// Arrange
+	suite.addNode("node-d")
+	next := []string{"node-a", "node-b", "node-c", "node-d"}
+	require.NoError(suite.T(), suite.nodes["node-d"].SetMembers(next))
+	var obuID int32
+	for id := int32(1); obuID == 0; id++ {
+		if suite.nodes["node-d"].Owner(id) == "node-d" {
+			obuID = id
+		}
+	}
+	for _, zone := range []string{"", "city", "A1"} {
+		err := suite.nodes["node-a"].AggregateDistance(&types.Distance{OBUID: obuID, Values: 2.5, ZoneID: zone})
+		require.NoError(suite.T(), err)
+	}
+
+	// Act
+	suite.setMembers(next...)
+
+	// Assert
+	store := suite.stores["node-d"]
+	assert.Equal(suite.T(), types.Total{Distance: 2.5}, store.zone(obuID, ""))
+	assert.Equal(suite.T(), types.Total{Distance: 2.5}, store.zone(obuID, "city"))
+	assert.Equal(suite.T(), types.Total{Distance: 2.5}, store.zone(obuID, "A1"))
+}
+
+// TestJoin_HandsOffEstimatedDistance tests that distance estimated over gaps stays flagged when handed to the new owner
+func (suite *AggregatorClusterTestSuite) TestJoin_HandsOffEstimatedDistance() {
+	// Arrange
+	suite.addNode("node-d")
+	next := []string{"node-a", "node-b", "node-c", "node-d"}
+	require.NoError(suite.T(), suite.nodes["node-d"].SetMembers(next))
+	var obuID int32
+	for id := int32(1); obuID == 0; id++ {
+		if suite.nodes["node-d"].Owner(id) == "node-d" {
+			obuID = id
+		}
+	}
+	for _, d := range []types.Distance{
+		{OBUID: obuID, Values: 3, ZoneID: "city"},
+		{OBUID: obuID, Values: 1.5, ZoneID: "city", Estimated: true},
+		{OBUID: obuID, Values: 2, ZoneID: "A1", Estimated: true},
+	} {
+		require.NoError(suite.T(), suite.nodes["node-a"].AggregateDistance(&d))
+	}
+
+	// Act
+	suite.setMembers(next...)
+
+	// Assert
+	store := suite.stores["node-d"]
+	assert.Equal(suite.T(), types.Total{Distance: 4.5, Estimated: 1.5}, store.zone(obuID, "city"))
+	assert.Equal(suite.T(), types.Total{Distance: 2, Estimated: 2}, store.zone(obuID, "A1"))
+}
+
+// TestJoin_HandsOffOffPeakDistance tests that the off-peak split of a total survives the handoff to the new owner
+func (suite *AggregatorClusterTestSuite) TestJoin_HandsOffOffPeakDistance() {
+	// Arrange
+	suite.addNode("node-d")
+	next := []string{"node-a", "node-b", "node-c", "node-d"}
+	require.NoError(suite.T(), suite.nodes["node-d"].SetMembers(next))
+	var obuID int32
+	for id := int32(1); obuID == 0; id++ {
+		if suite.nodes["node-d"].Owner(id) == "node-d" {
+			obuID = id
+		}
+	}
+	for _, d := range []types.Distance{
+		{OBUID: obuID, Values: 3, ZoneID: "city"},
+		{OBUID: obuID, Values: 1.5, ZoneID: "city", OffPeak: true},
+		{OBUID: obuID, Values: 0.5, ZoneID: "city", OffPeak: true, Estimated: true},
+		{OBUID: obuID, Values: 2, ZoneID: "A1", OffPeak: true},
+	} {
+		require.NoError(suite.T(), suite.nodes["node-a"].AggregateDistance(&d))
+	}
+
+	// Act
+	suite.setMembers(next...)
+
+	// Assert
+	store := suite.stores["node-d"]
+	assert.Equal(suite.T(), types.Total{Distance: 5, Estimated: 0.5, OffPeak: 2}, store.zone(obuID, "city"))
+	assert.Equal(suite.T(), types.Total{Distance: 2, OffPeak: 2}, store.zone(obuID, "A1"))
+}
+
+// TestJoin_HandoffKeepsDays tests that distance handed to the new owner stays on the day it was travelled, so it is billed in its own period
+func (suite *AggregatorClusterTestSuite) TestJoin_HandoffKeepsDays() {
+	// Arrange
+	suite.addNode("node-d")
+	next := []string{"node-a", "node-b", "node-c", "node-d"}
+	require.NoError(suite.T(), suite.nodes["node-d"].SetMembers(next))
+	var obuID int32
+	for id := int32(1); obuID == 0; id++ {
+		if suite.nodes["node-d"].Owner(id) == "node-d" {
+			obuID = id
+		}
+	}
+	lastMonth := time.Date(2025, 9, 30, 23, 0, 0, 0, time.UTC)
+	thisMonth := time.Date(2025, 10, 1, 1, 0, 0, 0, time.UTC)
+	for _, d := range []types.Distance{
+		{OBUID: obuID, Values: 3, ZoneID: "city", Unix: lastMonth.UnixNano()},
+		{OBUID: obuID, Values: 2, ZoneID: "city", Unix: thisMonth.UnixNano()},
+	} {
+		require.NoError(suite.T(), suite.nodes["node-a"].AggregateDistance(&d))
+	}
+
+	// Act
+	suite.setMembers(next...)
+
+	// Assert
+	store := suite.stores["node-d"]
+	assert.Equal(suite.T(), []time.Time{
+		time.Date(2025, 9, 30, 0, 0, 0, 0, time.UTC),
+		time.Date(2025, 10, 1, 0, 0, 0, 0, time.UTC),
+	}, store.days(obuID))
+	assert.Equal(suite.T(), types.Total{Distance: 5}, store.zone(obuID, "city"))
+}
+
+// TestClose_AfterHandoffBillsOnce tests that totals of a vehicle left on a member that no longer owns it are billed on the owner's one invoice
+func (suite *AggregatorClusterTestSuite) TestClose_AfterHandoffBillsOnce() {
+	// Arrange
+	var obuID int32
+	for id := int32(1); obuID == 0; id++ {
+		if suite.nodes["node-a"].Owner(id) == "node-b" {
+			obuID = id
+		}
+	}
+	// node-a took distance with a stale view of the ring, after the handoff.
+	require.NoError(suite.T(), suite.stores["node-b"].Insert(&types.Distance{OBUID: obuID, Values: 3, ZoneID: "A", Unix: time.Date(2025, 9, 29, 8, 0, 0, 0, time.UTC).UnixNano()}))
+	require.NoError(suite.T(), suite.stores["node-a"].Insert(&types.Distance{OBUID: obuID, Values: 2, ZoneID: "A", Unix: time.Date(2025, 9, 30, 8, 0, 0, 0, time.UTC).UnixNano()}))
+	books := map[string]*billing.Book{}
+	for _, addr := range []string{"node-a", "node-b"} {
+		books[addr] = billing.NewBook()
+	}
+
+	// Act
+	for _, addr := range []string{"node-a", "node-b"} {
+		closer := billing.NewCloser(books[addr], suite.nodes[addr].Owned(suite.stores[addr]), flatTariff{}, billing.Pricing{Currency: "EUR"}, billing.Monthly, false)
+		_, err := closer.CloseInvoices(context.Background(), "2025-09")
+		require.NoError(suite.T(), err, addr)
+	}
+
+	// Assert
+	id := fmt.Sprintf("INV-2025-09-%d", obuID)
+	_, err := books["node-a"].Invoice(context.Background(), id)
+	assert.True(suite.T(), apperr.IsCode(err, apperr.NotFound), "%v", err)
+	inv, err := books["node-b"].Invoice(context.Background(), id)
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), 5.0, inv.TotalDistance)
+	assert.Equal(suite.T(), "10.00", inv.Amount.String())
+	assert.Empty(suite.T(), suite.stores["node-a"].IDs())
+}
+
+// TestAggregate_ForwardKeepsOffPeak tests that distance marked off-peak by the node it arrived at stays marked on its owner
+func (suite *AggregatorClusterTestSuite) TestAggregate_ForwardKeepsOffPeak() {
+	// Arrange
+	var obuID int32
+	for id := int32(1); obuID == 0; id++ {
+		if suite.nodes["node-a"].Owner(id) != "node-a" {
+			obuID = id
+		}
+	}
+	owner := suite.nodes["node-a"].Owner(obuID)
+
+	// Act
+	err := suite.nodes["node-a"].AggregateDistance(&types.Distance{OBUID: obuID, Values: 4, OffPeak: true})
+
+	// Assert
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), types.Total{Distance: 4, OffPeak: 4}, suite.stores[owner].zone(obuID, ""))
+}
+
+// TestHTTPClientAggregate_CarriesOffPeak tests that the off-peak mark reaches the node a distance is handed to over HTTP
+func (suite *AggregatorClusterTestSuite) TestHTTPClientAggregate_CarriesOffPeak() {
+	// Arrange
+	var got types.Distance
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(suite.T(), json.NewDecoder(r.Body).Decode(&got))
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	// Act
+	err := client.NewHTTPClient(srv.URL).Aggregate(client.WithForwarded(context.Background()), &types.AggregatorRequest{ObuID: 7, Value: 4, OffPeak: true})
+
+	// Assert
+	require.NoError(suite.T(), err)
+	assert.True(suite.T(), got.OffPeak)
+}
+
+// TestCalculateInvoice_ForwardKeepsTax tests that the tax of an invoice forwarded from its owner survives both transports
+func (suite *AggregatorClusterTestSuite) TestCalculateInvoice_ForwardKeepsTax() {
+	// Arrange
+	want := &types.Invoice{
+		OBUID:    7,
+		Currency: "EUR",
+		Amount:   money.MustParse("10.00"),
+		Tax:      money.MustParse("1.90"),
+		Gross:    money.MustParse("11.90"),
+	}
+	lsn, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(suite.T(), err)
+	srv := grpc.NewServer()
+	types.RegisterAggregatorServer(srv, invoiceServer{resp: &types.InvoiceResponse{
+		ObuID:    want.OBUID,
+		Currency: string(want.Currency),
+		Amount:   want.Amount.String(),
+		Tax:      want.Tax.String(),
+		Gross:    want.Gross.String(),
+	}})
+	go srv.Serve(lsn)
+	defer srv.Stop()
+	httpSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(want)
+	}))
+	defer httpSrv.Close()
+	dialers := map[string]cluster.Dialer{
+		"grpc": func(string) (client.Client, error) { return client.NewGRPCClient(lsn.Addr().String()) },
+		"http": func(string) (client.Client, error) { return client.NewHTTPClient(httpSrv.URL), nil },
+	}
+	for name, dial := range dialers {
+		node := cluster.NewNode("node-a", NewInvoiceAggregator(newShardStore()), newShardStore(), dial)
+		require.NoError(suite.T(), node.SetMembers([]string{"node-a", "node-b"}))
+		var obuID int32
+		for id := int32(1); obuID == 0; id++ {
+			if node.Owner(id) == "node-b" {
+				obuID = id
+			}
+		}
+
+		// Act
+		inv, err := node.CalculateInvoice(obuID)
+
+		// Assert
+		require.NoError(suite.T(), err, name)
+		assert.Equal(suite.T(), "1.90", inv.Tax.String(), name)
+		assert.Equal(suite.T(), "11.90", inv.Gross.String(), name)
+		assert.Equal(suite.T(), money.Currency("EUR"), inv.Currency, name)
+	}
+}
+
+// TestLeave_DrainsNode tests that a leaving node hands all of its totals to the remaining nodes
+func (suite *AggregatorClusterTestSuite) TestLeave_DrainsNode() {
+	// Arrange
+	suite.aggregateThroughEveryNode(100)
+	before := suite.totalStored()
+
+	// Act
+	require.NoError(suite.T(), suite.nodes["node-c"].Leave())
+	suite.setMembers("node-a", "node-b")
+
+	// Assert
+	assert.Empty(suite.T(), suite.stores["node-c"].IDs())
+	assert.InDelta(suite.T(), before, suite.totalStored(), 0.001)
+	inv, err := suite.nodes["node-a"].CalculateInvoice(42)
+	require.NoError(suite.T(), err)
+	assert.InDelta(suite.T(), 4.5, inv.TotalDistance, 0.001)
+}
+
+// TestHandoff_UnreachableOwnerKeepsTotals tests that totals stay put when the new owner can't be reached
+func (suite *AggregatorClusterTestSuite) TestHandoff_UnreachableOwnerKeepsTotals() {
+	// Arrange
+	suite.aggregateThroughEveryNode(50)
+	before := suite.totalStored()
+	suite.addNode("node-d")
+	suite.down["node-d"] = true
+
+	// Act
+	err := suite.nodes["node-a"].SetMembers([]string{"node-a", "node-b", "node-c", "node-d"})
+
+	// Assert
+	assert.Error(suite.T(), err)
+	assert.Empty(suite.T(), suite.stores["node-d"].IDs())
+	assert.InDelta(suite.T(), before, suite.totalStored(), 0.001)
+}
+
+// TestRing_SpreadsOBUsEvenly tests that virtual nodes keep shard sizes balanced
+func (suite *AggregatorClusterTestSuite) TestRing_SpreadsOBUsEvenly() {
+	// Arrange
+	ring := cluster.NewRing([]string{"node-a", "node-b", "node-c"}, 0)
+	counts := make(map[string]int)
+
+	// Act
+	for id := int32(0); id < 30000; id++ {
+		counts[ring.Owner(id)]++
+	}
+
+	// Assert
+	assert.Len(suite.T(), counts, 3)
+	for member, n := range counts {
+		assert.InDelta(suite.T(), 10000, n, 2500, "member %s", member)
+	}
+}
+
+// Run the cluster test suite
+func TestAggregatorClusterTestSuite(t *testing.T) {
+	suite.Run(t, new(AggregatorClusterTestSuite))
+}
@@ -0,0 +1,151 @@
+package unit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/0x0Glitch/toll-calculator/aggregator/metrics"
+	"github.com/0x0Glitch/toll-calculator/apperr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// AggregatorMetricsTestSuite tests the labelled aggregator metrics
+type AggregatorMetricsTestSuite struct {
+	suite.Suite
+	registry *prometheus.Registry
+	metrics  *metrics.Metrics
+}
+
+// SetupTest registers the metrics with a fresh registry before each test
+func (suite *AggregatorMetricsTestSuite) SetupTest() {
+	suite.registry = prometheus.NewRegistry()
+	suite.metrics = metrics.New(suite.registry)
+}
+
+// value returns the counter or gauge value, or the histogram sample count,
+// of the series of the named family with exactly the given labels.
+func (suite *AggregatorMetricsTestSuite) value(name string, labels map[string]string) float64 {
+	families, err := suite.registry.Gather()
+	require.NoError(suite.T(), err)
+	for _, f := range families {
+		if f.GetName() != name {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			got := make(map[string]string)
+			for _, l := range m.GetLabel() {
+				got[l.GetName()] = l.GetValue()
+			}
+			if !assert.ObjectsAreEqual(labels, got) {
+				continue
+			}
+			switch {
+			case m.Counter != nil:
+				return m.GetCounter().GetValue()
+			case m.Gauge != nil:
+				return m.GetGauge().GetValue()
+			case m.Histogram != nil:
+				return float64(m.GetHistogram().GetSampleCount())
+			}
+		}
+	}
+	return 0
+}
+
+// TestHTTPHandler_LabelsStatusCode tests that HTTP requests are counted by method and status code
+func (suite *AggregatorMetricsTestSuite) TestHTTPHandler_LabelsStatusCode() {
+	// Arrange
+	handler := suite.metrics.HTTPHandler("GetInvoice", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("obu") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte("{}"))
+	}))
+
+	// Act
+	for _, target := range []string{"/invoice?obu=1", "/invoice?obu=2", "/invoice"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+	}
+
+	// Assert
+	ok := map[string]string{"transport": "http", "method": "GetInvoice", "status_code": "200"}
+	bad := map[string]string{"transport": "http", "method": "GetInvoice", "status_code": "400"}
+	assert.Equal(suite.T(), 2.0, suite.value("toll_aggregator_requests_total", ok))
+	assert.Equal(suite.T(), 1.0, suite.value("toll_aggregator_requests_total", bad))
+	assert.Equal(suite.T(), 2.0, suite.value("toll_aggregator_request_duration_seconds", ok))
+	assert.Equal(suite.T(), 0.0, suite.value("toll_aggregator_requests_in_flight", map[string]string{"transport": "http"}))
+}
+
+// TestUnaryServerInterceptor_LabelsGRPCCode tests that gRPC calls are counted by RPC name and status code
+func (suite *AggregatorMetricsTestSuite) TestUnaryServerInterceptor_LabelsGRPCCode() {
+	// Arrange
+	interceptor := suite.metrics.UnaryServerInterceptor()
+	info := &grpc.UnaryServerInfo{FullMethod: "/types.Aggregator/GetInvoice"}
+	found := func(ctx context.Context, req any) (any, error) { return "invoice", nil }
+	notFound := func(ctx context.Context, req any) (any, error) {
+		return nil, status.Error(codes.NotFound, "couldn't find distance")
+	}
+
+	// Act
+	_, foundErr := interceptor(context.Background(), nil, info, found)
+	_, notFoundErr := interceptor(context.Background(), nil, info, notFound)
+
+	// Assert
+	require.NoError(suite.T(), foundErr)
+	assert.Equal(suite.T(), codes.NotFound, status.Code(notFoundErr))
+	assert.Equal(suite.T(), 1.0, suite.value("toll_aggregator_requests_total",
+		map[string]string{"transport": "grpc", "method": "GetInvoice", "status_code": "OK"}))
+	assert.Equal(suite.T(), 1.0, suite.value("toll_aggregator_requests_total",
+		map[string]string{"transport": "grpc", "method": "GetInvoice", "status_code": "NotFound"}))
+	assert.Equal(suite.T(), 0.0, suite.value("toll_aggregator_requests_in_flight", map[string]string{"transport": "grpc"}))
+}
+
+// TestObserveCall_LabelsErrorCode tests that service calls are counted by apperr code
+func (suite *AggregatorMetricsTestSuite) TestObserveCall_LabelsErrorCode() {
+	// Act
+	suite.metrics.ObserveCall("CalculateInvoice", time.Now(), nil)
+	suite.metrics.ObserveCall("CalculateInvoice", time.Now(), apperr.NotFoundf("couldn't find distance for id: %d", 1))
+	suite.metrics.ObserveCall("AggregateDistance", time.Now(), errors.New("boom"))
+
+	// Assert
+	assert.Equal(suite.T(), 1.0, suite.value("toll_aggregator_service_calls_total",
+		map[string]string{"method": "CalculateInvoice", "code": "ok"}))
+	assert.Equal(suite.T(), 1.0, suite.value("toll_aggregator_service_calls_total",
+		map[string]string{"method": "CalculateInvoice", "code": "not_found"}))
+	assert.Equal(suite.T(), 1.0, suite.value("toll_aggregator_service_calls_total",
+		map[string]string{"method": "AggregateDistance", "code": "internal"}))
+	assert.Equal(suite.T(), 2.0, suite.value("toll_aggregator_service_call_duration_seconds",
+		map[string]string{"method": "CalculateInvoice"}))
+}
+
+// TestLint_NoProblems tests that every metric follows the Prometheus naming conventions
+func (suite *AggregatorMetricsTestSuite) TestLint_NoProblems() {
+	// Arrange
+	handler := suite.metrics.HTTPHandler("Aggregate", http.NotFoundHandler())
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/aggregate", nil))
+	suite.metrics.ObserveCall("AggregateDistance", time.Now(), nil)
+
+	// Act
+	problems, err := testutil.GatherAndLint(suite.registry)
+
+	// Assert
+	require.NoError(suite.T(), err)
+	assert.Empty(suite.T(), problems)
+}
+
+// Run the aggregator metrics test suite
+func TestAggregatorMetricsTestSuite(t *testing.T) {
+	suite.Run(t, new(AggregatorMetricsTestSuite))
+}
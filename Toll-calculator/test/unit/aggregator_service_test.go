@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"testing"
 
+	"github.com/0x0Glitch/toll-calculator/money"
 	"github.com/0x0Glitch/toll-calculator/test/fixtures"
 	"github.com/0x0Glitch/toll-calculator/test/helpers"
 	"github.com/0x0Glitch/toll-calculator/types"
@@ -47,7 +48,7 @@ func (i *InvoiceAggregator) CalculateInvoice(obuID int32) (*types.Invoice, error
 	inv := &types.Invoice{
 		OBUID:         obuID,
 		TotalDistance: dist,
-		Amount:        basePrice * dist,
+		Amount:        money.FromFloat(basePrice * dist),
 	}
 	return inv, nil
 }
@@ -173,7 +174,7 @@ func (suite *AggregatorServiceTestSuite) TestCalculateInvoice_ValidOBU() {
 	assert.NotNil(suite.T(), invoice)
 	assert.Equal(suite.T(), fixtures.TestOBUID1, invoice.OBUID)
 	helpers.AssertFloatEquals(suite.T(), 25.5, invoice.TotalDistance, 0.001)
-	helpers.AssertFloatEquals(suite.T(), 25.5*basePrice, invoice.Amount, 0.001)
+	helpers.AssertFloatEquals(suite.T(), 25.5*basePrice, invoice.Amount.Float64(), 0.001)
 }
 
 // TestCalculateInvoice_NonExistentOBU tests calculating invoice for non-existent OBU
@@ -202,7 +203,7 @@ func (suite *AggregatorServiceTestSuite) TestCalculateInvoice_ZeroDistance() {
 	assert.NotNil(suite.T(), invoice)
 	assert.Equal(suite.T(), fixtures.TestOBUID1, invoice.OBUID)
 	helpers.AssertFloatEquals(suite.T(), 0.0, invoice.TotalDistance, 0.001)
-	helpers.AssertFloatEquals(suite.T(), 0.0, invoice.Amount, 0.001)
+	helpers.AssertFloatEquals(suite.T(), 0.0, invoice.Amount.Float64(), 0.001)
 }
 
 // TestCalculateInvoice_LargeDistance tests calculating invoice for large distance
@@ -220,7 +221,7 @@ func (suite *AggregatorServiceTestSuite) TestCalculateInvoice_LargeDistance() {
 	assert.NoError(suite.T(), err)
 	assert.NotNil(suite.T(), invoice)
 	helpers.AssertFloatEquals(suite.T(), largeDistance, invoice.TotalDistance, 0.001)
-	helpers.AssertFloatEquals(suite.T(), largeDistance*basePrice, invoice.Amount, 0.001)
+	helpers.AssertFloatEquals(suite.T(), largeDistance*basePrice, invoice.Amount.Float64(), 0.001)
 }
 
 // TestCalculateInvoice_AccumulatedDistances tests invoice calculation with accumulated distances
@@ -243,7 +244,7 @@ func (suite *AggregatorServiceTestSuite) TestCalculateInvoice_AccumulatedDistanc
 	assert.NoError(suite.T(), err)
 	assert.NotNil(suite.T(), invoice)
 	helpers.AssertFloatEquals(suite.T(), expectedTotal, invoice.TotalDistance, 0.001)
-	helpers.AssertFloatEquals(suite.T(), expectedTotal*basePrice, invoice.Amount, 0.001)
+	helpers.AssertFloatEquals(suite.T(), expectedTotal*basePrice, invoice.Amount.Float64(), 0.001)
 }
 
 // TestBasePrice tests that the base price constant is correct
@@ -0,0 +1,194 @@
+package unit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/0x0Glitch/toll-calculator/aggregator/client"
+	"github.com/0x0Glitch/toll-calculator/apperr"
+	"github.com/0x0Glitch/toll-calculator/gokit/aggservice/aggsvc/aggendpoint"
+	"github.com/0x0Glitch/toll-calculator/gokit/aggservice/aggsvc/aggservice"
+	"github.com/0x0Glitch/toll-calculator/gokit/aggservice/aggsvc/aggtransport"
+	"github.com/0x0Glitch/toll-calculator/types"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ApperrTestSuite tests the typed error model and how it crosses transports
+type ApperrTestSuite struct {
+	suite.Suite
+	httpServer *httptest.Server
+	grpcServer *grpc.Server
+	grpcAddr   string
+}
+
+// SetupTest starts the go-kit aggregator on both transports before each test
+func (suite *ApperrTestSuite) SetupTest() {
+	svc := aggservice.NewAggregatorService(aggservice.NewMemoryStore())
+	endpoints := aggendpoint.New(svc, log.NewNopLogger(), rate.Inf)
+	suite.httpServer = httptest.NewServer(aggtransport.NewHTTPHandler(endpoints, log.NewNopLogger()))
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(suite.T(), err)
+	suite.grpcAddr = ln.Addr().String()
+	suite.grpcServer = grpc.NewServer()
+	types.RegisterAggregatorServer(suite.grpcServer, aggtransport.NewGRPCServer(endpoints, log.NewNopLogger()))
+	go suite.grpcServer.Serve(ln)
+}
+
+// TearDownTest stops both transports after each test
+func (suite *ApperrTestSuite) TearDownTest() {
+	suite.httpServer.Close()
+	suite.grpcServer.Stop()
+}
+
+// TestCodeOf_WrappedErrors tests that codes survive wrapping and untyped errors are internal
+func (suite *ApperrTestSuite) TestCodeOf_WrappedErrors() {
+	// Arrange
+	notFound := apperr.NotFoundf("couldn't find distance for id: %d", 7)
+	wrapped := fmt.Errorf("failed to calculate invoice: %w", notFound)
+	cause := errors.New("connection refused")
+
+	// Act & Assert
+	assert.Equal(suite.T(), apperr.NotFound, apperr.CodeOf(wrapped))
+	assert.True(suite.T(), apperr.IsCode(wrapped, apperr.NotFound))
+	assert.Equal(suite.T(), apperr.Internal, apperr.CodeOf(cause))
+	assert.Equal(suite.T(), apperr.Code(""), apperr.CodeOf(nil))
+	assert.Nil(suite.T(), apperr.Wrap(apperr.Unavailable, nil, "never"))
+	assert.ErrorIs(suite.T(), apperr.Wrap(apperr.Unavailable, cause, "calling aggregator"), cause)
+}
+
+// TestHTTPStatus_Mapping tests that every code maps to its HTTP status and gRPC code
+func (suite *ApperrTestSuite) TestHTTPStatus_Mapping() {
+	cases := []struct {
+		err    error
+		status int
+		grpc   codes.Code
+	}{
+		{apperr.NotFoundf("x"), http.StatusNotFound, codes.NotFound},
+		{apperr.InvalidArgumentf("x"), http.StatusBadRequest, codes.InvalidArgument},
+		{apperr.Unavailablef("x"), http.StatusServiceUnavailable, codes.Unavailable},
+		{apperr.Conflictf("x"), http.StatusConflict, codes.FailedPrecondition},
+		{errors.New("x"), http.StatusInternalServerError, codes.Internal},
+	}
+	for _, c := range cases {
+		// Act
+		httpStatus := apperr.HTTPStatus(c.err)
+		grpcErr := apperr.ToGRPC(c.err)
+
+		// Assert
+		assert.Equal(suite.T(), c.status, httpStatus, c.err.Error())
+		assert.Equal(suite.T(), c.grpc, status.Code(grpcErr), c.err.Error())
+		assert.Equal(suite.T(), apperr.CodeOf(c.err), apperr.CodeOf(apperr.FromGRPC(grpcErr)))
+	}
+}
+
+// TestFromHTTPResponse_FallsBackToStatus tests decoding responses with and without an error body
+func (suite *ApperrTestSuite) TestFromHTTPResponse_FallsBackToStatus() {
+	// Arrange
+	typed := &http.Response{
+		StatusCode: http.StatusConflict,
+		Status:     "409 Conflict",
+		Body:       httpBody(`{"error":"invoice already finalized","code":"conflict"}`),
+	}
+	bare := &http.Response{
+		StatusCode: http.StatusBadGateway,
+		Status:     "502 Bad Gateway",
+		Body:       httpBody("upstream went away"),
+	}
+
+	// Act
+	typedErr := apperr.FromHTTPResponse(typed)
+	bareErr := apperr.FromHTTPResponse(bare)
+
+	// Assert
+	assert.True(suite.T(), apperr.IsCode(typedErr, apperr.Conflict))
+	assert.Equal(suite.T(), "invoice already finalized", typedErr.Error())
+	assert.True(suite.T(), apperr.IsCode(bareErr, apperr.Unavailable))
+	assert.Contains(suite.T(), bareErr.Error(), "502")
+}
+
+// TestUnknownOBU_NotFoundOverBothTransports tests that clients see not_found for an unknown OBU
+func (suite *ApperrTestSuite) TestUnknownOBU_NotFoundOverBothTransports() {
+	// Arrange
+	grpcClient, err := client.NewGRPCClient(suite.grpcAddr)
+	require.NoError(suite.T(), err)
+	defer grpcClient.Close()
+	clients := map[string]client.Client{
+		"http": client.NewHTTPClient(suite.httpServer.URL),
+		"grpc": grpcClient,
+	}
+
+	for name, c := range clients {
+		// Act
+		_, err := c.GetInvoice(context.Background(), 424242)
+
+		// Assert
+		require.Error(suite.T(), err, name)
+		assert.True(suite.T(), apperr.IsCode(err, apperr.NotFound), "%s: %v", name, err)
+		assert.Contains(suite.T(), err.Error(), "couldn't find distance", name)
+	}
+}
+
+// TestHTTPErrorBody_CarriesCode tests the JSON error body written by the go-kit HTTP transport
+func (suite *ApperrTestSuite) TestHTTPErrorBody_CarriesCode() {
+	cases := map[string]struct {
+		path   string
+		status int
+		code   apperr.Code
+	}{
+		"unknown obu": {"/invoice?obu=99", http.StatusNotFound, apperr.NotFound},
+		"missing obu": {"/invoice", http.StatusBadRequest, apperr.InvalidArgument},
+		"invalid obu": {"/invoice?obu=abc", http.StatusBadRequest, apperr.InvalidArgument},
+	}
+	for name, c := range cases {
+		// Act
+		resp, err := http.Get(suite.httpServer.URL + c.path)
+		require.NoError(suite.T(), err, name)
+		var body apperr.Body
+		require.NoError(suite.T(), json.NewDecoder(resp.Body).Decode(&body), name)
+		resp.Body.Close()
+
+		// Assert
+		assert.Equal(suite.T(), c.status, resp.StatusCode, name)
+		assert.Equal(suite.T(), c.code, body.Code, name)
+		assert.NotEmpty(suite.T(), body.Error, name)
+	}
+}
+
+// TestUnreachableAggregator_Unavailable tests that transport failures are reported as unavailable
+func (suite *ApperrTestSuite) TestUnreachableAggregator_Unavailable() {
+	// Arrange
+	addr := suite.httpServer.URL
+	suite.httpServer.Close()
+	c := client.NewHTTPClient(addr)
+
+	// Act
+	err := c.Aggregate(context.Background(), &types.AggregatorRequest{ObuID: 1, Value: 1})
+
+	// Assert
+	assert.True(suite.T(), apperr.IsCode(err, apperr.Unavailable), "%v", err)
+}
+
+func httpBody(s string) io.ReadCloser {
+	return io.NopCloser(strings.NewReader(s))
+}
+
+// Run the apperr test suite
+func TestApperrTestSuite(t *testing.T) {
+	suite.Run(t, new(ApperrTestSuite))
+}
@@ -0,0 +1,799 @@
+package unit
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/0x0Glitch/toll-calculator/apperr"
+	"github.com/0x0Glitch/toll-calculator/billing"
+	"github.com/0x0Glitch/toll-calculator/contract"
+	"github.com/0x0Glitch/toll-calculator/money"
+	"github.com/0x0Glitch/toll-calculator/tax"
+	"github.com/0x0Glitch/toll-calculator/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+// BillingTestSuite tests closing billing periods into invoices and the invoice lifecycle
+type BillingTestSuite struct {
+	suite.Suite
+	book *billing.Book
+	ctx  context.Context
+}
+
+// SetupTest creates an empty book before each test
+func (suite *BillingTestSuite) SetupTest() {
+	suite.book = billing.NewBook()
+	suite.ctx = context.Background()
+}
+
+// totalsSource is an in-memory store of running totals
+type totalsSource map[int32]map[string]types.Total
+
+func (s totalsSource) IDs() []int32 {
+	ids := make([]int32, 0, len(s))
+	for id := range s {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// TakeBefore takes all of the totals, as if travelled before any period's end
+func (s totalsSource) TakeBefore(id int32, end time.Time) (types.DailyTotals, error) {
+	totals, ok := s[id]
+	if !ok {
+		return nil, apperr.NotFoundf("no totals for %d", id)
+	}
+	delete(s, id)
+	return types.DailyTotals{types.Day(end.Add(-time.Nanosecond).UnixNano()): totals}, nil
+}
+
+func (s totalsSource) Insert(d *types.Distance) error {
+	if s[d.OBUID] == nil {
+		s[d.OBUID] = map[string]types.Total{}
+	}
+	t := s[d.OBUID][d.ZoneID]
+	t.Add(d)
+	s[d.OBUID][d.ZoneID] = t
+	return nil
+}
+
+// datedSource is an in-memory store of the distances themselves, taken by when they were travelled
+type datedSource map[int32][]*types.Distance
+
+func (s datedSource) IDs() []int32 {
+	ids := make([]int32, 0, len(s))
+	for id := range s {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func (s datedSource) TakeBefore(id int32, end time.Time) (types.DailyTotals, error) {
+	totals := types.DailyTotals{}
+	var rest []*types.Distance
+	for _, d := range s[id] {
+		if !time.Unix(0, d.Unix).Before(end) {
+			rest = append(rest, d)
+			continue
+		}
+		totals.Add(d)
+	}
+	if len(totals) == 0 {
+		return nil, apperr.NotFoundf("no totals for %d before %s", id, end)
+	}
+	s[id] = rest
+	return totals, nil
+}
+
+func (s datedSource) Insert(d *types.Distance) error {
+	s[d.OBUID] = append(s[d.OBUID], d)
+	return nil
+}
+
+// flakySource fails to take the totals of the first fails calls
+type flakySource struct {
+	totalsSource
+	fails int
+}
+
+func (s *flakySource) TakeBefore(id int32, end time.Time) (types.DailyTotals, error) {
+	if s.fails > 0 {
+		s.fails--
+		return nil, apperr.Unavailablef("store down")
+	}
+	return s.totalsSource.TakeBefore(id, end)
+}
+
+// flatTariff prices every zone at 2 EUR except the untolled distance outside all zones
+type flatTariff struct{}
+
+func (flatTariff) UnitPrice(zone string) money.Money {
+	if zone == "" {
+		return money.Money{Currency: "EUR"}
+	}
+	return money.Money{Amount: money.NewFromInt(2), Currency: "EUR"}
+}
+
+func (flatTariff) TariffVersion() string {
+	return "flat-1"
+}
+
+// zoneTariff prices each zone at its own tariff and currency
+type zoneTariff map[string]money.Money
+
+func (t zoneTariff) UnitPrice(zone string) money.Money {
+	return t[zone]
+}
+
+func (zoneTariff) TariffVersion() string {
+	return "zones-1"
+}
+
+// eur bills in EUR, rounding every line half-even
+var eur = billing.Pricing{Currency: "EUR"}
+
+// bill returns a bill in EUR at the flat tariff with the lines and their total
+func bill(lines ...types.InvoiceLine) billing.Bill {
+	return billing.Bill{TariffVersion: "flat-1", Currency: "EUR", Lines: lines, Amount: eur.Total(lines)}
+}
+
+// amounts returns the amounts of the lines as they print
+func amounts(lines []types.InvoiceLine) []string {
+	out := make([]string, 0, len(lines))
+	for _, l := range lines {
+		out = append(out, l.Amount.String())
+	}
+	return out
+}
+
+// finalized issues a finalized invoice of OBU 1 for September 2025 billing 10 units in zone A
+func (suite *BillingTestSuite) finalized() *types.Invoice {
+	p, err := billing.Monthly.Parse("2025-09")
+	require.NoError(suite.T(), err)
+	inv, err := suite.book.Draft(1, p, bill(types.InvoiceLine{ZoneID: "A", Distance: 10, UnitPrice: money.NewFromInt(2), Amount: money.MustParse("20.00")}))
+	require.NoError(suite.T(), err)
+	inv, err = suite.book.FinalizeInvoice(suite.ctx, inv.ID)
+	require.NoError(suite.T(), err)
+	return inv
+}
+
+// TestCycle_Periods tests the bounds and labels of the periods of every cycle
+func (suite *BillingTestSuite) TestCycle_Periods() {
+	// Arrange
+	at := time.Date(2025, 10, 18, 15, 4, 5, 0, time.UTC)
+
+	// Act
+	day, week, month := billing.Daily.Period(at), billing.Weekly.Period(at), billing.Monthly.Period(at)
+
+	// Assert
+	assert.Equal(suite.T(), "2025-10-18", day.Label())
+	assert.Equal(suite.T(), time.Date(2025, 10, 19, 0, 0, 0, 0, time.UTC), day.End)
+	assert.Equal(suite.T(), "2025-W42", week.Label())
+	assert.Equal(suite.T(), time.Date(2025, 10, 13, 0, 0, 0, 0, time.UTC), week.Start)
+	assert.Equal(suite.T(), "2025-10", month.Label())
+	assert.Equal(suite.T(), time.Date(2025, 11, 1, 0, 0, 0, 0, time.UTC), month.End)
+	assert.Equal(suite.T(), "2025-11", month.Next().Label())
+}
+
+// TestCycle_ParseRoundTrips tests that labels parse back to their period and malformed labels are rejected
+func (suite *BillingTestSuite) TestCycle_ParseRoundTrips() {
+	testCases := []struct {
+		cycle billing.Cycle
+		label string
+		valid bool
+	}{
+		{billing.Daily, "2025-10-18", true},
+		{billing.Weekly, "2025-W01", true},
+		{billing.Weekly, "2026-W53", true},
+		{billing.Weekly, "2025-W53", false},
+		{billing.Weekly, "2025-W7", false},
+		{billing.Monthly, "2025-10", true},
+		{billing.Monthly, "2025-13", false},
+		{billing.Monthly, "October", false},
+	}
+
+	for _, tc := range testCases {
+		p, err := tc.cycle.Parse(tc.label)
+		if !tc.valid {
+			assert.Error(suite.T(), err, tc.label)
+			continue
+		}
+		require.NoError(suite.T(), err, tc.label)
+		assert.Equal(suite.T(), tc.label, p.Label())
+	}
+}
+
+// TestClose_BillsAndResetsTotals tests that closing a period finalizes an invoice per vehicle and takes its totals
+func (suite *BillingTestSuite) TestClose_BillsAndResetsTotals() {
+	// Arrange
+	source := totalsSource{
+		1: {"A": {Distance: 10, Estimated: 2}, "": {Distance: 5}},
+		2: {"A": {Distance: 1}},
+	}
+	closer := billing.NewCloser(suite.book, source, flatTariff{}, eur, billing.Monthly, false)
+
+	// Act
+	n, err := closer.CloseInvoices(suite.ctx, "2025-09")
+	invoices, listErr := suite.book.Invoices(suite.ctx, 1, time.Time{}, time.Time{})
+
+	// Assert
+	require.NoError(suite.T(), err)
+	require.NoError(suite.T(), listErr)
+	assert.Equal(suite.T(), 2, n)
+	assert.Empty(suite.T(), source)
+	require.Len(suite.T(), invoices, 1)
+	inv := invoices[0]
+	assert.Equal(suite.T(), "INV-2025-09-1", inv.ID)
+	assert.Equal(suite.T(), types.InvoiceFinalized, inv.Status)
+	assert.Equal(suite.T(), "flat-1", inv.TariffVersion)
+	assert.False(suite.T(), inv.FinalizedAt.IsZero())
+	require.Len(suite.T(), inv.Lines, 2)
+	assert.Equal(suite.T(), "", inv.Lines[0].ZoneID)
+	assert.Equal(suite.T(), "A", inv.Lines[1].ZoneID)
+	assert.Equal(suite.T(), 2.0, inv.Lines[1].Estimated)
+	assert.Equal(suite.T(), []string{"0.00", "20.00"}, amounts(inv.Lines))
+	assert.Equal(suite.T(), 15.0, inv.TotalDistance)
+	assert.Equal(suite.T(), 2.0, inv.EstimatedDistance)
+	assert.Equal(suite.T(), money.Currency("EUR"), inv.Currency)
+	assert.Equal(suite.T(), "20.00", inv.Amount.String())
+}
+
+// TestClose_RejectsOpenAndClosedPeriods tests that a period can only be closed once it ended, and only once
+func (suite *BillingTestSuite) TestClose_RejectsOpenAndClosedPeriods() {
+	// Arrange
+	closer := billing.NewCloser(suite.book, totalsSource{}, flatTariff{}, eur, billing.Monthly, false)
+	open := billing.Monthly.Period(time.Now()).Label()
+	_, err := closer.CloseInvoices(suite.ctx, "2025-09")
+	require.NoError(suite.T(), err)
+
+	// Act
+	_, openErr := closer.CloseInvoices(suite.ctx, open)
+	_, againErr := closer.CloseInvoices(suite.ctx, "2025-09")
+	_, badErr := closer.CloseInvoices(suite.ctx, "September")
+
+	// Assert
+	assert.True(suite.T(), apperr.IsCode(openErr, apperr.InvalidArgument))
+	assert.True(suite.T(), apperr.IsCode(againErr, apperr.Conflict))
+	assert.True(suite.T(), apperr.IsCode(badErr, apperr.InvalidArgument))
+}
+
+// TestClose_LeavesDistanceTravelledSince tests that closing a period only bills the distance travelled before its end
+func (suite *BillingTestSuite) TestClose_LeavesDistanceTravelledSince() {
+	// Arrange
+	sep := time.Date(2025, 9, 30, 23, 0, 0, 0, time.UTC)
+	oct := time.Date(2025, 10, 1, 1, 0, 0, 0, time.UTC)
+	source := datedSource{1: {
+		{OBUID: 1, Values: 4, ZoneID: "A", Unix: sep.UnixNano()},
+		{OBUID: 1, Values: 6, ZoneID: "A", Unix: oct.UnixNano()},
+	}}
+	closer := billing.NewCloser(suite.book, source, flatTariff{}, eur, billing.Monthly, false)
+
+	// Act
+	_, err := closer.CloseInvoices(suite.ctx, "2025-09")
+	inv, invErr := suite.book.Invoice(suite.ctx, "INV-2025-09-1")
+
+	// Assert
+	require.NoError(suite.T(), err)
+	require.NoError(suite.T(), invErr)
+	assert.Equal(suite.T(), 4.0, inv.TotalDistance)
+	require.Len(suite.T(), source[1], 1)
+	assert.Equal(suite.T(), 6.0, source[1][0].Values)
+}
+
+// TestClose_FailureLeavesPeriodOpen tests that a period stays open until every vehicle was billed, and closing it again only bills the rest
+func (suite *BillingTestSuite) TestClose_FailureLeavesPeriodOpen() {
+	// Arrange
+	sep := time.Date(2025, 9, 15, 0, 0, 0, 0, time.UTC).UnixNano()
+	source := datedSource{
+		1: {{OBUID: 1, Values: 4, ZoneID: "B", Unix: sep}},
+		2: {{OBUID: 2, Values: 3, ZoneID: "A", Unix: sep}},
+	}
+	tariff := zoneTariff{
+		"A": {Amount: money.NewFromInt(1), Currency: "EUR"},
+		"B": {Amount: money.NewFromInt(1), Currency: "CHF"},
+	}
+	closer := billing.NewCloser(suite.book, source, tariff, eur, billing.Monthly, false)
+	first, firstErr := closer.CloseInvoices(suite.ctx, "2025-09")
+
+	// Act
+	tariff["B"] = money.Money{Amount: money.NewFromInt(1), Currency: "EUR"}
+	second, secondErr := closer.CloseInvoices(suite.ctx, "2025-09")
+	_, againErr := closer.CloseInvoices(suite.ctx, "2025-09")
+	inv, invErr := suite.book.Invoice(suite.ctx, "INV-2025-09-1")
+
+	// Assert
+	assert.Error(suite.T(), firstErr)
+	assert.Equal(suite.T(), 1, first)
+	require.NoError(suite.T(), secondErr)
+	assert.Equal(suite.T(), 1, second)
+	assert.True(suite.T(), apperr.IsCode(againErr, apperr.Conflict))
+	require.NoError(suite.T(), invErr)
+	assert.Equal(suite.T(), 4.0, inv.TotalDistance)
+	assert.Equal(suite.T(), "4.00", inv.Amount.String())
+}
+
+// TestClose_ReviewLeavesDrafts tests that with review the invoices of a closed period wait to be finalized
+func (suite *BillingTestSuite) TestClose_ReviewLeavesDrafts() {
+	// Arrange
+	closer := billing.NewCloser(suite.book, totalsSource{1: {"A": {Distance: 3}}}, flatTariff{}, eur, billing.Monthly, true)
+	_, err := closer.CloseInvoices(suite.ctx, "2025-09")
+	require.NoError(suite.T(), err)
+
+	// Act
+	drafts, _ := suite.book.Invoices(suite.ctx, 1, time.Time{}, time.Time{})
+	inv, finalizeErr := suite.book.FinalizeInvoice(suite.ctx, "INV-2025-09-1")
+
+	// Assert
+	require.Len(suite.T(), drafts, 1)
+	assert.Equal(suite.T(), types.InvoiceDraft, drafts[0].Status)
+	require.NoError(suite.T(), finalizeErr)
+	assert.Equal(suite.T(), types.InvoiceFinalized, inv.Status)
+}
+
+// TestLifecycle_FinalizedInvoiceIsFrozen tests that a finalized invoice can't be redrafted or finalized again
+func (suite *BillingTestSuite) TestLifecycle_FinalizedInvoiceIsFrozen() {
+	// Arrange
+	inv := suite.finalized()
+	p, _ := billing.Monthly.Parse("2025-09")
+
+	// Act
+	_, draftErr := suite.book.Draft(1, p, bill(types.InvoiceLine{ZoneID: "A", Distance: 99, Amount: money.MustParse("198.00")}))
+	_, finalizeErr := suite.book.FinalizeInvoice(suite.ctx, inv.ID)
+	inv.Lines[0].Amount = money.Decimal{}
+	stored, _ := suite.book.Invoices(suite.ctx, 1, time.Time{}, time.Time{})
+
+	// Assert
+	assert.True(suite.T(), apperr.IsCode(draftErr, apperr.Conflict))
+	assert.True(suite.T(), apperr.IsCode(finalizeErr, apperr.Conflict))
+	assert.Equal(suite.T(), "20.00", stored[0].Lines[0].Amount.String())
+	assert.Equal(suite.T(), "20.00", stored[0].Amount.String())
+}
+
+// TestLifecycle_PayAndVoid tests the moves from finalized to paid or void and that neither can be undone
+func (suite *BillingTestSuite) TestLifecycle_PayAndVoid() {
+	// Arrange
+	inv := suite.finalized()
+
+	// Act
+	paid, payErr := suite.book.PayInvoice(suite.ctx, inv.ID)
+	_, voidErr := suite.book.VoidInvoice(suite.ctx, inv.ID, "duplicate")
+	_, missingErr := suite.book.PayInvoice(suite.ctx, "INV-2025-09-404")
+
+	// Assert
+	require.NoError(suite.T(), payErr)
+	assert.Equal(suite.T(), types.InvoicePaid, paid.Status)
+	assert.False(suite.T(), paid.PaidAt.IsZero())
+	assert.True(suite.T(), apperr.IsCode(voidErr, apperr.Conflict))
+	assert.True(suite.T(), apperr.IsCode(missingErr, apperr.NotFound))
+}
+
+// TestCredit_LimitedToInvoiceAmount tests that credit notes can't credit more than the invoice and block voiding it
+func (suite *BillingTestSuite) TestCredit_LimitedToInvoiceAmount() {
+	// Arrange
+	inv := suite.finalized()
+
+	// Act
+	first, firstErr := suite.book.CreditInvoice(suite.ctx, inv.ID, "wrong zone", []types.InvoiceLine{{ZoneID: "A", Distance: 4, Amount: money.MustParse("8.00")}})
+	_, tooMuchErr := suite.book.CreditInvoice(suite.ctx, inv.ID, "again", []types.InvoiceLine{{ZoneID: "A", Amount: money.MustParse("12.01")}})
+	_, negativeErr := suite.book.CreditInvoice(suite.ctx, inv.ID, "negative", []types.InvoiceLine{{ZoneID: "A", Amount: money.NewFromInt(-1)}})
+	second, secondErr := suite.book.CreditInvoice(suite.ctx, inv.ID, "rest", []types.InvoiceLine{{ZoneID: "A", Amount: money.MustParse("12.00")}})
+	_, voidErr := suite.book.VoidInvoice(suite.ctx, inv.ID, "")
+	notes, _ := suite.book.CreditNotes(suite.ctx, 1)
+
+	// Assert
+	require.NoError(suite.T(), firstErr)
+	assert.Equal(suite.T(), "CN-2025-09-1-1", first.ID)
+	assert.Equal(suite.T(), "8.00", first.Amount.String())
+	assert.Equal(suite.T(), money.Currency("EUR"), first.Currency)
+	assert.True(suite.T(), apperr.IsCode(tooMuchErr, apperr.Conflict))
+	assert.True(suite.T(), apperr.IsCode(negativeErr, apperr.InvalidArgument))
+	require.NoError(suite.T(), secondErr)
+	assert.Equal(suite.T(), "CN-2025-09-1-2", second.ID)
+	assert.True(suite.T(), apperr.IsCode(voidErr, apperr.Conflict))
+	assert.Len(suite.T(), notes, 2)
+}
+
+// TestCredit_RequiresFinalizedInvoice tests that a draft can't be credited
+func (suite *BillingTestSuite) TestCredit_RequiresFinalizedInvoice() {
+	// Arrange
+	p, _ := billing.Monthly.Parse("2025-09")
+	draft, err := suite.book.Draft(1, p, bill(types.InvoiceLine{ZoneID: "A", Amount: money.MustParse("20.00")}))
+	require.NoError(suite.T(), err)
+
+	// Act
+	_, creditErr := suite.book.CreditInvoice(suite.ctx, draft.ID, "", []types.InvoiceLine{{ZoneID: "A", Amount: money.NewFromInt(1)}})
+
+	// Assert
+	assert.True(suite.T(), apperr.IsCode(creditErr, apperr.Conflict))
+}
+
+// TestInvoices_FiltersByPeriod tests that only invoices whose period overlaps the range are listed
+func (suite *BillingTestSuite) TestInvoices_FiltersByPeriod() {
+	// Arrange
+	for _, label := range []string{"2025-08", "2025-09", "2025-10"} {
+		p, _ := billing.Monthly.Parse(label)
+		_, err := suite.book.Draft(1, p, bill())
+		require.NoError(suite.T(), err)
+	}
+
+	// Act
+	invoices, err := suite.book.Invoices(suite.ctx, 1, time.Date(2025, 9, 15, 0, 0, 0, 0, time.UTC), time.Date(2025, 10, 1, 0, 0, 0, 0, time.UTC))
+
+	// Assert
+	require.NoError(suite.T(), err)
+	require.Len(suite.T(), invoices, 1)
+	assert.Equal(suite.T(), "2025-09", invoices[0].Period)
+}
+
+// TestExportInvoices_StreamsPeriodInOBUOrder tests that an export yields every invoice of the period and no other
+func (suite *BillingTestSuite) TestExportInvoices_StreamsPeriodInOBUOrder() {
+	// Arrange
+	sep, _ := billing.Monthly.Parse("2025-09")
+	oct, _ := billing.Monthly.Parse("2025-10")
+	for _, id := range []int32{3, 1, 2} {
+		_, err := suite.book.Draft(id, sep, bill())
+		require.NoError(suite.T(), err)
+	}
+	_, err := suite.book.Draft(1, oct, bill())
+	require.NoError(suite.T(), err)
+
+	// Act
+	var ids []string
+	err = suite.book.ExportInvoices(suite.ctx, "2025-09", func(inv types.Invoice) error {
+		ids = append(ids, inv.ID)
+		return nil
+	})
+
+	// Assert
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), []string{"INV-2025-09-1", "INV-2025-09-2", "INV-2025-09-3"}, ids)
+}
+
+// TestPricing_ConvertsForeignTariffs tests that a tariff in another currency is converted at the exchange rate, which the line keeps
+func (suite *BillingTestSuite) TestPricing_ConvertsForeignTariffs() {
+	// Arrange
+	path := filepath.Join(suite.T().TempDir(), "rates.yaml")
+	require.NoError(suite.T(), os.WriteFile(path, []byte("base: EUR\nrates:\n  CHF: 0.9412\n"), 0o600))
+	rates, err := money.LoadRates(path)
+	require.NoError(suite.T(), err)
+	pricing := billing.Pricing{Currency: "EUR", Rates: rates}
+	tariff := zoneTariff{
+		"A": {Amount: money.MustParse("0.10"), Currency: "EUR"},
+		"B": {Amount: money.MustParse("0.20"), Currency: "CHF"},
+	}
+
+	// Act
+	b, err := pricing.Bill(1, time.Now(), map[string]types.Total{"A": {Distance: 10}, "B": {Distance: 10}}, tariff)
+
+	// Assert
+	require.NoError(suite.T(), err)
+	require.Len(suite.T(), b.Lines, 2)
+	assert.True(suite.T(), b.Lines[0].ExchangeRate.IsZero())
+	assert.Equal(suite.T(), money.Currency("CHF"), b.Lines[1].Currency)
+	// 1 / 0.9412 to 10 digits
+	assert.Equal(suite.T(), "1.0624734382", b.Lines[1].ExchangeRate.String())
+	assert.Equal(suite.T(), []string{"1.00", "2.12"}, amounts(b.Lines))
+	assert.Equal(suite.T(), "3.12", b.Amount.String())
+}
+
+// TestPricing_RoundingRules tests bankers' rounding and rounding per line against rounding the invoice total
+func (suite *BillingTestSuite) TestPricing_RoundingRules() {
+	// Arrange
+	tariff := zoneTariff{
+		"A": {Amount: money.MustParse("0.125"), Currency: "EUR"},
+		"B": {Amount: money.MustParse("0.125"), Currency: "EUR"},
+	}
+	totals := map[string]types.Total{"A": {Distance: 1}, "B": {Distance: 1}}
+	testCases := []struct {
+		rounding money.Rounding
+		lines    []string
+		total    string
+	}{
+		{money.Rounding{Mode: money.HalfEven, Scope: money.PerLine}, []string{"0.12", "0.12"}, "0.24"},
+		{money.Rounding{Mode: money.HalfUp, Scope: money.PerLine}, []string{"0.13", "0.13"}, "0.26"},
+		{money.Rounding{Mode: money.HalfEven, Scope: money.PerInvoice}, []string{"0.125", "0.125"}, "0.25"},
+	}
+
+	for _, tc := range testCases {
+		// Act
+		b, err := billing.Pricing{Currency: "EUR", Rounding: tc.rounding}.Bill(1, time.Now(), totals, tariff)
+
+		// Assert
+		require.NoError(suite.T(), err)
+		assert.Equal(suite.T(), tc.lines, amounts(b.Lines), tc.rounding)
+		assert.Equal(suite.T(), tc.total, b.Amount.String(), tc.rounding)
+	}
+}
+
+// TestClose_KeepsTotalsWithoutExchangeRate tests that a vehicle whose tariff can't be converted keeps its totals for the next period
+func (suite *BillingTestSuite) TestClose_KeepsTotalsWithoutExchangeRate() {
+	// Arrange
+	source := totalsSource{1: {"B": {Distance: 4}}}
+	tariff := zoneTariff{"B": {Amount: money.NewFromInt(1), Currency: "CHF"}}
+	closer := billing.NewCloser(suite.book, source, tariff, eur, billing.Monthly, false)
+
+	// Act
+	_, err := closer.CloseInvoices(suite.ctx, "2025-09")
+	invoices, _ := suite.book.Invoices(suite.ctx, 1, time.Time{}, time.Time{})
+
+	// Assert
+	assert.Error(suite.T(), err)
+	assert.Empty(suite.T(), invoices)
+	assert.Equal(suite.T(), 4.0, source[1]["B"].Distance)
+}
+
+// countries puts each zone in a country
+type countries map[string]string
+
+func (c countries) Country(zone string) string {
+	return c[zone]
+}
+
+// taxRules writes the tax rules to a file and loads them
+func (suite *BillingTestSuite) taxRules(rules string) *tax.RulesFile {
+	path := filepath.Join(suite.T().TempDir(), "taxes.yaml")
+	require.NoError(suite.T(), os.WriteFile(path, []byte(rules), 0o600))
+	f, err := tax.LoadRulesFile(path)
+	require.NoError(suite.T(), err)
+	return f
+}
+
+// TestPricing_TaxesByJurisdiction tests standard rates by country and zone, exemptions by vehicle class and reverse charge for foreign businesses
+func (suite *BillingTestSuite) TestPricing_TaxesByJurisdiction() {
+	// Arrange
+	rules := suite.taxRules(`
+jurisdictions:
+  - {code: NL, rate: "0.21", exemptClasses: [bus], reverseCharge: true}
+  - {code: DE, rate: "0.19"}
+zones:
+  bridge: DE
+default: NL
+vehicles:
+  2: {class: bus}
+  3: {vatID: BE0123456789, country: BE}
+  4: {vatID: NL123456789B01, country: NL}
+`)
+	pricing := billing.Pricing{Currency: "EUR", Taxes: rules, Places: countries{"A": "NL", "B": "DE", "bridge": "NL"}}
+	totals := map[string]types.Total{"A": {Distance: 10}, "B": {Distance: 10}, "bridge": {Distance: 5}, "C": {Distance: 5}}
+	testCases := []struct {
+		obuID      int32
+		treatments []types.TaxTreatment
+		taxes      []string
+		tax, gross string
+	}{
+		{1, []types.TaxTreatment{types.TaxStandard, types.TaxStandard, types.TaxStandard, types.TaxStandard}, []string{"4.20", "3.80", "2.10", "1.90"}, "12.00", "72.00"},
+		{2, []types.TaxTreatment{types.TaxExempt, types.TaxStandard, types.TaxExempt, types.TaxStandard}, []string{"0", "3.80", "0", "1.90"}, "5.70", "65.70"},
+		{3, []types.TaxTreatment{types.TaxReverseCharge, types.TaxStandard, types.TaxReverseCharge, types.TaxStandard}, []string{"0", "3.80", "0", "1.90"}, "5.70", "65.70"},
+		{4, []types.TaxTreatment{types.TaxStandard, types.TaxStandard, types.TaxStandard, types.TaxStandard}, []string{"4.20", "3.80", "2.10", "1.90"}, "12.00", "72.00"},
+	}
+
+	for _, tc := range testCases {
+		// Act
+		b, err := pricing.Bill(tc.obuID, time.Now(), totals, flatTariff{})
+
+		// Assert
+		require.NoError(suite.T(), err, tc.obuID)
+		var jurisdictions []string
+		var treatments []types.TaxTreatment
+		var taxes []string
+		for _, l := range b.Lines {
+			jurisdictions = append(jurisdictions, l.Jurisdiction)
+			treatments = append(treatments, l.TaxTreatment)
+			taxes = append(taxes, l.Tax.String())
+		}
+		assert.Equal(suite.T(), []string{"NL", "DE", "NL", "DE"}, jurisdictions, tc.obuID)
+		assert.Equal(suite.T(), tc.treatments, treatments, tc.obuID)
+		assert.Equal(suite.T(), tc.taxes, taxes, tc.obuID)
+		assert.Equal(suite.T(), "60.00", b.Amount.String(), tc.obuID)
+		assert.Equal(suite.T(), tc.tax, b.Tax.String(), tc.obuID)
+		assert.Equal(suite.T(), tc.gross, b.Gross.String(), tc.obuID)
+	}
+}
+
+// TestPricing_UntaxedZoneIsAnError tests that a zone no jurisdiction taxes can't be billed
+func (suite *BillingTestSuite) TestPricing_UntaxedZoneIsAnError() {
+	// Arrange
+	rules := suite.taxRules("jurisdictions:\n  - {code: NL, rate: \"0.21\"}\n")
+	pricing := billing.Pricing{Currency: "EUR", Taxes: rules, Places: countries{"A": "FR"}}
+
+	// Act
+	_, err := pricing.Bill(1, time.Now(), map[string]types.Total{"A": {Distance: 1}}, flatTariff{})
+
+	// Assert
+	assert.True(suite.T(), apperr.IsCode(err, apperr.Internal))
+}
+
+// TestCredit_CreditsTaxAtInvoiceRate tests that a credit note credits the tax of the invoice line of its zone
+func (suite *BillingTestSuite) TestCredit_CreditsTaxAtInvoiceRate() {
+	// Arrange
+	p, _ := billing.Monthly.Parse("2025-09")
+	pricing := billing.Pricing{Currency: "EUR", Taxes: suite.taxRules("jurisdictions:\n  - {code: NL, rate: \"0.21\"}\ndefault: NL\n")}
+	b, err := pricing.Bill(1, time.Now(), map[string]types.Total{"A": {Distance: 10}}, flatTariff{})
+	require.NoError(suite.T(), err)
+	inv, err := suite.book.Draft(1, p, b)
+	require.NoError(suite.T(), err)
+	_, err = suite.book.FinalizeInvoice(suite.ctx, inv.ID)
+	require.NoError(suite.T(), err)
+
+	// Act
+	note, err := suite.book.CreditInvoice(suite.ctx, inv.ID, "wrong zone", []types.InvoiceLine{
+		{ZoneID: "A", Amount: money.MustParse("5.05"), Tax: money.NewFromInt(100)},
+	})
+
+	// Assert
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "20.00", inv.Amount.String())
+	assert.Equal(suite.T(), "4.20", inv.Tax.String())
+	assert.Equal(suite.T(), "24.20", inv.Gross.String())
+	assert.Equal(suite.T(), "NL", note.Lines[0].Jurisdiction)
+	assert.Equal(suite.T(), "1.06", note.Lines[0].Tax.String())
+	assert.Equal(suite.T(), "1.06", note.Tax.String())
+	assert.Equal(suite.T(), "6.11", note.Gross.String())
+}
+
+// contracts returns a registry holding the contracts
+func (suite *BillingTestSuite) contracts(list ...types.Contract) *contract.Registry {
+	r := contract.NewRegistry()
+	for _, c := range list {
+		require.NoError(suite.T(), r.Put(c))
+	}
+	return r
+}
+
+// TestDiscount_AppliesContractInOrder tests that volume, off-peak and promo discounts each take their share of what the ones before left, and are recorded on the lines
+func (suite *BillingTestSuite) TestDiscount_AppliesContractInOrder() {
+	// Arrange
+	pricing := billing.Pricing{Currency: "EUR", Contracts: suite.contracts(types.Contract{
+		ID: "C1", Customer: "Acme Haulage", OBUIDs: []int32{1}, From: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		Discounts: []types.Discount{
+			{Kind: types.DiscountVolume, Percent: money.NewFromInt(10), Threshold: 10},
+			{Kind: types.DiscountOffPeak, Percent: money.NewFromInt(50)},
+			{Kind: types.DiscountPromo, Code: "SPRING", Amount: money.NewFromInt(5)},
+			{Kind: types.DiscountPromo, Code: "EXPIRED", Percent: money.NewFromInt(100), To: time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)},
+		},
+	})}
+	totals := map[string]types.Total{"A": {Distance: 20, OffPeak: 10}, "B": {Distance: 10}}
+
+	// Act
+	b, err := pricing.Bill(1, time.Date(2025, 9, 30, 0, 0, 0, 0, time.UTC), totals, flatTariff{})
+	other, otherErr := pricing.Bill(2, time.Date(2025, 9, 30, 0, 0, 0, 0, time.UTC), totals, flatTariff{})
+
+	// Assert
+	require.NoError(suite.T(), err)
+	require.NoError(suite.T(), otherErr)
+	assert.Equal(suite.T(), []string{"23.00", "18.67"}, amounts(b.Lines))
+	assert.Equal(suite.T(), "41.67", b.Amount.String())
+	a := b.Lines[0]
+	assert.Equal(suite.T(), "40.00", a.ListAmount.String())
+	var applied []string
+	for _, d := range a.Discounts {
+		assert.Equal(suite.T(), "C1", d.Contract)
+		applied = append(applied, string(d.Kind)+" "+d.Code+" "+d.Amount.String())
+	}
+	assert.Equal(suite.T(), []string{"volume  2.67", "off-peak  9.33", "promo SPRING 5.00"}, applied)
+	assert.Len(suite.T(), b.Lines[1].Discounts, 1)
+	assert.Equal(suite.T(), "60.00", other.Amount.String())
+	assert.Empty(suite.T(), other.Lines[0].Discounts)
+	assert.True(suite.T(), other.Lines[0].ListAmount.IsZero())
+}
+
+// TestDiscount_SubscriptionWaivesZonesAndBillsFee tests that a subscription waives the tolls of its zones, adds its fee as a line and that the discounted lines are taxed
+func (suite *BillingTestSuite) TestDiscount_SubscriptionWaivesZonesAndBillsFee() {
+	// Arrange
+	pricing := billing.Pricing{
+		Currency: "EUR",
+		Taxes:    suite.taxRules("jurisdictions:\n  - {code: NL, rate: \"0.21\"}\ndefault: NL\n"),
+		Contracts: suite.contracts(types.Contract{
+			ID: "C2", Customer: "Acme Haulage", OBUIDs: []int32{1}, From: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+			Subscription: &types.Subscription{Fee: money.MustParse("99.00"), Zones: []string{"A"}},
+		}),
+	}
+
+	// Act
+	b, err := pricing.Bill(1, time.Now(), map[string]types.Total{"A": {Distance: 10}, "B": {Distance: 5}}, flatTariff{})
+
+	// Assert
+	require.NoError(suite.T(), err)
+	require.Len(suite.T(), b.Lines, 3)
+	assert.Equal(suite.T(), []string{"0.00", "10.00", "99.00"}, amounts(b.Lines))
+	assert.Equal(suite.T(), "20.00", b.Lines[0].ListAmount.String())
+	require.Len(suite.T(), b.Lines[0].Discounts, 1)
+	assert.Equal(suite.T(), types.DiscountSubscription, b.Lines[0].Discounts[0].Kind)
+	assert.Equal(suite.T(), "Subscription of contract C2", b.Lines[2].Description)
+	assert.Equal(suite.T(), "109.00", b.Amount.String())
+	assert.Equal(suite.T(), "22.89", b.Tax.String())
+	assert.Equal(suite.T(), "131.89", b.Gross.String())
+}
+
+// TestDiscount_SubscriptionWithPromo tests that the discounts of a contract with a subscription still reach the toll lines it doesn't cover
+func (suite *BillingTestSuite) TestDiscount_SubscriptionWithPromo() {
+	// Arrange
+	pricing := billing.Pricing{Currency: "EUR", Contracts: suite.contracts(types.Contract{
+		ID: "C3", Customer: "Acme Haulage", OBUIDs: []int32{1}, From: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		Subscription: &types.Subscription{Fee: money.MustParse("99.00"), Zones: []string{"A"}},
+		Discounts:    []types.Discount{{Kind: types.DiscountPromo, Code: "HALF", Percent: money.NewFromInt(50)}},
+	})}
+
+	// Act
+	b, err := pricing.Bill(1, time.Now(), map[string]types.Total{"A": {Distance: 10}, "B": {Distance: 10}}, flatTariff{})
+
+	// Assert
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), []string{"0.00", "10.00", "99.00"}, amounts(b.Lines))
+	require.Len(suite.T(), b.Lines[1].Discounts, 1)
+	assert.Equal(suite.T(), "HALF", b.Lines[1].Discounts[0].Code)
+	assert.Empty(suite.T(), b.Lines[2].Discounts)
+	assert.Equal(suite.T(), "109.00", b.Amount.String())
+}
+
+// TestClose_BillsUnderContractInForceAtPeriodEnd tests that a closed period is discounted by the contract in force when it ended, not by a later one
+func (suite *BillingTestSuite) TestClose_BillsUnderContractInForceAtPeriodEnd() {
+	// Arrange
+	promo := []types.Discount{{Kind: types.DiscountPromo, Code: "WELCOME", Percent: money.NewFromInt(10)}}
+	pricing := billing.Pricing{Currency: "EUR", Contracts: suite.contracts(
+		types.Contract{ID: "C1", OBUIDs: []int32{1}, From: time.Date(2025, 9, 1, 0, 0, 0, 0, time.UTC), To: time.Date(2025, 10, 1, 0, 0, 0, 0, time.UTC), Discounts: promo},
+		types.Contract{ID: "C2", OBUIDs: []int32{2}, From: time.Date(2025, 10, 1, 0, 0, 0, 0, time.UTC), Discounts: promo},
+	)}
+	source := totalsSource{1: {"A": {Distance: 10}}, 2: {"A": {Distance: 10}}}
+	closer := billing.NewCloser(suite.book, source, flatTariff{}, pricing, billing.Monthly, false)
+
+	// Act
+	_, err := closer.CloseInvoices(suite.ctx, "2025-09")
+	first, _ := suite.book.Invoice(suite.ctx, "INV-2025-09-1")
+	second, _ := suite.book.Invoice(suite.ctx, "INV-2025-09-2")
+
+	// Assert
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "18.00", first.Amount.String())
+	assert.Equal(suite.T(), "WELCOME", first.Lines[0].Discounts[0].Code)
+	assert.Equal(suite.T(), "20.00", second.Amount.String())
+}
+
+// TestCloseEnded_RetriesFailedPeriodFirst tests that a period that failed to close stays pending and is closed before the periods after it
+func (suite *BillingTestSuite) TestCloseEnded_RetriesFailedPeriodFirst() {
+	// Arrange
+	source := &flakySource{totalsSource: totalsSource{1: {"A": {Distance: 10}}}, fails: 1}
+	closer := billing.NewCloser(suite.book, source, flatTariff{}, billing.Pricing{Currency: "EUR"}, billing.Monthly, false)
+	september, _ := billing.Monthly.Parse("2025-09")
+	now := time.Date(2025, 11, 2, 0, 0, 0, 0, time.UTC)
+
+	// Act
+	failed := closer.CloseEnded(september, now)
+	retried := closer.CloseEnded(failed, now)
+
+	// Assert
+	assert.Equal(suite.T(), "2025-09", failed.Label())
+	assert.Equal(suite.T(), "2025-11", retried.Label())
+	inv, err := suite.book.Invoice(suite.ctx, "INV-2025-09-1")
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "20.00", inv.Amount.String())
+	_, err = closer.CloseInvoices(suite.ctx, "2025-09")
+	assert.True(suite.T(), apperr.IsCode(err, apperr.Conflict), "%v", err)
+}
+
+// TestTotal_PartsAddUpAgain tests that the parts of a total with estimated and off-peak distance add up to it again
+func (suite *BillingTestSuite) TestTotal_PartsAddUpAgain() {
+	// Arrange
+	total := types.Total{Distance: 10, Estimated: 3, OffPeak: 8}
+	var again types.Total
+
+	// Act
+	parts := total.Parts(1, "A", 0)
+	for _, p := range parts {
+		again.Add(p)
+	}
+
+	// Assert
+	assert.Len(suite.T(), parts, 3)
+	assert.Equal(suite.T(), total, again)
+}
+
+// Run the billing test suite
+func TestBillingTestSuite(t *testing.T) {
+	suite.Run(t, new(BillingTestSuite))
+}
@@ -0,0 +1,220 @@
+package unit
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/0x0Glitch/toll-calculator/apperr"
+	"github.com/0x0Glitch/toll-calculator/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+// ConfigReloadTestSuite tests reloading a service config while it runs
+type ConfigReloadTestSuite struct {
+	suite.Suite
+	path    string
+	reg     *prometheus.Registry
+	watcher *config.Watcher[config.Gateway]
+}
+
+// SetupTest starts a watcher on a scratch config file before each test
+func (suite *ConfigReloadTestSuite) SetupTest() {
+	suite.T().Setenv(config.FileEnv, "")
+	suite.path = filepath.Join(suite.T().TempDir(), "gateway.yaml")
+	suite.writeFile("logLevel: info\nrateLimit: 10\n")
+	suite.reg = prometheus.NewRegistry()
+	w, err := config.NewWatcher[config.Gateway]([]string{"-config", suite.path}, suite.reg)
+	require.NoError(suite.T(), err)
+	suite.watcher = w
+}
+
+func (suite *ConfigReloadTestSuite) writeFile(content string) {
+	require.NoError(suite.T(), os.WriteFile(suite.path, []byte(content), 0o600))
+}
+
+// reloads returns the count of reloads with the given result, or the config
+// version for an empty result.
+func (suite *ConfigReloadTestSuite) reloads(result string) float64 {
+	families, err := suite.reg.Gather()
+	require.NoError(suite.T(), err)
+	for _, f := range families {
+		for _, m := range f.GetMetric() {
+			if result == "" && f.GetName() == "toll_config_version" {
+				return m.GetGauge().GetValue()
+			}
+			if f.GetName() == "toll_config_reloads_total" && m.GetLabel()[0].GetValue() == result {
+				return m.GetCounter().GetValue()
+			}
+		}
+	}
+	return 0
+}
+
+// TestReload_AppliesReloadableSettings tests that a valid change is applied and bumps the version
+func (suite *ConfigReloadTestSuite) TestReload_AppliesReloadableSettings() {
+	// Arrange
+	var applied float64
+	suite.watcher.OnReload(func(_, next *config.Gateway) error {
+		applied = next.RateLimit
+		return nil
+	})
+	suite.writeFile("logLevel: debug\nrateLimit: 25\n")
+
+	// Act
+	err := suite.watcher.Reload()
+
+	// Assert
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), 25.0, applied)
+	assert.Equal(suite.T(), int64(2), suite.watcher.Version())
+	assert.Equal(suite.T(), "debug", suite.watcher.Current().LogLevel)
+	assert.Equal(suite.T(), 1.0, suite.reloads("applied"))
+}
+
+// TestReload_UnchangedKeepsVersion tests that reloading an identical config is a no-op
+func (suite *ConfigReloadTestSuite) TestReload_UnchangedKeepsVersion() {
+	// Arrange
+	calls := 0
+	suite.watcher.OnReload(func(_, _ *config.Gateway) error {
+		calls++
+		return nil
+	})
+
+	// Act
+	err := suite.watcher.Reload()
+
+	// Assert
+	require.NoError(suite.T(), err)
+	assert.Zero(suite.T(), calls)
+	assert.Equal(suite.T(), int64(1), suite.watcher.Version())
+	assert.Equal(suite.T(), 1.0, suite.reloads("unchanged"))
+}
+
+// TestReload_RejectsInvalidConfig tests that a config failing validation leaves the running one in place
+func (suite *ConfigReloadTestSuite) TestReload_RejectsInvalidConfig() {
+	// Arrange
+	before := suite.watcher.Current()
+	suite.writeFile("logLevel: loud\nrateLimit: -1\n")
+
+	// Act
+	err := suite.watcher.Reload()
+
+	// Assert
+	require.Error(suite.T(), err)
+	assert.True(suite.T(), apperr.IsCode(err, apperr.InvalidArgument))
+	assert.Contains(suite.T(), err.Error(), "rateLimit: must not be negative")
+	assert.Same(suite.T(), before, suite.watcher.Current())
+	assert.Equal(suite.T(), int64(1), suite.watcher.Version())
+	assert.Equal(suite.T(), 1.0, suite.reloads("failed"))
+}
+
+// TestReload_RejectsRestartOnlyChanges tests that settings not tagged reloadable can't change at runtime
+func (suite *ConfigReloadTestSuite) TestReload_RejectsRestartOnlyChanges() {
+	// Arrange
+	suite.writeFile("logLevel: debug\nrateLimit: 10\nlistenAddr: \":7000\"\n")
+
+	// Act
+	err := suite.watcher.Reload()
+
+	// Assert
+	require.Error(suite.T(), err)
+	assert.Contains(suite.T(), err.Error(), "listenAddr can't change without a restart")
+	assert.Equal(suite.T(), "info", suite.watcher.Current().LogLevel)
+}
+
+// TestReload_RollsBackAppliedSteps tests that a failing step undoes the steps applied before it
+func (suite *ConfigReloadTestSuite) TestReload_RollsBackAppliedSteps() {
+	// Arrange
+	limit := suite.watcher.Current().RateLimit
+	suite.watcher.OnReload(func(_, next *config.Gateway) error {
+		limit = next.RateLimit
+		return nil
+	})
+	suite.watcher.OnReload(func(_, _ *config.Gateway) error {
+		return errors.New("limiter unavailable")
+	})
+	suite.writeFile("logLevel: info\nrateLimit: 50\n")
+
+	// Act
+	err := suite.watcher.Reload()
+
+	// Assert
+	require.Error(suite.T(), err)
+	assert.Contains(suite.T(), err.Error(), "limiter unavailable")
+	assert.Equal(suite.T(), 10.0, limit)
+	assert.Equal(suite.T(), 10.0, suite.watcher.Current().RateLimit)
+	assert.Equal(suite.T(), int64(1), suite.watcher.Version())
+}
+
+// TestRegister_ServesStatusAndReload tests the admin endpoint and the version gauge on /metrics
+func (suite *ConfigReloadTestSuite) TestRegister_ServesStatusAndReload() {
+	// Arrange
+	mux := http.NewServeMux()
+	suite.watcher.Register(mux)
+	suite.writeFile("logLevel: warn\nrateLimit: 10\n")
+
+	// Act
+	reload := httptest.NewRecorder()
+	mux.ServeHTTP(reload, httptest.NewRequest(http.MethodPost, "/admin/config/reload", nil))
+	status := httptest.NewRecorder()
+	mux.ServeHTTP(status, httptest.NewRequest(http.MethodGet, "/admin/config", nil))
+	wrongMethod := httptest.NewRecorder()
+	mux.ServeHTTP(wrongMethod, httptest.NewRequest(http.MethodGet, "/admin/config/reload", nil))
+
+	// Assert
+	assert.Equal(suite.T(), http.StatusOK, reload.Code)
+	require.Equal(suite.T(), http.StatusOK, status.Code)
+	var body config.Status
+	require.NoError(suite.T(), json.NewDecoder(status.Body).Decode(&body))
+	assert.Equal(suite.T(), int64(2), body.Version)
+	assert.Equal(suite.T(), "warn", body.Config["logLevel"])
+	assert.Equal(suite.T(), http.StatusMethodNotAllowed, wrongMethod.Code)
+	assert.Equal(suite.T(), 2.0, suite.reloads(""))
+}
+
+// TestRegister_RejectedReloadIsBadRequest tests that the admin endpoint reports a rejected reload
+func (suite *ConfigReloadTestSuite) TestRegister_RejectedReloadIsBadRequest() {
+	// Arrange
+	mux := http.NewServeMux()
+	suite.watcher.Register(mux)
+	suite.writeFile("rateLimit: lots\n")
+
+	// Act
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/admin/config/reload", nil))
+
+	// Assert
+	assert.Equal(suite.T(), http.StatusBadRequest, rec.Code)
+	var body apperr.Body
+	require.NoError(suite.T(), json.NewDecoder(rec.Body).Decode(&body))
+	assert.Equal(suite.T(), apperr.InvalidArgument, body.Code)
+}
+
+// TestLimit_ConvertsRateSettings tests that a zero rate is unlimited and bursts cover one second
+func (suite *ConfigReloadTestSuite) TestLimit_ConvertsRateSettings() {
+	// Act
+	unlimited, unlimitedBurst := config.Limit(0)
+	slow, slowBurst := config.Limit(0.5)
+	fast, fastBurst := config.Limit(40)
+
+	// Assert
+	assert.True(suite.T(), float64(unlimited) > 1e300)
+	assert.Equal(suite.T(), 1, unlimitedBurst)
+	assert.Equal(suite.T(), 0.5, float64(slow))
+	assert.Equal(suite.T(), 1, slowBurst)
+	assert.Equal(suite.T(), 40.0, float64(fast))
+	assert.Equal(suite.T(), 40, fastBurst)
+}
+
+// Run the config reload test suite
+func TestConfigReloadTestSuite(t *testing.T) {
+	suite.Run(t, new(ConfigReloadTestSuite))
+}
@@ -0,0 +1,180 @@
+package unit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/0x0Glitch/toll-calculator/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+// ConfigTestSuite tests loading, validating and printing the service configs
+type ConfigTestSuite struct {
+	suite.Suite
+	dir string
+}
+
+// SetupTest clears the config file variable and creates a scratch directory before each test
+func (suite *ConfigTestSuite) SetupTest() {
+	suite.T().Setenv(config.FileEnv, "")
+	suite.dir = suite.T().TempDir()
+}
+
+func (suite *ConfigTestSuite) writeFile(content string) string {
+	path := filepath.Join(suite.dir, "config.yaml")
+	require.NoError(suite.T(), os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+// TestLoad_Defaults tests that a service runs without any configuration
+func (suite *ConfigTestSuite) TestLoad_Defaults() {
+	// Arrange
+	var cfg config.Calculator
+
+	// Act
+	err := config.Load(&cfg, nil)
+
+	// Assert
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), ":9091", cfg.MetricsAddr)
+	assert.Equal(suite.T(), "obudata", cfg.Kafka.Topic)
+	assert.Equal(suite.T(), "myGroup", cfg.GroupID)
+	assert.Equal(suite.T(), "roundrobin", cfg.Aggregator.Balance)
+	assert.Equal(suite.T(), 15*time.Second, cfg.ShutdownTimeout)
+}
+
+// TestLoad_Precedence tests that flags beat the environment, which beats the file
+func (suite *ConfigTestSuite) TestLoad_Precedence() {
+	// Arrange
+	path := suite.writeFile(`
+httpAddr: ":4000"
+grpcAddr: ":4001"
+logLevel: warn
+cluster:
+  self: http://agg-1:4000
+  members: agg-1:4000,agg-2:4000
+tls:
+  caFile: /etc/toll/ca.pem
+  certFile: /etc/toll/agg-1.pem
+  keyFile: /etc/toll/agg-1-key.pem
+`)
+	suite.T().Setenv(config.FileEnv, path)
+	suite.T().Setenv("AGG_GRPC_LISTEN_ADDR", ":5001")
+	suite.T().Setenv("LOG_LEVEL", "debug")
+	var cfg config.Aggregator
+
+	// Act
+	err := config.Load(&cfg, []string{"-log-level", "error"})
+
+	// Assert
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), ":4000", cfg.HTTPAddr, "file over default")
+	assert.Equal(suite.T(), ":5001", cfg.GRPCAddr, "env over file")
+	assert.Equal(suite.T(), "error", cfg.LogLevel, "flag over env")
+	assert.Equal(suite.T(), "http://agg-1:4000", cfg.Cluster.Self)
+	assert.Equal(suite.T(), 10*time.Second, cfg.Cluster.PollInterval)
+}
+
+// TestLoad_ReportsEveryProblem tests that validation lists all invalid settings at once
+func (suite *ConfigTestSuite) TestLoad_ReportsEveryProblem() {
+	// Arrange
+	var cfg config.Gateway
+
+	// Act
+	err := config.Load(&cfg, []string{"-listenAddr", "6000", "-balance", "random", "-traces-exporter", "zipkin"})
+
+	// Assert
+	require.Error(suite.T(), err)
+	assert.Contains(suite.T(), err.Error(), "invalid gateway config")
+	assert.Contains(suite.T(), err.Error(), `listenAddr: "6000" is not a host:port address`)
+	assert.Contains(suite.T(), err.Error(), `aggregator.balance: unknown policy "random"`)
+	assert.Contains(suite.T(), err.Error(), `tracesExporter: unknown exporter "zipkin"`)
+}
+
+// TestLoad_AuthenticatesClusterAndAdmin tests that a cluster needs mutual TLS and the admin listener a token
+func (suite *ConfigTestSuite) TestLoad_AuthenticatesClusterAndAdmin() {
+	// Arrange
+	var cfg config.Aggregator
+
+	// Act
+	err := config.Load(&cfg, []string{"-cluster-self", "http://agg-1:3000", "-cluster-members", "agg-1:3000", "-admin-addr", ":3002"})
+
+	// Assert
+	require.Error(suite.T(), err)
+	assert.Contains(suite.T(), err.Error(), "cluster.self: needs tls to authenticate the members")
+	assert.Contains(suite.T(), err.Error(), "admin.token: must be set with admin.addr")
+}
+
+// TestLoad_NamesTheSource tests that malformed values and unknown keys point at where they came from
+func (suite *ConfigTestSuite) TestLoad_NamesTheSource() {
+	// Arrange
+	var fromFile, fromEnv config.Receiver
+	path := suite.writeFile("listenadr: \":30000\"\n")
+
+	// Act
+	fileErr := config.Load(&fromFile, []string{"-config", path})
+	suite.T().Setenv("SHUTDOWN_TIMEOUT", "soon")
+	envErr := config.Load(&fromEnv, nil)
+
+	// Assert
+	require.Error(suite.T(), envErr)
+	assert.Contains(suite.T(), envErr.Error(), `SHUTDOWN_TIMEOUT="soon"`)
+	require.Error(suite.T(), fileErr)
+	assert.Contains(suite.T(), fileErr.Error(), "listenadr")
+}
+
+// TestDump_RedactsSecrets tests that the effective config never prints secrets
+func (suite *ConfigTestSuite) TestDump_RedactsSecrets() {
+	// Arrange
+	suite.T().Setenv("KAFKA_SASL_USERNAME", "toll")
+	suite.T().Setenv("KAFKA_SASL_PASSWORD", "hunter2")
+	var cfg config.Receiver
+	require.NoError(suite.T(), config.Load(&cfg, nil))
+
+	// Act
+	dump := config.Dump(&cfg)
+
+	// Assert
+	assert.Contains(suite.T(), dump, "kafka.saslUsername: toll\n")
+	assert.Contains(suite.T(), dump, "kafka.saslPassword: [redacted]\n")
+	assert.NotContains(suite.T(), dump, "hunter2")
+	assert.Contains(suite.T(), dump, "listenAddr: :30000\n")
+	assert.Equal(suite.T(), "hunter2", cfg.Kafka.SASLPassword)
+}
+
+// TestLoad_SecretsAreNotFlags tests that secrets can't be passed on the command line
+func (suite *ConfigTestSuite) TestLoad_SecretsAreNotFlags() {
+	// Arrange
+	var cfg config.Receiver
+
+	// Act
+	err := config.Load(&cfg, []string{"-kafka-sasl-password", "hunter2"})
+
+	// Assert
+	assert.Error(suite.T(), err)
+}
+
+// TestKafkaConfigMap_EnablesSASL tests that credentials switch the Kafka client to SASL
+func (suite *ConfigTestSuite) TestKafkaConfigMap_EnablesSASL() {
+	// Arrange
+	plain := config.Kafka{Brokers: "kafka:9092"}
+	sasl := config.Kafka{Brokers: "kafka:9092", SASLUsername: "toll", SASLPassword: "hunter2"}
+
+	// Act
+	plainMap, saslMap := *plain.ConfigMap(), *sasl.ConfigMap()
+
+	// Assert
+	assert.Equal(suite.T(), "kafka:9092", plainMap["bootstrap.servers"])
+	assert.NotContains(suite.T(), plainMap, "security.protocol")
+	assert.Equal(suite.T(), "SASL_SSL", saslMap["security.protocol"])
+	assert.Equal(suite.T(), "hunter2", saslMap["sasl.password"])
+}
+
+// Run the config test suite
+func TestConfigTestSuite(t *testing.T) {
+	suite.Run(t, new(ConfigTestSuite))
+}
@@ -0,0 +1,226 @@
+package unit
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/0x0Glitch/toll-calculator/apperr"
+	"github.com/0x0Glitch/toll-calculator/config"
+	"github.com/0x0Glitch/toll-calculator/distance_calculator/consumer"
+	"github.com/0x0Glitch/toll-calculator/types"
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+// fakeSource serves its messages once, then times out, and records the stored offsets
+type fakeSource struct {
+	mu       sync.Mutex
+	messages []*kafka.Message
+	stored   []*kafka.Message
+}
+
+func (f *fakeSource) ReadMessage(timeout time.Duration) (*kafka.Message, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.messages) == 0 {
+		time.Sleep(time.Millisecond)
+		return nil, kafka.NewError(kafka.ErrTimedOut, "timed out", false)
+	}
+	msg := f.messages[0]
+	f.messages = f.messages[1:]
+	return msg, nil
+}
+
+func (f *fakeSource) StoreMessage(m *kafka.Message) ([]kafka.TopicPartition, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.stored = append(f.stored, m)
+	return nil, nil
+}
+
+func (f *fakeSource) Stored() []*kafka.Message {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]*kafka.Message(nil), f.stored...)
+}
+
+func (f *fakeSource) GetWatermarkOffsets(topic string, partition int32) (int64, int64, error) {
+	return 0, 1, nil
+}
+
+func (f *fakeSource) Commit() ([]kafka.TopicPartition, error) { return nil, nil }
+
+func (f *fakeSource) Close() error { return nil }
+
+// fixedCalculator returns the same legs for every fix
+type fixedCalculator struct {
+	legs []types.Distance
+}
+
+func (c fixedCalculator) CalculateDistance(types.OBUData) ([]types.Distance, error) {
+	return c.legs, nil
+}
+
+// flakyAggregator fails the first failures Aggregate calls with err, every call if negative, and records the rest
+type flakyAggregator struct {
+	mu       sync.Mutex
+	err      error
+	failures int
+	calls    int
+	got      []*types.AggregatorRequest
+}
+
+func (a *flakyAggregator) Aggregate(_ context.Context, req *types.AggregatorRequest) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.calls++
+	if a.failures != 0 {
+		a.failures--
+		return a.err
+	}
+	a.got = append(a.got, req)
+	return nil
+}
+
+func (a *flakyAggregator) GetInvoice(context.Context, int) (*types.Invoice, error) {
+	return nil, apperr.NotFoundf("no invoice")
+}
+
+func (a *flakyAggregator) Calls() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.calls
+}
+
+// ConsumerTestSuite tests when the distance calculator stores the offset of a fix
+type ConsumerTestSuite struct {
+	suite.Suite
+}
+
+func (suite *ConsumerTestSuite) message(value []byte) *kafka.Message {
+	topic := "obudata"
+	return &kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: 0, Offset: 42},
+		Value:          value,
+	}
+}
+
+func (suite *ConsumerTestSuite) fix() []byte {
+	b, err := json.Marshal(types.OBUData{OBUID: 7, Lat: 52.52, Long: 13.40})
+	require.NoError(suite.T(), err)
+	return b
+}
+
+func (suite *ConsumerTestSuite) start(src *fakeSource, agg *flakyAggregator, legs ...types.Distance) (context.CancelFunc, <-chan struct{}) {
+	var cfg config.Calculator
+	cfg.ShutdownTimeout = time.Second
+	cfg.RetryBackoff = 10 * time.Millisecond
+	c := consumer.NewKafkaConsumer(cfg, src, fixedCalculator{legs: legs}, agg, nil, prometheus.NewRegistry())
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.Start(ctx)
+	}()
+	return cancel, done
+}
+
+// TestStart_AggregatorDownKeepsOffset tests that a fix whose distance the aggregator never took is read again after a restart
+func (suite *ConsumerTestSuite) TestStart_AggregatorDownKeepsOffset() {
+	// Arrange
+	src := &fakeSource{messages: []*kafka.Message{suite.message(suite.fix())}}
+	agg := &flakyAggregator{err: apperr.Unavailablef("aggregator down"), failures: -1}
+	cancel, done := suite.start(src, agg, types.Distance{Values: 1.5})
+
+	// Act
+	require.Eventually(suite.T(), func() bool { return agg.Calls() >= 3 }, time.Second, time.Millisecond)
+	cancel()
+	<-done
+
+	// Assert
+	assert.Empty(suite.T(), src.Stored())
+}
+
+// TestStart_RetriesUntilDelivered tests that only the legs the aggregator failed to take are sent again before the offset is stored
+func (suite *ConsumerTestSuite) TestStart_RetriesUntilDelivered() {
+	// Arrange
+	msg := suite.message(suite.fix())
+	src := &fakeSource{messages: []*kafka.Message{msg}}
+	agg := &flakyAggregator{err: apperr.Unavailablef("aggregator down"), failures: 2}
+	cancel, done := suite.start(src, agg,
+		types.Distance{Values: 1.5, ZoneID: "center"},
+		types.Distance{Values: 0.5, ZoneID: "ring"},
+	)
+
+	// Act
+	require.Eventually(suite.T(), func() bool { return len(src.Stored()) == 1 }, time.Second, time.Millisecond)
+	cancel()
+	<-done
+
+	// Assert
+	assert.Same(suite.T(), msg, src.Stored()[0])
+	require.Len(suite.T(), agg.got, 2)
+	assert.Equal(suite.T(), "center", agg.got[0].ZoneID)
+	assert.Equal(suite.T(), "ring", agg.got[1].ZoneID)
+}
+
+// TestStart_DatesLegsByTheFix tests that a leg is dated by when its fix was driven, however late it is handled
+func (suite *ConsumerTestSuite) TestStart_DatesLegsByTheFix() {
+	// Arrange
+	driven := time.Date(2025, 9, 30, 8, 15, 0, 0, time.UTC)
+	b, err := json.Marshal(types.OBUData{OBUID: 7, Lat: 52.52, Long: 13.40, Unix: driven.UnixMilli()})
+	require.NoError(suite.T(), err)
+	src := &fakeSource{messages: []*kafka.Message{suite.message(b)}}
+	agg := &flakyAggregator{}
+	cancel, done := suite.start(src, agg, types.Distance{Values: 1.5})
+
+	// Act
+	require.Eventually(suite.T(), func() bool { return len(src.Stored()) == 1 }, time.Second, time.Millisecond)
+	cancel()
+	<-done
+
+	// Assert
+	require.Len(suite.T(), agg.got, 1)
+	assert.Equal(suite.T(), driven.UnixNano(), agg.got[0].Unix)
+}
+
+// TestStart_PermanentErrorsStoreOffset tests that fixes retrying can't deliver don't hold up the partition
+func (suite *ConsumerTestSuite) TestStart_PermanentErrorsStoreOffset() {
+	tests := []struct {
+		name  string
+		value []byte
+		err   error
+	}{
+		{name: "undecodable fix", value: []byte("not json")},
+		{name: "rejected by the aggregator", value: suite.fix(), err: apperr.InvalidArgumentf("bad distance")},
+	}
+	for _, tt := range tests {
+		suite.Run(tt.name, func() {
+			// Arrange
+			src := &fakeSource{messages: []*kafka.Message{suite.message(tt.value)}}
+			agg := &flakyAggregator{err: tt.err, failures: -1}
+			if tt.err == nil {
+				agg.failures = 0
+			}
+			cancel, done := suite.start(src, agg, types.Distance{Values: 1.5})
+
+			// Act
+			require.Eventually(suite.T(), func() bool { return len(src.Stored()) == 1 }, time.Second, time.Millisecond)
+			cancel()
+			<-done
+
+			// Assert
+			assert.LessOrEqual(suite.T(), agg.Calls(), 1)
+		})
+	}
+}
+
+func TestConsumerTestSuite(t *testing.T) {
+	suite.Run(t, new(ConsumerTestSuite))
+}
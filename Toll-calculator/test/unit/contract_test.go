@@ -0,0 +1,146 @@
+package unit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/0x0Glitch/toll-calculator/apperr"
+	"github.com/0x0Glitch/toll-calculator/contract"
+	"github.com/0x0Glitch/toll-calculator/money"
+	"github.com/0x0Glitch/toll-calculator/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+// ContractTestSuite tests the contracts of fleet customers and the off-peak hours
+type ContractTestSuite struct {
+	suite.Suite
+	from time.Time
+}
+
+// SetupTest sets the start of the contracts
+func (suite *ContractTestSuite) SetupTest() {
+	suite.from = time.Date(2025, 9, 1, 0, 0, 0, 0, time.UTC)
+}
+
+// promo returns a contract for the OBUs from to to taking 10% off
+func (suite *ContractTestSuite) promo(id string, from, to time.Time, obuIDs ...int32) types.Contract {
+	return types.Contract{
+		ID: id, Customer: "Acme Haulage", OBUIDs: obuIDs, From: from, To: to,
+		Discounts: []types.Discount{{Kind: types.DiscountPromo, Code: "WELCOME", Percent: money.NewFromInt(10)}},
+	}
+}
+
+// TestValidate_RejectsInvalidContracts tests that contracts without vehicles, period or terms, and malformed discounts are rejected
+func (suite *ContractTestSuite) TestValidate_RejectsInvalidContracts() {
+	valid := suite.promo("C1", suite.from, time.Time{}, 1)
+	testCases := map[string]func(c *types.Contract){
+		"no id":          func(c *types.Contract) { c.ID = "" },
+		"no vehicles":    func(c *types.Contract) { c.OBUIDs = nil },
+		"no start":       func(c *types.Contract) { c.From = time.Time{} },
+		"ends too early": func(c *types.Contract) { c.To = c.From },
+		"no terms":       func(c *types.Contract) { c.Discounts = nil },
+		"bad currency":   func(c *types.Contract) { c.Currency = "XYZ" },
+		"negative fee":   func(c *types.Contract) { c.Subscription = &types.Subscription{Fee: money.NewFromInt(-1)} },
+		"unknown kind":   func(c *types.Contract) { c.Discounts[0].Kind = "loyalty" },
+		"promo no code":  func(c *types.Contract) { c.Discounts[0].Code = "" },
+		"promo both":     func(c *types.Contract) { c.Discounts[0].Amount = money.NewFromInt(5) },
+		"over 100%":      func(c *types.Contract) { c.Discounts[0].Percent = money.NewFromInt(101) },
+		"volume amount": func(c *types.Contract) {
+			c.Discounts[0] = types.Discount{Kind: types.DiscountVolume, Percent: money.NewFromInt(5), Amount: money.NewFromInt(5)}
+		},
+		"off-peak without percent": func(c *types.Contract) { c.Discounts[0] = types.Discount{Kind: types.DiscountOffPeak} },
+	}
+
+	require.NoError(suite.T(), contract.Validate(valid))
+	for name, mutate := range testCases {
+		c := valid
+		c.Discounts = []types.Discount{valid.Discounts[0]}
+		mutate(&c)
+		err := contract.Validate(c)
+		assert.True(suite.T(), apperr.IsCode(err, apperr.InvalidArgument), name)
+	}
+}
+
+// TestRegistry_OneContractPerVehicleAtATime tests that overlapping contracts can't cover the same vehicle, while consecutive ones can
+func (suite *ContractTestSuite) TestRegistry_OneContractPerVehicleAtATime() {
+	// Arrange
+	registry := contract.NewRegistry()
+	october := suite.from.AddDate(0, 1, 0)
+	require.NoError(suite.T(), registry.Put(suite.promo("C1", suite.from, october, 1, 2)))
+
+	// Act
+	overlapErr := registry.Put(suite.promo("C2", suite.from.AddDate(0, 0, 14), time.Time{}, 2, 3))
+	nextErr := registry.Put(suite.promo("C3", october, time.Time{}, 1))
+	replaceErr := registry.Put(suite.promo("C1", suite.from, october, 1, 2, 4))
+	september, inSeptember := registry.For(4, suite.from.AddDate(0, 0, 29))
+	later, inOctober := registry.For(1, october)
+	_, notCovered := registry.For(3, october)
+
+	// Assert
+	assert.True(suite.T(), apperr.IsCode(overlapErr, apperr.Conflict))
+	require.NoError(suite.T(), nextErr)
+	require.NoError(suite.T(), replaceErr)
+	assert.True(suite.T(), inSeptember)
+	assert.Equal(suite.T(), "C1", september.ID)
+	assert.True(suite.T(), inOctober)
+	assert.Equal(suite.T(), "C3", later.ID)
+	assert.False(suite.T(), notCovered)
+	assert.Len(suite.T(), registry.Contracts(), 2)
+}
+
+// TestRegistry_Delete tests that a deleted contract no longer applies and deleting it again isn't found
+func (suite *ContractTestSuite) TestRegistry_Delete() {
+	// Arrange
+	registry := contract.NewRegistry()
+	require.NoError(suite.T(), registry.Put(suite.promo("C1", suite.from, time.Time{}, 1)))
+
+	// Act
+	err := registry.Delete("C1")
+	againErr := registry.Delete("C1")
+	_, covered := registry.For(1, suite.from)
+	_, getErr := registry.Contract("C1")
+
+	// Assert
+	require.NoError(suite.T(), err)
+	assert.True(suite.T(), apperr.IsCode(againErr, apperr.NotFound))
+	assert.False(suite.T(), covered)
+	assert.True(suite.T(), apperr.IsCode(getErr, apperr.NotFound))
+}
+
+// TestHours_Contains tests off-peak windows running past midnight, weekends and time zones
+func (suite *ContractTestSuite) TestHours_Contains() {
+	// Arrange
+	amsterdam, err := time.LoadLocation("Europe/Amsterdam")
+	require.NoError(suite.T(), err)
+	night, err := contract.ParseHours("22:00-06:00", true, amsterdam)
+	require.NoError(suite.T(), err)
+	_, badErr := contract.ParseHours("22:00-25:00", false, time.UTC)
+	testCases := []struct {
+		at      time.Time
+		offPeak bool
+	}{
+		{time.Date(2025, 9, 3, 21, 30, 0, 0, time.UTC), true}, // 23:30 in Amsterdam
+		{time.Date(2025, 9, 3, 3, 59, 0, 0, time.UTC), true},  // 05:59
+		{time.Date(2025, 9, 3, 4, 0, 0, 0, time.UTC), false},  // 06:00
+		{time.Date(2025, 9, 3, 12, 0, 0, 0, time.UTC), false}, // a Wednesday noon
+		{time.Date(2025, 9, 6, 12, 0, 0, 0, time.UTC), true},  // a Saturday noon
+		{time.Date(2025, 9, 7, 21, 59, 0, 0, time.UTC), true}, // Sunday 23:59
+		{time.Date(2025, 9, 7, 22, 30, 0, 0, time.UTC), true}, // Monday 00:30
+		{time.Date(2025, 9, 8, 7, 0, 0, 0, time.UTC), false},  // Monday 09:00
+	}
+
+	// Assert
+	assert.Error(suite.T(), badErr)
+	var none *contract.Hours
+	assert.False(suite.T(), none.Contains(time.Now()))
+	for _, tc := range testCases {
+		assert.Equal(suite.T(), tc.offPeak, night.Contains(tc.at), tc.at)
+	}
+}
+
+// Run the contract test suite
+func TestContractTestSuite(t *testing.T) {
+	suite.Run(t, new(ContractTestSuite))
+}
@@ -0,0 +1,210 @@
+package unit
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/0x0Glitch/toll-calculator/export"
+	"github.com/0x0Glitch/toll-calculator/money"
+	"github.com/0x0Glitch/toll-calculator/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+// ExportTestSuite tests the bulk and PDF exports of invoices
+type ExportTestSuite struct {
+	suite.Suite
+	invoice types.Invoice
+}
+
+// SetupTest builds a finalized invoice in EUR billing two zones, one at a tariff in CHF
+func (suite *ExportTestSuite) SetupTest() {
+	suite.invoice = types.Invoice{
+		ID:            "INV-2025-09-1",
+		OBUID:         1,
+		Status:        types.InvoiceFinalized,
+		Period:        "2025-09",
+		PeriodStart:   time.Date(2025, 9, 1, 0, 0, 0, 0, time.UTC),
+		PeriodEnd:     time.Date(2025, 10, 1, 0, 0, 0, 0, time.UTC),
+		TariffVersion: "flat-1",
+		Currency:      "EUR",
+		Lines: []types.InvoiceLine{
+			{
+				ZoneID: "A", Distance: 10, UnitPrice: money.NewFromInt(2), Currency: "EUR", Amount: money.MustParse("20.00"),
+				Jurisdiction: "NL", TaxTreatment: types.TaxStandard, TaxRate: money.MustParse("0.21"), Tax: money.MustParse("4.20"),
+			},
+			{
+				ZoneID: "B", Description: "Bridge (north)", Distance: 1.5, Estimated: 0.5,
+				UnitPrice: money.NewFromInt(4), Currency: "CHF", ExchangeRate: money.MustParse("1.0624734382"), Amount: money.MustParse("6.37"),
+				Jurisdiction: "CH", TaxTreatment: types.TaxExempt,
+			},
+		},
+		TotalDistance: 11.5,
+		Amount:        money.MustParse("26.37"),
+		Tax:           money.MustParse("4.20"),
+		Gross:         money.MustParse("30.57"),
+	}
+}
+
+// TestParseFormat_DefaultsToJSONL tests that a missing format is JSON Lines and an unknown one an error
+func (suite *ExportTestSuite) TestParseFormat_DefaultsToJSONL() {
+	// Act
+	empty, emptyErr := export.ParseFormat("")
+	_, badErr := export.ParseFormat("xlsx")
+
+	// Assert
+	require.NoError(suite.T(), emptyErr)
+	assert.Equal(suite.T(), export.JSONL, empty)
+	assert.Error(suite.T(), badErr)
+}
+
+// TestCSV_RowPerLineItem tests that the CSV export has a header and a row per line item
+func (suite *ExportTestSuite) TestCSV_RowPerLineItem() {
+	// Arrange
+	var buf bytes.Buffer
+	w := export.NewWriter(&buf, export.CSV)
+
+	// Act
+	require.NoError(suite.T(), w.Write(suite.invoice))
+	require.NoError(suite.T(), w.Write(types.Invoice{ID: "INV-2025-09-2", OBUID: 2, Period: "2025-09"}))
+	require.NoError(suite.T(), w.Flush())
+	rows, err := csv.NewReader(&buf).ReadAll()
+
+	// Assert
+	require.NoError(suite.T(), err)
+	require.Len(suite.T(), rows, 4)
+	assert.Equal(suite.T(), "invoice_id", rows[0][0])
+	assert.Equal(suite.T(), []string{
+		"INV-2025-09-1", "1", "2025-09", "2025-09-01T00:00:00Z", "2025-10-01T00:00:00Z", "finalized", "flat-1", "EUR",
+		"B", "Bridge (north)", "1.5", "0.5", "4", "CHF", "1.0624734382", "6.37", "CH", "exempt", "0", "0", "", "", "",
+	}, rows[2])
+	assert.Equal(suite.T(), []string{"NL", "standard", "0.21", "4.20"}, rows[1][16:20])
+	assert.Equal(suite.T(), "", rows[1][14])
+	assert.Equal(suite.T(), "INV-2025-09-2", rows[3][0])
+	assert.Equal(suite.T(), "", rows[3][8])
+}
+
+// TestCSV_EmptyExportHasHeader tests that an export without invoices is still a valid CSV file
+func (suite *ExportTestSuite) TestCSV_EmptyExportHasHeader() {
+	// Arrange
+	var buf bytes.Buffer
+
+	// Act
+	err := export.NewWriter(&buf, export.CSV).Flush()
+
+	// Assert
+	require.NoError(suite.T(), err)
+	assert.True(suite.T(), strings.HasPrefix(buf.String(), "invoice_id,"))
+}
+
+// TestDiscounts_ListedInCSVAndPDF tests that the discounts of a line are listed with its list amount in the CSV export and under it in the PDF
+func (suite *ExportTestSuite) TestDiscounts_ListedInCSVAndPDF() {
+	// Arrange
+	inv := suite.invoice
+	inv.Lines = slices.Clone(inv.Lines)
+	inv.Lines[0].ListAmount = money.MustParse("25.00")
+	inv.Lines[0].Discounts = []types.AppliedDiscount{
+		{Contract: "C1", Kind: types.DiscountVolume, Description: "10% off the distance beyond 100", Amount: money.MustParse("2.50")},
+		{Contract: "C1", Kind: types.DiscountPromo, Code: "SPRING", Description: "Promo code SPRING: 2.50 EUR off", Amount: money.MustParse("2.50")},
+	}
+	var csvBuf, pdfBuf bytes.Buffer
+	w := export.NewWriter(&csvBuf, export.CSV)
+
+	// Act
+	require.NoError(suite.T(), w.Write(inv))
+	require.NoError(suite.T(), w.Flush())
+	rows, err := csv.NewReader(&csvBuf).ReadAll()
+	pdfErr := export.WritePDF(&pdfBuf, inv, nil)
+
+	// Assert
+	require.NoError(suite.T(), err)
+	require.NoError(suite.T(), pdfErr)
+	assert.Equal(suite.T(), []string{"list_amount", "contract", "discounts"}, rows[0][20:])
+	assert.Equal(suite.T(), []string{"25.00", "C1", "volume=2.50;promo:SPRING=2.50"}, rows[1][20:])
+	assert.Equal(suite.T(), []string{"", "", ""}, rows[2][20:])
+	pdf := pdfBuf.String()
+	assert.Contains(suite.T(), pdf, "(25.00) Tj")
+	assert.Contains(suite.T(), pdf, "(Promo code SPRING: 2.50 EUR off, contract C1) Tj")
+	assert.Contains(suite.T(), pdf, "(-2.50) Tj")
+}
+
+// TestJSONL_InvoicePerLine tests that the JSON Lines export decodes back into the invoices
+func (suite *ExportTestSuite) TestJSONL_InvoicePerLine() {
+	// Arrange
+	var buf bytes.Buffer
+	w := export.NewWriter(&buf, export.JSONL)
+
+	// Act
+	require.NoError(suite.T(), w.Write(suite.invoice))
+	require.NoError(suite.T(), w.Write(suite.invoice))
+	require.NoError(suite.T(), w.Flush())
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	var got types.Invoice
+	err := json.Unmarshal([]byte(lines[0]), &got)
+
+	// Assert
+	require.Len(suite.T(), lines, 2)
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), suite.invoice, got)
+}
+
+// TestPDF_ListsLinesAndTrips tests that the PDF holds the invoice, its line items and trips, with escaped text
+func (suite *ExportTestSuite) TestPDF_ListsLinesAndTrips() {
+	// Arrange
+	var buf bytes.Buffer
+	trips := []types.Trip{{
+		ID:       "trip-1",
+		OBUID:    1,
+		Start:    types.TripPoint{Unix: time.Date(2025, 9, 3, 8, 0, 0, 0, time.UTC).UnixMilli()},
+		End:      types.TripPoint{Unix: time.Date(2025, 9, 3, 9, 30, 0, 0, time.UTC).UnixMilli()},
+		Distance: 11.5,
+		Amount:   26.37,
+		Ended:    true,
+	}}
+
+	// Act
+	err := export.WritePDF(&buf, suite.invoice, trips)
+	pdf := buf.String()
+
+	// Assert
+	require.NoError(suite.T(), err)
+	assert.True(suite.T(), strings.HasPrefix(pdf, "%PDF-1.4\n"))
+	assert.True(suite.T(), strings.HasSuffix(pdf, "%%EOF\n"))
+	assert.Contains(suite.T(), pdf, "(Invoice INV-2025-09-1) Tj")
+	assert.Contains(suite.T(), pdf, "(Tariff version: flat-1) Tj")
+	assert.Contains(suite.T(), pdf, `(Bridge \(north\)) Tj`)
+	assert.Contains(suite.T(), pdf, "(26.37 EUR) Tj")
+	assert.Contains(suite.T(), pdf, "(B: 1 CHF = 1.0624734382 EUR) Tj")
+	assert.Contains(suite.T(), pdf, "(NL: VAT at 21% on 20.00 EUR: 4.20 EUR) Tj")
+	assert.Contains(suite.T(), pdf, "(CH: exempt on 6.37 EUR) Tj")
+	assert.Contains(suite.T(), pdf, "(Total due: 30.57 EUR) Tj")
+	assert.Contains(suite.T(), pdf, "(trip-1) Tj")
+	assert.Contains(suite.T(), pdf, "(2025-09-03 09:30) Tj")
+}
+
+// TestPDF_BreaksLongInvoicesIntoPages tests that line items beyond a page continue on the next one
+func (suite *ExportTestSuite) TestPDF_BreaksLongInvoicesIntoPages() {
+	// Arrange
+	var buf bytes.Buffer
+	inv := suite.invoice
+	inv.Lines = make([]types.InvoiceLine, 120)
+
+	// Act
+	err := export.WritePDF(&buf, inv, nil)
+
+	// Assert
+	require.NoError(suite.T(), err)
+	assert.Contains(suite.T(), buf.String(), "/Count 3")
+	assert.Contains(suite.T(), buf.String(), "(No trips recorded in the period.) Tj")
+}
+
+// Run the export test suite
+func TestExportTestSuite(t *testing.T) {
+	suite.Run(t, new(ExportTestSuite))
+}
@@ -0,0 +1,115 @@
+package unit
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/0x0Glitch/toll-calculator/filewatch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+// FilewatchTestSuite tests the polling of files that are reloaded when they change
+type FilewatchTestSuite struct {
+	suite.Suite
+	path string
+}
+
+// SetupTest writes the watched file before each test
+func (suite *FilewatchTestSuite) SetupTest() {
+	suite.path = filepath.Join(suite.T().TempDir(), "zones.json")
+	require.NoError(suite.T(), os.WriteFile(suite.path, []byte("{}"), 0o600))
+}
+
+// touch moves the file's modification time forward, as an edit does
+func (suite *FilewatchTestSuite) touch(by time.Duration) {
+	require.NoError(suite.T(), os.Chtimes(suite.path, time.Now(), time.Now().Add(by)))
+}
+
+// TestChanged_AfterEditOnly tests that a loaded file only counts as changed once it is modified
+func (suite *FilewatchTestSuite) TestChanged_AfterEditOnly() {
+	// Arrange
+	files := filewatch.New(suite.path)
+	fresh := files.Changed()
+	require.NoError(suite.T(), files.Load(func() error { return nil }))
+
+	// Act
+	loaded := files.Changed()
+	suite.touch(time.Second)
+	edited := files.Changed()
+
+	// Assert
+	assert.True(suite.T(), fresh, "never loaded")
+	assert.False(suite.T(), loaded)
+	assert.True(suite.T(), edited)
+}
+
+// TestLoad_FailureIsRetried tests that a file which failed to load still counts as changed
+func (suite *FilewatchTestSuite) TestLoad_FailureIsRetried() {
+	// Arrange
+	files := filewatch.New(suite.path)
+	require.NoError(suite.T(), files.Load(func() error { return nil }))
+	suite.touch(time.Second)
+
+	// Act
+	err := files.Load(func() error { return errors.New("half written") })
+
+	// Assert
+	assert.Error(suite.T(), err)
+	assert.True(suite.T(), files.Changed())
+}
+
+// TestChanged_IgnoresMissingFile tests that a file being replaced isn't reloaded until it is back
+func (suite *FilewatchTestSuite) TestChanged_IgnoresMissingFile() {
+	// Arrange
+	files := filewatch.New(suite.path)
+	require.NoError(suite.T(), files.Load(func() error { return nil }))
+	require.NoError(suite.T(), os.Remove(suite.path))
+
+	// Act
+	changed := files.Changed()
+	err := files.Load(func() error { return nil })
+
+	// Assert
+	assert.False(suite.T(), changed)
+	assert.Error(suite.T(), err)
+}
+
+// TestRun_ReloadsOnChange tests that Run reloads an edited file and nothing else
+func (suite *FilewatchTestSuite) TestRun_ReloadsOnChange() {
+	// Arrange
+	files := filewatch.New(suite.path)
+	require.NoError(suite.T(), files.Load(func() error { return nil }))
+	reloads := make(chan struct{}, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go files.Run(ctx, 5*time.Millisecond, "zones", func() error {
+		return files.Load(func() error {
+			reloads <- struct{}{}
+			return nil
+		})
+	})
+	time.Sleep(20 * time.Millisecond)
+
+	// Act
+	suite.touch(time.Second)
+
+	// Assert
+	select {
+	case <-reloads:
+	case <-time.After(time.Second):
+		suite.T().Fatal("edited file wasn't reloaded")
+	}
+	time.Sleep(20 * time.Millisecond)
+	assert.Empty(suite.T(), reloads, "reloaded more than once")
+}
+
+// Run the filewatch test suite
+func TestFilewatchTestSuite(t *testing.T) {
+	suite.Run(t, new(FilewatchTestSuite))
+}
@@ -0,0 +1,196 @@
+package unit
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/0x0Glitch/toll-calculator/geofence"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+// zonesJSON has a square city zone with a hole, a tolled road running east through it and a free standing zone
+const zonesJSON = `{
+  "type": "FeatureCollection",
+  "features": [
+    {"type": "Feature", "properties": {"zoneID": "city", "tariff": 500},
+     "geometry": {"type": "Polygon", "coordinates": [
+       [[0, 0], [1, 0], [1, 1], [0, 1], [0, 0]],
+       [[0.4, 0.7], [0.6, 0.7], [0.6, 0.9], [0.4, 0.9], [0.4, 0.7]]]}},
+    {"type": "Feature", "id": "A1", "properties": {"width": 100},
+     "geometry": {"type": "LineString", "coordinates": [[-1, 0.5], [0.5, 0.5]]}},
+    {"type": "Feature", "id": "A1", "properties": {"width": 100},
+     "geometry": {"type": "LineString", "coordinates": [[0.5, 0.5], [2, 0.5]]}},
+    {"type": "Feature", "id": 7,
+     "geometry": {"type": "MultiPolygon", "coordinates": [[[[5, 5], [6, 5], [6, 6], [5, 6]]]]}}
+  ]
+}`
+
+// GeofenceTestSuite tests splitting legs at the boundaries of toll zones and roads
+type GeofenceTestSuite struct {
+	suite.Suite
+	zones *geofence.Index
+}
+
+// SetupTest parses the test zones before each test
+func (suite *GeofenceTestSuite) SetupTest() {
+	var err error
+	suite.zones, err = geofence.Parse([]byte(zonesJSON))
+	require.NoError(suite.T(), err)
+}
+
+// TestParse_ReadsZonesAndRoads tests that features sharing an ID make up one zone with the right kind
+func (suite *GeofenceTestSuite) TestParse_ReadsZonesAndRoads() {
+	// Act
+	zones := suite.zones.Zones()
+
+	// Assert
+	require.Len(suite.T(), zones, 3)
+	assert.Equal(suite.T(), geofence.Zone{ID: "7", Kind: geofence.KindZone}, zones[0])
+	assert.Equal(suite.T(), geofence.Zone{ID: "A1", Kind: geofence.KindRoad, Priority: 1}, zones[1])
+	assert.Equal(suite.T(), geofence.Zone{ID: "city", Kind: geofence.KindZone, Tariff: 500}, zones[2])
+}
+
+// TestSplit_CutsAtZoneBoundaries tests that a leg through a zone is split where it enters and leaves it
+func (suite *GeofenceTestSuite) TestSplit_CutsAtZoneBoundaries() {
+	// Arrange
+	from, to := geofence.Point{Lat: 0.2, Long: -1}, geofence.Point{Lat: 0.2, Long: 2}
+
+	// Act
+	pieces := suite.zones.Split(from, to)
+
+	// Assert
+	require.Len(suite.T(), pieces, 3)
+	assert.Equal(suite.T(), []string{"", "city", ""}, zoneIDs(pieces))
+	assert.InDelta(suite.T(), 1.0/3, pieces[1].From, 1e-9)
+	assert.InDelta(suite.T(), 2.0/3, pieces[1].To, 1e-9)
+	assert.Equal(suite.T(), 0.0, pieces[0].From)
+	assert.Equal(suite.T(), 1.0, pieces[2].To)
+}
+
+// TestSplit_ExcludesHoles tests that the part of a leg inside a hole of a zone is untolled
+func (suite *GeofenceTestSuite) TestSplit_ExcludesHoles() {
+	// Arrange
+	from, to := geofence.Point{Lat: 0.8, Long: 0.2}, geofence.Point{Lat: 0.8, Long: 0.8}
+
+	// Act
+	pieces := suite.zones.Split(from, to)
+
+	// Assert
+	assert.Equal(suite.T(), []string{"city", "", "city"}, zoneIDs(pieces))
+	assert.InDelta(suite.T(), 1.0/3, pieces[1].From, 1e-9)
+	assert.InDelta(suite.T(), 2.0/3, pieces[1].To, 1e-9)
+}
+
+// TestSplit_RoadsOutrankZones tests that a leg along a tolled road through a zone is billed to the road
+func (suite *GeofenceTestSuite) TestSplit_RoadsOutrankZones() {
+	// Arrange
+	along := [2]geofence.Point{{Lat: 0.5, Long: -0.5}, {Lat: 0.5, Long: 1.5}}
+	across := [2]geofence.Point{{Lat: 0.2, Long: 0.3}, {Lat: 0.8, Long: 0.3}}
+
+	// Act
+	alongPieces := suite.zones.Split(along[0], along[1])
+	acrossPieces := suite.zones.Split(across[0], across[1])
+
+	// Assert
+	assert.Equal(suite.T(), []string{"A1"}, zoneIDs(alongPieces))
+	assert.Equal(suite.T(), []string{"city", "A1", "city"}, zoneIDs(acrossPieces))
+	// The 100m wide corridor is about 0.0009 degrees of a 0.6 degree leg.
+	assert.InDelta(suite.T(), 0.0015, acrossPieces[1].To-acrossPieces[1].From, 0.0002)
+}
+
+// TestLocate_MatchesBruteForce tests that the R-tree finds the same zones as checking every zone
+func (suite *GeofenceTestSuite) TestLocate_MatchesBruteForce() {
+	// Arrange
+	var features []string
+	for x := 0; x < 20; x++ {
+		for y := 0; y < 20; y++ {
+			features = append(features, fmt.Sprintf(
+				`{"type":"Feature","id":"z%d-%d","geometry":{"type":"Polygon","coordinates":[[[%d,%d],[%d.5,%d],[%d.5,%d.5],[%d,%d.5]]]}}`,
+				x, y, x, y, x, y, x, y, x, y))
+		}
+	}
+	grid, err := geofence.Parse([]byte(`{"type":"FeatureCollection","features":[` + strings.Join(features, ",") + `]}`))
+	require.NoError(suite.T(), err)
+	rng := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 1000; i++ {
+		p := geofence.Point{Lat: rng.Float64() * 20, Long: rng.Float64() * 20}
+		want := ""
+		if x, y := int(p.Long), int(p.Lat); p.Long-float64(x) < 0.5 && p.Lat-float64(y) < 0.5 {
+			want = fmt.Sprintf("z%d-%d", x, y)
+		}
+
+		// Act
+		got := grid.Locate(p)
+
+		// Assert
+		require.Equal(suite.T(), want, got, "point %+v", p)
+	}
+}
+
+// TestSplit_WithoutZones tests that without zones the whole leg is one untolled piece
+func (suite *GeofenceTestSuite) TestSplit_WithoutZones() {
+	// Arrange
+	var none *geofence.Index
+
+	// Act
+	pieces := none.Split(geofence.Point{Lat: 0.5, Long: 0.5}, geofence.Point{Lat: 5.5, Long: 5.5})
+
+	// Assert
+	assert.Equal(suite.T(), []geofence.Piece{{From: 0, To: 1}}, pieces)
+}
+
+// TestParse_RejectsInvalidZones tests that broken files are reported rather than partly loaded
+func (suite *GeofenceTestSuite) TestParse_RejectsInvalidZones() {
+	cases := map[string]string{
+		"no id":            `{"type":"FeatureCollection","features":[{"geometry":{"type":"LineString","coordinates":[[0,0],[1,1]]}}]}`,
+		"conflicting":      `{"type":"FeatureCollection","features":[{"id":"a","properties":{"tariff":1},"geometry":{"type":"LineString","coordinates":[[0,0],[1,1]]}},{"id":"a","properties":{"tariff":2},"geometry":{"type":"LineString","coordinates":[[1,1],[2,2]]}}]}`,
+		"short ring":       `{"type":"FeatureCollection","features":[{"id":"a","geometry":{"type":"Polygon","coordinates":[[[0,0],[1,1],[0,0]]]}}]}`,
+		"out of range":     `{"type":"FeatureCollection","features":[{"id":"a","geometry":{"type":"LineString","coordinates":[[0,0],[0,91]]}}]}`,
+		"point":            `{"type":"FeatureCollection","features":[{"id":"a","geometry":{"type":"Point","coordinates":[0,0]}}]}`,
+		"not a collection": `{"type":"Feature"}`,
+	}
+	for name, doc := range cases {
+		// Act
+		_, err := geofence.Parse([]byte(doc))
+
+		// Assert
+		assert.Error(suite.T(), err, name)
+	}
+}
+
+// TestFences_KeepZonesOnInvalidFile tests that a broken edit of the zones file leaves the loaded zones in place
+func (suite *GeofenceTestSuite) TestFences_KeepZonesOnInvalidFile() {
+	// Arrange
+	path := filepath.Join(suite.T().TempDir(), "zones.geojson")
+	require.NoError(suite.T(), os.WriteFile(path, []byte(zonesJSON), 0o600))
+	fences, err := geofence.LoadFences(path)
+	require.NoError(suite.T(), err)
+	require.NoError(suite.T(), os.WriteFile(path, []byte(`{"type":"FeatureCollection","features":[{}]}`), 0o600))
+
+	// Act
+	err = fences.Reload()
+
+	// Assert
+	assert.Error(suite.T(), err)
+	assert.Equal(suite.T(), "city", fences.Index().Locate(geofence.Point{Lat: 0.2, Long: 0.2}))
+}
+
+func zoneIDs(pieces []geofence.Piece) []string {
+	ids := make([]string, len(pieces))
+	for i, p := range pieces {
+		ids[i] = p.ZoneID
+	}
+	return ids
+}
+
+// Run the geofence test suite
+func TestGeofenceTestSuite(t *testing.T) {
+	suite.Run(t, new(GeofenceTestSuite))
+}
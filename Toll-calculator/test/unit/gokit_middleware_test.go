@@ -0,0 +1,156 @@
+package unit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/0x0Glitch/toll-calculator/gokit/aggservice/aggsvc/aggendpoint"
+	"github.com/0x0Glitch/toll-calculator/gokit/aggservice/aggsvc/aggservice"
+	"github.com/0x0Glitch/toll-calculator/test/fixtures"
+	"github.com/0x0Glitch/toll-calculator/types"
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/ratelimit"
+	"github.com/go-kit/log"
+	"github.com/sony/gobreaker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+// labelRecorder is a counter and histogram that remembers the labels it was used with
+type labelRecorder struct {
+	calls  float64
+	labels [][]string
+}
+
+func (r *labelRecorder) With(lvs ...string) metrics.Counter {
+	r.labels = append(r.labels, lvs)
+	return r
+}
+
+func (r *labelRecorder) Add(delta float64) { r.calls += delta }
+
+type histogramRecorder struct{ *labelRecorder }
+
+func (h histogramRecorder) With(lvs ...string) metrics.Histogram {
+	h.labelRecorder.With(lvs...)
+	return h
+}
+
+func (h histogramRecorder) Observe(float64) { h.calls++ }
+
+// GokitMiddlewareTestSuite tests the go-kit service and endpoint middlewares
+type GokitMiddlewareTestSuite struct {
+	suite.Suite
+	logs     *bytes.Buffer
+	requests *labelRecorder
+	latency  *labelRecorder
+	svc      aggservice.Service
+}
+
+// SetupTest builds a fully wrapped service before each test
+func (suite *GokitMiddlewareTestSuite) SetupTest() {
+	suite.logs = &bytes.Buffer{}
+	suite.requests = &labelRecorder{}
+	suite.latency = &labelRecorder{}
+	suite.svc = aggservice.NewAggregatorService(aggservice.NewMemoryStore(),
+		aggservice.LoggingMiddleware(log.NewLogfmtLogger(suite.logs)),
+		aggservice.InstrumentationMiddleware(suite.requests, histogramRecorder{suite.latency}),
+	)
+}
+
+// TestServiceMiddlewares_CallThrough tests that wrapping the service keeps it working
+func (suite *GokitMiddlewareTestSuite) TestServiceMiddlewares_CallThrough() {
+	// Act
+	err := suite.svc.Aggregate(context.Background(), types.Distance{OBUID: fixtures.TestOBUID1, Values: 2})
+	require.NoError(suite.T(), err)
+	inv, err := suite.svc.Calculate(context.Background(), fixtures.TestOBUID1)
+
+	// Assert
+	require.NoError(suite.T(), err)
+	assert.InDelta(suite.T(), 2.0, inv.TotalDistance, 0.001)
+	assert.InDelta(suite.T(), 2.0*315, inv.Amount.Float64(), 0.001)
+}
+
+// TestLoggingMiddleware_RecordsCall tests that method, OBU ID and error are logged
+func (suite *GokitMiddlewareTestSuite) TestLoggingMiddleware_RecordsCall() {
+	// Act
+	_, err := suite.svc.Calculate(context.Background(), fixtures.TestOBUID2)
+
+	// Assert
+	assert.Error(suite.T(), err)
+	out := suite.logs.String()
+	assert.Contains(suite.T(), out, "method=Calculate")
+	assert.Contains(suite.T(), out, "obuID=67890")
+	assert.Contains(suite.T(), out, "took=")
+	assert.Contains(suite.T(), out, "couldn't find distance")
+}
+
+// TestInstrumentationMiddleware_CountsCalls tests that every call is counted and timed
+func (suite *GokitMiddlewareTestSuite) TestInstrumentationMiddleware_CountsCalls() {
+	// Act
+	suite.svc.Aggregate(context.Background(), types.Distance{OBUID: fixtures.TestOBUID1, Values: 1})
+	suite.svc.Calculate(context.Background(), fixtures.TestOBUID1)
+	suite.svc.Calculate(context.Background(), fixtures.TestOBUID2)
+
+	// Assert
+	assert.Equal(suite.T(), 3.0, suite.requests.calls)
+	assert.Equal(suite.T(), 3.0, suite.latency.calls)
+	assert.Equal(suite.T(), []string{"method", "Aggregate", "error", "false"}, suite.requests.labels[0])
+	assert.Equal(suite.T(), []string{"method", "Calculate", "error", "true"}, suite.requests.labels[2])
+}
+
+// TestRateLimitMiddleware_RejectsBurst tests that requests over the limit are rejected
+func (suite *GokitMiddlewareTestSuite) TestRateLimitMiddleware_RejectsBurst() {
+	// Arrange
+	ep := aggendpoint.RateLimitMiddleware(1, 1)(aggendpoint.MakeCalculateEndpoint(suite.svc))
+
+	// Act
+	_, first := ep(context.Background(), aggendpoint.CalculateRequest{OBUID: fixtures.TestOBUID1})
+	_, second := ep(context.Background(), aggendpoint.CalculateRequest{OBUID: fixtures.TestOBUID1})
+
+	// Assert
+	assert.NoError(suite.T(), first)
+	assert.ErrorIs(suite.T(), second, ratelimit.ErrLimited)
+}
+
+// TestCircuitBreakerMiddleware_OpensAfterFailures tests that a failing endpoint is short-circuited
+func (suite *GokitMiddlewareTestSuite) TestCircuitBreakerMiddleware_OpensAfterFailures() {
+	// Arrange
+	calls := 0
+	failing := func(context.Context, interface{}) (interface{}, error) {
+		calls++
+		return nil, errors.New("store unreachable")
+	}
+	ep := aggendpoint.CircuitBreakerMiddleware("test")(failing)
+
+	// Act
+	var err error
+	for i := 0; i < 7; i++ {
+		_, err = ep(context.Background(), nil)
+	}
+
+	// Assert
+	assert.Equal(suite.T(), 5, calls)
+	assert.ErrorIs(suite.T(), err, gobreaker.ErrOpenState)
+}
+
+// TestCircuitBreakerMiddleware_IgnoresFailedResponses tests that business errors never trip the breaker
+func (suite *GokitMiddlewareTestSuite) TestCircuitBreakerMiddleware_IgnoresFailedResponses() {
+	// Arrange
+	ep := aggendpoint.CircuitBreakerMiddleware("test")(aggendpoint.MakeCalculateEndpoint(suite.svc))
+
+	// Act & Assert
+	for i := 0; i < 10; i++ {
+		resp, err := ep(context.Background(), aggendpoint.CalculateRequest{OBUID: fixtures.TestOBUID3})
+		require.NoError(suite.T(), err)
+		assert.Error(suite.T(), resp.(aggendpoint.CalculateResponse).Err)
+	}
+}
+
+// Run the go-kit middleware test suite
+func TestGokitMiddlewareTestSuite(t *testing.T) {
+	suite.Run(t, new(GokitMiddlewareTestSuite))
+}
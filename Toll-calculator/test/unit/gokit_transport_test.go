@@ -0,0 +1,180 @@
+package unit
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/0x0Glitch/toll-calculator/aggregator/client"
+	"github.com/0x0Glitch/toll-calculator/gokit/aggservice/aggsvc/aggendpoint"
+	"github.com/0x0Glitch/toll-calculator/gokit/aggservice/aggsvc/aggservice"
+	"github.com/0x0Glitch/toll-calculator/gokit/aggservice/aggsvc/aggtransport"
+	"github.com/0x0Glitch/toll-calculator/money"
+	"github.com/0x0Glitch/toll-calculator/test/fixtures"
+	"github.com/0x0Glitch/toll-calculator/types"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc"
+)
+
+// recordingService records the distances that reach the service through the transports
+type recordingService struct {
+	aggservice.Service
+	mu  sync.Mutex
+	got []types.Distance
+}
+
+func (r *recordingService) Aggregate(ctx context.Context, d types.Distance) error {
+	r.mu.Lock()
+	r.got = append(r.got, d)
+	r.mu.Unlock()
+	return r.Service.Aggregate(ctx, d)
+}
+
+func (r *recordingService) Got() []types.Distance {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]types.Distance(nil), r.got...)
+}
+
+// GokitTransportTestSuite tests that the go-kit service is a drop-in replacement for the aggregator
+type GokitTransportTestSuite struct {
+	suite.Suite
+	httpServer *httptest.Server
+	grpcServer *grpc.Server
+	grpcAddr   string
+	recorded   *recordingService
+}
+
+// SetupTest starts the go-kit service on both transports before each test
+func (suite *GokitTransportTestSuite) SetupTest() {
+	record := func(next aggservice.Service) aggservice.Service {
+		suite.recorded = &recordingService{Service: next}
+		return suite.recorded
+	}
+	svc := aggservice.NewAggregatorService(aggservice.NewMemoryStore(), record, aggservice.LoggingMiddleware(log.NewNopLogger()))
+	endpoints := aggendpoint.New(svc, log.NewNopLogger(), rate.Inf)
+	suite.httpServer = httptest.NewServer(aggtransport.NewHTTPHandler(endpoints, log.NewNopLogger()))
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(suite.T(), err)
+	suite.grpcAddr = ln.Addr().String()
+	suite.grpcServer = grpc.NewServer()
+	types.RegisterAggregatorServer(suite.grpcServer, aggtransport.NewGRPCServer(endpoints, log.NewNopLogger()))
+	go suite.grpcServer.Serve(ln)
+}
+
+// TearDownTest stops both transports after each test
+func (suite *GokitTransportTestSuite) TearDownTest() {
+	suite.httpServer.Close()
+	suite.grpcServer.Stop()
+}
+
+func (suite *GokitTransportTestSuite) clients() map[string]client.Client {
+	grpcClient, err := client.NewGRPCClient(suite.grpcAddr)
+	require.NoError(suite.T(), err)
+	suite.T().Cleanup(func() { grpcClient.Close() })
+	return map[string]client.Client{
+		"http": client.NewHTTPClient(suite.httpServer.URL),
+		"grpc": grpcClient,
+	}
+}
+
+// TestAggregateAndInvoice_ExistingClients tests the full flow through the existing aggregator clients
+func (suite *GokitTransportTestSuite) TestAggregateAndInvoice_ExistingClients() {
+	for name, c := range suite.clients() {
+		// Arrange
+		obuID := fixtures.TestOBUID1
+		if name == "grpc" {
+			obuID = fixtures.TestOBUID2
+		}
+
+		// Act
+		for _, v := range []float64{10.5, 4.5} {
+			err := c.Aggregate(context.Background(), &types.AggregatorRequest{ObuID: obuID, Value: v})
+			require.NoError(suite.T(), err, name)
+		}
+		inv, err := c.GetInvoice(context.Background(), int(obuID))
+
+		// Assert
+		require.NoError(suite.T(), err, name)
+		assert.Equal(suite.T(), obuID, inv.OBUID, name)
+		assert.InDelta(suite.T(), 15.0, inv.TotalDistance, 0.001, name)
+		assert.InDelta(suite.T(), 15.0*315, inv.Amount.Float64(), 0.001, name)
+		assert.Equal(suite.T(), "4725.00", inv.Amount.String(), name)
+		assert.Equal(suite.T(), money.Currency("EUR"), inv.Currency, name)
+	}
+}
+
+// TestAggregate_CarriesZonesAndTrips tests that the zone, gap, off-peak and trip of a distance reach the service and the invoice on both transports
+func (suite *GokitTransportTestSuite) TestAggregate_CarriesZonesAndTrips() {
+	for name, c := range suite.clients() {
+		// Arrange
+		obuID := fixtures.TestOBUID1
+		if name == "grpc" {
+			obuID = fixtures.TestOBUID2
+		}
+		leg := &types.TripLeg{
+			TripID: "trip-1",
+			Start:  types.TripPoint{Unix: 1000, Lat: 52.52, Long: 13.40},
+			End:    types.TripPoint{Unix: 2000, Lat: 52.53, Long: 13.41},
+			Ends:   true,
+		}
+		reqs := []*types.AggregatorRequest{
+			{ObuID: obuID, Value: 10, ZoneID: "center", Trip: leg.Proto()},
+			{ObuID: obuID, Value: 4, ZoneID: "center", Estimated: true, OffPeak: true, Trip: leg.Proto()},
+			{ObuID: obuID, Value: 1, Trip: leg.Proto()},
+		}
+
+		// Act
+		for _, req := range reqs {
+			require.NoError(suite.T(), c.Aggregate(context.Background(), req), name)
+		}
+		inv, err := c.GetInvoice(context.Background(), int(obuID))
+
+		// Assert
+		require.NoError(suite.T(), err, name)
+		var got []types.Distance
+		for _, d := range suite.recorded.Got() {
+			if d.OBUID == obuID {
+				got = append(got, d)
+			}
+		}
+		require.Len(suite.T(), got, 3, name)
+		assert.Equal(suite.T(), "center", got[1].ZoneID, name)
+		assert.True(suite.T(), got[1].Estimated, name)
+		assert.True(suite.T(), got[1].OffPeak, name)
+		assert.Equal(suite.T(), leg, got[2].Trip, name)
+		assert.InDelta(suite.T(), 15.0, inv.TotalDistance, 0.001, name)
+		assert.InDelta(suite.T(), 4.0, inv.EstimatedDistance, 0.001, name)
+		require.Len(suite.T(), inv.Zones, 2, name)
+		assert.Equal(suite.T(), "", inv.Zones[0].ZoneID, name)
+		assert.Equal(suite.T(), "center", inv.Zones[1].ZoneID, name)
+		assert.Equal(suite.T(), "4410.00", inv.Zones[1].Amount.String(), name)
+		assert.InDelta(suite.T(), 4.0, inv.Zones[1].Estimated, 0.001, name)
+		assert.Equal(suite.T(), "4725.00", inv.Amount.String(), name)
+		assert.Equal(suite.T(), "4725.00", inv.Gross.String(), name)
+	}
+}
+
+// TestGetInvoice_UnknownOBU tests that a missing OBU is reported as an error on both transports
+func (suite *GokitTransportTestSuite) TestGetInvoice_UnknownOBU() {
+	for name, c := range suite.clients() {
+		// Act
+		inv, err := c.GetInvoice(context.Background(), int(fixtures.TestOBUID3))
+
+		// Assert
+		assert.Error(suite.T(), err, name)
+		assert.Nil(suite.T(), inv, name)
+	}
+}
+
+// Run the go-kit transport test suite
+func TestGokitTransportTestSuite(t *testing.T) {
+	suite.Run(t, new(GokitTransportTestSuite))
+}
@@ -0,0 +1,200 @@
+package unit
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/0x0Glitch/toll-calculator/geo"
+	"github.com/0x0Glitch/toll-calculator/gpsfilter"
+	"github.com/0x0Glitch/toll-calculator/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+// GPSFilterTestSuite tests rejecting implausible fixes and smoothing the rest
+type GPSFilterTestSuite struct {
+	suite.Suite
+}
+
+// timedFix returns a fix of OBU 1 taken the given seconds into the test, metres north and east of the origin
+func timedFix(seconds, north, east float64) types.OBUData {
+	return types.OBUData{
+		OBUID: 1,
+		Lat:   north / geo.MetersPerDegree,
+		Long:  east / geo.MetersPerDegree,
+		Unix:  1_760_000_000_000 + int64(seconds*1000),
+	}
+}
+
+// TestAccept_RejectsJump tests that a fix jumping 50 km away is rejected while the next plausible fix is accepted
+func (suite *GPSFilterTestSuite) TestAccept_RejectsJump() {
+	// Arrange
+	f := gpsfilter.New(gpsfilter.Options{MaxSpeed: 50})
+	_, err := f.Accept(timedFix(0, 0, 0))
+	require.NoError(suite.T(), err)
+
+	// Act
+	_, jumpErr := f.Accept(timedFix(5, 0, 50_000))
+	p, nextErr := f.Accept(timedFix(10, 0, 200))
+
+	// Assert
+	assert.ErrorIs(suite.T(), jumpErr, gpsfilter.ErrTooFast)
+	assert.Equal(suite.T(), "speed", gpsfilter.Reason(jumpErr))
+	require.NoError(suite.T(), nextErr)
+	assert.InDelta(suite.T(), 200, p.Long*geo.MetersPerDegree, 1e-6)
+}
+
+// TestAccept_StartsOverAfterRepeatedJumps tests that when every later fix is too fast, the earlier fix is dropped instead
+func (suite *GPSFilterTestSuite) TestAccept_StartsOverAfterRepeatedJumps() {
+	// Arrange
+	f := gpsfilter.New(gpsfilter.Options{MaxSpeed: 50})
+	_, err := f.Accept(timedFix(0, 0, 50_000))
+	require.NoError(suite.T(), err)
+
+	// Act
+	var errs []error
+	for i := 1; i <= 4; i++ {
+		_, err := f.Accept(timedFix(float64(i), 0, float64(i*10)))
+		errs = append(errs, err)
+	}
+
+	// Assert
+	assert.ErrorIs(suite.T(), errs[0], gpsfilter.ErrTooFast)
+	assert.ErrorIs(suite.T(), errs[1], gpsfilter.ErrTooFast)
+	assert.NoError(suite.T(), errs[2])
+	assert.NoError(suite.T(), errs[3])
+}
+
+// TestAccept_RejectsPoorQuality tests that fixes reporting a bad HDOP or accuracy are rejected when limits are set
+func (suite *GPSFilterTestSuite) TestAccept_RejectsPoorQuality() {
+	// Arrange
+	f := gpsfilter.New(gpsfilter.Options{MaxHDOP: 5, MaxAccuracy: 30})
+	poorHDOP, poorAccuracy, good, unreported := timedFix(0, 0, 0), timedFix(1, 0, 0), timedFix(2, 0, 0), timedFix(3, 0, 0)
+	poorHDOP.HDOP = 8
+	poorAccuracy.Accuracy = 120
+	good.HDOP, good.Accuracy = 1.2, 4
+
+	// Act
+	_, hdopErr := f.Accept(poorHDOP)
+	_, accuracyErr := f.Accept(poorAccuracy)
+	_, goodErr := f.Accept(good)
+	_, unreportedErr := f.Accept(unreported)
+
+	// Assert
+	assert.Equal(suite.T(), "hdop", gpsfilter.Reason(hdopErr))
+	assert.Equal(suite.T(), "accuracy", gpsfilter.Reason(accuracyErr))
+	assert.NoError(suite.T(), goodErr)
+	assert.NoError(suite.T(), unreportedErr)
+}
+
+// TestAccept_RejectsOutOfOrder tests that a fix older than the last accepted one is rejected
+func (suite *GPSFilterTestSuite) TestAccept_RejectsOutOfOrder() {
+	// Arrange
+	f := gpsfilter.New(gpsfilter.Options{MaxSpeed: 50})
+	_, err := f.Accept(timedFix(10, 0, 0))
+	require.NoError(suite.T(), err)
+
+	// Act
+	_, err = f.Accept(timedFix(5, 0, 10))
+
+	// Assert
+	assert.ErrorIs(suite.T(), err, gpsfilter.ErrOutOfOrder)
+	assert.Equal(suite.T(), "out_of_order", gpsfilter.Reason(err))
+}
+
+// TestAccept_SkipsSpeedCheckWithoutTimestamps tests that fixes without a timestamp can't be too fast
+func (suite *GPSFilterTestSuite) TestAccept_SkipsSpeedCheckWithoutTimestamps() {
+	// Arrange
+	f := gpsfilter.New(gpsfilter.Options{MaxSpeed: 50})
+	first, second := timedFix(0, 0, 0), timedFix(1, 0, 50_000)
+	first.Unix, second.Unix = 0, 0
+
+	// Act
+	_, firstErr := f.Accept(first)
+	_, secondErr := f.Accept(second)
+
+	// Assert
+	assert.NoError(suite.T(), firstErr)
+	assert.NoError(suite.T(), secondErr)
+}
+
+// TestAccept_SuppressesStationaryJitter tests that fixes scattered around a parked vehicle don't move it
+func (suite *GPSFilterTestSuite) TestAccept_SuppressesStationaryJitter() {
+	// Arrange
+	f := gpsfilter.New(gpsfilter.Options{Jitter: 10})
+	_, err := f.Accept(timedFix(0, 0, 0))
+	require.NoError(suite.T(), err)
+
+	// Act
+	_, e1 := f.Accept(timedFix(1, 4, -3))
+	_, e2 := f.Accept(timedFix(2, -6, 2))
+	p, moved := f.Accept(timedFix(3, 0, 25))
+
+	// Assert
+	assert.ErrorIs(suite.T(), e1, gpsfilter.ErrStationary)
+	assert.Equal(suite.T(), "stationary", gpsfilter.Reason(e2))
+	require.NoError(suite.T(), moved)
+	assert.InDelta(suite.T(), 25, p.Long*geo.MetersPerDegree, 1e-6)
+}
+
+// TestAccept_SmoothsNoise tests that the Kalman filter places a vehicle driving straight closer to its true track than its fixes
+func (suite *GPSFilterTestSuite) TestAccept_SmoothsNoise() {
+	// Arrange
+	f := gpsfilter.New(gpsfilter.Options{Noise: 0.5})
+	rng := rand.New(rand.NewSource(42))
+	var rawErr, smoothErr float64
+
+	// Act
+	for i := 0; i < 120; i++ {
+		truth := float64(i) * 15
+		fix := timedFix(float64(i), rng.NormFloat64()*10, truth+rng.NormFloat64()*10)
+		p, err := f.Accept(fix)
+		require.NoError(suite.T(), err)
+		if i < 20 {
+			continue
+		}
+		rawErr += math.Pow(fix.Lat*geo.MetersPerDegree, 2) + math.Pow(fix.Long*geo.MetersPerDegree-truth, 2)
+		smoothErr += math.Pow(p.Lat*geo.MetersPerDegree, 2) + math.Pow(p.Long*geo.MetersPerDegree-truth, 2)
+	}
+
+	// Assert
+	assert.Less(suite.T(), smoothErr, rawErr/2)
+}
+
+// TestAccept_KeepsVehiclesApart tests that one vehicle's fixes aren't compared against another's
+func (suite *GPSFilterTestSuite) TestAccept_KeepsVehiclesApart() {
+	// Arrange
+	f := gpsfilter.New(gpsfilter.Options{MaxSpeed: 50})
+	other := timedFix(1, 0, 50_000)
+	other.OBUID = 2
+	_, err := f.Accept(timedFix(0, 0, 0))
+	require.NoError(suite.T(), err)
+
+	// Act
+	_, otherErr := f.Accept(other)
+	f.Forget(1)
+	_, forgottenErr := f.Accept(timedFix(2, 0, 50_000))
+
+	// Assert
+	assert.NoError(suite.T(), otherErr)
+	assert.NoError(suite.T(), forgottenErr)
+}
+
+// TestReason_IgnoresOtherErrors tests that only rejections get a reason
+func (suite *GPSFilterTestSuite) TestReason_IgnoresOtherErrors() {
+	// Act
+	none := gpsfilter.Reason(nil)
+	other := gpsfilter.Reason(errors.New("boom"))
+
+	// Assert
+	assert.Empty(suite.T(), none)
+	assert.Empty(suite.T(), other)
+}
+
+// Run the GPS filter test suite
+func TestGPSFilterTestSuite(t *testing.T) {
+	suite.Run(t, new(GPSFilterTestSuite))
+}
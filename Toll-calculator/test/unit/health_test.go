@@ -0,0 +1,193 @@
+package unit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/0x0Glitch/toll-calculator/aggregator/client"
+	"github.com/0x0Glitch/toll-calculator/apperr"
+	"github.com/0x0Glitch/toll-calculator/health"
+	"github.com/0x0Glitch/toll-calculator/types"
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"google.golang.org/grpc"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// fakeMetadata answers Kafka metadata requests without a broker
+type fakeMetadata struct {
+	md  *kafka.Metadata
+	err error
+}
+
+func (f fakeMetadata) GetMetadata(topic *string, allTopics bool, timeoutMs int) (*kafka.Metadata, error) {
+	return f.md, f.err
+}
+
+// HealthTestSuite tests the liveness and readiness endpoints and checks
+type HealthTestSuite struct {
+	suite.Suite
+	checker *health.Checker
+	ready   atomic.Bool
+}
+
+// SetupTest creates a checker with one toggleable dependency before each test
+func (suite *HealthTestSuite) SetupTest() {
+	suite.checker = health.NewChecker()
+	suite.ready.Store(true)
+	suite.checker.Add("dependency", func(ctx context.Context) error {
+		if !suite.ready.Load() {
+			return errors.New("dependency down")
+		}
+		return nil
+	})
+}
+
+func (suite *HealthTestSuite) get(h http.Handler, path string) (int, health.Status) {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	var status health.Status
+	require.NoError(suite.T(), json.NewDecoder(rec.Body).Decode(&status))
+	return rec.Code, status
+}
+
+// TestReadyz_ReportsEveryCheck tests that /readyz answers 200 only when every check passes
+func (suite *HealthTestSuite) TestReadyz_ReportsEveryCheck() {
+	// Arrange
+	mux := http.NewServeMux()
+	suite.checker.Register(mux)
+	suite.checker.Add("store", func(ctx context.Context) error { return nil })
+
+	// Act
+	readyCode, ready := suite.get(mux, "/readyz")
+	suite.ready.Store(false)
+	notReadyCode, notReady := suite.get(mux, "/readyz")
+	liveCode, live := suite.get(mux, "/healthz")
+
+	// Assert
+	assert.Equal(suite.T(), http.StatusOK, readyCode)
+	assert.Equal(suite.T(), map[string]string{"dependency": "ok", "store": "ok"}, ready.Checks)
+	assert.Equal(suite.T(), http.StatusServiceUnavailable, notReadyCode)
+	assert.Equal(suite.T(), "not ready", notReady.Status)
+	assert.Equal(suite.T(), "dependency down", notReady.Checks["dependency"])
+	assert.Equal(suite.T(), "ok", notReady.Checks["store"])
+	assert.Equal(suite.T(), http.StatusOK, liveCode, "liveness must not depend on the checks")
+	assert.Equal(suite.T(), "ok", live.Status)
+}
+
+// TestWaitReady_BlocksUntilReady tests that startup waits for the dependencies
+func (suite *HealthTestSuite) TestWaitReady_BlocksUntilReady() {
+	// Arrange
+	suite.ready.Store(false)
+	time.AfterFunc(50*time.Millisecond, func() { suite.ready.Store(true) })
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	start := time.Now()
+
+	// Act
+	err := suite.checker.WaitReady(ctx, 10*time.Millisecond)
+
+	// Assert
+	require.NoError(suite.T(), err)
+	assert.GreaterOrEqual(suite.T(), time.Since(start), 50*time.Millisecond)
+}
+
+// TestWaitReady_GivesUpWithContext tests that waiting stops when the context is done
+func (suite *HealthTestSuite) TestWaitReady_GivesUpWithContext() {
+	// Arrange
+	suite.ready.Store(false)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	// Act
+	err := suite.checker.WaitReady(ctx, 10*time.Millisecond)
+
+	// Assert
+	assert.ErrorIs(suite.T(), err, context.DeadlineExceeded)
+}
+
+// TestKafkaCheck_NeedsBrokers tests the Kafka readiness check against fake metadata
+func (suite *HealthTestSuite) TestKafkaCheck_NeedsBrokers() {
+	// Arrange
+	withBroker := &kafka.Metadata{
+		Brokers: []kafka.BrokerMetadata{{ID: 1, Host: "localhost", Port: 9092}},
+		Topics:  map[string]kafka.TopicMetadata{"obudata": {Topic: "obudata"}},
+	}
+	cases := map[string]struct {
+		client health.MetadataGetter
+		ready  bool
+	}{
+		"reachable":   {fakeMetadata{md: withBroker}, true},
+		"no brokers":  {fakeMetadata{md: &kafka.Metadata{}}, false},
+		"unreachable": {fakeMetadata{err: errors.New("timed out")}, false},
+	}
+
+	for name, c := range cases {
+		// Act
+		err := health.KafkaCheck(c.client, "obudata")(context.Background())
+
+		// Assert
+		assert.Equal(suite.T(), c.ready, err == nil, "%s: %v", name, err)
+	}
+}
+
+// TestHTTPClientPing_FollowsReadyz tests that the HTTP client reports the aggregator's readiness
+func (suite *HealthTestSuite) TestHTTPClientPing_FollowsReadyz() {
+	// Arrange
+	mux := http.NewServeMux()
+	suite.checker.Register(mux)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	c := client.NewHTTPClient(server.URL)
+
+	// Act
+	readyErr := c.Ping(context.Background())
+	suite.ready.Store(false)
+	notReadyErr := c.Ping(context.Background())
+
+	// Assert
+	assert.NoError(suite.T(), readyErr)
+	assert.True(suite.T(), apperr.IsCode(notReadyErr, apperr.Unavailable), "%v", notReadyErr)
+}
+
+// TestGRPCHealth_FollowsChecks tests that the gRPC health service follows the checks
+func (suite *HealthTestSuite) TestGRPCHealth_FollowsChecks() {
+	// Arrange
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(suite.T(), err)
+	server := grpc.NewServer()
+	hs := grpchealth.NewServer()
+	healthpb.RegisterHealthServer(server, hs)
+	go server.Serve(ln)
+	defer server.Stop()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go suite.checker.ServeGRPC(ctx, hs, 10*time.Millisecond, types.Aggregator_ServiceDesc.ServiceName)
+	c, err := client.NewGRPCClient(ln.Addr().String())
+	require.NoError(suite.T(), err)
+	defer c.Close()
+
+	// Act & Assert
+	assert.Eventually(suite.T(), func() bool {
+		return c.Ping(context.Background()) == nil
+	}, time.Second, 10*time.Millisecond)
+	suite.ready.Store(false)
+	assert.Eventually(suite.T(), func() bool {
+		return apperr.IsCode(c.Ping(context.Background()), apperr.Unavailable)
+	}, time.Second, 10*time.Millisecond)
+}
+
+// Run the health test suite
+func TestHealthTestSuite(t *testing.T) {
+	suite.Run(t, new(HealthTestSuite))
+}
@@ -8,6 +8,7 @@ import (
 	"net/http/httptest"
 	"testing"
 
+	"github.com/0x0Glitch/toll-calculator/money"
 	"github.com/0x0Glitch/toll-calculator/test/fixtures"
 	"github.com/0x0Glitch/toll-calculator/test/helpers"
 	"github.com/0x0Glitch/toll-calculator/types"
@@ -54,7 +55,7 @@ func (m *MockHTTPAggregator) CalculateInvoice(obuID int32) (*types.Invoice, erro
 		invoice := &types.Invoice{
 			OBUID:         obuID,
 			TotalDistance: distance,
-			Amount:        distance * 315,
+			Amount:        money.FromFloat(distance * 315),
 		}
 		return invoice, nil
 	}
@@ -186,7 +187,7 @@ func (suite *HTTPHandlersTestSuite) TestGetInvoice_ValidOBU() {
 
 	assert.Equal(suite.T(), expectedInvoice.OBUID, invoice.OBUID)
 	helpers.AssertFloatEquals(suite.T(), expectedInvoice.TotalDistance, invoice.TotalDistance, 0.001)
-	helpers.AssertFloatEquals(suite.T(), expectedInvoice.Amount, invoice.Amount, 0.001)
+	helpers.AssertFloatEquals(suite.T(), expectedInvoice.Amount.Float64(), invoice.Amount.Float64(), 0.001)
 }
 
 // TestGetInvoice_MissingOBUParam tests getting invoice without OBU parameter
@@ -369,7 +370,7 @@ func (suite *HTTPHandlersTestSuite) TestIntegration_AggregateAndGetInvoice() {
 
 	assert.Equal(suite.T(), fixtures.TestOBUID1, invoice.OBUID)
 	helpers.AssertFloatEquals(suite.T(), 25.7, invoice.TotalDistance, 0.001) // 10.5 + 15.2
-	helpers.AssertFloatEquals(suite.T(), 25.7*315, invoice.Amount, 0.001)
+	helpers.AssertFloatEquals(suite.T(), 25.7*315, invoice.Amount.Float64(), 0.001)
 }
 
 // Run the test suite
@@ -0,0 +1,74 @@
+package unit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/0x0Glitch/toll-calculator/distance_calculator/leg"
+	"github.com/0x0Glitch/toll-calculator/geofence"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+// Two fixes of a vehicle that went silent between Amsterdam Centraal and
+// Schiphol, about 11.8 km apart in a straight line
+var (
+	amsterdamCentraal = geofence.Point{Lat: 52.3791, Long: 4.9003}
+	schiphol          = geofence.Point{Lat: 52.3105, Long: 4.7683}
+)
+
+// LegTestSuite tests the measuring of legs and the detection of gaps between fixes
+type LegTestSuite struct {
+	suite.Suite
+}
+
+// TestSplit_MeasuresKilometres tests that a leg is billed by its real-world length
+func (suite *LegTestSuite) TestSplit_MeasuresKilometres() {
+	// Act
+	legs := leg.Split(1, nil, []geofence.Point{amsterdamCentraal, schiphol})
+
+	// Assert
+	require.Len(suite.T(), legs, 1)
+	assert.InDelta(suite.T(), 11.8, legs[0].Values, 0.1)
+}
+
+// TestSpans_RealWorldGap tests that the gap threshold in metres is measured like the leg it flags
+func (suite *LegTestSuite) TestSpans_RealWorldGap() {
+	// Arrange
+	prev := leg.Fix{Point: amsterdamCentraal}
+	next := leg.Fix{Point: schiphol}
+	billed := leg.Split(1, nil, []geofence.Point{prev.Point, next.Point})[0].Values
+
+	// Act
+	below := leg.GapPolicy{MaxDistance: billed*1000 - 10}.Spans(prev, next)
+	above := leg.GapPolicy{MaxDistance: billed*1000 + 10}.Spans(prev, next)
+	hop := leg.GapPolicy{MaxDistance: 500}.Spans(prev, leg.Fix{Point: geofence.Point{Lat: 52.3800, Long: 4.9003}})
+
+	// Assert
+	assert.True(suite.T(), below, "a %.1f km leg spans a threshold just under it", billed)
+	assert.False(suite.T(), above)
+	assert.False(suite.T(), hop, "a 100 m hop is no gap")
+}
+
+// TestSpans_TimeGap tests that fixes further apart in time than the threshold span a gap
+func (suite *LegTestSuite) TestSpans_TimeGap() {
+	// Arrange
+	policy := leg.GapPolicy{MaxTime: time.Minute}
+	prev := leg.Fix{Point: amsterdamCentraal, Unix: 1_760_781_600_000}
+
+	// Act
+	late := policy.Spans(prev, leg.Fix{Point: amsterdamCentraal, Unix: prev.Unix + 2*60_000})
+	soon := policy.Spans(prev, leg.Fix{Point: amsterdamCentraal, Unix: prev.Unix + 30_000})
+	untimed := policy.Spans(leg.Fix{Point: amsterdamCentraal}, leg.Fix{Point: amsterdamCentraal, Unix: prev.Unix})
+
+	// Assert
+	assert.True(suite.T(), late)
+	assert.False(suite.T(), soon)
+	assert.False(suite.T(), untimed, "a fix without a time spans no time gap")
+}
+
+// Run the leg test suite
+func TestLegTestSuite(t *testing.T) {
+	suite.Run(t, new(LegTestSuite))
+}
@@ -0,0 +1,415 @@
+package unit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/0x0Glitch/toll-calculator/geo"
+	"github.com/0x0Glitch/toll-calculator/mapmatch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+// roadsJSON is a road running east along the equator with a vertex every 0.002 degrees,
+// a road turning north at its end, and a service road 33 metres north of it that connects to neither
+const roadsJSON = `{
+  "type": "FeatureCollection",
+  "features": [
+    {"type": "Feature", "properties": {"roadID": "main"},
+     "geometry": {"type": "LineString", "coordinates": [[0, 0], [0.002, 0], [0.004, 0], [0.006, 0], [0.008, 0], [0.01, 0]]}},
+    {"type": "Feature", "id": "bend",
+     "geometry": {"type": "LineString", "coordinates": [[0.01, 0], [0.01, 0.005], [0.01, 0.01]]}},
+    {"type": "Feature", "properties": {"roadID": "service"},
+     "geometry": {"type": "MultiLineString", "coordinates": [[[0.002, 0.0003], [0.006, 0.0003]]]}},
+    {"type": "Feature", "id": "depot",
+     "geometry": {"type": "Point", "coordinates": [0.005, 0.005]}}
+  ]
+}`
+
+// osmXML has two highways sharing a node and a building that isn't a road
+const osmXML = `<?xml version="1.0" encoding="UTF-8"?>
+<osm version="0.6">
+  <node id="1" lat="0" lon="0"/>
+  <node id="2" lat="0" lon="0.002"/>
+  <node id="3" lat="0.002" lon="0.002"/>
+  <node id="4" lat="0.001" lon="0.001"/>
+  <way id="10"><nd ref="1"/><nd ref="2"/><tag k="highway" v="primary"/></way>
+  <way id="11"><nd ref="2"/><nd ref="3"/><tag k="highway" v="residential"/></way>
+  <way id="12"><nd ref="1"/><nd ref="4"/><nd ref="3"/><tag k="building" v="yes"/></way>
+</osm>`
+
+// MapMatchTestSuite tests matching noisy fixes to a small synthetic road network
+type MapMatchTestSuite struct {
+	suite.Suite
+	matcher *mapmatch.Matcher
+}
+
+// SetupTest builds a matcher on the test road network before each test
+func (suite *MapMatchTestSuite) SetupTest() {
+	g, err := mapmatch.ParseGeoJSON([]byte(roadsJSON))
+	require.NoError(suite.T(), err)
+	suite.matcher = mapmatch.NewMatcher(g, mapmatch.Options{})
+}
+
+// match feeds the fixes of one vehicle to the matcher and returns what the last one matched
+func (suite *MapMatchTestSuite) match(fixes ...geo.Point) (mapmatch.Match, bool) {
+	var (
+		m  mapmatch.Match
+		ok bool
+	)
+	for _, fix := range fixes {
+		m, ok = suite.matcher.Match(1, fix)
+	}
+	return m, ok
+}
+
+// pathLength sums the distance in degrees along a matched path
+func pathLength(path []geo.Point) float64 {
+	total := 0.0
+	for i := 1; i < len(path); i++ {
+		total += math.Hypot(path[i].Lat-path[i-1].Lat, path[i].Long-path[i-1].Long)
+	}
+	return total
+}
+
+// TestParseGeoJSON_BuildsConnectedRoads tests that roads become segments joined at shared vertices
+func (suite *MapMatchTestSuite) TestParseGeoJSON_BuildsConnectedRoads() {
+	// Act
+	g, err := mapmatch.ParseGeoJSON([]byte(roadsJSON))
+
+	// Assert
+	require.NoError(suite.T(), err)
+	nodes, edges := g.Size()
+	assert.Equal(suite.T(), 10, nodes)
+	assert.Equal(suite.T(), 8, edges)
+}
+
+// TestParseGeoJSON_RejectsRoadWithoutID tests that a road must be named for auditing
+func (suite *MapMatchTestSuite) TestParseGeoJSON_RejectsRoadWithoutID() {
+	// Arrange
+	doc := `{"type": "FeatureCollection", "features": [
+	  {"type": "Feature", "geometry": {"type": "LineString", "coordinates": [[0, 0], [1, 0]]}}]}`
+
+	// Act
+	_, err := mapmatch.ParseGeoJSON([]byte(doc))
+
+	// Assert
+	assert.ErrorContains(suite.T(), err, "no roadID")
+}
+
+// TestParseOSM_ReadsHighways tests that only ways tagged as highways become roads
+func (suite *MapMatchTestSuite) TestParseOSM_ReadsHighways() {
+	// Act
+	g, err := mapmatch.ParseOSM(strings.NewReader(osmXML))
+
+	// Assert
+	require.NoError(suite.T(), err)
+	nodes, edges := g.Size()
+	assert.Equal(suite.T(), 3, nodes)
+	assert.Equal(suite.T(), 2, edges)
+
+	// Arrange
+	matcher := mapmatch.NewMatcher(g, mapmatch.Options{})
+
+	// Act
+	matcher.Match(1, geo.Point{Lat: 0.0001, Long: 0.001})
+	m, ok := matcher.Match(1, geo.Point{Lat: 0.001, Long: 0.0021})
+
+	// Assert
+	require.True(suite.T(), ok)
+	assert.Equal(suite.T(), []string{"way/10", "way/11"}, m.Roads)
+}
+
+// TestLoadGraph_PicksFormatByExtension tests that .osm files are read as OSM XML and others as GeoJSON
+func (suite *MapMatchTestSuite) TestLoadGraph_PicksFormatByExtension() {
+	// Arrange
+	dir := suite.T().TempDir()
+	osmPath := filepath.Join(dir, "extract.osm")
+	jsonPath := filepath.Join(dir, "roads.geojson")
+	require.NoError(suite.T(), os.WriteFile(osmPath, []byte(osmXML), 0o600))
+	require.NoError(suite.T(), os.WriteFile(jsonPath, []byte(roadsJSON), 0o600))
+
+	// Act
+	osmGraph, osmErr := mapmatch.LoadGraph(osmPath)
+	jsonGraph, jsonErr := mapmatch.LoadGraph(jsonPath)
+	_, missingErr := mapmatch.LoadGraph(filepath.Join(dir, "missing.osm"))
+
+	// Assert
+	require.NoError(suite.T(), osmErr)
+	require.NoError(suite.T(), jsonErr)
+	_, osmEdges := osmGraph.Size()
+	_, jsonEdges := jsonGraph.Size()
+	assert.Equal(suite.T(), 2, osmEdges)
+	assert.Equal(suite.T(), 8, jsonEdges)
+	assert.Error(suite.T(), missingErr)
+}
+
+// TestMatch_FirstFixHasNoLeg tests that the first fix of a vehicle only starts its track
+func (suite *MapMatchTestSuite) TestMatch_FirstFixHasNoLeg() {
+	// Act
+	_, ok := suite.match(geo.Point{Lat: 0.0001, Long: 0.001})
+
+	// Assert
+	assert.False(suite.T(), ok)
+}
+
+// TestMatch_SnapsNoisyFixesToRoad tests that fixes scattered around a road are measured along it
+func (suite *MapMatchTestSuite) TestMatch_SnapsNoisyFixesToRoad() {
+	// Arrange
+	fixes := []geo.Point{
+		{Lat: 0.0001, Long: 0.0005},
+		{Lat: -0.0001, Long: 0.0015},
+		{Lat: 0.00008, Long: 0.0025},
+		{Lat: -0.00012, Long: 0.0035},
+	}
+	total := 0.0
+	var roads []string
+
+	// Act
+	suite.matcher.Match(1, fixes[0])
+	for _, fix := range fixes[1:] {
+		m, ok := suite.matcher.Match(1, fix)
+		require.True(suite.T(), ok)
+		total += pathLength(m.Path)
+		roads = append(roads, m.Roads...)
+	}
+
+	// Assert
+	assert.InDelta(suite.T(), 0.003, total, 1e-9)
+	assert.Equal(suite.T(), []string{"main", "main", "main"}, roads)
+}
+
+// TestMatch_FollowsRoadAroundCorner tests that a leg cutting a corner is measured along the roads, longer than the chord
+func (suite *MapMatchTestSuite) TestMatch_FollowsRoadAroundCorner() {
+	// Arrange
+	from := geo.Point{Lat: 0.00005, Long: 0.008}
+	to := geo.Point{Lat: 0.002, Long: 0.01005}
+
+	// Act
+	m, ok := suite.match(from, to)
+
+	// Assert
+	require.True(suite.T(), ok)
+	assert.Equal(suite.T(), []string{"main", "bend"}, m.Roads)
+	assert.Contains(suite.T(), m.Path, geo.Point{Lat: 0, Long: 0.01})
+	assert.InDelta(suite.T(), 0.004, pathLength(m.Path), 1e-9)
+	assert.Greater(suite.T(), pathLength(m.Path), math.Hypot(to.Lat-from.Lat, to.Long-from.Long))
+}
+
+// TestMatch_IgnoresUnreachableRoad tests that a fix nearer to a disconnected road is still matched to the road the vehicle is on
+func (suite *MapMatchTestSuite) TestMatch_IgnoresUnreachableRoad() {
+	// Act
+	m, ok := suite.match(
+		geo.Point{Lat: 0, Long: 0.001},
+		geo.Point{Lat: 0.00025, Long: 0.003},
+	)
+
+	// Assert
+	require.True(suite.T(), ok)
+	assert.Equal(suite.T(), []string{"main"}, m.Roads)
+	assert.InDelta(suite.T(), 0.002, pathLength(m.Path), 1e-9)
+}
+
+// TestMatch_StationaryJitterAddsNoDistance tests that fixes jumping across the road while stopped don't travel
+func (suite *MapMatchTestSuite) TestMatch_StationaryJitterAddsNoDistance() {
+	// Act
+	m, ok := suite.match(
+		geo.Point{Lat: 0.0001, Long: 0.005},
+		geo.Point{Lat: -0.0001, Long: 0.005},
+		geo.Point{Lat: 0.00015, Long: 0.005},
+	)
+
+	// Assert
+	require.True(suite.T(), ok)
+	assert.InDelta(suite.T(), 0, pathLength(m.Path), 1e-12)
+}
+
+// TestMatch_FixOffNetworkStartsOver tests that a fix far from every road has no leg and restarts the track
+func (suite *MapMatchTestSuite) TestMatch_FixOffNetworkStartsOver() {
+	// Act
+	_, offRoad := suite.match(
+		geo.Point{Lat: 0, Long: 0.001},
+		geo.Point{Lat: 0.005, Long: 0.005},
+	)
+	_, restarted := suite.match(geo.Point{Lat: 0, Long: 0.003})
+	_, resumed := suite.match(geo.Point{Lat: 0, Long: 0.004})
+
+	// Assert
+	assert.False(suite.T(), offRoad)
+	assert.False(suite.T(), restarted)
+	assert.True(suite.T(), resumed)
+}
+
+// TestRoute_InterpolatesAcrossGap tests that two fixes far apart are joined by the shortest route over the roads
+func (suite *MapMatchTestSuite) TestRoute_InterpolatesAcrossGap() {
+	// Arrange
+	from := geo.Point{Lat: 0.0001, Long: 0.001}
+	to := geo.Point{Lat: 0.008, Long: 0.0101}
+
+	// Act
+	m, ok := suite.matcher.Route(from, to)
+
+	// Assert
+	require.True(suite.T(), ok)
+	assert.Equal(suite.T(), []string{"main", "bend"}, m.Roads)
+	assert.InDelta(suite.T(), 0.017, pathLength(m.Path), 1e-9)
+}
+
+// TestRoute_NeedsRoadsAtBothEnds tests that a gap ending off the network isn't routed
+func (suite *MapMatchTestSuite) TestRoute_NeedsRoadsAtBothEnds() {
+	// Act
+	_, ok := suite.matcher.Route(geo.Point{Lat: 0, Long: 0.001}, geo.Point{Lat: 0.005, Long: 0.005})
+
+	// Assert
+	assert.False(suite.T(), ok)
+}
+
+// TestRoute_LeavesTracksAlone tests that routing a gap doesn't start a track for the vehicle
+func (suite *MapMatchTestSuite) TestRoute_LeavesTracksAlone() {
+	// Act
+	_, routed := suite.matcher.Route(geo.Point{Lat: 0, Long: 0.001}, geo.Point{Lat: 0, Long: 0.003})
+	_, first := suite.match(geo.Point{Lat: 0, Long: 0.004})
+
+	// Assert
+	assert.True(suite.T(), routed)
+	assert.False(suite.T(), first)
+}
+
+// TestMatch_KeepsVehiclesApart tests that the track of one vehicle doesn't leak into another's
+func (suite *MapMatchTestSuite) TestMatch_KeepsVehiclesApart() {
+	// Act
+	suite.matcher.Match(1, geo.Point{Lat: 0, Long: 0.001})
+	_, first := suite.matcher.Match(2, geo.Point{Lat: 0, Long: 0.003})
+	suite.matcher.Forget(1)
+	_, forgotten := suite.matcher.Match(1, geo.Point{Lat: 0, Long: 0.002})
+
+	// Assert
+	assert.False(suite.T(), first)
+	assert.False(suite.T(), forgotten)
+}
+
+// TestAuditLog_AppendsRecordsWithoutCoordinates tests that matched roads are kept as JSON lines that hold no location
+func (suite *MapMatchTestSuite) TestAuditLog_AppendsRecordsWithoutCoordinates() {
+	// Arrange
+	path := filepath.Join(suite.T().TempDir(), "audit.jsonl")
+	audit, err := mapmatch.OpenAuditLog(path)
+	require.NoError(suite.T(), err)
+
+	// Act
+	require.NoError(suite.T(), audit.Record(mapmatch.AuditRecord{OBUID: 1, Seq: 2, Matched: true, Roads: []string{"main", "bend"}, Distance: 0.004}))
+	require.NoError(suite.T(), audit.Record(mapmatch.AuditRecord{OBUID: 1, Seq: 3, Distance: 0.001}))
+	require.NoError(suite.T(), audit.Close())
+
+	// Assert
+	f, err := os.Open(path)
+	require.NoError(suite.T(), err)
+	defer f.Close()
+	var records []map[string]any
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var r map[string]any
+		require.NoError(suite.T(), json.Unmarshal(scanner.Bytes(), &r))
+		records = append(records, r)
+	}
+	require.Len(suite.T(), records, 2)
+	assert.Equal(suite.T(), []any{"main", "bend"}, records[0]["roads"])
+	assert.Equal(suite.T(), false, records[1]["matched"])
+	for _, r := range records {
+		assert.NotContains(suite.T(), r, "lat")
+		assert.NotContains(suite.T(), r, "long")
+	}
+}
+
+// readAudit reads the records of the audit log at path
+func (suite *MapMatchTestSuite) readAudit(path string) []mapmatch.AuditRecord {
+	f, err := os.Open(path)
+	require.NoError(suite.T(), err)
+	defer f.Close()
+	var records []mapmatch.AuditRecord
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var r mapmatch.AuditRecord
+		require.NoError(suite.T(), json.Unmarshal(scanner.Bytes(), &r))
+		records = append(records, r)
+	}
+	return records
+}
+
+// TestAuditLog_EraseDropsVehicle tests that erasing a vehicle drops its legs, and legs recorded after are still kept
+func (suite *MapMatchTestSuite) TestAuditLog_EraseDropsVehicle() {
+	// Arrange
+	path := filepath.Join(suite.T().TempDir(), "audit.jsonl")
+	audit, err := mapmatch.OpenAuditLog(path)
+	require.NoError(suite.T(), err)
+	require.NoError(suite.T(), audit.Record(mapmatch.AuditRecord{OBUID: 1, Roads: []string{"main"}}))
+	require.NoError(suite.T(), audit.Record(mapmatch.AuditRecord{OBUID: 2, Roads: []string{"bend"}}))
+	require.NoError(suite.T(), audit.Record(mapmatch.AuditRecord{OBUID: 1, Roads: []string{"service"}}))
+
+	// Act
+	err = audit.Erase(context.Background(), 1)
+	require.NoError(suite.T(), err)
+	require.NoError(suite.T(), audit.Record(mapmatch.AuditRecord{OBUID: 3, Roads: []string{"depot"}}))
+	require.NoError(suite.T(), audit.Close())
+
+	// Assert
+	records := suite.readAudit(path)
+	require.Len(suite.T(), records, 2)
+	assert.Equal(suite.T(), int32(2), records[0].OBUID)
+	assert.Equal(suite.T(), int32(3), records[1].OBUID)
+}
+
+// TestAuditLog_PurgeDropsOldLegs tests that retention drops the legs that ended before the cutoff
+func (suite *MapMatchTestSuite) TestAuditLog_PurgeDropsOldLegs() {
+	// Arrange
+	path := filepath.Join(suite.T().TempDir(), "audit.jsonl")
+	audit, err := mapmatch.OpenAuditLog(path)
+	require.NoError(suite.T(), err)
+	defer audit.Close()
+	cutoff := time.Now().Add(-time.Hour)
+	require.NoError(suite.T(), audit.Record(mapmatch.AuditRecord{OBUID: 1, Unix: cutoff.Add(-time.Minute).UnixMilli()}))
+	require.NoError(suite.T(), audit.Record(mapmatch.AuditRecord{OBUID: 2, Unix: cutoff.Add(-time.Second).UnixMilli()}))
+	require.NoError(suite.T(), audit.Record(mapmatch.AuditRecord{OBUID: 1, Unix: cutoff.Add(time.Minute).UnixMilli()}))
+	// A leg of an unsigned fix is dated when it was recorded.
+	require.NoError(suite.T(), audit.Record(mapmatch.AuditRecord{OBUID: 3}))
+
+	// Act
+	n, err := audit.Purge(context.Background(), cutoff)
+
+	// Assert
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), 2, n)
+	records := suite.readAudit(path)
+	require.Len(suite.T(), records, 2)
+	assert.Equal(suite.T(), int32(1), records[0].OBUID)
+	assert.Equal(suite.T(), int32(3), records[1].OBUID)
+}
+
+// TestAuditLog_NilLogs tests that without an audit file records are logged rather than dropped
+func (suite *MapMatchTestSuite) TestAuditLog_NilLogs() {
+	// Arrange
+	var audit *mapmatch.AuditLog
+
+	// Act
+	err := audit.Record(mapmatch.AuditRecord{OBUID: 1})
+
+	// Assert
+	assert.NoError(suite.T(), err)
+	assert.NoError(suite.T(), audit.Erase(context.Background(), 1))
+	n, err := audit.Purge(context.Background(), time.Now())
+	assert.NoError(suite.T(), err)
+	assert.Zero(suite.T(), n)
+	assert.NoError(suite.T(), audit.Close())
+}
+
+// Run the map matching test suite
+func TestMapMatchTestSuite(t *testing.T) {
+	suite.Run(t, new(MapMatchTestSuite))
+}
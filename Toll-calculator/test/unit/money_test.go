@@ -0,0 +1,156 @@
+package unit
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/0x0Glitch/toll-calculator/money"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+// MoneyTestSuite tests exact decimal amounts, their rounding and exchange rates
+type MoneyTestSuite struct {
+	suite.Suite
+}
+
+// TestDecimal_SumsExactly tests that amounts don't drift the way floats do
+func (suite *MoneyTestSuite) TestDecimal_SumsExactly() {
+	// Arrange
+	var sum money.Decimal
+	var f float64
+
+	// Act
+	for i := 0; i < 10; i++ {
+		sum = sum.Add(money.MustParse("0.1"))
+		f += 0.1
+	}
+
+	// Assert
+	assert.Equal(suite.T(), "1.0", sum.String())
+	assert.True(suite.T(), sum.Equal(money.NewFromInt(1)))
+	assert.NotEqual(suite.T(), 1.0, f)
+}
+
+// TestDecimal_Parse tests the decimals that parse and those that don't
+func (suite *MoneyTestSuite) TestDecimal_Parse() {
+	testCases := []struct {
+		in    string
+		want  string
+		valid bool
+	}{
+		{"12", "12", true},
+		{"-0.5", "-0.5", true},
+		{"+1999.99", "1999.99", true},
+		{".25", "0.25", true},
+		{"", "", false},
+		{"1.2.3", "", false},
+		{"--1", "", false},
+		{"1e3", "", false},
+	}
+
+	for _, tc := range testCases {
+		d, err := money.Parse(tc.in)
+		if !tc.valid {
+			assert.Error(suite.T(), err, tc.in)
+			continue
+		}
+		require.NoError(suite.T(), err, tc.in)
+		assert.Equal(suite.T(), tc.want, d.String(), tc.in)
+	}
+}
+
+// TestDecimal_RoundingModes tests rounding halves to even, away from zero and dropping digits
+func (suite *MoneyTestSuite) TestDecimal_RoundingModes() {
+	testCases := []struct {
+		in                   string
+		halfEven, halfUp, dn string
+	}{
+		{"0.125", "0.12", "0.13", "0.12"},
+		{"0.135", "0.14", "0.14", "0.13"},
+		{"-0.125", "-0.12", "-0.13", "-0.12"},
+		{"0.1251", "0.13", "0.13", "0.12"},
+		{"7", "7.00", "7.00", "7.00"},
+	}
+
+	for _, tc := range testCases {
+		d := money.MustParse(tc.in)
+		assert.Equal(suite.T(), tc.halfEven, d.Round(2, money.HalfEven).String(), tc.in)
+		assert.Equal(suite.T(), tc.halfUp, d.Round(2, money.HalfUp).String(), tc.in)
+		assert.Equal(suite.T(), tc.dn, d.Round(2, money.Down).String(), tc.in)
+	}
+}
+
+// TestDecimal_JSON tests that amounts are written as strings and read from strings or numbers
+func (suite *MoneyTestSuite) TestDecimal_JSON() {
+	// Arrange
+	var v struct {
+		A, B, C money.Decimal
+	}
+
+	// Act
+	out, marshalErr := json.Marshal(money.Money{Amount: money.MustParse("19.99"), Currency: "EUR"})
+	err := json.Unmarshal([]byte(`{"A": "0.10", "B": 2.5, "C": 1e-2}`), &v)
+
+	// Assert
+	require.NoError(suite.T(), marshalErr)
+	assert.JSONEq(suite.T(), `{"amount": "19.99", "currency": "EUR"}`, string(out))
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "0.10", v.A.String())
+	assert.Equal(suite.T(), "2.5", v.B.String())
+	assert.Equal(suite.T(), "0.01", v.C.String())
+}
+
+// TestCurrency_MinorUnits tests that amounts are rounded to the digits of their currency and unknown codes are rejected
+func (suite *MoneyTestSuite) TestCurrency_MinorUnits() {
+	// Act
+	jpy := money.Rounding{}.Total(money.MustParse("1234.5"), "JPY")
+	bhd := money.Rounding{}.Total(money.MustParse("1.2345"), "BHD")
+	_, err := money.ParseCurrency("XYZ")
+
+	// Assert
+	assert.Equal(suite.T(), "1234", jpy.String())
+	assert.Equal(suite.T(), "1.234", bhd.String())
+	assert.Error(suite.T(), err)
+}
+
+// TestTable_CrossRates tests rates to, from and between the currencies of a table
+func (suite *MoneyTestSuite) TestTable_CrossRates() {
+	// Arrange
+	table, err := money.ParseTable([]byte("base: EUR\nrates:\n  CHF: 0.9412\n  GBP: 0.8571\n"))
+	require.NoError(suite.T(), err)
+
+	// Act
+	toCHF, _ := table.Rate("EUR", "CHF")
+	fromCHF, _ := table.Rate("CHF", "EUR")
+	cross, _ := table.Rate("GBP", "CHF")
+	_, missingErr := table.Rate("USD", "EUR")
+
+	// Assert
+	assert.Equal(suite.T(), "0.9412", toCHF.String())
+	assert.Equal(suite.T(), "1.0624734382", fromCHF.String())
+	assert.Equal(suite.T(), "1.0981215727", cross.String())
+	assert.Error(suite.T(), missingErr)
+	assert.Equal(suite.T(), []money.Currency{"CHF", "EUR", "GBP"}, table.Currencies())
+}
+
+// TestTable_RejectsInvalidRates tests that unknown currencies and non-positive rates are rejected
+func (suite *MoneyTestSuite) TestTable_RejectsInvalidRates() {
+	testCases := []string{
+		"base: XYZ\n",
+		"base: EUR\nrates:\n  XYZ: 1\n",
+		"base: EUR\nrates:\n  CHF: 0\n",
+		"base: EUR\nrates:\n  EUR: 2\n",
+	}
+
+	for _, tc := range testCases {
+		_, err := money.ParseTable([]byte(tc))
+		assert.Error(suite.T(), err, tc)
+	}
+}
+
+// Run the money test suite
+func TestMoneyTestSuite(t *testing.T) {
+	suite.Run(t, new(MoneyTestSuite))
+}
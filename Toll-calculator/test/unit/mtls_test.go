@@ -0,0 +1,351 @@
+package unit
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/0x0Glitch/toll-calculator/aggregator/client"
+	"github.com/0x0Glitch/toll-calculator/apperr"
+	"github.com/0x0Glitch/toll-calculator/mtls"
+	"github.com/0x0Glitch/toll-calculator/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// testCA signs the certificates of a test PKI
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue writes ca.pem, <name>.pem and <name>-key.pem to dir and returns
+// their paths
+func (ca *testCA) issue(t *testing.T, dir, name string) (caFile, certFile, keyFile string) {
+	return ca.issueFor(t, dir, name, []string{"localhost"}, []net.IP{net.IPv4(127, 0, 0, 1)})
+}
+
+// issueFor issues a certificate naming the given hosts, as issue does
+func (ca *testCA) issueFor(t *testing.T, dir, name string, dnsNames []string, ips []net.IP) (caFile, certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:     dnsNames,
+		IPAddresses:  ips,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	caFile = filepath.Join(dir, "ca.pem")
+	certFile = filepath.Join(dir, name+".pem")
+	keyFile = filepath.Join(dir, name+"-key.pem")
+	require.NoError(t, os.WriteFile(caFile, ca.pem, 0o600))
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return caFile, certFile, keyFile
+}
+
+// MTLSTestSuite tests mutual TLS between the aggregator and its clients
+type MTLSTestSuite struct {
+	suite.Suite
+	ca     *testCA
+	server *mtls.Reloader
+	client *mtls.Reloader
+}
+
+// SetupTest issues a server and a client certificate from a fresh CA before each test
+func (suite *MTLSTestSuite) SetupTest() {
+	t := suite.T()
+	suite.ca = newTestCA(t)
+	var err error
+	suite.server, err = mtls.NewReloader(suite.ca.issue(t, t.TempDir(), "aggregator"))
+	require.NoError(t, err)
+	suite.client, err = mtls.NewReloader(suite.ca.issue(t, t.TempDir(), "gateway"))
+	require.NoError(t, err)
+}
+
+// serveTLS answers every request with 200 over TLS with cfg and returns the
+// server address. httptest's StartTLS isn't used as it adds a certificate of
+// its own.
+func (suite *MTLSTestSuite) serveTLS(cfg *tls.Config) string {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Listener = tls.NewListener(server.Listener, cfg)
+	server.Start()
+	suite.T().Cleanup(server.Close)
+	return server.Listener.Addr().String()
+}
+
+// TestHTTP_AcceptsClientsOfTheCA tests that a client with a certificate of the CA reaches the aggregator
+func (suite *MTLSTestSuite) TestHTTP_AcceptsClientsOfTheCA() {
+	// Arrange
+	addr := suite.serveTLS(suite.server.ServerConfig())
+	c := client.NewHTTPClient(addr, client.WithTLS(suite.client.ClientConfig()))
+
+	// Act
+	err := c.Ping(context.Background())
+
+	// Assert
+	assert.NoError(suite.T(), err)
+}
+
+// TestHTTP_RejectsClientsWithoutCertificate tests that plain TLS clients are turned away
+func (suite *MTLSTestSuite) TestHTTP_RejectsClientsWithoutCertificate() {
+	// Arrange
+	addr := suite.serveTLS(suite.server.ServerConfig())
+	pool := x509.NewCertPool()
+	pool.AddCert(suite.ca.cert)
+	noCert := client.NewHTTPClient(addr, client.WithTLS(&tls.Config{RootCAs: pool}))
+	plaintext := client.NewHTTPClient(addr)
+
+	// Act
+	noCertErr := noCert.Ping(context.Background())
+	plaintextErr := plaintext.Ping(context.Background())
+
+	// Assert
+	assert.True(suite.T(), apperr.IsCode(noCertErr, apperr.Unavailable), "%v", noCertErr)
+	assert.Error(suite.T(), plaintextErr)
+}
+
+// TestHTTP_RejectsOtherCAs tests that certificates of another CA are refused in both directions
+func (suite *MTLSTestSuite) TestHTTP_RejectsOtherCAs() {
+	// Arrange
+	addr := suite.serveTLS(suite.server.ServerConfig())
+	t := suite.T()
+	stranger, err := mtls.NewReloader(newTestCA(t).issue(t, t.TempDir(), "stranger"))
+	require.NoError(t, err)
+	c := client.NewHTTPClient(addr, client.WithTLS(stranger.ClientConfig()))
+
+	// Act
+	err = c.Ping(context.Background())
+
+	// Assert
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "certificate")
+}
+
+// TestReload_RotatesToANewCA tests that replaced files take effect on new connections without a restart
+func (suite *MTLSTestSuite) TestReload_RotatesToANewCA() {
+	// Arrange
+	t := suite.T()
+	serverDir, clientDir := t.TempDir(), t.TempDir()
+	server, err := mtls.NewReloader(suite.ca.issue(t, serverDir, "aggregator"))
+	require.NoError(t, err)
+	client1, err := mtls.NewReloader(suite.ca.issue(t, clientDir, "gateway"))
+	require.NoError(t, err)
+	stale, err := mtls.NewReloader(suite.ca.issue(t, t.TempDir(), "calculator"))
+	require.NoError(t, err)
+	addr := suite.serveTLS(server.ServerConfig())
+	next := newTestCA(t)
+	next.issue(t, serverDir, "aggregator")
+	next.issue(t, clientDir, "gateway")
+
+	// Act
+	require.NoError(t, server.Reload())
+	require.NoError(t, client1.Reload())
+	rotatedErr := client.NewHTTPClient(addr, client.WithTLS(client1.ClientConfig())).Ping(context.Background())
+	staleErr := client.NewHTTPClient(addr, client.WithTLS(stale.ClientConfig())).Ping(context.Background())
+
+	// Assert
+	assert.NoError(t, rotatedErr)
+	assert.Error(t, staleErr)
+}
+
+// TestReload_KeepsCertificateOnInvalidFiles tests that a half-written rotation doesn't take the service down
+func (suite *MTLSTestSuite) TestReload_KeepsCertificateOnInvalidFiles() {
+	// Arrange
+	t := suite.T()
+	dir := t.TempDir()
+	caFile, certFile, keyFile := suite.ca.issue(t, dir, "aggregator")
+	server, err := mtls.NewReloader(caFile, certFile, keyFile)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(certFile, []byte("-----BEGIN CERT"), 0o600))
+
+	// Act
+	reloadErr := server.Reload()
+	addr := suite.serveTLS(server.ServerConfig())
+	pingErr := client.NewHTTPClient(addr, client.WithTLS(suite.client.ClientConfig())).Ping(context.Background())
+
+	// Assert
+	assert.Error(t, reloadErr)
+	assert.NoError(t, pingErr)
+}
+
+// TestGRPC_MutualTLS tests that the gRPC client and server authenticate each other
+func (suite *MTLSTestSuite) TestGRPC_MutualTLS() {
+	// Arrange
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(suite.T(), err)
+	server := grpc.NewServer(grpc.Creds(credentials.NewTLS(suite.server.ServerConfig())))
+	hs := grpchealth.NewServer()
+	hs.SetServingStatus(types.Aggregator_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(server, hs)
+	go server.Serve(ln)
+	defer server.Stop()
+	secure, err := client.NewGRPCClient(ln.Addr().String(), client.WithTLS(suite.client.ClientConfig()))
+	require.NoError(suite.T(), err)
+	defer secure.Close()
+	insecure, err := client.NewGRPCClient(ln.Addr().String())
+	require.NoError(suite.T(), err)
+	defer insecure.Close()
+
+	// Act
+	secureErr := secure.Ping(context.Background())
+	insecureErr := insecure.Ping(context.Background())
+
+	// Assert
+	assert.NoError(suite.T(), secureErr)
+	assert.True(suite.T(), apperr.IsCode(insecureErr, apperr.Unavailable), "%v", insecureErr)
+}
+
+// forwardedServer records whether the last call reached it marked as forwarded
+type forwardedServer struct {
+	types.UnimplementedAggregatorServer
+	forwarded chan bool
+}
+
+func (s forwardedServer) GetInvoice(ctx context.Context, _ *types.GetInvoiceRequest) (*types.InvoiceResponse, error) {
+	s.forwarded <- client.IsForwardedIncoming(ctx)
+	return &types.InvoiceResponse{Amount: "0", Tax: "0", Gross: "0"}, nil
+}
+
+// forwardedOverHTTP sends a request marked as forwarded with cfg to a
+// server trusting the members and reports whether the mark got through
+func (suite *MTLSTestSuite) forwardedOverHTTP(cfg *tls.Config, members ...string) bool {
+	var forwarded bool
+	server := httptest.NewUnstartedServer(client.TrustForwarded(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = r.Header.Get(client.ForwardedHeader) != ""
+	}), func() []string { return members }))
+	server.Listener = tls.NewListener(server.Listener, suite.server.ServerConfig())
+	server.Start()
+	defer server.Close()
+	req, err := http.NewRequest(http.MethodGet, "https://"+server.Listener.Addr().String()+"/invoice", nil)
+	require.NoError(suite.T(), err)
+	req.Header.Set(client.ForwardedHeader, "1")
+	resp, err := (&http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}).Do(req)
+	require.NoError(suite.T(), err)
+	resp.Body.Close()
+	return forwarded
+}
+
+// TestTrustForwarded_OnlyFromMembers tests that only a member's certificate lets a request through marked as forwarded
+func (suite *MTLSTestSuite) TestTrustForwarded_OnlyFromMembers() {
+	// Arrange
+	t := suite.T()
+	member, err := mtls.NewReloader(suite.ca.issueFor(t, t.TempDir(), "agg-2", []string{"agg-2"}, nil))
+	require.NoError(t, err)
+
+	// Act
+	fromMember := suite.forwardedOverHTTP(member.ClientConfig(), "agg-1:3000", "http://agg-2:3000")
+	fromClient := suite.forwardedOverHTTP(suite.client.ClientConfig(), "agg-1:3000", "http://agg-2:3000")
+	alone := suite.forwardedOverHTTP(member.ClientConfig())
+
+	// Assert
+	assert.True(t, fromMember)
+	assert.False(t, fromClient, "a client of the CA isn't a member")
+	assert.False(t, alone, "a single node has no members")
+}
+
+// TestTrustForwarded_DropsPlaintextHeader tests that the forwarded header of a request without mutual TLS is ignored
+func (suite *MTLSTestSuite) TestTrustForwarded_DropsPlaintextHeader() {
+	// Arrange
+	var forwarded bool
+	server := httptest.NewServer(client.TrustForwarded(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = r.Header.Get(client.ForwardedHeader) != ""
+	}), func() []string { return []string{"127.0.0.1:3000"} }))
+	defer server.Close()
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/invoice", nil)
+	require.NoError(suite.T(), err)
+	req.Header.Set(client.ForwardedHeader, "1")
+
+	// Act
+	resp, err := http.DefaultClient.Do(req)
+
+	// Assert
+	require.NoError(suite.T(), err)
+	resp.Body.Close()
+	assert.False(suite.T(), forwarded)
+}
+
+// TestTrustForwardedInterceptor_OnlyFromMembers tests that gRPC calls keep the forwarded mark only when a member made them
+func (suite *MTLSTestSuite) TestTrustForwardedInterceptor_OnlyFromMembers() {
+	// Arrange
+	t := suite.T()
+	member, err := mtls.NewReloader(suite.ca.issueFor(t, t.TempDir(), "agg-2", []string{"agg-2"}, nil))
+	require.NoError(t, err)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := grpc.NewServer(
+		grpc.Creds(credentials.NewTLS(suite.server.ServerConfig())),
+		grpc.UnaryInterceptor(client.TrustForwardedInterceptor(func() []string { return []string{"agg-2:3000"} })),
+	)
+	rec := forwardedServer{forwarded: make(chan bool, 1)}
+	types.RegisterAggregatorServer(server, rec)
+	go server.Serve(ln)
+	defer server.Stop()
+	call := func(cfg *tls.Config) bool {
+		c, err := client.NewGRPCClient(ln.Addr().String(), client.WithTLS(cfg))
+		require.NoError(t, err)
+		defer c.Close()
+		_, err = c.GetInvoice(client.WithForwarded(context.Background()), 1)
+		require.NoError(t, err)
+		return <-rec.forwarded
+	}
+
+	// Act
+	fromMember := call(member.ClientConfig())
+	fromClient := call(suite.client.ClientConfig())
+
+	// Assert
+	assert.True(t, fromMember)
+	assert.False(t, fromClient)
+}
+
+// Run the mutual TLS test suite
+func TestMTLSTestSuite(t *testing.T) {
+	suite.Run(t, new(MTLSTestSuite))
+}
@@ -0,0 +1,232 @@
+package unit
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/0x0Glitch/toll-calculator/obuauth"
+	"github.com/0x0Glitch/toll-calculator/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+// OBUAuthTestSuite tests signing fixes on the OBU and verifying them on the data receiver
+type OBUAuthTestSuite struct {
+	suite.Suite
+	registry []obuauth.Device
+	devices  []obuauth.Device
+	verifier *obuauth.Verifier
+}
+
+// SetupTest provisions an Ed25519 and an HMAC device before each test
+func (suite *OBUAuthTestSuite) SetupTest() {
+	suite.registry, suite.devices = nil, nil
+	for i, useHMAC := range []bool{false, true} {
+		r, d, err := obuauth.Provision(int32(100+i), useHMAC)
+		require.NoError(suite.T(), err)
+		suite.registry = append(suite.registry, r)
+		suite.devices = append(suite.devices, d)
+	}
+	registry, err := obuauth.NewRegistry(suite.registry)
+	require.NoError(suite.T(), err)
+	suite.verifier = obuauth.NewVerifier(registry, time.Minute)
+}
+
+func (suite *OBUAuthTestSuite) signed(device int) types.OBUData {
+	signer, err := obuauth.NewSigner(suite.devices[device])
+	require.NoError(suite.T(), err)
+	data := types.OBUData{Lat: 52.37, Long: 4.89}
+	signer.Sign(&data)
+	return data
+}
+
+// TestVerify_AcceptsSignedFixes tests that fixes signed with either kind of credential are accepted
+func (suite *OBUAuthTestSuite) TestVerify_AcceptsSignedFixes() {
+	// Arrange
+	ed, mac := suite.signed(0), suite.signed(1)
+
+	// Act
+	edErr, macErr := suite.verifier.Verify(ed), suite.verifier.Verify(mac)
+
+	// Assert
+	assert.NoError(suite.T(), edErr)
+	assert.NoError(suite.T(), macErr)
+	assert.Equal(suite.T(), int32(100), ed.OBUID)
+	assert.Len(suite.T(), ed.Sig, 64)
+	assert.Len(suite.T(), mac.Sig, 32)
+}
+
+// TestVerify_RejectsTamperedAndSpoofedFixes tests that changing a signed fix or its OBU ID invalidates it
+func (suite *OBUAuthTestSuite) TestVerify_RejectsTamperedAndSpoofedFixes() {
+	// Arrange
+	tampered := suite.signed(0)
+	tampered.Lat += 0.01
+	spoofed := suite.signed(0)
+	spoofed.OBUID = 101
+
+	// Act
+	tamperedErr, spoofedErr := suite.verifier.Verify(tampered), suite.verifier.Verify(spoofed)
+
+	// Assert
+	assert.ErrorIs(suite.T(), tamperedErr, obuauth.ErrBadSignature)
+	assert.ErrorIs(suite.T(), spoofedErr, obuauth.ErrBadSignature)
+	assert.Equal(suite.T(), "bad_signature", obuauth.Reason(spoofedErr))
+}
+
+// TestVerify_SignsIgnitionOff tests that switching the ignition off is signed, so it can't be added to a fix or stripped from one
+func (suite *OBUAuthTestSuite) TestVerify_SignsIgnitionOff() {
+	for i, name := range []string{"ed25519", "hmac"} {
+		// Arrange
+		signer, err := obuauth.NewSigner(suite.devices[i])
+		require.NoError(suite.T(), err)
+		off := types.OBUData{Lat: 52.37, Long: 4.89, IgnitionOff: true}
+		signer.Sign(&off)
+		stripped := off
+		stripped.IgnitionOff = false
+		added := types.OBUData{Lat: 52.37, Long: 4.89}
+		signer.Sign(&added)
+		added.IgnitionOff = true
+
+		// Act
+		strippedErr, addedErr := suite.verifier.Verify(stripped), suite.verifier.Verify(added)
+		offErr := suite.verifier.Verify(off)
+
+		// Assert
+		assert.ErrorIs(suite.T(), strippedErr, obuauth.ErrBadSignature, name)
+		assert.ErrorIs(suite.T(), addedErr, obuauth.ErrBadSignature, name)
+		assert.NoError(suite.T(), offErr, name)
+	}
+}
+
+// TestVerify_AcceptsVersion1Signatures tests that fixes of devices signing the payload without flags still verify
+func (suite *OBUAuthTestSuite) TestVerify_AcceptsVersion1Signatures() {
+	// Arrange
+	secret, err := base64.StdEncoding.DecodeString(suite.devices[1].HMACSecret)
+	require.NoError(suite.T(), err)
+	data := types.OBUData{OBUID: 101, Seq: 1, Unix: time.Now().UnixMilli(), Lat: 52.37, Long: 4.89}
+	payload := []byte("toll-obu-v1")
+	payload = binary.BigEndian.AppendUint32(payload, uint32(data.OBUID))
+	payload = binary.BigEndian.AppendUint64(payload, data.Seq)
+	payload = binary.BigEndian.AppendUint64(payload, uint64(data.Unix))
+	payload = binary.BigEndian.AppendUint64(payload, math.Float64bits(data.Lat))
+	payload = binary.BigEndian.AppendUint64(payload, math.Float64bits(data.Long))
+	m := hmac.New(sha256.New, secret)
+	m.Write(payload)
+	data.Sig = m.Sum(nil)
+
+	// Act
+	err = suite.verifier.Verify(data)
+
+	// Assert
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), payload, obuauth.Payload(data))
+}
+
+// TestVerify_RejectsReplays tests that a fix can't be sent twice, nor an older one after a newer one
+func (suite *OBUAuthTestSuite) TestVerify_RejectsReplays() {
+	// Arrange
+	signer, err := obuauth.NewSigner(suite.devices[1])
+	require.NoError(suite.T(), err)
+	first, second := types.OBUData{Lat: 1}, types.OBUData{Lat: 2}
+	signer.Sign(&first)
+	signer.Sign(&second)
+
+	// Act
+	secondErr := suite.verifier.Verify(second)
+	firstErr := suite.verifier.Verify(first)
+	againErr := suite.verifier.Verify(second)
+
+	// Assert
+	assert.NoError(suite.T(), secondErr)
+	assert.ErrorIs(suite.T(), firstErr, obuauth.ErrReplay)
+	assert.ErrorIs(suite.T(), againErr, obuauth.ErrReplay)
+	assert.Equal(suite.T(), "replay", obuauth.Reason(againErr))
+}
+
+// TestVerify_RejectsStaleFixes tests that a validly signed fix outside the time window is rejected
+func (suite *OBUAuthTestSuite) TestVerify_RejectsStaleFixes() {
+	// Arrange
+	secret, err := base64.StdEncoding.DecodeString(suite.devices[1].HMACSecret)
+	require.NoError(suite.T(), err)
+	data := types.OBUData{OBUID: 101, Seq: 1, Unix: time.Now().Add(-time.Hour).UnixMilli(), Lat: 1, Long: 2}
+	m := hmac.New(sha256.New, secret)
+	m.Write(obuauth.Payload(data))
+	data.Sig = m.Sum(nil)
+
+	// Act
+	err = suite.verifier.Verify(data)
+
+	// Assert
+	assert.ErrorIs(suite.T(), err, obuauth.ErrStale)
+	assert.Equal(suite.T(), "stale", obuauth.Reason(err))
+}
+
+// TestVerify_RejectsUnsignedAndUnknownDevices tests the rejections that don't need a signature check
+func (suite *OBUAuthTestSuite) TestVerify_RejectsUnsignedAndUnknownDevices() {
+	// Arrange
+	unsigned := types.OBUData{OBUID: 100, Lat: 1, Long: 2}
+	unknown := suite.signed(0)
+	unknown.OBUID = 999
+
+	// Act
+	unsignedErr, unknownErr := suite.verifier.Verify(unsigned), suite.verifier.Verify(unknown)
+
+	// Assert
+	assert.Equal(suite.T(), "unsigned", obuauth.Reason(unsignedErr))
+	assert.Equal(suite.T(), "unknown_device", obuauth.Reason(unknownErr))
+}
+
+// TestRegistry_LoadsAndReloadsTheFile tests provisioning through files and picking up new devices
+func (suite *OBUAuthTestSuite) TestRegistry_LoadsAndReloadsTheFile() {
+	// Arrange
+	dir := suite.T().TempDir()
+	registryPath, devicesPath := filepath.Join(dir, "registry.yaml"), filepath.Join(dir, "devices.yaml")
+	require.NoError(suite.T(), obuauth.WriteDevices(registryPath, suite.registry[:1]))
+	require.NoError(suite.T(), obuauth.WriteDevices(devicesPath, suite.devices))
+	registry, err := obuauth.LoadRegistry(registryPath)
+	require.NoError(suite.T(), err)
+	verifier := obuauth.NewVerifier(registry, time.Minute)
+	devices, err := obuauth.LoadDevices(devicesPath)
+	require.NoError(suite.T(), err)
+	signer, err := obuauth.NewSigner(devices[1])
+	require.NoError(suite.T(), err)
+
+	// Act
+	var before, after types.OBUData
+	signer.Sign(&before)
+	beforeErr := verifier.Verify(before)
+	require.NoError(suite.T(), obuauth.WriteDevices(registryPath, suite.registry))
+	require.NoError(suite.T(), registry.Reload())
+	signer.Sign(&after)
+	afterErr := verifier.Verify(after)
+	require.NoError(suite.T(), os.WriteFile(registryPath, []byte("devices:\n  - obuID: 1\n"), 0o600))
+	invalidErr := registry.Reload()
+
+	// Assert
+	assert.ErrorIs(suite.T(), beforeErr, obuauth.ErrUnknownDevice)
+	assert.NoError(suite.T(), afterErr)
+	assert.Error(suite.T(), invalidErr)
+	assert.NoError(suite.T(), verifier.Verify(suite.signed(0)), "invalid file keeps the registry")
+}
+
+// TestRegistry_RejectsDuplicateDevices tests that an OBU can't be listed with two credentials
+func (suite *OBUAuthTestSuite) TestRegistry_RejectsDuplicateDevices() {
+	// Act
+	_, err := obuauth.NewRegistry([]obuauth.Device{suite.registry[0], suite.registry[0]})
+
+	// Assert
+	assert.ErrorContains(suite.T(), err, "listed twice")
+}
+
+// Run the OBU authentication test suite
+func TestOBUAuthTestSuite(t *testing.T) {
+	suite.Run(t, new(OBUAuthTestSuite))
+}
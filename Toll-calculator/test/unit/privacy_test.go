@@ -0,0 +1,228 @@
+package unit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/0x0Glitch/toll-calculator/privacy"
+	"github.com/0x0Glitch/toll-calculator/types"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+// PrivacyTestSuite tests the encryption, redaction, retention and erasure of location data
+type PrivacyTestSuite struct {
+	suite.Suite
+	dir    string
+	master []byte
+	keys   *privacy.Keyring
+}
+
+// SetupTest creates an empty keyring before each test
+func (suite *PrivacyTestSuite) SetupTest() {
+	suite.dir = suite.T().TempDir()
+	suite.master = bytes.Repeat([]byte{7}, 32)
+	var err error
+	suite.keys, err = privacy.NewKeyring(suite.dir, suite.master)
+	require.NoError(suite.T(), err)
+}
+
+// locatedFix returns a fix of the OBU with its coordinates set
+func locatedFix(obuID int32) types.OBUData {
+	return types.OBUData{OBUID: obuID, Lat: 52.370216, Long: 4.895168, Seq: 9, Unix: 1700000000000}
+}
+
+// TestSeal_RoundTrips tests that a sealed fix opens to the original coordinates
+func (suite *PrivacyTestSuite) TestSeal_RoundTrips() {
+	// Arrange
+	data := locatedFix(1)
+
+	// Act
+	sealed, sealErr := privacy.Seal(suite.keys, data)
+	opened, openErr := privacy.Open(suite.keys, sealed)
+
+	// Assert
+	require.NoError(suite.T(), sealErr)
+	require.NoError(suite.T(), openErr)
+	assert.Equal(suite.T(), data, opened)
+}
+
+// TestSeal_KeepsCoordinatesOutOfThePayload tests that the Kafka payload holds no plaintext coordinates
+func (suite *PrivacyTestSuite) TestSeal_KeepsCoordinatesOutOfThePayload() {
+	// Arrange
+	data := locatedFix(1)
+
+	// Act
+	sealed, err := privacy.Seal(suite.keys, data)
+	require.NoError(suite.T(), err)
+	b, err := json.Marshal(sealed)
+
+	// Assert
+	require.NoError(suite.T(), err)
+	assert.NotContains(suite.T(), string(b), "52.37")
+	assert.NotContains(suite.T(), string(b), "4.89")
+	assert.NotEmpty(suite.T(), sealed.KeyID)
+}
+
+// TestOpen_RejectsMovedCiphertext tests that coordinates can't be moved to another fix
+func (suite *PrivacyTestSuite) TestOpen_RejectsMovedCiphertext() {
+	// Arrange
+	sealed, err := privacy.Seal(suite.keys, locatedFix(1))
+	require.NoError(suite.T(), err)
+	sealed.Seq++
+
+	// Act
+	_, err = privacy.Open(suite.keys, sealed)
+
+	// Assert
+	assert.Error(suite.T(), err)
+	assert.NotErrorIs(suite.T(), err, privacy.ErrKeyDestroyed)
+}
+
+// TestKeyring_IsSharedThroughTheDirectory tests that another service opens fixes sealed with the same directory and master key
+func (suite *PrivacyTestSuite) TestKeyring_IsSharedThroughTheDirectory() {
+	// Arrange
+	other, err := privacy.NewKeyring(suite.dir, suite.master)
+	require.NoError(suite.T(), err)
+	sealed, err := privacy.Seal(suite.keys, locatedFix(1))
+	require.NoError(suite.T(), err)
+
+	// Act
+	opened, err := privacy.Open(other, sealed)
+
+	// Assert
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), 52.370216, opened.Lat)
+}
+
+// TestErase_DestroysTheDataKey tests that fixes sealed before an erasure stay unreadable, even once the vehicle is back
+func (suite *PrivacyTestSuite) TestErase_DestroysTheDataKey() {
+	// Arrange
+	before, err := privacy.Seal(suite.keys, locatedFix(1))
+	require.NoError(suite.T(), err)
+	other, err := privacy.Seal(suite.keys, locatedFix(2))
+	require.NoError(suite.T(), err)
+
+	// Act
+	require.NoError(suite.T(), suite.keys.Erase(context.Background(), 1))
+	_, erasedErr := privacy.Open(suite.keys, before)
+	after, err := privacy.Seal(suite.keys, locatedFix(1))
+	require.NoError(suite.T(), err)
+	_, stillErr := privacy.Open(suite.keys, before)
+	_, otherErr := privacy.Open(suite.keys, other)
+
+	// Assert
+	assert.ErrorIs(suite.T(), erasedErr, privacy.ErrKeyDestroyed)
+	assert.ErrorIs(suite.T(), stillErr, privacy.ErrKeyDestroyed)
+	assert.NotEqual(suite.T(), before.KeyID, after.KeyID)
+	assert.NoError(suite.T(), otherErr)
+}
+
+// TestRedactHook_DropsCoordinates tests that coordinates never reach the log output
+func (suite *PrivacyTestSuite) TestRedactHook_DropsCoordinates() {
+	// Arrange
+	var out bytes.Buffer
+	logger := logrus.New()
+	logger.SetOutput(&out)
+	logger.SetFormatter(&logrus.JSONFormatter{})
+	logger.AddHook(privacy.RedactHook{})
+
+	// Act
+	logger.WithFields(logrus.Fields{"obuID": 1, "lat": 52.370216, "Longitude": 4.895168}).Info("fix")
+	logger.WithField("data", locatedFix(1)).Info("fix")
+
+	// Assert
+	assert.NotContains(suite.T(), out.String(), "52.37")
+	assert.NotContains(suite.T(), out.String(), "4.89")
+	assert.Contains(suite.T(), out.String(), privacy.Redacted)
+	assert.Contains(suite.T(), out.String(), `"obuID":1`)
+}
+
+// TestErasure_ReportsErasedStores tests that the erasure API erases the vehicle from every store
+func (suite *PrivacyTestSuite) TestErasure_ReportsErasedStores() {
+	// Arrange
+	var erasedID int32
+	var erasure privacy.Erasure
+	erasure.Add("keyring", suite.keys)
+	erasure.Add("distances", privacy.EraserFunc(func(ctx context.Context, obuID int32) error {
+		erasedID = obuID
+		return nil
+	}))
+	rec := httptest.NewRecorder()
+
+	// Act
+	erasure.Handler()(rec, httptest.NewRequest(http.MethodPost, "/admin/erase?obu=42", nil))
+
+	// Assert
+	require.Equal(suite.T(), http.StatusOK, rec.Code)
+	var result privacy.ErasureResult
+	require.NoError(suite.T(), json.NewDecoder(rec.Body).Decode(&result))
+	assert.Equal(suite.T(), privacy.ErasureResult{OBUID: 42, Erased: []string{"keyring", "distances"}}, result)
+	assert.Equal(suite.T(), int32(42), erasedID)
+}
+
+// TestErasure_CarriesOnPastFailures tests that a failing store doesn't keep the others from being erased
+func (suite *PrivacyTestSuite) TestErasure_CarriesOnPastFailures() {
+	// Arrange
+	var erasure privacy.Erasure
+	erasure.Add("cluster", privacy.EraserFunc(func(ctx context.Context, obuID int32) error {
+		return errors.New("node down")
+	}))
+	erasure.Add("keyring", suite.keys)
+	rec := httptest.NewRecorder()
+	bad := httptest.NewRecorder()
+
+	// Act
+	erasure.Handler()(rec, httptest.NewRequest(http.MethodPost, "/admin/erase?obu=42", nil))
+	erasure.Handler()(bad, httptest.NewRequest(http.MethodPost, "/admin/erase?obu=x", nil))
+	erased, err := erasure.Erase(context.Background(), 42)
+
+	// Assert
+	assert.Equal(suite.T(), http.StatusInternalServerError, rec.Code)
+	assert.Contains(suite.T(), rec.Body.String(), "node down")
+	assert.Equal(suite.T(), http.StatusBadRequest, bad.Code)
+	assert.Equal(suite.T(), []string{"keyring"}, erased)
+	assert.ErrorContains(suite.T(), err, "cluster: node down")
+}
+
+// countingPurger records the cutoffs it is asked to purge before
+type countingPurger struct {
+	calls  atomic.Int32
+	cutoff atomic.Int64
+}
+
+func (p *countingPurger) Purge(ctx context.Context, before time.Time) (int, error) {
+	p.calls.Add(1)
+	p.cutoff.Store(before.UnixNano())
+	return 1, nil
+}
+
+// TestRunRetention_PurgesPastTheRetention tests that retention purges what is older than the retention every interval
+func (suite *PrivacyTestSuite) TestRunRetention_PurgesPastTheRetention() {
+	// Arrange
+	p := &countingPurger{}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Act
+	go privacy.RunRetention(ctx, "distances", p, time.Hour, 5*time.Millisecond)
+
+	// Assert
+	require.Eventually(suite.T(), func() bool { return p.calls.Load() >= 2 }, time.Second, time.Millisecond)
+	age := time.Since(time.Unix(0, p.cutoff.Load()))
+	assert.InDelta(suite.T(), time.Hour.Seconds(), age.Seconds(), 1)
+}
+
+// Run the privacy test suite
+func TestPrivacyTestSuite(t *testing.T) {
+	suite.Run(t, new(PrivacyTestSuite))
+}
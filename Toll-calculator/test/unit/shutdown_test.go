@@ -0,0 +1,278 @@
+package unit
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/0x0Glitch/toll-calculator/aggregator/client"
+	"github.com/0x0Glitch/toll-calculator/apperr"
+	"github.com/0x0Glitch/toll-calculator/gokit/aggservice/aggsvc/aggendpoint"
+	"github.com/0x0Glitch/toll-calculator/gokit/aggservice/aggsvc/aggservice"
+	"github.com/0x0Glitch/toll-calculator/gokit/aggservice/aggsvc/aggtransport"
+	"github.com/0x0Glitch/toll-calculator/shutdown"
+	"github.com/0x0Glitch/toll-calculator/types"
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc"
+)
+
+// gatedService holds every Aggregate call until the gate is opened or the call is cancelled
+type gatedService struct {
+	aggservice.Service
+	started chan struct{}
+	release chan struct{}
+	once    sync.Once
+}
+
+func newGatedService(next aggservice.Service) *gatedService {
+	return &gatedService{Service: next, started: make(chan struct{}), release: make(chan struct{})}
+}
+
+func (g *gatedService) Aggregate(ctx context.Context, distance types.Distance) error {
+	g.once.Do(func() { close(g.started) })
+	select {
+	case <-g.release:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return g.Service.Aggregate(ctx, distance)
+}
+
+// fakeFlusher delivers one queued message per Flush call
+type fakeFlusher struct {
+	queued int
+}
+
+func (f *fakeFlusher) Flush(timeoutMs int) int {
+	if f.queued > 0 {
+		f.queued--
+	}
+	return f.queued
+}
+
+// fakeCommitter returns a fixed error from Commit
+type fakeCommitter struct {
+	err error
+}
+
+func (f fakeCommitter) Commit() ([]kafka.TopicPartition, error) {
+	return nil, f.err
+}
+
+// ShutdownTestSuite tests that draining a service loses nothing in flight
+type ShutdownTestSuite struct {
+	suite.Suite
+	store *aggservice.MemoryStore
+	gate  *gatedService
+	ln    net.Listener
+}
+
+// SetupTest creates a gated go-kit service and a listener before each test
+func (suite *ShutdownTestSuite) SetupTest() {
+	suite.store = aggservice.NewMemoryStore()
+	suite.gate = newGatedService(aggservice.NewBasicService(suite.store))
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(suite.T(), err)
+	suite.ln = ln
+}
+
+func (suite *ShutdownTestSuite) endpoints() aggendpoint.Set {
+	return aggendpoint.New(suite.gate, log.NewNopLogger(), rate.Inf)
+}
+
+// drainWhileInFlight sends one distance, shuts down while it is held by the
+// gate and returns the results of the call and of the shutdown.
+func (suite *ShutdownTestSuite) drainWhileInFlight(c client.Client, hook shutdown.Hook) (aggErr, shutdownErr error) {
+	aggDone := make(chan error, 1)
+	go func() {
+		aggDone <- c.Aggregate(context.Background(), &types.AggregatorRequest{ObuID: 7, Value: 12.5})
+	}()
+	<-suite.gate.started
+
+	shutdownDone := make(chan error, 1)
+	go func() { shutdownDone <- shutdown.Run(5*time.Second, hook) }()
+	select {
+	case err := <-shutdownDone:
+		suite.T().Fatalf("shutdown returned with a request in flight: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(suite.gate.release)
+	return <-aggDone, <-shutdownDone
+}
+
+// TestHTTPServer_FinishesRequestsInFlight tests that an HTTP shutdown waits for the aggregate in flight and refuses new ones
+func (suite *ShutdownTestSuite) TestHTTPServer_FinishesRequestsInFlight() {
+	// Arrange
+	srv := &http.Server{Handler: aggtransport.NewHTTPHandler(suite.endpoints(), log.NewNopLogger())}
+	go srv.Serve(suite.ln)
+	c := client.NewHTTPClient("http://" + suite.ln.Addr().String())
+
+	// Act
+	aggErr, shutdownErr := suite.drainWhileInFlight(c, shutdown.HTTPServer("http", srv))
+	afterErr := c.Aggregate(context.Background(), &types.AggregatorRequest{ObuID: 7, Value: 1})
+
+	// Assert
+	require.NoError(suite.T(), aggErr)
+	require.NoError(suite.T(), shutdownErr)
+	total, err := suite.store.Get(7)
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), 12.5, total)
+	assert.True(suite.T(), apperr.IsCode(afterErr, apperr.Unavailable), "%v", afterErr)
+}
+
+// TestGRPCServer_FinishesCallsInFlight tests that a gRPC shutdown waits for the aggregate in flight
+func (suite *ShutdownTestSuite) TestGRPCServer_FinishesCallsInFlight() {
+	// Arrange
+	srv := grpc.NewServer()
+	types.RegisterAggregatorServer(srv, aggtransport.NewGRPCServer(suite.endpoints(), log.NewNopLogger()))
+	go srv.Serve(suite.ln)
+	c, err := client.NewGRPCClient(suite.ln.Addr().String())
+	require.NoError(suite.T(), err)
+	defer c.Close()
+
+	// Act
+	aggErr, shutdownErr := suite.drainWhileInFlight(c, shutdown.GRPCServer("grpc", srv))
+
+	// Assert
+	require.NoError(suite.T(), aggErr)
+	require.NoError(suite.T(), shutdownErr)
+	total, err := suite.store.Get(7)
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), 12.5, total)
+}
+
+// TestGRPCServer_StopsAtDeadline tests that calls still running at the deadline are cancelled
+func (suite *ShutdownTestSuite) TestGRPCServer_StopsAtDeadline() {
+	// Arrange
+	srv := grpc.NewServer()
+	types.RegisterAggregatorServer(srv, aggtransport.NewGRPCServer(suite.endpoints(), log.NewNopLogger()))
+	go srv.Serve(suite.ln)
+	c, err := client.NewGRPCClient(suite.ln.Addr().String())
+	require.NoError(suite.T(), err)
+	defer c.Close()
+	defer close(suite.gate.release)
+	go c.Aggregate(context.Background(), &types.AggregatorRequest{ObuID: 7, Value: 1})
+	<-suite.gate.started
+
+	// Act
+	err = shutdown.Run(50*time.Millisecond, shutdown.GRPCServer("grpc", srv))
+
+	// Assert
+	assert.ErrorIs(suite.T(), err, context.DeadlineExceeded)
+}
+
+// TestRun_RunsEveryHookInOrder tests that a failing hook doesn't skip the ones after it
+func (suite *ShutdownTestSuite) TestRun_RunsEveryHookInOrder() {
+	// Arrange
+	var order []string
+	step := func(name string, err error) shutdown.Hook {
+		return shutdown.Func(name, func() error {
+			order = append(order, name)
+			return err
+		})
+	}
+
+	// Act
+	err := shutdown.Run(time.Second,
+		step("http", nil),
+		step("kafka producer", errors.New("broker down")),
+		step("tracing", nil),
+	)
+
+	// Assert
+	assert.Equal(suite.T(), []string{"http", "kafka producer", "tracing"}, order)
+	assert.EqualError(suite.T(), err, "kafka producer: broker down")
+}
+
+// TestInFlight_DrainWaitsForWork tests that draining waits for accepted work and refuses new work
+func (suite *ShutdownTestSuite) TestInFlight_DrainWaitsForWork() {
+	// Arrange
+	var inflight shutdown.InFlight
+	require.True(suite.T(), inflight.Begin())
+	var finished bool
+	time.AfterFunc(50*time.Millisecond, func() {
+		finished = true
+		inflight.Done()
+	})
+
+	// Act
+	err := inflight.Drain(context.Background())
+
+	// Assert
+	require.NoError(suite.T(), err)
+	assert.True(suite.T(), finished)
+	assert.False(suite.T(), inflight.Begin(), "no work may start once draining")
+}
+
+// TestInFlight_DrainGivesUpAtDeadline tests that draining stops waiting when the context is done
+func (suite *ShutdownTestSuite) TestInFlight_DrainGivesUpAtDeadline() {
+	// Arrange
+	var inflight shutdown.InFlight
+	require.True(suite.T(), inflight.Begin())
+	defer inflight.Done()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	// Act
+	err := inflight.Drain(ctx)
+
+	// Assert
+	assert.ErrorIs(suite.T(), err, context.DeadlineExceeded)
+}
+
+// TestFlushProducer_DeliversEverything tests that flushing waits until the producer queue is empty
+func (suite *ShutdownTestSuite) TestFlushProducer_DeliversEverything() {
+	// Arrange
+	p := &fakeFlusher{queued: 3}
+
+	// Act
+	err := shutdown.FlushProducer(context.Background(), p)
+
+	// Assert
+	require.NoError(suite.T(), err)
+	assert.Zero(suite.T(), p.queued)
+}
+
+// TestFlushProducer_ReportsUndelivered tests that flushing reports what is left at the deadline
+func (suite *ShutdownTestSuite) TestFlushProducer_ReportsUndelivered() {
+	// Arrange
+	p := &fakeFlusher{queued: 1 << 30}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// Act
+	err := shutdown.FlushProducer(ctx, p)
+
+	// Assert
+	assert.ErrorIs(suite.T(), err, context.Canceled)
+	assert.Contains(suite.T(), err.Error(), "messages not delivered")
+}
+
+// TestCommitConsumer_IgnoresNothingToCommit tests that an empty commit is not an error
+func (suite *ShutdownTestSuite) TestCommitConsumer_IgnoresNothingToCommit() {
+	// Arrange
+	noOffset := kafka.NewError(kafka.ErrNoOffset, "no offset", false)
+	failed := kafka.NewError(kafka.ErrTransport, "broker down", false)
+
+	// Act
+	noOffsetErr := shutdown.CommitConsumer(fakeCommitter{err: noOffset})
+	failedErr := shutdown.CommitConsumer(fakeCommitter{err: failed})
+
+	// Assert
+	assert.NoError(suite.T(), noOffsetErr)
+	assert.Error(suite.T(), failedErr)
+}
+
+// Run the shutdown test suite
+func TestShutdownTestSuite(t *testing.T) {
+	suite.Run(t, new(ShutdownTestSuite))
+}
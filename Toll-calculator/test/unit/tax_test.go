@@ -0,0 +1,105 @@
+package unit
+
+import (
+	"testing"
+
+	"github.com/0x0Glitch/toll-calculator/money"
+	"github.com/0x0Glitch/toll-calculator/tax"
+	"github.com/0x0Glitch/toll-calculator/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+// TaxTestSuite tests the tax rules of jurisdictions and the tax summary of a period
+type TaxTestSuite struct {
+	suite.Suite
+}
+
+// TestParseRules_RejectsInvalidRules tests that rules referring to unknown jurisdictions or with invalid rates are rejected
+func (suite *TaxTestSuite) TestParseRules_RejectsInvalidRules() {
+	testCases := []string{
+		"jurisdictions:\n  - {rate: \"0.2\"}\n",
+		"jurisdictions:\n  - {code: NL, rate: \"0.21\"}\n  - {code: NL, rate: \"0.09\"}\n",
+		"jurisdictions:\n  - {code: NL, rate: \"1.5\"}\n",
+		"jurisdictions:\n  - {code: NL, rate: \"-0.1\"}\n",
+		"jurisdictions:\n  - {code: NL, rate: \"0.21\"}\nzones:\n  A: DE\n",
+		"jurisdictions:\n  - {code: NL, rate: \"0.21\"}\ndefault: DE\n",
+		"jurisdictions:\n  - {code: NL, rate: \"0.21\"}\nvehicles:\n  truck: {class: bus}\n",
+		"jurisdictions:\n  - {code: NL, rate: \"0.21\"}\nvehicles:\n  1: {vatID: NL123}\n",
+	}
+
+	for _, tc := range testCases {
+		_, err := tax.ParseRules([]byte(tc))
+		assert.Error(suite.T(), err, tc)
+	}
+}
+
+// TestAssess_RegionOfCountry tests that a region taxes under reverse charge only businesses from outside its country
+func (suite *TaxTestSuite) TestAssess_RegionOfCountry() {
+	// Arrange
+	rules, err := tax.ParseRules([]byte(`
+jurisdictions:
+  - {code: ES, rate: "0.21", reverseCharge: true}
+  - {code: ES-CN, country: ES, rate: "0.07", reverseCharge: true}
+zones:
+  tenerife: ES-CN
+vehicles:
+  1: {vatID: ESB12345678, country: ES}
+  2: {vatID: FR12345678901, country: FR}
+`))
+	require.NoError(suite.T(), err)
+
+	// Act
+	local, localErr := rules.Assess(1, "tenerife", "ES")
+	foreign, _ := rules.Assess(2, "tenerife", "ES")
+	_, missingErr := rules.Assess(1, "paris", "FR")
+
+	// Assert
+	require.NoError(suite.T(), localErr)
+	assert.Equal(suite.T(), "ES-CN", local.Jurisdiction)
+	assert.Equal(suite.T(), types.TaxStandard, local.Treatment)
+	assert.Equal(suite.T(), "0.07", local.Rate.String())
+	assert.Equal(suite.T(), types.TaxReverseCharge, foreign.Treatment)
+	assert.Equal(suite.T(), "0.07", foreign.Rate.String())
+	assert.Error(suite.T(), missingErr)
+}
+
+// TestSummary_SumsIssuedInvoicesLessCredits tests that the summary sums the lines of issued invoices per jurisdiction, treatment and rate and subtracts credit notes
+func (suite *TaxTestSuite) TestSummary_SumsIssuedInvoicesLessCredits() {
+	// Arrange
+	nl := types.InvoiceLine{ZoneID: "A", Amount: money.MustParse("20.00"), Jurisdiction: "NL", TaxTreatment: types.TaxStandard, TaxRate: money.MustParse("0.21"), Tax: money.MustParse("4.20")}
+	de := types.InvoiceLine{ZoneID: "B", Amount: money.MustParse("10.00"), Jurisdiction: "DE", TaxTreatment: types.TaxStandard, TaxRate: money.MustParse("0.19"), Tax: money.MustParse("1.90")}
+	rc := types.InvoiceLine{ZoneID: "A", Amount: money.MustParse("5.00"), Jurisdiction: "NL", TaxTreatment: types.TaxReverseCharge, TaxRate: money.MustParse("0.21")}
+	summary := tax.NewSummary("2025-09")
+
+	// Act
+	summary.AddInvoice(types.Invoice{ID: "INV-2025-09-1", Period: "2025-09", Status: types.InvoiceFinalized, Currency: "EUR", Lines: []types.InvoiceLine{nl, de}})
+	summary.AddInvoice(types.Invoice{ID: "INV-2025-09-2", Period: "2025-09", Status: types.InvoicePaid, Currency: "EUR", Lines: []types.InvoiceLine{nl, rc}})
+	summary.AddInvoice(types.Invoice{ID: "INV-2025-09-3", Period: "2025-09", Status: types.InvoiceDraft, Currency: "EUR", Lines: []types.InvoiceLine{nl}})
+	summary.AddInvoice(types.Invoice{ID: "INV-2025-09-4", Period: "2025-09", Status: types.InvoiceVoid, Currency: "EUR", Lines: []types.InvoiceLine{nl}})
+	summary.AddInvoice(types.Invoice{ID: "INV-2025-10-1", Period: "2025-10", Status: types.InvoiceFinalized, Currency: "EUR", Lines: []types.InvoiceLine{nl}})
+	credit := nl
+	credit.Amount, credit.Tax = money.MustParse("5.00"), money.MustParse("1.05")
+	summary.AddCreditNote(types.CreditNote{InvoiceID: "INV-2025-09-1", Currency: "EUR", Lines: []types.InvoiceLine{credit}})
+
+	// Assert
+	assert.Equal(suite.T(), 2, summary.Invoices)
+	assert.Equal(suite.T(), 1, summary.CreditNotes)
+	require.Len(suite.T(), summary.Rows, 3)
+	got := make([][]string, 0, len(summary.Rows))
+	for _, r := range summary.Rows {
+		got = append(got, []string{r.Jurisdiction, string(r.Treatment), r.Rate.String(), r.Net.String(), r.Tax.String(), r.Gross.String()})
+	}
+	assert.Equal(suite.T(), [][]string{
+		{"DE", "standard", "0.19", "10.00", "1.90", "11.90"},
+		{"NL", "reverse-charge", "0.21", "5.00", "0", "5.00"},
+		{"NL", "standard", "0.21", "35.00", "7.35", "42.35"},
+	}, got)
+	assert.Equal(suite.T(), 3, summary.Rows[2].Lines)
+}
+
+// Run the tax test suite
+func TestTaxTestSuite(t *testing.T) {
+	suite.Run(t, new(TaxTestSuite))
+}
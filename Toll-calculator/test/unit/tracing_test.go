@@ -0,0 +1,156 @@
+package unit
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/0x0Glitch/toll-calculator/aggregator/client"
+	"github.com/0x0Glitch/toll-calculator/gokit/aggservice/aggsvc/aggendpoint"
+	"github.com/0x0Glitch/toll-calculator/gokit/aggservice/aggsvc/aggservice"
+	"github.com/0x0Glitch/toll-calculator/gokit/aggservice/aggsvc/aggtransport"
+	"github.com/0x0Glitch/toll-calculator/tracing"
+	"github.com/0x0Glitch/toll-calculator/types"
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc"
+)
+
+// TracingTestSuite tests that trace context survives every hop into the aggregator
+type TracingTestSuite struct {
+	suite.Suite
+	exporter   *tracetest.InMemoryExporter
+	provider   *sdktrace.TracerProvider
+	httpServer *httptest.Server
+	grpcServer *grpc.Server
+	grpcAddr   string
+}
+
+// SetupSuite installs the in-memory tracer provider once, as the global provider can only be delegated once
+func (suite *TracingTestSuite) SetupSuite() {
+	suite.exporter, suite.provider = tracing.InMemory("unit-test")
+}
+
+// TearDownSuite shuts the tracer provider down
+func (suite *TracingTestSuite) TearDownSuite() {
+	suite.provider.Shutdown(context.Background())
+}
+
+// SetupTest starts the traced go-kit aggregator on both transports before each test
+func (suite *TracingTestSuite) SetupTest() {
+	suite.exporter.Reset()
+	svc := aggservice.NewAggregatorService(aggservice.NewMemoryStore())
+	endpoints := aggendpoint.New(svc, log.NewNopLogger(), rate.Inf)
+	suite.httpServer = httptest.NewServer(tracing.HTTPHandler(aggtransport.NewHTTPHandler(endpoints, log.NewNopLogger()), "aggsvc"))
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(suite.T(), err)
+	suite.grpcAddr = ln.Addr().String()
+	suite.grpcServer = grpc.NewServer(tracing.GRPCServerOption())
+	types.RegisterAggregatorServer(suite.grpcServer, aggtransport.NewGRPCServer(endpoints, log.NewNopLogger()))
+	go suite.grpcServer.Serve(ln)
+}
+
+// TearDownTest stops both transports after each test
+func (suite *TracingTestSuite) TearDownTest() {
+	suite.httpServer.Close()
+	suite.grpcServer.Stop()
+}
+
+func (suite *TracingTestSuite) spansIn(traceID trace.TraceID) []tracetest.SpanStub {
+	var spans []tracetest.SpanStub
+	for _, s := range suite.exporter.GetSpans() {
+		if s.SpanContext.TraceID() == traceID {
+			spans = append(spans, s)
+		}
+	}
+	return spans
+}
+
+func hasSpan(spans []tracetest.SpanStub, match func(tracetest.SpanStub) bool) bool {
+	for _, s := range spans {
+		if match(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// TestKafkaHeaders_CarryTraceContext tests that a consumer continues the producer's trace
+func (suite *TracingTestSuite) TestKafkaHeaders_CarryTraceContext() {
+	// Arrange
+	topic := "obudata"
+	ctx, span := suite.provider.Tracer("test").Start(context.Background(), "obudata publish")
+	msg := &kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic},
+		Headers:        []kafka.Header{{Key: "unrelated", Value: []byte("kept")}},
+	}
+
+	// Act
+	tracing.InjectKafka(ctx, msg)
+	span.End()
+	consumed := trace.SpanContextFromContext(tracing.ExtractKafka(context.Background(), msg))
+
+	// Assert
+	assert.True(suite.T(), consumed.IsRemote())
+	assert.Equal(suite.T(), span.SpanContext().TraceID(), consumed.TraceID())
+	assert.Equal(suite.T(), span.SpanContext().SpanID(), consumed.SpanID())
+	assert.Equal(suite.T(), "unrelated", msg.Headers[0].Key)
+}
+
+// TestKafkaHeaders_NoTraceContext tests that a message without headers starts no remote trace
+func (suite *TracingTestSuite) TestKafkaHeaders_NoTraceContext() {
+	// Act
+	consumed := trace.SpanContextFromContext(tracing.ExtractKafka(context.Background(), &kafka.Message{}))
+
+	// Assert
+	assert.False(suite.T(), consumed.IsValid())
+}
+
+// TestAggregate_PropagatesOverBothTransports tests that the aggregator's spans join the caller's trace
+func (suite *TracingTestSuite) TestAggregate_PropagatesOverBothTransports() {
+	// Arrange
+	grpcClient, err := client.NewGRPCClient(suite.grpcAddr)
+	require.NoError(suite.T(), err)
+	defer grpcClient.Close()
+	clients := map[string]client.Client{
+		"http": client.NewHTTPClient(suite.httpServer.URL),
+		"grpc": grpcClient,
+	}
+
+	for name, c := range clients {
+		ctx, parent := suite.provider.Tracer("test").Start(context.Background(), "obudata process")
+
+		// Act
+		err := c.Aggregate(ctx, &types.AggregatorRequest{ObuID: 7, Value: 1.5})
+		parent.End()
+
+		// Assert
+		require.NoError(suite.T(), err, name)
+		traceID := parent.SpanContext().TraceID()
+		assert.True(suite.T(), hasSpan(suite.spansIn(traceID), func(s tracetest.SpanStub) bool {
+			return s.Name == "Aggregate" && s.Parent.SpanID() != parent.SpanContext().SpanID()
+		}), "%s: endpoint span missing from the caller's trace", name)
+		// The server span ends once the response is on the wire, which can
+		// be after the client has already returned.
+		assert.Eventually(suite.T(), func() bool {
+			return hasSpan(suite.spansIn(traceID), func(s tracetest.SpanStub) bool {
+				return s.SpanKind == trace.SpanKindServer
+			})
+		}, time.Second, 10*time.Millisecond, "%s: server span missing from the caller's trace", name)
+	}
+}
+
+// Run the tracing test suite
+func TestTracingTestSuite(t *testing.T) {
+	suite.Run(t, new(TracingTestSuite))
+}
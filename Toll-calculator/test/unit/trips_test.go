@@ -0,0 +1,225 @@
+package unit
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/0x0Glitch/toll-calculator/aggregator/client"
+	"github.com/0x0Glitch/toll-calculator/trip"
+	"github.com/0x0Glitch/toll-calculator/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+// TripsTestSuite tests carrying trips from the calculator to the aggregator and listing them
+type TripsTestSuite struct {
+	suite.Suite
+}
+
+// TestTripLeg_ProtoRoundTrip tests that a trip leg survives the conversion to protobuf and back
+func (suite *TripsTestSuite) TestTripLeg_ProtoRoundTrip() {
+	// Arrange
+	leg := &types.TripLeg{
+		TripID: "7-1760000000000",
+		Start:  types.TripPoint{Unix: 1_760_000_000_000, Lat: 52.52, Long: 13.40},
+		End:    types.TripPoint{Unix: 1_760_000_600_000, Lat: 52.50, Long: 13.45},
+		Ends:   true,
+	}
+
+	// Act
+	back := leg.Proto().Leg()
+
+	// Assert
+	assert.Equal(suite.T(), leg, back)
+}
+
+// TestTripLeg_NilConvertsToNil tests that distances without a trip don't gain one on the wire
+func (suite *TripsTestSuite) TestTripLeg_NilConvertsToNil() {
+	// Arrange
+	var leg *types.TripLeg
+	var ref *types.TripRef
+
+	// Act & Assert
+	assert.Nil(suite.T(), leg.Proto())
+	assert.Nil(suite.T(), ref.Leg())
+}
+
+// TestHTTPClientTrips_SendsPeriod tests that the client asks for the OBU's trips in the period and decodes them
+func (suite *TripsTestSuite) TestHTTPClientTrips_SendsPeriod() {
+	// Arrange
+	var query url.Values
+	want := []types.Trip{{ID: "7-1760000000000", OBUID: 7, Distance: 12.5, Amount: 40, Ended: true}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(suite.T(), "/trips", r.URL.Path)
+		query = r.URL.Query()
+		json.NewEncoder(w).Encode(want)
+	}))
+	defer srv.Close()
+	from := time.Date(2025, 10, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 1, 0)
+
+	// Act
+	trips, err := client.NewHTTPClient(srv.URL).Trips(context.Background(), 7, from, to)
+
+	// Assert
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), want, trips)
+	assert.Equal(suite.T(), "7", query.Get("obu"))
+	assert.Equal(suite.T(), "2025-10-01T00:00:00Z", query.Get("from"))
+	assert.Equal(suite.T(), "2025-11-01T00:00:00Z", query.Get("to"))
+}
+
+// TestHTTPClientTrips_OmitsOpenEnds tests that a zero from or to isn't sent
+func (suite *TripsTestSuite) TestHTTPClientTrips_OmitsOpenEnds() {
+	// Arrange
+	var query url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		w.Write([]byte("[]"))
+	}))
+	defer srv.Close()
+
+	// Act
+	trips, err := client.NewHTTPClient(srv.URL).Trips(context.Background(), 7, time.Time{}, time.Time{})
+
+	// Assert
+	require.NoError(suite.T(), err)
+	assert.Empty(suite.T(), trips)
+	assert.False(suite.T(), query.Has("from"))
+	assert.False(suite.T(), query.Has("to"))
+}
+
+// TestHTTPClientTrips_ReturnsAPIError tests that an error response of the aggregator is returned as an error
+func (suite *TripsTestSuite) TestHTTPClientTrips_ReturnsAPIError() {
+	// Arrange
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"invalid from"}`))
+	}))
+	defer srv.Close()
+
+	// Act
+	_, err := client.NewHTTPClient(srv.URL).Trips(context.Background(), 7, time.Time{}, time.Time{})
+
+	// Assert
+	assert.Error(suite.T(), err)
+}
+
+// TestTracker_IgnitionOffEndsTrip tests that the fix switching the ignition off ends the trip and the next fix starts a new one where the vehicle stood
+func (suite *TripsTestSuite) TestTracker_IgnitionOffEndsTrip() {
+	// Arrange
+	tracker := trip.NewTracker(10 * time.Minute)
+	home := types.TripPoint{Unix: 1_000, Lat: 52.52, Long: 13.40}
+	work := types.TripPoint{Unix: 61_000, Lat: 52.50, Long: 13.45}
+	tracker.Move(7, home, false)
+
+	// Act
+	arrive := tracker.Move(7, work, true)
+	leave := tracker.Move(7, types.TripPoint{Unix: 121_000, Lat: 52.51, Long: 13.44}, false)
+
+	// Assert
+	assert.Equal(suite.T(), &types.TripLeg{TripID: "7-1000", Start: home, End: work, Ends: true}, arrive)
+	assert.Equal(suite.T(), "7-121000", leave.TripID)
+	assert.Equal(suite.T(), types.TripPoint{Unix: 121_000, Lat: 52.50, Long: 13.45}, leave.Start)
+	assert.False(suite.T(), leave.Ends)
+}
+
+// TestTracker_IdleGapSplitsTrip tests that a vehicle standing still longer than the idle time starts a new trip
+func (suite *TripsTestSuite) TestTracker_IdleGapSplitsTrip() {
+	// Arrange
+	tracker := trip.NewTracker(10 * time.Minute)
+	first := tracker.Move(7, types.TripPoint{Unix: 0, Lat: 52.52, Long: 13.40}, false)
+	tracker.Move(7, types.TripPoint{Unix: 60_000, Lat: 52.50, Long: 13.45}, false)
+
+	// Act
+	soon := tracker.Move(7, types.TripPoint{Unix: 9 * 60_000, Lat: 52.49, Long: 13.46}, false)
+	later := tracker.Move(7, types.TripPoint{Unix: 30 * 60_000, Lat: 52.48, Long: 13.47}, false)
+
+	// Assert
+	assert.Equal(suite.T(), first.TripID, soon.TripID)
+	assert.NotEqual(suite.T(), first.TripID, later.TripID)
+	assert.Equal(suite.T(), types.TripPoint{Unix: 30 * 60_000, Lat: 52.49, Long: 13.46}, later.Start)
+}
+
+// TestTracker_EndsTripOfRejectedFix tests that a rejected fix switching the ignition off still ends the trip, once
+func (suite *TripsTestSuite) TestTracker_EndsTripOfRejectedFix() {
+	// Arrange
+	tracker := trip.NewTracker(10 * time.Minute)
+	tracker.Move(7, types.TripPoint{Unix: 1_000, Lat: 52.52, Long: 13.40}, false)
+
+	// Act
+	leg, ok := tracker.End(7, 5_000)
+	_, again := tracker.End(7, 6_000)
+	_, unknown := tracker.End(8, 6_000)
+
+	// Assert
+	require.True(suite.T(), ok)
+	assert.True(suite.T(), leg.Ends)
+	assert.Equal(suite.T(), types.TripPoint{Unix: 5_000, Lat: 52.52, Long: 13.40}, leg.End)
+	assert.False(suite.T(), again)
+	assert.False(suite.T(), unknown)
+}
+
+// TestTracker_NilDetectsNoTrips tests that without an idle time legs carry no trip
+func (suite *TripsTestSuite) TestTracker_NilDetectsNoTrips() {
+	// Arrange
+	tracker := trip.NewTracker(0)
+
+	// Act
+	leg := tracker.Move(7, types.TripPoint{Unix: 1_000}, true)
+	_, ok := tracker.End(7, 2_000)
+
+	// Assert
+	assert.Nil(suite.T(), tracker)
+	assert.Nil(suite.T(), leg)
+	assert.False(suite.T(), ok)
+}
+
+// TestStore_LegsOutOfOrder tests that a leg of an earlier trip arriving after a later trip started is placed before it, and ended
+func (suite *TripsTestSuite) TestStore_LegsOutOfOrder() {
+	// Arrange
+	store := trip.NewStore()
+	first := &types.TripLeg{TripID: "7-1000", Start: types.TripPoint{Unix: 1_000}, End: types.TripPoint{Unix: 2_000}}
+	second := &types.TripLeg{TripID: "7-5000", Start: types.TripPoint{Unix: 5_000}, End: types.TripPoint{Unix: 6_000}}
+
+	// Act
+	store.Record(&types.Distance{OBUID: 7, Values: 2, Trip: second}, 6)
+	store.Record(&types.Distance{OBUID: 7, Values: 1, Trip: first}, 3)
+	store.Record(&types.Distance{OBUID: 7, Values: 0.5, Estimated: true, Trip: &types.TripLeg{TripID: "7-1000", Start: first.Start, End: types.TripPoint{Unix: 1_500}}}, 1)
+	trips, err := store.Trips(context.Background(), 7, time.Time{}, time.Time{})
+
+	// Assert
+	require.NoError(suite.T(), err)
+	require.Len(suite.T(), trips, 2)
+	assert.Equal(suite.T(), types.Trip{ID: "7-1000", OBUID: 7, Start: first.Start, End: first.End, Distance: 1.5, Estimated: 0.5, Amount: 4, Ended: true}, trips[0])
+	assert.Equal(suite.T(), "7-5000", trips[1].ID)
+	assert.False(suite.T(), trips[1].Ended)
+}
+
+// TestMerge_JoinsTripsAcrossNodes tests that the parts of a trip kept by the members that owned the vehicle add up to one trip
+func (suite *TripsTestSuite) TestMerge_JoinsTripsAcrossNodes() {
+	// Arrange
+	before := types.Trip{ID: "7-1000", OBUID: 7, Start: types.TripPoint{Unix: 1_000}, End: types.TripPoint{Unix: 3_000}, Distance: 2, Amount: 6}
+	after := types.Trip{ID: "7-1000", OBUID: 7, Start: types.TripPoint{Unix: 1_000}, End: types.TripPoint{Unix: 8_000}, Distance: 1, Estimated: 1, Amount: 3}
+	next := types.Trip{ID: "7-9000", OBUID: 7, Start: types.TripPoint{Unix: 9_000}, End: types.TripPoint{Unix: 9_500}, Distance: 1, Amount: 3}
+
+	// Act
+	trips := trip.Merge([]types.Trip{next, after, before})
+
+	// Assert
+	require.Len(suite.T(), trips, 2)
+	assert.Equal(suite.T(), types.Trip{ID: "7-1000", OBUID: 7, Start: types.TripPoint{Unix: 1_000}, End: types.TripPoint{Unix: 8_000}, Distance: 3, Estimated: 1, Amount: 9, Ended: true}, trips[0])
+	assert.Equal(suite.T(), "7-9000", trips[1].ID)
+	assert.False(suite.T(), trips[1].Ended)
+}
+
+// Run the trips test suite
+func TestTripsTestSuite(t *testing.T) {
+	suite.Run(t, new(TripsTestSuite))
+}
@@ -0,0 +1,66 @@
+// Package tracing sets up OpenTelemetry for the services and carries trace
+// context across the hops of the pipeline: the WebSocket receiver, Kafka,
+// the distance calculator and the aggregator's HTTP and gRPC transports.
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// NewExporter returns the span exporter with the given name: "none" or ""
+// to drop spans, "stdout" to print them, or "otlp" to send them to an
+// OTLP collector over gRPC.
+func NewExporter(ctx context.Context, name string) (sdktrace.SpanExporter, error) {
+	switch name {
+	case "", "none":
+		return nil, nil
+	case "stdout":
+		return stdouttrace.New(stdouttrace.WithPrettyPrint())
+	case "otlp":
+		return otlptracegrpc.New(ctx)
+	}
+	return nil, fmt.Errorf("unknown trace exporter %q", name)
+}
+
+// Init installs the global tracer provider and propagator for service,
+// exporting through the named exporter, see NewExporter. The returned
+// provider must be shut down on exit to flush pending spans.
+func Init(ctx context.Context, service, exporter string) (*sdktrace.TracerProvider, error) {
+	exp, err := NewExporter(ctx, exporter)
+	if err != nil {
+		return nil, err
+	}
+	var opts []sdktrace.TracerProviderOption
+	if exp != nil {
+		opts = append(opts, sdktrace.WithBatcher(exp))
+	}
+	return install(service, opts...), nil
+}
+
+// InMemory installs a tracer provider that records spans synchronously
+// into the returned exporter, for tests to inspect.
+func InMemory(service string) (*tracetest.InMemoryExporter, *sdktrace.TracerProvider) {
+	exp := tracetest.NewInMemoryExporter()
+	return exp, install(service, sdktrace.WithSyncer(exp))
+}
+
+func install(service string, opts ...sdktrace.TracerProviderOption) *sdktrace.TracerProvider {
+	res := resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(service))
+	tp := sdktrace.NewTracerProvider(append(opts, sdktrace.WithResource(res))...)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+	return tp
+}
@@ -0,0 +1,157 @@
+package trip
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/0x0Glitch/toll-calculator/types"
+)
+
+// Lister lists the trips of a vehicle that overlap a period. A zero from
+// or to leaves that end of the period open.
+type Lister interface {
+	Trips(ctx context.Context, obuID int32, from, to time.Time) ([]types.Trip, error)
+}
+
+// Store keeps the trips of every vehicle, built from the distances the
+// calculator tags with their trip.
+type Store struct {
+	mu sync.RWMutex
+	// trips holds the trips of each OBU in the order they started.
+	trips map[int32][]*types.Trip
+	// updated is when each OBU's trips last changed, for retention.
+	updated map[int32]time.Time
+}
+
+func NewStore() *Store {
+	return &Store{
+		trips:   make(map[int32][]*types.Trip),
+		updated: make(map[int32]time.Time),
+	}
+}
+
+// Record adds the distance and what it cost to its trip. Distances without
+// a trip are ignored. Legs may arrive out of order: a trip is placed by
+// when it started, and ends any trip started before it.
+func (s *Store) Record(d *types.Distance, amount float64) {
+	leg := d.Trip
+	if leg == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	trips := s.trips[d.OBUID]
+	var trip *types.Trip
+	for i := len(trips) - 1; i >= 0 && trip == nil; i-- {
+		if trips[i].ID == leg.TripID {
+			trip = trips[i]
+		}
+	}
+	if trip == nil {
+		trip = &types.Trip{ID: leg.TripID, OBUID: d.OBUID, Start: leg.Start, End: leg.End}
+		trips = append(trips, trip)
+		sort.SliceStable(trips, func(a, b int) bool { return trips[a].Start.Unix < trips[b].Start.Unix })
+		// A vehicle setting off again ended its earlier trips, even if the
+		// fix switching its ignition off never came.
+		for _, t := range trips {
+			if t.Start.Unix < trip.Start.Unix {
+				t.Ended = true
+			}
+		}
+		// Likewise, the trip ended if one started after it is known.
+		if trips[len(trips)-1] != trip {
+			trip.Ended = true
+		}
+		s.trips[d.OBUID] = trips
+	}
+	trip.Distance += d.Values
+	if d.Estimated {
+		trip.Estimated += d.Values
+	}
+	trip.Amount += amount
+	if leg.End.Unix >= trip.End.Unix {
+		trip.End = leg.End
+	}
+	trip.Ended = trip.Ended || leg.Ends
+	s.updated[d.OBUID] = time.Now()
+}
+
+func (s *Store) Trips(ctx context.Context, obuID int32, from, to time.Time) ([]types.Trip, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := []types.Trip{}
+	for _, t := range s.trips[obuID] {
+		if overlaps(t, from, to) {
+			out = append(out, *t)
+		}
+	}
+	return out, nil
+}
+
+func overlaps(t *types.Trip, from, to time.Time) bool {
+	if !from.IsZero() && t.End.Unix < from.UnixMilli() {
+		return false
+	}
+	return to.IsZero() || t.Start.Unix < to.UnixMilli()
+}
+
+// Erase drops the trips of id.
+func (s *Store) Erase(ctx context.Context, id int32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.trips, id)
+	delete(s.updated, id)
+	return nil
+}
+
+// Purge drops the trips of the vehicles that haven't driven since before
+// and returns how many vehicles it dropped.
+func (s *Store) Purge(ctx context.Context, before time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for id, t := range s.updated {
+		if t.Before(before) {
+			delete(s.trips, id)
+			delete(s.updated, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// Merge joins the parts of the trips of a vehicle split across the cluster
+// members that owned it while it drove, and orders the trips by when they
+// started.
+func Merge(parts []types.Trip) []types.Trip {
+	byID := make(map[string]int)
+	out := []types.Trip{}
+	for _, p := range parts {
+		i, ok := byID[p.ID]
+		if !ok {
+			byID[p.ID] = len(out)
+			out = append(out, p)
+			continue
+		}
+		t := &out[i]
+		if p.Start.Unix < t.Start.Unix {
+			t.Start = p.Start
+		}
+		if p.End.Unix > t.End.Unix {
+			t.End = p.End
+		}
+		t.Distance += p.Distance
+		t.Estimated += p.Estimated
+		t.Amount += p.Amount
+		t.Ended = t.Ended || p.Ended
+	}
+	sort.SliceStable(out, func(a, b int) bool { return out[a].Start.Unix < out[b].Start.Unix })
+	// Every trip but the last ended, even if only the member that owned
+	// the vehicle later learned it set off again.
+	for i := 0; i < len(out)-1; i++ {
+		out[i].Ended = true
+	}
+	return out
+}
@@ -0,0 +1,92 @@
+// Package trip splits the journeys of every vehicle into trips. The
+// calculator's Tracker tags each leg with the trip it belongs to, and the
+// aggregator's Store adds the legs up into the trips vehicle owners list.
+package trip
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/0x0Glitch/toll-calculator/types"
+)
+
+// Tracker follows the trip every vehicle is on. A trip ends with a fix
+// switching the ignition off, or when the vehicle stood still longer than
+// the idle time; the next fix that moves it starts a new one from where it
+// stood. A nil Tracker detects no trips.
+type Tracker struct {
+	idle time.Duration
+
+	mu       sync.Mutex
+	vehicles map[int32]current
+}
+
+// current is the trip a vehicle is on and where it last moved to.
+type current struct {
+	id    string
+	start types.TripPoint
+	// at is the vehicle's last accepted fix; a stationary fix doesn't move
+	// it on.
+	at types.TripPoint
+	// ended is set by the fix switching the ignition off, so the next fix
+	// starts a new trip.
+	ended bool
+}
+
+// NewTracker ends trips after the vehicle stood still for idle. It returns
+// nil, detecting no trips, if idle isn't positive.
+func NewTracker(idle time.Duration) *Tracker {
+	if idle <= 0 {
+		return nil
+	}
+	return &Tracker{idle: idle, vehicles: make(map[int32]current)}
+}
+
+// Move records that the vehicle moved to at, in milliseconds, and returns
+// the trip leg that brought it there, which ends the trip if the fix
+// switched the ignition off. It returns nil if t is nil.
+func (t *Tracker) Move(obuID int32, at types.TripPoint, ignitionOff bool) *types.TripLeg {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c, seen := t.vehicles[obuID]
+	idle := seen && c.at.Unix != 0 && at.Unix != 0 &&
+		time.Duration(at.Unix-c.at.Unix)*time.Millisecond > t.idle
+	if !seen || c.ended || idle {
+		from := at
+		if seen {
+			from = c.at
+		}
+		c.start = types.TripPoint{Unix: at.Unix, Lat: from.Lat, Long: from.Long}
+		c.id = fmt.Sprintf("%d-%d", obuID, c.start.Unix)
+	}
+	c.at, c.ended = at, ignitionOff
+	t.vehicles[obuID] = c
+	return c.leg(at)
+}
+
+// End ends the trip of a vehicle whose fix switching the ignition off was
+// rejected, at the time unix where the vehicle last moved to. It returns
+// the empty leg that tells the aggregator so, and false if the vehicle
+// isn't on a trip.
+func (t *Tracker) End(obuID int32, unix int64) (*types.TripLeg, bool) {
+	if t == nil {
+		return nil, false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c, ok := t.vehicles[obuID]
+	if !ok || c.ended {
+		return nil, false
+	}
+	c.ended = true
+	t.vehicles[obuID] = c
+	return c.leg(types.TripPoint{Unix: unix, Lat: c.at.Lat, Long: c.at.Long}), true
+}
+
+func (c current) leg(end types.TripPoint) *types.TripLeg {
+	return &types.TripLeg{TripID: c.id, Start: c.start, End: end, Ends: c.ended}
+}
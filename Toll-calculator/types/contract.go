@@ -0,0 +1,66 @@
+package types
+
+import (
+	"time"
+
+	"github.com/0x0Glitch/toll-calculator/money"
+)
+
+// DiscountKind is the kind of discount a contract gives.
+type DiscountKind string
+
+const (
+	// DiscountVolume takes a percentage off the distance driven beyond a
+	// threshold in the billing period.
+	DiscountVolume DiscountKind = "volume"
+	// DiscountSubscription waives the tolls a flat-rate subscription
+	// covers.
+	DiscountSubscription DiscountKind = "subscription"
+	// DiscountOffPeak takes a percentage off the distance driven in the
+	// off-peak hours.
+	DiscountOffPeak DiscountKind = "off-peak"
+	// DiscountPromo takes a percentage or a fixed amount off, under a
+	// promo code.
+	DiscountPromo DiscountKind = "promo"
+)
+
+// Contract is what a fleet customer negotiated for its vehicles: a
+// flat-rate subscription, discounts, or both. It applies to the periods
+// billed from From up to To, open-ended if To is zero.
+type Contract struct {
+	ID       string    `json:"id"`
+	Customer string    `json:"customer"`
+	OBUIDs   []int32   `json:"obuIDs"`
+	From     time.Time `json:"from"`
+	To       time.Time `json:"to,omitzero"`
+	// Currency is the currency of the contract's fees and fixed amounts,
+	// the billing currency if empty.
+	Currency     money.Currency `json:"currency,omitempty"`
+	Subscription *Subscription  `json:"subscription,omitempty"`
+	// Discounts apply in order, each to what the ones before it left.
+	Discounts []Discount `json:"discounts,omitempty"`
+}
+
+// Subscription bills a flat fee per period for the tolls of its zones,
+// all of them if Zones is empty.
+type Subscription struct {
+	Fee   money.Decimal `json:"fee"`
+	Zones []string      `json:"zones,omitempty"`
+}
+
+// Discount is a discount of a contract on the lines of its zones, all of
+// them if Zones is empty. Percent is between 0 and 100; a promo discount
+// takes either Percent or the fixed Amount off. From and To bound when a
+// promo code is valid.
+type Discount struct {
+	Kind    DiscountKind  `json:"kind"`
+	Percent money.Decimal `json:"percent,omitzero"`
+	Amount  money.Decimal `json:"amount,omitzero"`
+	// Threshold is the distance per billing period beyond which a volume
+	// discount applies.
+	Threshold float64   `json:"threshold,omitempty"`
+	Code      string    `json:"code,omitempty"`
+	Zones     []string  `json:"zones,omitempty"`
+	From      time.Time `json:"from,omitzero"`
+	To        time.Time `json:"to,omitzero"`
+}
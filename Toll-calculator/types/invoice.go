@@ -0,0 +1,91 @@
+package types
+
+import (
+	"time"
+
+	"github.com/0x0Glitch/toll-calculator/money"
+)
+
+// InvoiceStatus is where an invoice document is in its lifecycle. A draft
+// may still change; finalizing it freezes it, and it is then either paid
+// or voided.
+type InvoiceStatus string
+
+const (
+	InvoiceDraft     InvoiceStatus = "draft"
+	InvoiceFinalized InvoiceStatus = "finalized"
+	InvoicePaid      InvoiceStatus = "paid"
+	InvoiceVoid      InvoiceStatus = "void"
+)
+
+// TaxTreatment is how the tax on a line item is accounted for.
+type TaxTreatment string
+
+const (
+	// TaxStandard charges the tax at the rate of the jurisdiction.
+	TaxStandard TaxTreatment = "standard"
+	// TaxExempt charges no tax, for the vehicle classes the jurisdiction
+	// exempts.
+	TaxExempt TaxTreatment = "exempt"
+	// TaxReverseCharge charges no tax: the business customer accounts for
+	// it at the rate of the jurisdiction in its own return.
+	TaxReverseCharge TaxTreatment = "reverse-charge"
+)
+
+// AppliedDiscount is a discount a contract gave on a line item, which
+// tells on the invoice why the line costs less than its list amount.
+type AppliedDiscount struct {
+	Contract string       `json:"contract"`
+	Kind     DiscountKind `json:"kind"`
+	// Code is the promo code of a promo discount.
+	Code        string        `json:"code,omitempty"`
+	Description string        `json:"description"`
+	Amount      money.Decimal `json:"amount"`
+}
+
+// InvoiceLine is a line item of an invoice or credit note: the distance
+// travelled in a toll zone, "" outside every zone, and its price.
+type InvoiceLine struct {
+	ZoneID      string  `json:"zoneID"`
+	Description string  `json:"description,omitempty"`
+	Distance    float64 `json:"distance"`
+	// Estimated is the part of Distance interpolated over gaps.
+	Estimated float64       `json:"estimated,omitempty"`
+	UnitPrice money.Decimal `json:"unitPrice"`
+	// Currency is the currency of the zone's tariff, the invoice's if
+	// empty. A tariff in another currency is converted at ExchangeRate,
+	// the units of the invoice's currency one unit of the tariff's buys.
+	Currency     money.Currency `json:"currency,omitempty"`
+	ExchangeRate money.Decimal  `json:"exchangeRate,omitzero"`
+	// Amount is in the currency of the invoice, net of tax and of the
+	// Discounts, which took it down from ListAmount. Lines without
+	// discounts have no list amount.
+	Amount     money.Decimal     `json:"amount"`
+	ListAmount money.Decimal     `json:"listAmount,omitzero"`
+	Discounts  []AppliedDiscount `json:"discounts,omitempty"`
+	// Jurisdiction is where the distance was driven and taxed, at TaxRate
+	// under TaxTreatment, which makes Tax. Lines billed without tax rules
+	// have none.
+	Jurisdiction string        `json:"jurisdiction,omitempty"`
+	TaxTreatment TaxTreatment  `json:"taxTreatment,omitempty"`
+	TaxRate      money.Decimal `json:"taxRate,omitzero"`
+	Tax          money.Decimal `json:"tax,omitzero"`
+}
+
+// CreditNote corrects a finalized invoice by crediting part of its amount
+// back, as the invoice itself can't change anymore.
+type CreditNote struct {
+	ID        string        `json:"id"`
+	InvoiceID string        `json:"invoiceID"`
+	OBUID     int32         `json:"obuID"`
+	Reason    string        `json:"reason"`
+	Lines     []InvoiceLine `json:"lines"`
+	// Amount is the total credited net of tax, a positive amount in
+	// Currency, the currency of the invoice. Tax is the tax credited with
+	// it at the rates of the invoice's lines, and Gross their sum.
+	Amount   money.Decimal  `json:"amount"`
+	Tax      money.Decimal  `json:"tax"`
+	Gross    money.Decimal  `json:"gross"`
+	Currency money.Currency `json:"currency,omitempty"`
+	IssuedAt time.Time      `json:"issuedAt"`
+}
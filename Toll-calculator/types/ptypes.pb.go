@@ -103,9 +103,13 @@ func (x *GetInvoiceRequest) GetObuID() int32 {
 
 type AggregatorRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
-	ObuID         int32                  `protobuf:"varint,1,opt,name=ObuID,proto3" json:"ObuID,omitempty"`  // renamed to snake_case
-	Value         float64                `protobuf:"fixed64,2,opt,name=Value,proto3" json:"Value,omitempty"` // added “= 2;”
-	Unix          int64                  `protobuf:"varint,3,opt,name=Unix,proto3" json:"Unix,omitempty"`    // swapped type/name so follows “type name = N” syntax
+	ObuID         int32                  `protobuf:"varint,1,opt,name=ObuID,proto3" json:"ObuID,omitempty"`         // renamed to snake_case
+	Value         float64                `protobuf:"fixed64,2,opt,name=Value,proto3" json:"Value,omitempty"`        // added “= 2;”
+	Unix          int64                  `protobuf:"varint,3,opt,name=Unix,proto3" json:"Unix,omitempty"`           // swapped type/name so follows “type name = N” syntax
+	ZoneID        string                 `protobuf:"bytes,4,opt,name=ZoneID,proto3" json:"ZoneID,omitempty"`        // empty outside every toll zone
+	Estimated     bool                   `protobuf:"varint,5,opt,name=Estimated,proto3" json:"Estimated,omitempty"` // interpolated over a gap in the fixes
+	Trip          *TripRef               `protobuf:"bytes,6,opt,name=Trip,proto3" json:"Trip,omitempty"`            // unset when trips aren't detected
+	OffPeak       bool                   `protobuf:"varint,7,opt,name=OffPeak,proto3" json:"OffPeak,omitempty"`     // travelled in the off-peak hours, as told by the first node to take it
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -161,6 +165,330 @@ func (x *AggregatorRequest) GetUnix() int64 {
 	return 0
 }
 
+func (x *AggregatorRequest) GetZoneID() string {
+	if x != nil {
+		return x.ZoneID
+	}
+	return ""
+}
+
+func (x *AggregatorRequest) GetEstimated() bool {
+	if x != nil {
+		return x.Estimated
+	}
+	return false
+}
+
+func (x *AggregatorRequest) GetTrip() *TripRef {
+	if x != nil {
+		return x.Trip
+	}
+	return nil
+}
+
+func (x *AggregatorRequest) GetOffPeak() bool {
+	if x != nil {
+		return x.OffPeak
+	}
+	return false
+}
+
+type Fix struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Unix          int64                  `protobuf:"varint,1,opt,name=Unix,proto3" json:"Unix,omitempty"`
+	Lat           float64                `protobuf:"fixed64,2,opt,name=Lat,proto3" json:"Lat,omitempty"`
+	Long          float64                `protobuf:"fixed64,3,opt,name=Long,proto3" json:"Long,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Fix) Reset() {
+	*x = Fix{}
+	mi := &file_types_ptypes_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Fix) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Fix) ProtoMessage() {}
+
+func (x *Fix) ProtoReflect() protoreflect.Message {
+	mi := &file_types_ptypes_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Fix.ProtoReflect.Descriptor instead.
+func (*Fix) Descriptor() ([]byte, []int) {
+	return file_types_ptypes_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *Fix) GetUnix() int64 {
+	if x != nil {
+		return x.Unix
+	}
+	return 0
+}
+
+func (x *Fix) GetLat() float64 {
+	if x != nil {
+		return x.Lat
+	}
+	return 0
+}
+
+func (x *Fix) GetLong() float64 {
+	if x != nil {
+		return x.Long
+	}
+	return 0
+}
+
+type TripRef struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	TripID        string                 `protobuf:"bytes,1,opt,name=TripID,proto3" json:"TripID,omitempty"`
+	Start         *Fix                   `protobuf:"bytes,2,opt,name=Start,proto3" json:"Start,omitempty"`
+	End           *Fix                   `protobuf:"bytes,3,opt,name=End,proto3" json:"End,omitempty"`
+	Ends          bool                   `protobuf:"varint,4,opt,name=Ends,proto3" json:"Ends,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TripRef) Reset() {
+	*x = TripRef{}
+	mi := &file_types_ptypes_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TripRef) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TripRef) ProtoMessage() {}
+
+func (x *TripRef) ProtoReflect() protoreflect.Message {
+	mi := &file_types_ptypes_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TripRef.ProtoReflect.Descriptor instead.
+func (*TripRef) Descriptor() ([]byte, []int) {
+	return file_types_ptypes_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *TripRef) GetTripID() string {
+	if x != nil {
+		return x.TripID
+	}
+	return ""
+}
+
+func (x *TripRef) GetStart() *Fix {
+	if x != nil {
+		return x.Start
+	}
+	return nil
+}
+
+func (x *TripRef) GetEnd() *Fix {
+	if x != nil {
+		return x.End
+	}
+	return nil
+}
+
+func (x *TripRef) GetEnds() bool {
+	if x != nil {
+		return x.Ends
+	}
+	return false
+}
+
+type ZoneCharge struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ZoneID        string                 `protobuf:"bytes,1,opt,name=ZoneID,proto3" json:"ZoneID,omitempty"`
+	Distance      float64                `protobuf:"fixed64,2,opt,name=Distance,proto3" json:"Distance,omitempty"`
+	Estimated     float64                `protobuf:"fixed64,4,opt,name=Estimated,proto3" json:"Estimated,omitempty"`
+	Amount        string                 `protobuf:"bytes,5,opt,name=Amount,proto3" json:"Amount,omitempty"` // exact decimal, such as 19.99, in the invoice's currency
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ZoneCharge) Reset() {
+	*x = ZoneCharge{}
+	mi := &file_types_ptypes_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ZoneCharge) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ZoneCharge) ProtoMessage() {}
+
+func (x *ZoneCharge) ProtoReflect() protoreflect.Message {
+	mi := &file_types_ptypes_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ZoneCharge.ProtoReflect.Descriptor instead.
+func (*ZoneCharge) Descriptor() ([]byte, []int) {
+	return file_types_ptypes_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *ZoneCharge) GetZoneID() string {
+	if x != nil {
+		return x.ZoneID
+	}
+	return ""
+}
+
+func (x *ZoneCharge) GetDistance() float64 {
+	if x != nil {
+		return x.Distance
+	}
+	return 0
+}
+
+func (x *ZoneCharge) GetEstimated() float64 {
+	if x != nil {
+		return x.Estimated
+	}
+	return 0
+}
+
+func (x *ZoneCharge) GetAmount() string {
+	if x != nil {
+		return x.Amount
+	}
+	return ""
+}
+
+type InvoiceResponse struct {
+	state             protoimpl.MessageState `protogen:"open.v1"`
+	ObuID             int32                  `protobuf:"varint,1,opt,name=ObuID,proto3" json:"ObuID,omitempty"`
+	TotalDistance     float64                `protobuf:"fixed64,2,opt,name=TotalDistance,proto3" json:"TotalDistance,omitempty"`
+	Zones             []*ZoneCharge          `protobuf:"bytes,4,rep,name=Zones,proto3" json:"Zones,omitempty"`
+	EstimatedDistance float64                `protobuf:"fixed64,5,opt,name=EstimatedDistance,proto3" json:"EstimatedDistance,omitempty"`
+	Amount            string                 `protobuf:"bytes,6,opt,name=Amount,proto3" json:"Amount,omitempty"`     // exact decimal, such as 19.99, net of tax
+	Currency          string                 `protobuf:"bytes,7,opt,name=Currency,proto3" json:"Currency,omitempty"` // ISO 4217 code of every amount of the invoice
+	Tax               string                 `protobuf:"bytes,8,opt,name=Tax,proto3" json:"Tax,omitempty"`           // exact decimal of the tax charged on Amount
+	Gross             string                 `protobuf:"bytes,9,opt,name=Gross,proto3" json:"Gross,omitempty"`       // exact decimal of Amount plus Tax, what is owed
+	unknownFields     protoimpl.UnknownFields
+	sizeCache         protoimpl.SizeCache
+}
+
+func (x *InvoiceResponse) Reset() {
+	*x = InvoiceResponse{}
+	mi := &file_types_ptypes_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *InvoiceResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*InvoiceResponse) ProtoMessage() {}
+
+func (x *InvoiceResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_types_ptypes_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use InvoiceResponse.ProtoReflect.Descriptor instead.
+func (*InvoiceResponse) Descriptor() ([]byte, []int) {
+	return file_types_ptypes_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *InvoiceResponse) GetObuID() int32 {
+	if x != nil {
+		return x.ObuID
+	}
+	return 0
+}
+
+func (x *InvoiceResponse) GetTotalDistance() float64 {
+	if x != nil {
+		return x.TotalDistance
+	}
+	return 0
+}
+
+func (x *InvoiceResponse) GetZones() []*ZoneCharge {
+	if x != nil {
+		return x.Zones
+	}
+	return nil
+}
+
+func (x *InvoiceResponse) GetEstimatedDistance() float64 {
+	if x != nil {
+		return x.EstimatedDistance
+	}
+	return 0
+}
+
+func (x *InvoiceResponse) GetAmount() string {
+	if x != nil {
+		return x.Amount
+	}
+	return ""
+}
+
+func (x *InvoiceResponse) GetCurrency() string {
+	if x != nil {
+		return x.Currency
+	}
+	return ""
+}
+
+func (x *InvoiceResponse) GetTax() string {
+	if x != nil {
+		return x.Tax
+	}
+	return ""
+}
+
+func (x *InvoiceResponse) GetGross() string {
+	if x != nil {
+		return x.Gross
+	}
+	return ""
+}
+
 var File_types_ptypes_proto protoreflect.FileDescriptor
 
 const file_types_ptypes_proto_rawDesc = "" +
@@ -168,14 +496,46 @@ const file_types_ptypes_proto_rawDesc = "" +
 	"\x12types/ptypes.proto\x12\x05types\"\a\n" +
 	"\x05Empty\")\n" +
 	"\x11GetInvoiceRequest\x12\x14\n" +
-	"\x05ObuID\x18\x01 \x01(\x05R\x05ObuID\"S\n" +
+	"\x05ObuID\x18\x01 \x01(\x05R\x05ObuID\"\xc7\x01\n" +
 	"\x11AggregatorRequest\x12\x14\n" +
 	"\x05ObuID\x18\x01 \x01(\x05R\x05ObuID\x12\x14\n" +
 	"\x05Value\x18\x02 \x01(\x01R\x05Value\x12\x12\n" +
-	"\x04Unix\x18\x03 \x01(\x03R\x04Unix2A\n" +
+	"\x04Unix\x18\x03 \x01(\x03R\x04Unix\x12\x16\n" +
+	"\x06ZoneID\x18\x04 \x01(\tR\x06ZoneID\x12\x1c\n" +
+	"\tEstimated\x18\x05 \x01(\bR\tEstimated\x12\"\n" +
+	"\x04Trip\x18\x06 \x01(\v2\x0e.types.TripRefR\x04Trip\x12\x18\n" +
+	"\aOffPeak\x18\a \x01(\bR\aOffPeak\"?\n" +
+	"\x03Fix\x12\x12\n" +
+	"\x04Unix\x18\x01 \x01(\x03R\x04Unix\x12\x10\n" +
+	"\x03Lat\x18\x02 \x01(\x01R\x03Lat\x12\x12\n" +
+	"\x04Long\x18\x03 \x01(\x01R\x04Long\"u\n" +
+	"\aTripRef\x12\x16\n" +
+	"\x06TripID\x18\x01 \x01(\tR\x06TripID\x12 \n" +
+	"\x05Start\x18\x02 \x01(\v2\n" +
+	".types.FixR\x05Start\x12\x1c\n" +
+	"\x03End\x18\x03 \x01(\v2\n" +
+	".types.FixR\x03End\x12\x12\n" +
+	"\x04Ends\x18\x04 \x01(\bR\x04Ends\"|\n" +
+	"\n" +
+	"ZoneCharge\x12\x16\n" +
+	"\x06ZoneID\x18\x01 \x01(\tR\x06ZoneID\x12\x1a\n" +
+	"\bDistance\x18\x02 \x01(\x01R\bDistance\x12\x1c\n" +
+	"\tEstimated\x18\x04 \x01(\x01R\tEstimated\x12\x16\n" +
+	"\x06Amount\x18\x05 \x01(\tR\x06AmountJ\x04\b\x03\x10\x04\"\x86\x02\n" +
+	"\x0fInvoiceResponse\x12\x14\n" +
+	"\x05ObuID\x18\x01 \x01(\x05R\x05ObuID\x12$\n" +
+	"\rTotalDistance\x18\x02 \x01(\x01R\rTotalDistance\x12'\n" +
+	"\x05Zones\x18\x04 \x03(\v2\x11.types.ZoneChargeR\x05Zones\x12,\n" +
+	"\x11EstimatedDistance\x18\x05 \x01(\x01R\x11EstimatedDistance\x12\x16\n" +
+	"\x06Amount\x18\x06 \x01(\tR\x06Amount\x12\x1a\n" +
+	"\bCurrency\x18\a \x01(\tR\bCurrency\x12\x10\n" +
+	"\x03Tax\x18\b \x01(\tR\x03Tax\x12\x14\n" +
+	"\x05Gross\x18\t \x01(\tR\x05GrossJ\x04\b\x03\x10\x042\x81\x01\n" +
 	"\n" +
 	"Aggregator\x123\n" +
-	"\tAggregate\x12\x18.types.AggregatorRequest\x1a\f.types.EmptyB*Z(github.com/0x0Glitch/tolling/types;typesb\x06proto3"
+	"\tAggregate\x12\x18.types.AggregatorRequest\x1a\f.types.Empty\x12>\n" +
+	"\n" +
+	"GetInvoice\x12\x18.types.GetInvoiceRequest\x1a\x16.types.InvoiceResponseB*Z(github.com/0x0Glitch/tolling/types;typesb\x06proto3"
 
 var (
 	file_types_ptypes_proto_rawDescOnce sync.Once
@@ -189,20 +549,30 @@ func file_types_ptypes_proto_rawDescGZIP() []byte {
 	return file_types_ptypes_proto_rawDescData
 }
 
-var file_types_ptypes_proto_msgTypes = make([]protoimpl.MessageInfo, 3)
+var file_types_ptypes_proto_msgTypes = make([]protoimpl.MessageInfo, 7)
 var file_types_ptypes_proto_goTypes = []any{
 	(*Empty)(nil),             // 0: types.Empty
 	(*GetInvoiceRequest)(nil), // 1: types.GetInvoiceRequest
 	(*AggregatorRequest)(nil), // 2: types.AggregatorRequest
+	(*Fix)(nil),               // 3: types.Fix
+	(*TripRef)(nil),           // 4: types.TripRef
+	(*ZoneCharge)(nil),        // 5: types.ZoneCharge
+	(*InvoiceResponse)(nil),   // 6: types.InvoiceResponse
 }
 var file_types_ptypes_proto_depIdxs = []int32{
-	2, // 0: types.Aggregator.Aggregate:input_type -> types.AggregatorRequest
-	0, // 1: types.Aggregator.Aggregate:output_type -> types.Empty
-	1, // [1:2] is the sub-list for method output_type
-	0, // [0:1] is the sub-list for method input_type
-	0, // [0:0] is the sub-list for extension type_name
-	0, // [0:0] is the sub-list for extension extendee
-	0, // [0:0] is the sub-list for field type_name
+	4, // 0: types.AggregatorRequest.Trip:type_name -> types.TripRef
+	3, // 1: types.TripRef.Start:type_name -> types.Fix
+	3, // 2: types.TripRef.End:type_name -> types.Fix
+	5, // 3: types.InvoiceResponse.Zones:type_name -> types.ZoneCharge
+	2, // 4: types.Aggregator.Aggregate:input_type -> types.AggregatorRequest
+	1, // 5: types.Aggregator.GetInvoice:input_type -> types.GetInvoiceRequest
+	0, // 6: types.Aggregator.Aggregate:output_type -> types.Empty
+	6, // 7: types.Aggregator.GetInvoice:output_type -> types.InvoiceResponse
+	6, // [6:8] is the sub-list for method output_type
+	4, // [4:6] is the sub-list for method input_type
+	4, // [4:4] is the sub-list for extension type_name
+	4, // [4:4] is the sub-list for extension extendee
+	0, // [0:4] is the sub-list for field type_name
 }
 
 func init() { file_types_ptypes_proto_init() }
@@ -216,7 +586,7 @@ func file_types_ptypes_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_types_ptypes_proto_rawDesc), len(file_types_ptypes_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   3,
+			NumMessages:   7,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
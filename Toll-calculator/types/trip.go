@@ -0,0 +1,25 @@
+package types
+
+// Proto converts the leg to its protobuf form. A nil leg converts to nil.
+func (l *TripLeg) Proto() *TripRef {
+	if l == nil {
+		return nil
+	}
+	return &TripRef{TripID: l.TripID, Start: l.Start.proto(), End: l.End.proto(), Ends: l.Ends}
+}
+
+func (p TripPoint) proto() *Fix {
+	return &Fix{Unix: p.Unix, Lat: p.Lat, Long: p.Long}
+}
+
+// Leg converts the protobuf form back. A nil ref converts to nil.
+func (r *TripRef) Leg() *TripLeg {
+	if r == nil {
+		return nil
+	}
+	return &TripLeg{TripID: r.TripID, Start: r.Start.point(), End: r.End.point(), Ends: r.Ends}
+}
+
+func (f *Fix) point() TripPoint {
+	return TripPoint{Unix: f.GetUnix(), Lat: f.GetLat(), Long: f.GetLong()}
+}
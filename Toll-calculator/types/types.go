@@ -1,21 +1,231 @@
 package types
 
+import (
+	"time"
+
+	"github.com/0x0Glitch/toll-calculator/money"
+)
+
 type OBUData struct {
 	OBUID int32   `json:"obuID"`
 	Lat   float64 `json:"lat"`
 	Long  float64 `json:"long"`
 	RequestID     int     `json:"requestID"`
+	// Seq, Unix (milliseconds) and Sig are set by OBUs signing their fixes,
+	// see package obuauth.
+	Seq  uint64 `json:"seq,omitempty"`
+	Unix int64  `json:"unix,omitempty"`
+	Sig  []byte `json:"sig,omitempty"`
+	// HDOP and Accuracy (metres) are set by devices that report the
+	// quality of their fixes, see package gpsfilter. They aren't signed, as
+	// a forged value only gets a fix rejected or trusted less.
+	HDOP     float64 `json:"hdop,omitempty"`
+	Accuracy float64 `json:"accuracy,omitempty"`
+	// IgnitionOff is set on the fix a device sends when the engine is
+	// switched off, which ends the trip.
+	IgnitionOff bool `json:"ignitionOff,omitempty"`
+	// KeyID and Sealed hold Lat and Long encrypted with the vehicle's data
+	// key once the fix left the data receiver, see package privacy.
+	KeyID  string `json:"keyID,omitempty"`
+	Sealed []byte `json:"sealed,omitempty"`
 }
 
 type Distance struct {
 	Values float64 `json:"value"`
 	OBUID  int32   `json:"obuID"`
-	Unix   int64   `json:"unix"`
+	// Unix is when the distance was travelled in nanoseconds, the time of
+	// the fix that ended it.
+	Unix int64 `json:"unix"`
+	// ZoneID is the toll zone the distance was travelled in, empty outside
+	// every zone, see package geofence.
+	ZoneID string `json:"zoneID,omitempty"`
+	// Estimated is set when the distance was interpolated over a gap in
+	// the fixes rather than measured.
+	Estimated bool `json:"estimated,omitempty"`
+	// OffPeak is set when the distance was travelled in the off-peak hours
+	// contracts may discount, see package contract.
+	OffPeak bool `json:"offPeak,omitempty"`
+	// Trip places the distance in a trip of the vehicle.
+	Trip *TripLeg `json:"trip,omitempty"`
+}
+
+// TripLeg ties a distance to the trip it was travelled on.
+type TripLeg struct {
+	TripID string `json:"tripID"`
+	// Start is where and when the trip started, End where and when the
+	// leg ended.
+	Start TripPoint `json:"start"`
+	End   TripPoint `json:"end"`
+	// Ends is set on the last leg of a trip.
+	Ends bool `json:"ends,omitempty"`
+}
+
+// TripPoint is a position and its time in milliseconds.
+type TripPoint struct {
+	Unix int64   `json:"unix"`
+	Lat  float64 `json:"lat"`
+	Long float64 `json:"long"`
+}
+
+// Trip is a journey of a vehicle, from when it set off to when its
+// ignition was switched off or it stood idle.
+type Trip struct {
+	ID       string    `json:"id"`
+	OBUID    int32     `json:"obuID"`
+	Start    TripPoint `json:"start"`
+	End      TripPoint `json:"end"`
+	Distance float64   `json:"distance"`
+	// Estimated is the part of Distance interpolated over gaps.
+	Estimated float64 `json:"estimated,omitempty"`
+	// Amount is what the trip cost in the billing currency, a breakdown
+	// for the vehicle's owner. The invoice is what's billed.
+	Amount float64 `json:"amount"`
+	// Ended is false while the vehicle may still be on the trip.
+	Ended bool `json:"ended"`
 }
 
 type Invoice struct {
-	OBUID         int32   `json:"obuID"`
-	TotalDistance float64 `json:"totalDistance"`
-	Amount        float64 `json:"amount"`
-	
+	OBUID         int32         `json:"obuID"`
+	TotalDistance float64       `json:"totalDistance"`
+	// Amount is the total net of tax, Tax the tax charged on it and Gross
+	// what is owed.
+	Amount money.Decimal `json:"amount"`
+	Tax    money.Decimal `json:"tax"`
+	Gross  money.Decimal `json:"gross"`
+	// Currency is the currency of the amounts of the invoice, its zones
+	// and lines.
+	Currency money.Currency `json:"currency,omitempty"`
+	// EstimatedDistance is the part of TotalDistance interpolated over gaps
+	// in the fixes.
+	EstimatedDistance float64 `json:"estimatedDistance,omitempty"`
+	// Zones breaks the invoice down by toll zone.
+	Zones []ZoneTotal `json:"zones,omitempty"`
+
+	// The fields below are set on invoice documents, which bill a closed
+	// period, and not on the running invoice of the open one.
+	ID     string        `json:"id,omitempty"`
+	Status InvoiceStatus `json:"status,omitempty"`
+	// Period labels the billing period from PeriodStart to PeriodEnd.
+	Period      string    `json:"period,omitempty"`
+	PeriodStart time.Time `json:"periodStart,omitzero"`
+	PeriodEnd   time.Time `json:"periodEnd,omitzero"`
+	// TariffVersion identifies the prices the invoice was billed at.
+	TariffVersion string `json:"tariffVersion,omitempty"`
+	// Lines are the line items; a finalized invoice never changes them.
+	Lines       []InvoiceLine `json:"lines,omitempty"`
+	FinalizedAt time.Time     `json:"finalizedAt,omitzero"`
+	PaidAt      time.Time     `json:"paidAt,omitzero"`
+	VoidedAt    time.Time     `json:"voidedAt,omitzero"`
+	VoidReason  string        `json:"voidReason,omitempty"`
+}
+
+// ZoneTotal is the distance travelled in a toll zone and its price.
+type ZoneTotal struct {
+	ZoneID   string        `json:"zoneID"`
+	Distance float64       `json:"distance"`
+	Amount   money.Decimal `json:"amount"`
+	// Estimated is the part of Distance interpolated over gaps.
+	Estimated float64 `json:"estimated,omitempty"`
+}
+
+// Total is the distance an OBU travelled in a toll zone, of which
+// Estimated was interpolated over gaps in its fixes and OffPeak travelled
+// in the off-peak hours.
+type Total struct {
+	Distance  float64
+	Estimated float64
+	OffPeak   float64
+}
+
+// Parts splits the total of the OBU in the zone back into distances that
+// add up to it again, measured apart from estimated and peak apart from
+// off-peak, so another store can keep them apart too. The parts are dated
+// at unix.
+func (t Total) Parts(obuID int32, zone string, unix int64) []*Distance {
+	// both is the estimated distance travelled off-peak, as little as the
+	// totals allow; the marginals are all a total keeps.
+	both := max(0, t.Estimated+t.OffPeak-t.Distance)
+	parts := []struct {
+		value              float64
+		estimated, offPeak bool
+	}{
+		{t.Distance - t.Estimated - t.OffPeak + both, false, false},
+		{t.Estimated - both, true, false},
+		{t.OffPeak - both, false, true},
+		{both, true, true},
+	}
+	var out []*Distance
+	for i, p := range parts {
+		if p.value == 0 && (i > 0 || t.Distance != 0) {
+			continue
+		}
+		out = append(out, &Distance{OBUID: obuID, Values: p.value, Unix: unix, ZoneID: zone, Estimated: p.estimated, OffPeak: p.offPeak})
+	}
+	return out
+}
+
+// Add adds the distance to the total.
+func (t *Total) Add(d *Distance) {
+	t.Distance += d.Values
+	if d.Estimated {
+		t.Estimated += d.Values
+	}
+	if d.OffPeak {
+		t.OffPeak += d.Values
+	}
+}
+
+// Merge adds the total u to t.
+func (t *Total) Merge(u Total) {
+	t.Distance += u.Distance
+	t.Estimated += u.Estimated
+	t.OffPeak += u.OffPeak
+}
+
+// DailyTotals are the totals of a vehicle by the UTC day the distance was
+// travelled on and toll zone. Billing periods start at midnight UTC, so a
+// period's totals are those of its days.
+type DailyTotals map[time.Time]map[string]Total
+
+// Day returns the UTC day a distance dated at unix was travelled on.
+func Day(unix int64) time.Time {
+	return time.Unix(0, unix).UTC().Truncate(24 * time.Hour)
+}
+
+// Add adds the distance to the total of its day and zone.
+func (d DailyTotals) Add(dist *Distance) {
+	day := Day(dist.Unix)
+	zones, ok := d[day]
+	if !ok {
+		zones = make(map[string]Total)
+		d[day] = zones
+	}
+	t := zones[dist.ZoneID]
+	t.Add(dist)
+	zones[dist.ZoneID] = t
+}
+
+// Sum adds the totals of every day up by toll zone.
+func (d DailyTotals) Sum() map[string]Total {
+	out := make(map[string]Total)
+	for _, zones := range d {
+		for zone, t := range zones {
+			total := out[zone]
+			total.Merge(t)
+			out[zone] = total
+		}
+	}
+	return out
+}
+
+// Parts splits the totals of the OBU back into distances dated on their
+// day, so another store keeps them on the day they were travelled.
+func (d DailyTotals) Parts(obuID int32) []*Distance {
+	var out []*Distance
+	for day, zones := range d {
+		for zone, t := range zones {
+			out = append(out, t.Parts(obuID, zone, day.UnixNano())...)
+		}
+	}
+	return out
 }